@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+)
+
+type AdminAPI struct {
+	backups *BackupManager
+}
+
+func NewAdminAPI(backups *BackupManager) *AdminAPI {
+	return &AdminAPI{backups: backups}
+}
+
+// CreateBackupHandler godoc
+// @Summary Snapshot the database into the backup directory
+// @Description Uses VACUUM INTO, so the server keeps serving while it runs. Older backups beyond the configured count are removed.
+// @Tags admin
+// @Produce json
+// @Param X-User header string true "Admin user"
+// @Success 201 {object} BackupInfo
+// @Failure 401 {object} errorResponse
+// @Failure 403 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /admin/backup [post]
+func (api *AdminAPI) CreateBackupHandler(w http.ResponseWriter, r *http.Request) {
+	info, err := api.backups.Create(r.Context())
+	if err != nil {
+		loggerFrom(r.Context()).Error("create backup", "err", err)
+		writeError(w, r, http.StatusInternalServerError, "internal_error")
+		return
+	}
+	writeJSON(w, http.StatusCreated, info)
+}
+
+// ListBackupsHandler godoc
+// @Summary List backups, newest first
+// @Tags admin
+// @Produce json
+// @Param X-User header string true "Admin user"
+// @Success 200 {array} BackupInfo
+// @Failure 401 {object} errorResponse
+// @Failure 403 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /admin/backups [get]
+func (api *AdminAPI) ListBackupsHandler(w http.ResponseWriter, r *http.Request) {
+	backups, err := api.backups.List()
+	if err != nil {
+		loggerFrom(r.Context()).Error("list backups", "err", err)
+		writeError(w, r, http.StatusInternalServerError, "internal_error")
+		return
+	}
+	writeJSON(w, http.StatusOK, backups)
+}
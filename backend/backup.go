@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	backupPrefix      = "books-"
+	backupExt         = ".db"
+	backupManifestExt = ".json"
+	defaultBackupDir  = "backups"
+	defaultBackupKeep = 7
+	preRestoreSuffix  = ".pre-restore"
+)
+
+// ErrChecksumMismatch means a backup no longer matches its manifest.
+var ErrChecksumMismatch = errors.New("backup checksum does not match manifest")
+
+// BackupInfo is the manifest written next to every backup file.
+type BackupInfo struct {
+	Name          string    `json:"name"`
+	Size          int64     `json:"size"`
+	SHA256        string    `json:"sha256"`
+	SchemaVersion int       `json:"schema_version"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// BackupManager writes snapshots of a live database into Dir with VACUUM
+// INTO, which reads inside a transaction and so never blocks writers for
+// long or captures a half-written page. Only the newest Keep backups are
+// kept.
+type BackupManager struct {
+	db   *sql.DB
+	Dir  string
+	Keep int
+
+	mu  sync.Mutex // one backup at a time
+	now func() time.Time
+}
+
+func NewBackupManager(db *sql.DB, dir string, keep int) *BackupManager {
+	return &BackupManager{db: db, Dir: dir, Keep: keep, now: time.Now}
+}
+
+// Create snapshots the database, writes its manifest and rotates old backups.
+func (m *BackupManager) Create(ctx context.Context) (BackupInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := os.MkdirAll(m.Dir, 0o755); err != nil {
+		return BackupInfo{}, err
+	}
+
+	createdAt := m.now().UTC()
+	name := backupPrefix + strings.Replace(createdAt.Format("20060102T150405.000Z"), ".", "", 1) + backupExt
+	path := filepath.Join(m.Dir, name)
+	tmp := path + ".tmp"
+	_ = os.Remove(tmp)
+
+	if err := BackupTo(ctx, m.db, tmp); err != nil {
+		_ = os.Remove(tmp)
+		return BackupInfo{}, fmt.Errorf("vacuum into: %w", err)
+	}
+
+	var version int
+	if err := m.db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version); err != nil {
+		_ = os.Remove(tmp)
+		return BackupInfo{}, err
+	}
+
+	sum, size, err := fileSHA256(tmp)
+	if err != nil {
+		_ = os.Remove(tmp)
+		return BackupInfo{}, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return BackupInfo{}, err
+	}
+
+	info := BackupInfo{Name: name, Size: size, SHA256: sum, SchemaVersion: version, CreatedAt: createdAt}
+	if err := writeManifest(path+backupManifestExt, info); err != nil {
+		_ = os.Remove(path)
+		return BackupInfo{}, err
+	}
+
+	if err := m.rotate(); err != nil {
+		loggerFrom(ctx).Warn("rotate backups", "dir", m.Dir, "err", err)
+	}
+	loggerFrom(ctx).Info("backup created", "name", name, "size", size)
+	return info, nil
+}
+
+// List returns the backups in Dir that have a manifest, newest first.
+func (m *BackupManager) List() ([]BackupInfo, error) {
+	manifests, err := filepath.Glob(filepath.Join(m.Dir, backupPrefix+"*"+backupExt+backupManifestExt))
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]BackupInfo, 0, len(manifests))
+	for _, p := range manifests {
+		info, err := readManifest(p)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filepath.Base(p), err)
+		}
+		out = append(out, info)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name > out[j].Name })
+	return out, nil
+}
+
+// rotate deletes everything but the newest Keep backups. Keep <= 0 keeps all.
+func (m *BackupManager) rotate() error {
+	if m.Keep <= 0 {
+		return nil
+	}
+	backups, err := m.List()
+	if err != nil {
+		return err
+	}
+	for _, b := range backups[min(m.Keep, len(backups)):] {
+		path := filepath.Join(m.Dir, b.Name)
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if err := os.Remove(path + backupManifestExt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// VerifyBackup checks a backup file against its manifest, when there is one,
+// and runs PRAGMA integrity_check on it.
+func VerifyBackup(ctx context.Context, path string) error {
+	if info, err := readManifest(path + backupManifestExt); err == nil {
+		sum, _, err := fileSHA256(path)
+		if err != nil {
+			return err
+		}
+		if sum != info.SHA256 {
+			return ErrChecksumMismatch
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return integrityCheck(ctx, path)
+}
+
+// RestoreBackup replaces the database at dbPath with the backup at src after
+// verifying it. The server must not be running. The replaced file is kept
+// as dbPath + ".pre-restore".
+func RestoreBackup(ctx context.Context, src, dbPath string) error {
+	if err := VerifyBackup(ctx, src); err != nil {
+		return fmt.Errorf("verify %s: %w", src, err)
+	}
+
+	// Copy next to the target first so the final swap is an atomic rename on
+	// the same filesystem.
+	tmp := dbPath + ".restore-tmp"
+	if err := copyFile(src, tmp); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	if err := integrityCheck(ctx, tmp); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("verify copy: %w", err)
+	}
+
+	if err := os.Rename(dbPath, dbPath+preRestoreSuffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+		_ = os.Remove(tmp)
+		return err
+	}
+	// A leftover journal would be replayed onto the restored file.
+	for _, suffix := range []string{"-journal", "-wal", "-shm"} {
+		_ = os.Remove(dbPath + suffix)
+	}
+	return os.Rename(tmp, dbPath)
+}
+
+func integrityCheck(ctx context.Context, path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	db, err := OpenDB("file:" + path + "?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	rows, err := db.QueryContext(ctx, `PRAGMA integrity_check`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var problems []string
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return err
+		}
+		if line != "ok" {
+			problems = append(problems, line)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("integrity check failed: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func fileSHA256(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func writeManifest(path string, info BackupInfo) error {
+	b, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(b, '\n'), 0o644)
+}
+
+func readManifest(path string) (BackupInfo, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return BackupInfo{}, err
+	}
+	var info BackupInfo
+	if err := json.Unmarshal(b, &info); err != nil {
+		return BackupInfo{}, err
+	}
+	return info, nil
+}
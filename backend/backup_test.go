@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// mountBackups serves the backup endpoints to the admin "root".
+func mountBackups(r chi.Router, backups *BackupManager) {
+	api := NewAdminAPI(backups)
+	r.Route("/admin", func(r chi.Router) {
+		r.Use(Identify, RequireAdmin([]string{"root"}))
+		r.Post("/backup", api.CreateBackupHandler)
+		r.Get("/backups", api.ListBackupsHandler)
+	})
+}
+
+func TestBackups_RotationAndManifest(t *testing.T) {
+	r, db := setupTestRouter(t)
+	defer db.Close()
+
+	dir := t.TempDir()
+	backups := NewBackupManager(db, dir, 2)
+	now := time.Date(2026, 1, 1, 9, 0, 0, 0, time.UTC)
+	backups.now = func() time.Time { return now }
+
+	mountBackups(r, backups)
+
+	doJSON(t, r, http.MethodPost, "/books", `{"title":"Dune","author":"Frank Herbert","year":1965}`)
+
+	var names []string
+	for i := 0; i < 3; i++ {
+		rr := doIn(t, r, "root", "", http.MethodPost, "/admin/backup", ``)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("backup status %d body=%s", rr.Code, rr.Body.String())
+		}
+		info := decodeJSON[BackupInfo](t, rr)
+		if len(info.SHA256) != 64 || info.SchemaVersion != len(migrations) {
+			t.Fatalf("unexpected manifest: %+v", info)
+		}
+		names = append(names, info.Name)
+		now = now.Add(time.Hour)
+	}
+
+	rr := doIn(t, r, "root", "", http.MethodGet, "/admin/backups", ``)
+	listed := decodeJSON[[]BackupInfo](t, rr)
+	if len(listed) != 2 || listed[0].Name != names[2] || listed[1].Name != names[1] {
+		t.Fatalf("expected the two newest backups, got %+v", listed)
+	}
+	if _, err := os.Stat(filepath.Join(dir, names[0])); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("oldest backup not rotated out: %v", err)
+	}
+
+	path := filepath.Join(dir, names[2])
+	if err := VerifyBackup(t.Context(), path); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("tampered"))
+	f.Close()
+	if err := VerifyBackup(t.Context(), path); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("expected checksum mismatch, got %v", err)
+	}
+}
+
+func TestBackups_AdminOnly(t *testing.T) {
+	r, db := setupTestRouter(t)
+	defer db.Close()
+
+	dir := t.TempDir()
+	mountBackups(r, NewBackupManager(db, dir, 0))
+
+	for _, tc := range []struct {
+		user   string
+		status int
+		code   string
+	}{
+		{"", http.StatusUnauthorized, "authentication_required"},
+		{"alice", http.StatusForbidden, "admin_required"},
+	} {
+		for _, route := range []struct{ method, path string }{
+			{http.MethodPost, "/admin/backup"},
+			{http.MethodGet, "/admin/backups"},
+		} {
+			rr := doIn(t, r, tc.user, "", route.method, route.path, ``)
+			if rr.Code != tc.status || decodeJSON[errorResponse](t, rr).Code != tc.code {
+				t.Fatalf("%s %s as %q: status %d body=%s", route.method, route.path, tc.user, rr.Code, rr.Body.String())
+			}
+		}
+	}
+
+	// Refused requests write nothing to disk.
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != 0 {
+		t.Fatalf("backup dir: %v %v", entries, err)
+	}
+}
+
+func TestBackups_Restore(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "books.db")
+
+	db, err := OpenDB("file:" + dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	store := NewBookStore(db)
+	dune, err := store.Create(t.Context(), Book{Title: "Dune", Author: "Frank Herbert", Year: 1965})
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := NewBackupManager(db, filepath.Join(dir, "backups"), 0).Create(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(t.Context(), dune.ID); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	// A corrupt file is rejected and the live database is left alone.
+	junk := filepath.Join(dir, "junk.db")
+	if err := os.WriteFile(junk, []byte("this is not a database"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := RestoreBackup(t.Context(), junk, dbPath); err == nil {
+		t.Fatal("expected restore of a corrupt file to fail")
+	}
+	if _, err := os.Stat(dbPath + preRestoreSuffix); !errors.Is(err, os.ErrNotExist) {
+		t.Fatal("live database was moved aside for a failed restore")
+	}
+
+	if err := RestoreBackup(t.Context(), filepath.Join(dir, "backups", info.Name), dbPath); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = OpenDB("file:" + dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	got, err := NewBookStore(db).Get(t.Context(), dune.ID)
+	if err != nil || got.Title != "Dune" {
+		t.Fatalf("restored book: %+v err=%v", got, err)
+	}
+	if _, err := os.Stat(dbPath + preRestoreSuffix); err != nil {
+		t.Fatalf("previous database not kept: %v", err)
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Change log limits for GET /books/changes.
+const (
+	defaultChangesLimit    = 500
+	maxChangesLimit        = 1000
+	defaultChangeRetention = 30 * 24 * time.Hour
+)
+
+// ErrResyncRequired is returned for a sync token older than the change log
+// still covers, or one it never issued.
+var ErrResyncRequired = newCodedError("resync_required")
+
+// Change operations.
+const (
+	ChangeUpsert = "upsert"
+	ChangeDelete = "delete"
+)
+
+// BookChange is the latest change of one book.
+type BookChange struct {
+	Seq    int64  `json:"seq"`
+	Op     string `json:"op" enums:"upsert,delete"`
+	BookID int64  `json:"book_id"`
+	// Book is the book as it is now; absent for deletes.
+	Book      *Book     `json:"book,omitempty"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// BookChanges is a page of the change log.
+type BookChanges struct {
+	Changes []BookChange `json:"changes"`
+	// Token is sent back as since to get the changes after these.
+	Token   string `json:"token"`
+	HasMore bool   `json:"has_more"`
+}
+
+// Changes returns the workspace's books changed after the sync token since,
+// oldest change first, at most limit of them. A book changed several times
+// appears once, at its latest change. since 0 is a full sync: every book,
+// without tombstones.
+func (s *BookStore) Changes(ctx context.Context, since int64, limit int) (BookChanges, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	// one transaction, so the token matches the books read
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return BookChanges{}, err
+	}
+	defer tx.Rollback()
+
+	var horizon, latest int64
+	if err := tx.QueryRowContext(ctx,
+		`SELECT seq, COALESCE((SELECT MAX(seq) FROM book_changes), 0) FROM book_changes_horizon WHERE id = 1`,
+	).Scan(&horizon, &latest); err != nil {
+		return BookChanges{}, err
+	}
+	latest = max(latest, horizon)
+	if since > latest || (since > 0 && since < horizon) {
+		return BookChanges{}, ErrResyncRequired
+	}
+
+	query := `SELECT seq, book_id, deleted, changed_at FROM book_changes WHERE workspace_id = ? AND seq > ?`
+	if since == 0 {
+		query += ` AND deleted = 0`
+	}
+	query += ` ORDER BY seq LIMIT ?`
+
+	out := BookChanges{Changes: []BookChange{}}
+	var live []any
+	err = eachRow(ctx, tx, query, []any{workspaceFromContext(ctx), since, limit + 1}, func(rows *sql.Rows) error {
+		var (
+			c       BookChange
+			deleted bool
+		)
+		if err := rows.Scan(&c.Seq, &c.BookID, &deleted, &c.ChangedAt); err != nil {
+			return err
+		}
+		c.Op = ChangeUpsert
+		if deleted {
+			c.Op = ChangeDelete
+		} else if len(out.Changes) < limit {
+			live = append(live, c.BookID)
+		}
+		out.Changes = append(out.Changes, c)
+		return nil
+	})
+	if err != nil {
+		return BookChanges{}, err
+	}
+
+	out.Token = strconv.FormatInt(latest, 10)
+	if len(out.Changes) > limit {
+		out.Changes, out.HasMore = out.Changes[:limit], true
+		out.Token = strconv.FormatInt(out.Changes[limit-1].Seq, 10)
+	}
+
+	var books []Book
+	err = inBatches(live, func(placeholders string, args []any) error {
+		return eachRow(ctx, tx, `SELECT `+bookColumns+` FROM books WHERE id IN (`+placeholders+`)`, args, func(rows *sql.Rows) error {
+			b, err := scanBook(rows)
+			books = append(books, b)
+			return err
+		})
+	})
+	if err != nil {
+		return BookChanges{}, err
+	}
+	if err := loadLocations(ctx, tx, books); err != nil {
+		return BookChanges{}, err
+	}
+
+	byID := make(map[int64]*Book, len(books))
+	for i := range books {
+		byID[books[i].ID] = &books[i]
+	}
+	for i := range out.Changes {
+		out.Changes[i].Book = byID[out.Changes[i].BookID]
+	}
+	return out, nil
+}
+
+// PruneChanges drops the tombstones of books deleted before before and
+// moves the horizon past them, returning how many were dropped. Sync tokens
+// from before the horizon get ErrResyncRequired from then on.
+func (s *BookStore) PruneChanges(ctx context.Context, before time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var upTo int64
+	if err := tx.QueryRowContext(ctx,
+		`SELECT COALESCE(MAX(seq), 0) FROM book_changes WHERE deleted = 1 AND changed_at < ?`, before.UTC(),
+	).Scan(&upTo); err != nil {
+		return 0, err
+	}
+	if upTo == 0 {
+		return 0, nil
+	}
+
+	res, err := tx.ExecContext(ctx, `DELETE FROM book_changes WHERE deleted = 1 AND seq <= ?`, upTo)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE book_changes_horizon SET seq = MAX(seq, ?) WHERE id = 1`, upTo,
+	); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	n, _ := res.RowsAffected()
+	return n, nil
+}
+
+// RunChangePruning prunes tombstones older than ChangeRetention every
+// interval until ctx is done.
+func (s *BookStore) RunChangePruning(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if n, err := s.PruneChanges(ctx, time.Now().Add(-s.ChangeRetention)); err != nil && ctx.Err() == nil {
+			slog.Error("change log pruning", "err", err)
+		} else if n > 0 {
+			slog.Debug("change log pruned", "tombstones", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ChangesHandler godoc
+// @Summary Books changed since a sync token
+// @Description Delta sync for clients that keep their own copy of the catalog. Without since, every book is returned as an upsert (a full sync); with the token of a previous response, every book inserted, updated or deleted since, oldest change first. A book appears once, at its latest change; deletes carry only book_id. Follow has_more with the returned token until it is false, then keep the token for next time. Tombstones of deleted books are kept for 30 days: an older token gets 410 and the client must sync fully again.
+// @Tags books
+// @Produce json
+// @Param since query string false "Sync token from a previous response"
+// @Param limit query int false "Changes per page (default 500, max 1000)"
+// @Success 200 {object} BookChanges
+// @Failure 400 {object} errorResponse
+// @Failure 410 {object} errorResponse "Sync token expired; sync again without since"
+// @Failure 500 {object} errorResponse
+// @Router /books/changes [get]
+func (api *BooksAPI) ChangesHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var since int64
+	if raw := q.Get("since"); raw != "" {
+		v, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || v < 0 {
+			writeError(w, r, http.StatusBadRequest, "since_invalid")
+			return
+		}
+		since = v
+	}
+	limit := defaultChangesLimit
+	if raw := q.Get("limit"); raw != "" {
+		v, err := strconv.Atoi(raw)
+		if err != nil || v < 1 || v > maxChangesLimit {
+			writeError(w, r, http.StatusBadRequest, "changes_limit_range")
+			return
+		}
+		limit = v
+	}
+
+	page, err := api.store.Changes(r.Context(), since, limit)
+	if err == ErrResyncRequired {
+		writeError(w, r, http.StatusGone, errorCode(err))
+		return
+	}
+	if err != nil {
+		loggerFrom(r.Context()).Error("book changes", "err", err)
+		writeError(w, r, http.StatusInternalServerError, "internal_error")
+		return
+	}
+	writeJSON(w, http.StatusOK, renderChanges(r, page))
+}
+
+// renderChanges is renderBook for a page of changes.
+func renderChanges(r *http.Request, page BookChanges) any {
+	if apiVersionFromContext(r.Context()).Book == nil {
+		return page
+	}
+	type change struct {
+		BookChange
+		Book any `json:"book,omitempty"`
+	}
+	changes := make([]change, len(page.Changes))
+	for i, c := range page.Changes {
+		changes[i].BookChange = c
+		if c.Book != nil {
+			changes[i].Book = renderBook(r, *c.Book)
+		}
+	}
+	return map[string]any{"changes": changes, "token": page.Token, "has_more": page.HasMore}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestBookChanges_InsertUpdateDelete(t *testing.T) {
+	r, db := setupTestRouter(t)
+	defer db.Close()
+	api := NewBooksAPI(NewBookStore(db))
+	r.Get("/books/changes", api.ChangesHandler)
+
+	var ids []int64
+	for _, body := range []string{
+		`{"title":"Dune","author":"Frank Herbert","year":1965}`,
+		`{"title":"Hyperion","author":"Dan Simmons","year":1989}`,
+		`{"title":"Solaris","author":"Stanislaw Lem","year":1961}`,
+	} {
+		ids = append(ids, decodeJSON[Book](t, doJSON(t, r, http.MethodPost, "/books", body)).ID)
+	}
+
+	full := decodeJSON[BookChanges](t, doJSON(t, r, http.MethodGet, "/books/changes", ""))
+	if len(full.Changes) != 3 || full.HasMore || full.Changes[0].Book.Title != "Dune" {
+		t.Fatalf("full sync = %+v", full)
+	}
+	token := full.Token
+
+	doJSON(t, r, http.MethodPut, "/books/"+strconv.FormatInt(ids[0], 10), `{"title":"Dune","author":"Frank Herbert","year":1966}`)
+	doJSON(t, r, http.MethodDelete, "/books/"+strconv.FormatInt(ids[1], 10), "")
+	doJSON(t, r, http.MethodPost, "/books", `{"title":"Ubik","author":"Philip K. Dick","year":1969}`)
+
+	rr := doJSON(t, r, http.MethodGet, "/books/changes?since="+token, "")
+	delta := decodeJSON[BookChanges](t, rr)
+	if len(delta.Changes) != 3 {
+		t.Fatalf("delta = %s", rr.Body.String())
+	}
+	update, tombstone, insert := delta.Changes[0], delta.Changes[1], delta.Changes[2]
+	if update.Op != ChangeUpsert || update.BookID != ids[0] || update.Book.Year != 1966 {
+		t.Fatalf("update = %+v", update)
+	}
+	if tombstone.Op != ChangeDelete || tombstone.BookID != ids[1] || tombstone.Book != nil {
+		t.Fatalf("tombstone = %+v", tombstone)
+	}
+	if insert.Op != ChangeUpsert || insert.Book.Title != "Ubik" {
+		t.Fatalf("insert = %+v", insert)
+	}
+	if !(update.Seq < tombstone.Seq && tombstone.Seq < insert.Seq) {
+		t.Fatalf("changes out of order: %+v", delta.Changes)
+	}
+
+	// nothing new: same token, no changes
+	again := decodeJSON[BookChanges](t, doJSON(t, r, http.MethodGet, "/books/changes?since="+delta.Token, ""))
+	if len(again.Changes) != 0 || again.Token != delta.Token {
+		t.Fatalf("caught up = %+v", again)
+	}
+
+	// a full sync leaves the deleted book out
+	if full := decodeJSON[BookChanges](t, doJSON(t, r, http.MethodGet, "/books/changes", "")); len(full.Changes) != 3 {
+		t.Fatalf("full sync after delete = %+v", full)
+	}
+
+	for _, query := range []string{"since=abc", "since=-1", "limit=0", "limit=1001"} {
+		rr := doJSON(t, r, http.MethodGet, "/books/changes?"+query, "")
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("%s: %d", query, rr.Code)
+		}
+	}
+}
+
+func TestBookChanges_Paging(t *testing.T) {
+	r, db := setupTestRouter(t)
+	defer db.Close()
+	api := NewBooksAPI(NewBookStore(db))
+	r.Get("/books/changes", api.ChangesHandler)
+
+	for i := 0; i < 5; i++ {
+		doJSON(t, r, http.MethodPost, "/books", `{"title":"Book `+strconv.Itoa(i)+`","author":"A","year":2000}`)
+	}
+
+	var titles []string
+	token, pages := "", 0
+	for {
+		page := decodeJSON[BookChanges](t, doJSON(t, r, http.MethodGet, "/books/changes?limit=2&since="+token, ""))
+		pages++
+		for _, c := range page.Changes {
+			titles = append(titles, c.Book.Title)
+		}
+		token = page.Token
+		if !page.HasMore {
+			break
+		}
+	}
+	if pages != 3 || len(titles) != 5 || titles[0] != "Book 0" || titles[4] != "Book 4" {
+		t.Fatalf("%d pages: %v", pages, titles)
+	}
+}
+
+func TestBookChanges_StaleTokenAndWorkspaces(t *testing.T) {
+	_, db := setupTestRouter(t)
+	defer db.Close()
+	store := NewBookStore(db)
+	ctx := context.Background()
+
+	if _, err := db.Exec(`INSERT INTO workspaces(id, slug, name, created_at) VALUES (2, 'acme', 'Acme', ?)`, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	acme := withWorkspace(ctx, 2)
+
+	kept, err := store.Create(ctx, Book{Title: "Dune", Author: "Frank Herbert", Year: 1965})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gone, err := store.Create(ctx, Book{Title: "Hyperion", Author: "Dan Simmons", Year: 1989})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Create(acme, Book{Title: "Solaris", Author: "Stanislaw Lem", Year: 1961}); err != nil {
+		t.Fatal(err)
+	}
+
+	page, err := store.Changes(acme, 0, 10)
+	if err != nil || len(page.Changes) != 1 || page.Changes[0].Book.Title != "Solaris" {
+		t.Fatalf("acme = %+v, %v", page, err)
+	}
+	page, err = store.Changes(ctx, 0, 10)
+	if err != nil || len(page.Changes) != 2 {
+		t.Fatalf("default = %+v, %v", page, err)
+	}
+	old, _ := strconv.ParseInt(page.Token, 10, 64)
+
+	if err := store.Delete(ctx, gone.ID); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := store.PruneChanges(ctx, time.Now().Add(-time.Hour)); err != nil || n != 0 {
+		t.Fatalf("pruned a fresh tombstone: %d, %v", n, err)
+	}
+	if n, err := store.PruneChanges(ctx, time.Now().Add(time.Hour)); err != nil || n != 1 {
+		t.Fatalf("pruned %d, %v", n, err)
+	}
+
+	if _, err := store.Changes(ctx, old, 10); err != ErrResyncRequired || errorCode(err) != "resync_required" {
+		t.Fatalf("stale token: %v", err)
+	}
+	if _, err := store.Changes(ctx, old+1000, 10); err != ErrResyncRequired {
+		t.Fatalf("token from the future: %v", err)
+	}
+	page, err = store.Changes(ctx, 0, 10)
+	if err != nil || len(page.Changes) != 1 || page.Changes[0].BookID != kept.ID {
+		t.Fatalf("resync = %+v, %v", page, err)
+	}
+
+	r := chi.NewRouter()
+	r.Get("/books/changes", NewBooksAPI(store).ChangesHandler)
+	rr := doJSON(t, r, http.MethodGet, "/books/changes?since="+strconv.FormatInt(old, 10), "")
+	if rr.Code != http.StatusGone || decodeJSON[errorResponse](t, rr).Code != "resync_required" {
+		t.Fatalf("stale token over HTTP: %d %s", rr.Code, rr.Body.String())
+	}
+}
@@ -0,0 +1,528 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	defaultDuplicateScore = 0.85
+	maxDuplicateClusters  = 100
+	maxMergeBooks         = 20
+
+	// maxDuplicateBlock is the most books compared pairwise for sharing a
+	// word. A word in more titles says little about them being the same
+	// work, and every pair of its books would be compared.
+	maxDuplicateBlock = 200
+)
+
+// titleArticles are dropped from titles, wherever cataloguing moved them:
+// "The Hobbit" and "Hobbit, The" compare equal.
+var titleArticles = map[string]bool{"the": true, "a": true, "an": true}
+
+// blockStopWords are too common in titles to pick out books to compare.
+var blockStopWords = map[string]bool{
+	"of": true, "and": true, "in": true, "on": true, "to": true, "for": true,
+	"with": true, "from": true, "at": true, "by": true, "or": true, "vol": true,
+	"volume": true, "book": true, "edition": true, "de": true, "la": true, "le": true,
+}
+
+// DuplicateCluster is a group of books that probably describe the same work.
+// Score is the weakest similarity (0-1) linking them.
+type DuplicateCluster struct {
+	Score float64 `json:"score" example:"0.94"`
+	Books []Book  `json:"books"`
+}
+
+// DuplicateOptions narrow duplicate detection.
+type DuplicateOptions struct {
+	MinScore float64 // pairs scoring below this are not linked
+	Limit    int     // clusters returned, best first
+}
+
+func (o DuplicateOptions) withDefaults() DuplicateOptions {
+	if o.MinScore == 0 {
+		o.MinScore = defaultDuplicateScore
+	}
+	if o.Limit <= 0 {
+		o.Limit = maxDuplicateClusters
+	}
+	return o
+}
+
+// words lowercases s and splits it on anything but letters and digits.
+func words(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// titleKey is the title without punctuation or articles, words sorted.
+func titleKey(title string) string {
+	var kept []string
+	for _, w := range words(title) {
+		if !titleArticles[w] {
+			kept = append(kept, w)
+		}
+	}
+	sort.Strings(kept)
+	return strings.Join(kept, " ")
+}
+
+// authorWords are the author's names without initials, sorted, so
+// "J.R.R. Tolkien", "Tolkien, J. R. R." and "Tolkien" all become [tolkien].
+func authorWords(author string) []string {
+	var kept []string
+	for _, w := range words(author) {
+		if len([]rune(w)) > 1 {
+			kept = append(kept, w)
+		}
+	}
+	sort.Strings(kept)
+	return kept
+}
+
+// levenshtein is the edit distance between a and b, in runes.
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+// similarity is 1 minus the edit distance relative to the longer string.
+func similarity(a, b string) float64 {
+	n := max(len([]rune(a)), len([]rune(b)))
+	if n == 0 {
+		return 1
+	}
+	return 1 - float64(levenshtein(a, b))/float64(n)
+}
+
+// authorSimilarity scores two author names. When every name of one appears in
+// the other ("Tolkien" and "John Ronald Reuel Tolkien") they score 0.9.
+func authorSimilarity(a, b []string) float64 {
+	sa, sb := strings.Join(a, " "), strings.Join(b, " ")
+	if sa == sb {
+		return 1
+	}
+	if len(a) > 0 && len(b) > 0 && (isSubset(a, b) || isSubset(b, a)) {
+		return 0.9
+	}
+	return similarity(sa, sb)
+}
+
+func isSubset(small, big []string) bool {
+	set := make(map[string]bool, len(big))
+	for _, w := range big {
+		set[w] = true
+	}
+	for _, w := range small {
+		if !set[w] {
+			return false
+		}
+	}
+	return true
+}
+
+// duplicateScore weighs title over author similarity.
+func duplicateScore(titleA, titleB string, authorA, authorB []string) float64 {
+	return 0.6*similarity(titleA, titleB) + 0.4*authorSimilarity(authorA, authorB)
+}
+
+// Duplicates finds clusters of books whose normalized titles and authors are
+// close. Only books sharing a title word, or the whole normalized title, are
+// compared, which keeps the work well below every pair, at the cost of
+// missing titles misspelt throughout. Stop words and words in more than
+// maxDuplicateBlock titles do not count as shared.
+func (s *BookStore) Duplicates(ctx context.Context, o DuplicateOptions) ([]DuplicateCluster, error) {
+	o = o.withDefaults()
+	if o.MinScore < 0 || o.MinScore > 1 {
+		return nil, &ValidationError{Field: "min_score", Code: "min_score_range", Message: "min_score must be between 0 and 1"}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+bookColumns+` FROM books WHERE workspace_id = ? ORDER BY id`, workspaceFromContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var (
+		books   []Book
+		titles  []string
+		authors [][]string
+		byWord  = map[string][]int{}
+	)
+	for rows.Next() {
+		b, err := scanBook(rows)
+		if err != nil {
+			return nil, err
+		}
+		i := len(books)
+		books = append(books, b)
+		titles = append(titles, titleKey(b.Title))
+		authors = append(authors, authorWords(b.Author))
+		// "=" keeps the whole title apart from single words, so titles made
+		// only of common words still meet their duplicates.
+		for _, w := range append(strings.Fields(titles[i]), "="+titles[i]) {
+			if blockStopWords[w] {
+				continue
+			}
+			if l := byWord[w]; len(l) == 0 || l[len(l)-1] != i {
+				byWord[w] = append(l, i)
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Link every close pair, then read clusters off the union-find forest.
+	type link struct {
+		i, j  int
+		score float64
+	}
+	var (
+		links  []link
+		seen   = map[[2]int]bool{}
+		parent = make([]int, len(books))
+	)
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	for _, group := range byWord {
+		if len(group) > maxDuplicateBlock {
+			continue
+		}
+		for x := 0; x < len(group); x++ {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			for y := x + 1; y < len(group); y++ {
+				i, j := group[x], group[y]
+				if seen[[2]int{i, j}] {
+					continue
+				}
+				seen[[2]int{i, j}] = true
+
+				score := duplicateScore(titles[i], titles[j], authors[i], authors[j])
+				if score >= o.MinScore {
+					links = append(links, link{i, j, score})
+					parent[find(j)] = find(i)
+				}
+			}
+		}
+	}
+
+	weakest := map[int]float64{}
+	for _, l := range links {
+		r := find(l.i)
+		if w, ok := weakest[r]; !ok || l.score < w {
+			weakest[r] = l.score
+		}
+	}
+	members := map[int][]Book{}
+	for i := range books {
+		if r := find(i); weakest[r] != 0 {
+			members[r] = append(members[r], books[i])
+		}
+	}
+	clusters := []DuplicateCluster{}
+	for r, bs := range members {
+		clusters = append(clusters, DuplicateCluster{Score: math.Round(weakest[r]*100) / 100, Books: bs})
+	}
+	sort.Slice(clusters, func(a, b int) bool {
+		if clusters[a].Score != clusters[b].Score {
+			return clusters[a].Score > clusters[b].Score
+		}
+		return clusters[a].Books[0].ID < clusters[b].Books[0].ID
+	})
+	if len(clusters) > o.Limit {
+		clusters = clusters[:o.Limit]
+	}
+	return clusters, nil
+}
+
+// MergeRequest names the books to merge. SurvivorID is the book that is kept;
+// when zero, the one with the most copies, then reviews, then the oldest is.
+// Title, Author, Year and ISBN override the combined fields when set.
+type MergeRequest struct {
+	BookIDs    []int64 `json:"book_ids"`
+	SurvivorID int64   `json:"survivor_id,omitempty"`
+	Title      string  `json:"title,omitempty"`
+	Author     string  `json:"author,omitempty"`
+	Year       int     `json:"year,omitempty"`
+	ISBN       string  `json:"isbn,omitempty"`
+}
+
+// MergeResult is the surviving book and what was moved onto it.
+type MergeResult struct {
+	Book      Book    `json:"book"`
+	MergedIDs []int64 `json:"merged_ids"`
+	Reviews   int64   `json:"reviews_moved"`
+	Loans     int64   `json:"loans_moved"`
+	Copies    int64   `json:"copies_moved"`
+	Holds     int64   `json:"holds_moved"`
+}
+
+func validateMergeRequest(req MergeRequest) ([]int64, error) {
+	seen := map[int64]bool{}
+	var ids []int64
+	for _, id := range req.BookIDs {
+		if id <= 0 {
+			return nil, &ValidationError{Field: "book_ids", Code: "book_ids_invalid", Message: "book_ids must be positive"}
+		}
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) < 2 || len(ids) > maxMergeBooks {
+		return nil, &ValidationError{Field: "book_ids", Code: "book_ids_count", Message: "book_ids must name between 2 and 20 books"}
+	}
+	if req.SurvivorID != 0 && !seen[req.SurvivorID] {
+		return nil, &ValidationError{Field: "survivor_id", Code: "survivor_not_listed", Message: "survivor_id must be one of book_ids"}
+	}
+	return ids, nil
+}
+
+// combineBooks picks the survivor and fills in its fields: an empty ISBN is
+// taken from another book, and a shorter form of the author's name gives way
+// to a fuller one ("Tolkien" becomes "J.R.R. Tolkien").
+func combineBooks(books []Book, req MergeRequest) (survivor Book, combined Book) {
+	survivor = books[0]
+	for _, b := range books[1:] {
+		switch {
+		case req.SurvivorID != 0:
+			if b.ID == req.SurvivorID {
+				survivor = b
+			}
+		case b.CopiesTotal != survivor.CopiesTotal:
+			if b.CopiesTotal > survivor.CopiesTotal {
+				survivor = b
+			}
+		case b.RatingCount != survivor.RatingCount:
+			if b.RatingCount > survivor.RatingCount {
+				survivor = b
+			}
+		case b.ID < survivor.ID:
+			survivor = b
+		}
+	}
+
+	combined = survivor
+	names := authorWords(survivor.Author)
+	for _, b := range books {
+		if combined.ISBN == "" {
+			combined.ISBN = b.ISBN
+		}
+		other := authorWords(b.Author)
+		if isSubset(names, other) && (len(other) > len(names) || len(b.Author) > len(combined.Author)) {
+			combined.Author, names = b.Author, other
+		}
+	}
+
+	if req.Title != "" {
+		combined.Title = req.Title
+	}
+	if req.Author != "" {
+		combined.Author = req.Author
+	}
+	if req.Year != 0 {
+		combined.Year = req.Year
+	}
+	if req.ISBN != "" {
+		combined.ISBN = req.ISBN
+	}
+	return survivor, combined
+}
+
+// Merge folds books into one in a single transaction. Reviews, loans, copies
+// and holds of the merged books move to the survivor, and the merged books
+// are deleted. Where a user reviewed more than one of the books, the review
+// of the survivor, or else the first one moved, is kept; the user's oldest
+// active hold is kept and the others are cancelled.
+func (s *BookStore) Merge(ctx context.Context, req MergeRequest) (MergeResult, error) {
+	ids, err := validateMergeRequest(req)
+	if err != nil {
+		return MergeResult{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return MergeResult{}, err
+	}
+	defer tx.Rollback()
+
+	// Every later statement works on these ids, so checking the workspace
+	// here keeps the whole merge inside it.
+	books := make([]Book, 0, len(ids))
+	for _, id := range ids {
+		b, err := scanBook(tx.QueryRowContext(ctx,
+			`SELECT `+bookColumns+` FROM books WHERE id = ? AND workspace_id = ?`, id, workspaceFromContext(ctx)))
+		if errors.Is(err, sql.ErrNoRows) {
+			return MergeResult{}, ErrNotFound
+		}
+		if err != nil {
+			return MergeResult{}, err
+		}
+		books = append(books, b)
+	}
+
+	survivor, combined := combineBooks(books, req)
+	combined.ISBN = normalizeISBN(combined.ISBN)
+	if err := validateBook(combined); err != nil {
+		return MergeResult{}, err
+	}
+
+	var (
+		merged []int64
+		args   = []any{survivor.ID}
+	)
+	for _, id := range ids {
+		if id != survivor.ID {
+			merged = append(merged, id)
+			args = append(args, id)
+		}
+	}
+	all := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+	others := strings.TrimSuffix(strings.Repeat("?,", len(merged)), ",")
+	res := MergeResult{MergedIDs: merged}
+	now := time.Now().UTC()
+
+	// A user may only have one active hold per book: keep the oldest, cancel
+	// the rest and put any copy set aside for them back on the shelf.
+	dupHolds := `book_id IN (` + all + `) AND status IN ('waiting', 'ready') AND EXISTS (
+		SELECT 1 FROM holds h WHERE h.book_id IN (` + all + `) AND h.user = holds.user
+			AND h.status IN ('waiting', 'ready') AND h.id < holds.id)`
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE copies SET status = 'available', updated_at = ?
+		WHERE status = 'on_hold' AND id IN (SELECT copy_id FROM holds WHERE status = 'ready' AND `+dupHolds+`)`,
+		append(append([]any{now}, args...), args...)...,
+	); err != nil {
+		return MergeResult{}, err
+	}
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE holds SET status = 'cancelled', closed_at = ? WHERE `+dupHolds,
+		append(append([]any{now}, args...), args...)...,
+	); err != nil {
+		return MergeResult{}, err
+	}
+
+	// Reviews that would give a user two reviews of the survivor stay behind
+	// and are deleted with their book.
+	moves := []struct {
+		stmt  string
+		count *int64
+	}{
+		{`UPDATE OR IGNORE reviews SET book_id = ? WHERE book_id IN (` + others + `)`, &res.Reviews},
+		{`UPDATE loans SET book_id = ? WHERE book_id IN (` + others + `)`, &res.Loans},
+		{`UPDATE copies SET book_id = ? WHERE book_id IN (` + others + `)`, &res.Copies},
+		{`UPDATE holds SET book_id = ? WHERE book_id IN (` + others + `)`, &res.Holds},
+	}
+	for _, m := range moves {
+		r, err := tx.ExecContext(ctx, m.stmt, args...)
+		if err != nil {
+			return MergeResult{}, err
+		}
+		if *m.count, err = r.RowsAffected(); err != nil {
+			return MergeResult{}, err
+		}
+	}
+
+	// Keep the metadata that matches the surviving ISBN.
+	if _, err := tx.ExecContext(ctx,
+		`DELETE FROM book_metadata WHERE book_id = ? AND isbn <> ?`, survivor.ID, combined.ISBN,
+	); err != nil {
+		return MergeResult{}, err
+	}
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE OR IGNORE book_metadata SET book_id = ? WHERE isbn = ? AND book_id IN (`+others+`)`,
+		append([]any{survivor.ID, combined.ISBN}, args[1:]...)...,
+	); err != nil {
+		return MergeResult{}, err
+	}
+
+	// The merged books go first, freeing their ISBNs for the survivor.
+	var events []BookEvent
+	for _, id := range merged {
+		b, err := scanBook(tx.QueryRowContext(ctx, `DELETE FROM books WHERE id = ? RETURNING `+bookColumns, id))
+		if err != nil {
+			return MergeResult{}, err
+		}
+		ev, err := recordBookEvent(ctx, tx, EventBookDeleted, b)
+		if err != nil {
+			return MergeResult{}, err
+		}
+		events = append(events, ev)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE books SET title = ?, author = ?, year = ?, isbn = ?, updated_at = ? WHERE id = ?`,
+		combined.Title, combined.Author, combined.Year, combined.ISBN, now, survivor.ID,
+	)
+	if isUniqueViolation(err) {
+		return MergeResult{}, duplicateISBN(ctx, tx, combined.ISBN)
+	}
+	if err != nil {
+		return MergeResult{}, err
+	}
+	if _, err := promoteHolds(ctx, tx, survivor.ID, now, s.HoldPickup); err != nil {
+		return MergeResult{}, err
+	}
+	if err := refreshBookCopies(ctx, tx, survivor.ID); err != nil {
+		return MergeResult{}, err
+	}
+	if err := refreshBookRating(ctx, tx, survivor.ID); err != nil {
+		return MergeResult{}, err
+	}
+
+	if res.Book, err = scanBook(tx.QueryRowContext(ctx, `SELECT `+bookColumns+` FROM books WHERE id = ?`, survivor.ID)); err != nil {
+		return MergeResult{}, err
+	}
+	ev, err := recordBookEvent(ctx, tx, EventBookUpdated, res.Book)
+	if err != nil {
+		return MergeResult{}, err
+	}
+	events = append(events, ev)
+
+	if err := s.commitAndNotify(tx, events...); err != nil {
+		return MergeResult{}, err
+	}
+	loggerFrom(ctx).Info("books merged", "book_id", survivor.ID, "merged", merged)
+	return res, nil
+}
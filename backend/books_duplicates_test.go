@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestDuplicates_Clusters(t *testing.T) {
+	r, db := setupTestRouter(t)
+	defer db.Close()
+	r.Get("/duplicates", NewBooksAPI(NewBookStore(db)).DuplicatesHandler)
+
+	for _, body := range []string{
+		`{"title":"The Hobbit","author":"J.R.R. Tolkien","year":1937}`,
+		`{"title":"Hobbit, The","author":"Tolkien","year":1966}`,
+		`{"title":"The Lord of the Rings","author":"J.R.R. Tolkien","year":1954}`,
+		`{"title":"Pride and Prejudice","author":"Jane Austen","year":1813}`,
+		`{"title":"Emma","author":"Jane Austen","year":1815}`,
+		`{"title":"Pride & Prejudice","author":"Austen, Jane","year":1813}`,
+		`{"title":"hobbit","author":"John Ronald Reuel Tolkien","year":1995}`,
+	} {
+		if rr := doJSON(t, r, http.MethodPost, "/books", body); rr.Code != http.StatusCreated {
+			t.Fatalf("create status %d", rr.Code)
+		}
+	}
+
+	ids := func(c DuplicateCluster) string {
+		var out []int64
+		for _, b := range c.Books {
+			out = append(out, b.ID)
+		}
+		return fmt.Sprint(out)
+	}
+
+	clusters := decodeJSON[[]DuplicateCluster](t, doJSON(t, r, http.MethodGet, "/duplicates", ``))
+	if len(clusters) != 2 {
+		t.Fatalf("clusters: %+v", clusters)
+	}
+	// The fullest name links at 0.9 for the author, so the cluster scores 0.96.
+	if ids(clusters[0]) != "[1 2 7]" || clusters[0].Score != 0.96 {
+		t.Fatalf("hobbit cluster: %s score %v", ids(clusters[0]), clusters[0].Score)
+	}
+	// "&" is dropped, so the titles are 4 edits ("and ") out of 19 apart.
+	if ids(clusters[1]) != "[4 6]" || clusters[1].Score != 0.87 {
+		t.Fatalf("pride cluster: %s score %v", ids(clusters[1]), clusters[1].Score)
+	}
+
+	clusters = decodeJSON[[]DuplicateCluster](t, doJSON(t, r, http.MethodGet, "/duplicates?min_score=0.99", ``))
+	if len(clusters) != 1 || ids(clusters[0]) != "[1 2]" || clusters[0].Score != 1 {
+		t.Fatalf("strict clusters: %+v", clusters)
+	}
+	if got := decodeJSON[[]DuplicateCluster](t, doJSON(t, r, http.MethodGet, "/duplicates?limit=1", ``)); len(got) != 1 {
+		t.Fatalf("limit: %+v", got)
+	}
+
+	for _, q := range []string{"min_score=0", "min_score=1.5", "min_score=x", "limit=0", "limit=101"} {
+		if rr := doJSON(t, r, http.MethodGet, "/duplicates?"+q, ``); rr.Code != http.StatusBadRequest {
+			t.Fatalf("%s: status %d", q, rr.Code)
+		}
+	}
+}
+
+func TestMerge_MovesEverythingInOneTransaction(t *testing.T) {
+	r, store, cleanup := setupLoans(t)
+	defer cleanup()
+	r.Post("/books/merge", NewBooksAPI(NewBookStore(store.db)).MergeBooksHandler)
+	reviews := NewReviewStore(store.db)
+
+	a := decodeJSON[Book](t, doJSON(t, r, http.MethodPost, "/books", `{"title":"The Hobbit","author":"J.R.R. Tolkien","year":1937}`))
+	b := decodeJSON[Book](t, doJSON(t, r, http.MethodPost, "/books", `{"title":"Hobbit, The","author":"Tolkien","year":1966,"isbn":"9780547928227"}`))
+	other := decodeJSON[Book](t, doJSON(t, r, http.MethodPost, "/books", `{"title":"Dune","author":"Frank Herbert","year":1965,"isbn":"9780441172719"}`))
+	pathA, pathB := fmt.Sprintf("/books/%d", a.ID), fmt.Sprintf("/books/%d", b.ID)
+
+	// alice borrows A's only copy and carol queues for it; bob borrows B's
+	// first copy, carol queues for B too and gets its second copy set aside.
+	addCopy(t, r, a.ID, "A-1", "Tokyo")
+	addCopy(t, r, b.ID, "B-1", "Osaka")
+	doAs(t, r, "alice", http.MethodPost, pathA+"/checkout", ``)
+	doAs(t, r, "carol", http.MethodPost, pathA+"/holds", ``)
+	doAs(t, r, "bob", http.MethodPost, pathB+"/checkout", ``)
+	doAs(t, r, "carol", http.MethodPost, pathB+"/holds", ``)
+	addCopy(t, r, b.ID, "B-2", "Osaka")
+
+	for _, rv := range []struct {
+		book   int64
+		user   string
+		rating int
+	}{{a.ID, "alice", 5}, {b.ID, "alice", 3}, {b.ID, "bob", 4}} {
+		if _, err := reviews.Create(t.Context(), rv.book, rv.user, Review{Rating: rv.rating}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := store.db.Exec(`INSERT INTO book_metadata(book_id, source, isbn, publisher, fetched_at) VALUES (?, 'openlibrary', '9780547928227', 'Mariner', ?)`, b.ID, time.Now().UTC()); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"one book", fmt.Sprintf(`{"book_ids":[%d,%d]}`, a.ID, a.ID), http.StatusBadRequest},
+		{"survivor not merged", fmt.Sprintf(`{"book_ids":[%d,%d],"survivor_id":%d}`, a.ID, b.ID, other.ID), http.StatusBadRequest},
+		{"unknown book", fmt.Sprintf(`{"book_ids":[%d,9999]}`, a.ID), http.StatusNotFound},
+		{"bad year", fmt.Sprintf(`{"book_ids":[%d,%d],"year":-1}`, a.ID, b.ID), http.StatusBadRequest},
+		// Fails after the rows were moved, so the rollback is visible below.
+		{"isbn taken", fmt.Sprintf(`{"book_ids":[%d,%d],"survivor_id":%d,"isbn":"0441172717"}`, a.ID, b.ID, a.ID), http.StatusConflict},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if rr := doJSON(t, r, http.MethodPost, "/books/merge", tc.body); rr.Code != tc.status {
+				t.Fatalf("status got %d want %d body=%s", rr.Code, tc.status, rr.Body.String())
+			}
+		})
+	}
+	if got := decodeJSON[Book](t, doJSON(t, r, http.MethodGet, pathB, ``)); got.CopiesTotal != 2 || got.RatingCount != 2 {
+		t.Fatalf("failed merge changed B: %+v", got)
+	}
+
+	rr := doJSON(t, r, http.MethodPost, "/books/merge", fmt.Sprintf(`{"book_ids":[%d,%d],"survivor_id":%d}`, b.ID, a.ID, a.ID))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("merge status %d body=%s", rr.Code, rr.Body.String())
+	}
+	res := decodeJSON[MergeResult](t, rr)
+	if fmt.Sprint(res.MergedIDs) != fmt.Sprint([]int64{b.ID}) || res.Copies != 2 || res.Loans != 1 || res.Holds != 1 || res.Reviews != 1 {
+		t.Fatalf("moved: %+v", res)
+	}
+	got := res.Book
+	if got.ID != a.ID || got.Title != "The Hobbit" || got.Author != "J.R.R. Tolkien" || got.Year != 1937 || got.ISBN != "9780547928227" {
+		t.Fatalf("combined fields: %+v", got)
+	}
+	// alice's review of the survivor wins over her review of B.
+	if got.RatingCount != 2 || got.RatingAvg != 4.5 {
+		t.Fatalf("ratings: count=%d avg=%v", got.RatingCount, got.RatingAvg)
+	}
+	// carol keeps her older hold, which now gets the copy set aside for the
+	// cancelled one.
+	if got.CopiesTotal != 3 || got.CopiesAvailable != 0 || got.Status != BookOnLoan {
+		t.Fatalf("copies: %+v", got)
+	}
+	holds := decodeJSON[[]Hold](t, doJSON(t, r, http.MethodGet, "/holds?user=carol", ``))
+	statuses := map[string]int{}
+	for _, h := range holds {
+		if h.BookID != a.ID {
+			t.Fatalf("hold left on merged book: %+v", h)
+		}
+		statuses[h.Status]++
+	}
+	if statuses[HoldReady] != 1 || statuses[HoldCancelled] != 1 {
+		t.Fatalf("carol's holds: %+v", holds)
+	}
+
+	if rr := doJSON(t, r, http.MethodGet, pathB, ``); rr.Code != http.StatusNotFound {
+		t.Fatalf("merged book status %d", rr.Code)
+	}
+	if rr := doAs(t, r, "bob", http.MethodPost, pathA+"/return", ``); rr.Code != http.StatusOK {
+		t.Fatalf("bob returns to the survivor: status %d body=%s", rr.Code, rr.Body.String())
+	}
+	var publisher string
+	if err := store.db.QueryRow(`SELECT publisher FROM book_metadata WHERE book_id = ?`, a.ID).Scan(&publisher); err != nil || publisher != "Mariner" {
+		t.Fatalf("metadata: %q %v", publisher, err)
+	}
+}
+
+func TestMerge_PicksSurvivorAndFullerAuthor(t *testing.T) {
+	r, store, cleanup := setupLoans(t)
+	defer cleanup()
+	r.Post("/books/merge", NewBooksAPI(NewBookStore(store.db)).MergeBooksHandler)
+
+	a := decodeJSON[Book](t, doJSON(t, r, http.MethodPost, "/books", `{"title":"The Hobbit","author":"Tolkien","year":1937}`))
+	b := decodeJSON[Book](t, doJSON(t, r, http.MethodPost, "/books", `{"title":"Hobbit, The","author":"John Ronald Reuel Tolkien","year":1966}`))
+	c := decodeJSON[Book](t, doJSON(t, r, http.MethodPost, "/books", `{"title":"The Hobbit","author":"Tolkien","year":1937}`))
+	addCopy(t, r, c.ID, "C-1", "Tokyo")
+
+	rr := doJSON(t, r, http.MethodPost, "/books/merge", fmt.Sprintf(`{"book_ids":[%d,%d,%d],"title":"The Hobbit, or There and Back Again"}`, a.ID, b.ID, c.ID))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("merge status %d body=%s", rr.Code, rr.Body.String())
+	}
+	res := decodeJSON[MergeResult](t, rr)
+	if res.Book.ID != c.ID || fmt.Sprint(res.MergedIDs) != fmt.Sprint([]int64{a.ID, b.ID}) {
+		t.Fatalf("the book with copies should survive: %+v", res)
+	}
+	if res.Book.Author != "John Ronald Reuel Tolkien" || res.Book.Title != "The Hobbit, or There and Back Again" || res.Book.Year != 1937 {
+		t.Fatalf("combined fields: %+v", res.Book)
+	}
+}
+
+func TestDuplicates_SkipsCommonWordsAndStopsOnCancel(t *testing.T) {
+	_, db := setupTestRouter(t)
+	defer db.Close()
+	store := NewBookStore(db)
+
+	// "history" is in too many titles to compare every pair of them; the
+	// duplicates still meet through "rome".
+	for i := range maxDuplicateBlock + 50 {
+		if _, err := store.Create(t.Context(), Book{Title: fmt.Sprintf("History of Place %d", i), Author: fmt.Sprintf("Author %d", i), Year: 2000}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, title := range []string{"A History of Rome", "History of Rome"} {
+		if _, err := store.Create(t.Context(), Book{Title: title, Author: "Mary Beard", Year: 2015}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	clusters, err := store.Duplicates(t.Context(), DuplicateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clusters) != 1 || len(clusters[0].Books) != 2 || clusters[0].Books[0].Author != "Mary Beard" {
+		t.Fatalf("clusters: %+v", clusters)
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	if _, err := store.Duplicates(ctx, DuplicateOptions{}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancelled: %v", err)
+	}
+}
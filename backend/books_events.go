@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type BookEventsAPI struct {
+	hub *EventHub
+
+	// Heartbeat is the interval between keep-alive comments.
+	Heartbeat time.Duration
+}
+
+func NewBookEventsAPI(hub *EventHub) *BookEventsAPI {
+	return &BookEventsAPI{hub: hub, Heartbeat: 15 * time.Second}
+}
+
+// StreamBookEventsHandler godoc
+// @Summary Stream book changes as Server-Sent Events
+// @Description Emits book.created, book.updated and book.deleted events of the caller's workspace whose data is a BookEvent. Reconnect with Last-Event-ID to resume; a "reset" event means the position is no longer in the log and the client must reload /books.
+// @Tags books
+// @Produce text/event-stream
+// @Param Last-Event-ID header int false "ID of the last event received"
+// @Param last_event_id query int false "Alternative to the Last-Event-ID header"
+// @Success 200 {object} BookEvent
+// @Router /books/events [get]
+func (api *BookEventsAPI) StreamBookEventsHandler(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+
+	lastID, _ := strconv.ParseInt(r.Header.Get("Last-Event-ID"), 10, 64)
+	if raw := r.URL.Query().Get("last_event_id"); raw != "" && lastID == 0 {
+		lastID, _ = strconv.ParseInt(raw, 10, 64)
+	}
+
+	workspace := workspaceFromContext(r.Context())
+	sub, replay, complete := api.hub.Subscribe(lastID)
+	defer api.hub.Unsubscribe(sub)
+
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Connection", "keep-alive")
+	h.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprint(w, "retry: 3000\n\n")
+	if !complete {
+		fmt.Fprint(w, "event: reset\ndata: {}\n\n")
+	}
+	for _, ev := range replay {
+		if ev.Book.WorkspaceID != workspace {
+			continue
+		}
+		if err := writeSSE(w, r, ev); err != nil {
+			return
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(api.Heartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case ev, ok := <-sub.C:
+			if !ok {
+				// Dropped for falling behind; the client reconnects and resumes.
+				return
+			}
+			if ev.Book.WorkspaceID != workspace {
+				continue
+			}
+			if err := writeSSE(w, r, ev); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func writeSSE(w http.ResponseWriter, r *http.Request, ev BookEvent) error {
+	data, err := json.Marshal(renderEvent(r, ev))
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.ID, ev.Type, data)
+	return err
+}
+
+// renderEvent is ev with its book in the request's version.
+func renderEvent(r *http.Request, ev BookEvent) any {
+	if apiVersionFromContext(r.Context()).Book == nil {
+		return ev
+	}
+	return struct {
+		BookEvent
+		Book any `json:"book"`
+	}{ev, renderBook(r, ev.Book)}
+}
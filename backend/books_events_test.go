@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type sseMessage struct {
+	id    string
+	event string
+	data  string
+}
+
+// readSSE parses messages from the stream until n have been read.
+func readSSE(t *testing.T, sc *bufio.Scanner, n int) []sseMessage {
+	t.Helper()
+	var (
+		out []sseMessage
+		cur sseMessage
+	)
+	for len(out) < n && sc.Scan() {
+		line := sc.Text()
+		switch {
+		case line == "":
+			if cur.event != "" || cur.data != "" {
+				out = append(out, cur)
+			}
+			cur = sseMessage{}
+		case strings.HasPrefix(line, "id: "):
+			cur.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			cur.event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			cur.data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+	if len(out) < n {
+		t.Fatalf("stream ended after %d messages: %v", len(out), sc.Err())
+	}
+	return out
+}
+
+func TestBookEvents_StreamAndResume(t *testing.T) {
+	_, db := setupTestRouter(t)
+	defer db.Close()
+
+	store := NewBookStore(db)
+	hub := NewEventHub(100)
+	store.OnCommit(hub.Publish)
+	api := NewBooksAPI(store)
+	events := NewBookEventsAPI(hub)
+
+	r := chi.NewRouter()
+	r.Post("/books", api.CreateBookHandler)
+	r.Delete("/books/{id}", api.DeleteBookHandler)
+	r.Get("/books/events", events.StreamBookEventsHandler)
+
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/books/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("content-type got %q", ct)
+	}
+	sc := bufio.NewScanner(resp.Body)
+
+	rr := doJSON(t, r, http.MethodPost, "/books", `{"title":"Dune","author":"Frank Herbert","year":1965}`)
+	created := decodeJSON[Book](t, rr)
+	doJSON(t, r, http.MethodDelete, "/books/"+strconv.FormatInt(created.ID, 10), ``)
+
+	msgs := readSSE(t, sc, 2)
+	if msgs[0].event != EventBookCreated || msgs[1].event != EventBookDeleted {
+		t.Fatalf("unexpected events: %+v", msgs)
+	}
+	var ev BookEvent
+	if err := json.Unmarshal([]byte(msgs[0].data), &ev); err != nil {
+		t.Fatal(err)
+	}
+	if ev.Book.ID != created.ID || strconv.FormatInt(ev.ID, 10) != msgs[0].id {
+		t.Fatalf("unexpected payload: %+v id=%s", ev, msgs[0].id)
+	}
+
+	// Resume after the first event: only the delete is replayed.
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/books/events", nil)
+	req.Header.Set("Last-Event-ID", msgs[0].id)
+	resp2, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp2.Body.Close()
+	replayed := readSSE(t, bufio.NewScanner(resp2.Body), 1)
+	if replayed[0].event != EventBookDeleted || replayed[0].id != msgs[1].id {
+		t.Fatalf("unexpected replay: %+v", replayed)
+	}
+}
+
+func TestEventHub_ResetWhenLogOverflowed(t *testing.T) {
+	hub := NewEventHub(2)
+	for i := int64(1); i <= 5; i++ {
+		hub.Publish(BookEvent{ID: i, Type: EventBookUpdated})
+	}
+
+	sub, replay, complete := hub.Subscribe(1)
+	defer hub.Unsubscribe(sub)
+	if complete {
+		t.Fatal("expected incomplete replay for an evicted position")
+	}
+	if len(replay) != 2 || replay[0].ID != 4 {
+		t.Fatalf("unexpected replay: %+v", replay)
+	}
+
+	sub2, replay, complete := hub.Subscribe(3)
+	defer hub.Unsubscribe(sub2)
+	if !complete || len(replay) != 2 {
+		t.Fatalf("complete=%v replay=%+v", complete, replay)
+	}
+}
+
+func TestEventHub_SlowSubscriberDoesNotBlockPublish(t *testing.T) {
+	hub := NewEventHub(10)
+	slow, _, _ := hub.Subscribe(0)
+
+	done := make(chan struct{})
+	go func() {
+		for i := int64(1); i <= subscriberBuffer*2; i++ {
+			hub.Publish(BookEvent{ID: i})
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("publish blocked on a slow subscriber")
+	}
+
+	n := 0
+	for range slow.C {
+		n++
+	}
+	if n != subscriberBuffer {
+		t.Fatalf("expected %d buffered events before drop, got %d", subscriberBuffer, n)
+	}
+	hub.Unsubscribe(slow) // closing twice is safe
+}
+
+func TestBookEvents_ConcurrentWritersPublishInOrder(t *testing.T) {
+	_, db := setupTestRouter(t)
+	defer db.Close()
+
+	store := NewBookStore(db)
+	var (
+		mu  sync.Mutex
+		ids []int64
+	)
+	store.OnCommit(func(ev BookEvent) {
+		// a slow listener lets a later commit overtake this one
+		if ev.ID%3 == 0 {
+			time.Sleep(2 * time.Millisecond)
+		}
+		mu.Lock()
+		ids = append(ids, ev.ID)
+		mu.Unlock()
+	})
+
+	var wg sync.WaitGroup
+	for i := range 30 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = store.Create(t.Context(), Book{Title: "Book " + strconv.Itoa(i), Author: "A", Year: 2000})
+		}()
+	}
+	wg.Wait()
+
+	if len(ids) == 0 {
+		t.Fatal("no events published")
+	}
+	for i := 1; i < len(ids); i++ {
+		if ids[i] <= ids[i-1] {
+			t.Fatalf("events published out of order: %v", ids)
+		}
+	}
+}
@@ -0,0 +1,518 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// bookFields are the Book fields ?fields= may name, with the column each is
+// read from and its value in a response. Locations has no column; it is
+// loaded only when selected, and sent as [] rather than left out.
+var bookFields = []struct {
+	name, column string
+	value        func(b Book) any
+}{
+	{"id", "id", func(b Book) any { return b.ID }},
+	{"title", "title", func(b Book) any { return b.Title }},
+	{"author", "author", func(b Book) any { return b.Author }},
+	{"year", "year", func(b Book) any { return b.Year }},
+	{"isbn", "isbn", func(b Book) any { return b.ISBN }},
+	{"created_at", "created_at", func(b Book) any { return b.CreatedAt }},
+	{"updated_at", "updated_at", func(b Book) any { return b.UpdatedAt }},
+	{"rating_avg", "rating_avg", func(b Book) any { return b.RatingAvg }},
+	{"rating_count", "rating_count", func(b Book) any { return b.RatingCount }},
+	{"status", "status", func(b Book) any { return b.Status }},
+	{"copies_total", "copies_total", func(b Book) any { return b.CopiesTotal }},
+	{"copies_available", "copies_available", func(b Book) any { return b.CopiesAvailable }},
+	{"locations", "", func(b Book) any {
+		if b.Locations == nil {
+			return []LocationCount{}
+		}
+		return b.Locations
+	}},
+	{"workspace_id", "workspace_id", func(b Book) any { return b.WorkspaceID }},
+}
+
+func bookFieldNames() []string {
+	names := make([]string, len(bookFields))
+	for i, f := range bookFields {
+		names[i] = f.name
+	}
+	return names
+}
+
+// Related resources ?expand= may load alongside books.
+const (
+	ExpandAuthorBooks = "author_books" // the author's other books in the workspace
+	ExpandMetadata    = "metadata"     // catalog metadata, null until enriched
+	ExpandStats       = "stats"        // review, loan and hold counts
+)
+
+var bookExpansions = []string{ExpandAuthorBooks, ExpandMetadata, ExpandStats}
+
+// BookSelection is the shape of a book read: the fields to return (nil for
+// all) and the related resources to expand.
+type BookSelection struct {
+	Fields []string
+	Expand []string
+}
+
+func (sel BookSelection) isZero() bool {
+	return sel.Fields == nil && len(sel.Expand) == 0
+}
+
+func (sel BookSelection) expands(name string) bool {
+	for _, e := range sel.Expand {
+		if e == name {
+			return true
+		}
+	}
+	return false
+}
+
+// parseBookSelection reads ?fields= and ?expand=, both comma-separated.
+func parseBookSelection(q url.Values) (BookSelection, error) {
+	var sel BookSelection
+	if raw := q.Get("fields"); raw != "" {
+		fields, ok := parseList(raw, bookFieldNames())
+		if !ok {
+			return sel, &ValidationError{Field: "fields", Code: "fields_invalid", Message: "fields must be a comma-separated list of: " + strings.Join(bookFieldNames(), ", ")}
+		}
+		sel.Fields = fields
+	}
+	if raw := q.Get("expand"); raw != "" {
+		expand, ok := parseList(raw, bookExpansions)
+		if !ok {
+			return sel, &ValidationError{Field: "expand", Code: "expand_invalid", Message: "expand must be a comma-separated list of: " + strings.Join(bookExpansions, ", ")}
+		}
+		sel.Expand = expand
+	}
+	return sel, nil
+}
+
+// parseList splits raw on commas, dropping duplicates, and reports whether
+// every item is one of allowed.
+func parseList(raw string, allowed []string) ([]string, bool) {
+	var out []string
+	seen := map[string]bool{}
+	for _, item := range strings.Split(raw, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" || seen[item] {
+			continue
+		}
+		found := false
+		for _, a := range allowed {
+			found = found || a == item
+		}
+		if !found {
+			return nil, false
+		}
+		seen[item] = true
+		out = append(out, item)
+	}
+	return out, len(out) > 0
+}
+
+// bookProjection is the SQL side of a selection: the columns to read and
+// whether to load locations. The id is always read, and so is the author
+// when author_books is expanded.
+type bookProjection struct {
+	columns   []string
+	locations bool
+}
+
+func projectBook(sel BookSelection) bookProjection {
+	if sel.Fields == nil {
+		p := bookProjection{locations: true}
+		for _, f := range bookFields {
+			if f.column != "" {
+				p.columns = append(p.columns, f.column)
+			}
+		}
+		return p
+	}
+
+	want := map[string]bool{"id": true}
+	for _, f := range sel.Fields {
+		want[f] = true
+	}
+	if sel.expands(ExpandAuthorBooks) {
+		want["author"] = true
+	}
+	var p bookProjection
+	for _, f := range bookFields {
+		switch {
+		case !want[f.name]:
+		case f.column == "":
+			p.locations = true
+		default:
+			p.columns = append(p.columns, f.column)
+		}
+	}
+	return p
+}
+
+func (p bookProjection) list() string {
+	return strings.Join(p.columns, ", ")
+}
+
+func (p bookProjection) scan(row rowScanner) (Book, error) {
+	var b Book
+	dest := make([]any, len(p.columns))
+	for i, c := range p.columns {
+		dest[i] = bookColumnDest(&b, c)
+	}
+	return b, row.Scan(dest...)
+}
+
+func bookColumnDest(b *Book, column string) any {
+	switch column {
+	case "id":
+		return &b.ID
+	case "title":
+		return &b.Title
+	case "author":
+		return &b.Author
+	case "year":
+		return &b.Year
+	case "isbn":
+		return &b.ISBN
+	case "created_at":
+		return &b.CreatedAt
+	case "updated_at":
+		return &b.UpdatedAt
+	case "rating_avg":
+		return &b.RatingAvg
+	case "rating_count":
+		return &b.RatingCount
+	case "status":
+		return &b.Status
+	case "copies_total":
+		return &b.CopiesTotal
+	case "copies_available":
+		return &b.CopiesAvailable
+	case "workspace_id":
+		return &b.WorkspaceID
+	}
+	panic("no book column " + column)
+}
+
+// Select is List reading only the columns sel needs.
+func (s *BookStore) Select(ctx context.Context, f BookFilter, sort string, sel BookSelection) ([]Book, error) {
+	order, ok := bookSortOrder[sort]
+	if !ok {
+		order = bookSortOrder[SortByID]
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	p := projectBook(sel)
+	where, args := f.where(workspaceFromContext(ctx))
+	rows, err := s.db.QueryContext(ctx, `SELECT `+p.list()+` FROM books WHERE `+where+` ORDER BY `+order, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []Book
+	for rows.Next() {
+		b, err := p.scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if !p.locations {
+		return out, nil
+	}
+	return out, loadLocations(ctx, s.db, out)
+}
+
+// GetSelected is Get reading only the columns sel needs.
+func (s *BookStore) GetSelected(ctx context.Context, id int64, sel BookSelection) (Book, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	p := projectBook(sel)
+	b, err := p.scan(s.db.QueryRowContext(ctx,
+		`SELECT `+p.list()+` FROM books WHERE id = ? AND workspace_id = ?`, id, workspaceFromContext(ctx)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Book{}, ErrNotFound
+	}
+	if err != nil {
+		return Book{}, err
+	}
+	books := []Book{b}
+	if p.locations {
+		if err := loadLocations(ctx, s.db, books); err != nil {
+			return Book{}, err
+		}
+	}
+	return books[0], nil
+}
+
+// BookSummary is a book listed inside another resource.
+type BookSummary struct {
+	ID    int64  `json:"id"`
+	Title string `json:"title"`
+	Year  int    `json:"year"`
+}
+
+// BookCounts are the per-book statistics of expand=stats.
+type BookCounts struct {
+	// Ratings counts reviews per rating, "1" to "5".
+	Ratings map[int]int `json:"ratings"`
+	Loans   int         `json:"loans"`
+	Holds   int         `json:"active_holds"`
+}
+
+// queryer is what the expansion loaders read through.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// expandBatch bounds the number of ids per expansion query.
+const expandBatch = 500
+
+// Expand loads the related resources named in expand for books, returning
+// them by book index. Each expansion costs a fixed number of queries per
+// expandBatch books, never one per book.
+func (s *BookStore) Expand(ctx context.Context, books []Book, expand []string) ([]map[string]any, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	return expandBooks(ctx, s.db, books, expand)
+}
+
+func expandBooks(ctx context.Context, q queryer, books []Book, expand []string) ([]map[string]any, error) {
+	out := make([]map[string]any, len(books))
+	for i := range out {
+		out[i] = map[string]any{}
+	}
+	for _, name := range expand {
+		var err error
+		switch name {
+		case ExpandAuthorBooks:
+			err = expandAuthorBooks(ctx, q, books, out)
+		case ExpandMetadata:
+			err = expandMetadata(ctx, q, books, out)
+		case ExpandStats:
+			err = expandStats(ctx, q, books, out)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// inBatches calls fn with IN-list placeholders and args for every
+// expandBatch of values.
+func inBatches(values []any, fn func(placeholders string, args []any) error) error {
+	for start := 0; start < len(values); start += expandBatch {
+		batch := values[start:min(start+expandBatch, len(values))]
+		if err := fn(strings.TrimSuffix(strings.Repeat("?,", len(batch)), ","), batch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// eachRow runs query and calls fn for every row.
+func eachRow(ctx context.Context, q queryer, query string, args []any, fn func(*sql.Rows) error) error {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := fn(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func bookIDArgs(books []Book) []any {
+	ids := make([]any, len(books))
+	for i, b := range books {
+		ids[i] = b.ID
+	}
+	return ids
+}
+
+func expandAuthorBooks(ctx context.Context, q queryer, books []Book, out []map[string]any) error {
+	var authors []any
+	seen := map[string]bool{}
+	for _, b := range books {
+		if !seen[b.Author] {
+			seen[b.Author] = true
+			authors = append(authors, b.Author)
+		}
+	}
+
+	byAuthor := map[string][]BookSummary{}
+	workspace := workspaceFromContext(ctx)
+	err := inBatches(authors, func(placeholders string, args []any) error {
+		return eachRow(ctx, q, `
+			SELECT id, title, year, author FROM books
+			WHERE workspace_id = ? AND author IN (`+placeholders+`) ORDER BY year, id`,
+			append([]any{workspace}, args...),
+			func(rows *sql.Rows) error {
+				var (
+					s      BookSummary
+					author string
+				)
+				if err := rows.Scan(&s.ID, &s.Title, &s.Year, &author); err != nil {
+					return err
+				}
+				byAuthor[author] = append(byAuthor[author], s)
+				return nil
+			})
+	})
+	if err != nil {
+		return err
+	}
+
+	for i, b := range books {
+		others := []BookSummary{}
+		for _, s := range byAuthor[b.Author] {
+			if s.ID != b.ID {
+				others = append(others, s)
+			}
+		}
+		out[i][ExpandAuthorBooks] = others
+	}
+	return nil
+}
+
+func expandMetadata(ctx context.Context, q queryer, books []Book, out []map[string]any) error {
+	found := map[int64]BookMetadata{}
+	err := inBatches(bookIDArgs(books), func(placeholders string, args []any) error {
+		return eachRow(ctx, q, `
+			SELECT book_id, source, isbn, publisher, page_count, subjects, description, cover_url, fetched_at
+			FROM book_metadata WHERE book_id IN (`+placeholders+`)`, args,
+			func(rows *sql.Rows) error {
+				var (
+					bm       BookMetadata
+					subjects string
+				)
+				if err := rows.Scan(&bm.BookID, &bm.Source, &bm.ISBN, &bm.Publisher, &bm.PageCount, &subjects, &bm.Description, &bm.CoverURL, &bm.FetchedAt); err != nil {
+					return err
+				}
+				if err := json.Unmarshal([]byte(subjects), &bm.Subjects); err != nil {
+					return err
+				}
+				found[bm.BookID] = bm
+				return nil
+			})
+	})
+	if err != nil {
+		return err
+	}
+
+	for i, b := range books {
+		if bm, ok := found[b.ID]; ok {
+			out[i][ExpandMetadata] = bm
+		} else {
+			out[i][ExpandMetadata] = nil
+		}
+	}
+	return nil
+}
+
+func expandStats(ctx context.Context, q queryer, books []Book, out []map[string]any) error {
+	counts := make(map[int64]*BookCounts, len(books))
+	for _, b := range books {
+		counts[b.ID] = &BookCounts{Ratings: map[int]int{1: 0, 2: 0, 3: 0, 4: 0, 5: 0}}
+	}
+
+	err := inBatches(bookIDArgs(books), func(placeholders string, args []any) error {
+		if err := eachRow(ctx, q, `
+			SELECT book_id, rating, COUNT(*) FROM reviews
+			WHERE book_id IN (`+placeholders+`) GROUP BY book_id, rating`, args,
+			func(rows *sql.Rows) error {
+				var id int64
+				var rating, n int
+				if err := rows.Scan(&id, &rating, &n); err != nil {
+					return err
+				}
+				counts[id].Ratings[rating] = n
+				return nil
+			}); err != nil {
+			return err
+		}
+		if err := eachRow(ctx, q, `
+			SELECT book_id, COUNT(*) FROM loans
+			WHERE book_id IN (`+placeholders+`) GROUP BY book_id`, args,
+			func(rows *sql.Rows) error {
+				var id int64
+				var n int
+				if err := rows.Scan(&id, &n); err != nil {
+					return err
+				}
+				counts[id].Loans = n
+				return nil
+			}); err != nil {
+			return err
+		}
+		return eachRow(ctx, q, `
+			SELECT book_id, COUNT(*) FROM holds
+			WHERE status IN ('waiting', 'ready') AND book_id IN (`+placeholders+`) GROUP BY book_id`, args,
+			func(rows *sql.Rows) error {
+				var id int64
+				var n int
+				if err := rows.Scan(&id, &n); err != nil {
+					return err
+				}
+				counts[id].Holds = n
+				return nil
+			})
+	})
+	if err != nil {
+		return err
+	}
+
+	for i, b := range books {
+		out[i][ExpandStats] = counts[b.ID]
+	}
+	return nil
+}
+
+// renderSelected renders b with only the selected fields, always including
+// the id, and adds its expansions. Without a selection it is renderBook.
+// Field names are those of Book; a version with its own representation gets
+// the book as loaded, rendered its way, under "book".
+func renderSelected(r *http.Request, b Book, sel BookSelection, expanded map[string]any) any {
+	if sel.isZero() {
+		return renderBook(r, b)
+	}
+
+	out := make(map[string]any, len(bookFields)+len(expanded))
+	if apiVersionFromContext(r.Context()).Book != nil {
+		out["book"] = renderBook(r, b)
+	} else {
+		want := map[string]bool{"id": true}
+		for _, f := range sel.Fields {
+			want[f] = true
+		}
+		for _, f := range bookFields {
+			if sel.Fields == nil || want[f.name] {
+				out[f.name] = f.value(b)
+			}
+		}
+	}
+	for k, e := range expanded {
+		out[k] = e
+	}
+	return out
+}
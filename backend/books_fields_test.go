@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func keysOf(m map[string]json.RawMessage) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestProjectBook_PushesFieldsIntoColumns(t *testing.T) {
+	cases := []struct {
+		sel       BookSelection
+		columns   string
+		locations bool
+	}{
+		{BookSelection{}, bookColumns, true},
+		{BookSelection{Fields: []string{"title"}}, "id, title", false},
+		{BookSelection{Fields: []string{"year", "id", "locations"}}, "id, year", true},
+		{BookSelection{Fields: []string{"title"}, Expand: []string{ExpandAuthorBooks}}, "id, title, author", false},
+		{BookSelection{Expand: []string{ExpandStats}}, bookColumns, true},
+	}
+	for _, tc := range cases {
+		p := projectBook(tc.sel)
+		if p.list() != tc.columns || p.locations != tc.locations {
+			t.Errorf("%+v: columns %q locations %v, want %q %v", tc.sel, p.list(), p.locations, tc.columns, tc.locations)
+		}
+	}
+}
+
+func TestBooks_SparseFieldsets(t *testing.T) {
+	r, db := setupTestRouter(t)
+	defer db.Close()
+
+	created := decodeJSON[Book](t, doJSON(t, r, http.MethodPost, "/books", `{"title":"Dune","author":"Frank Herbert","year":1965}`))
+	path := "/books/" + strconv.FormatInt(created.ID, 10)
+
+	one := decodeJSON[map[string]json.RawMessage](t, doJSON(t, r, http.MethodGet, path+"?fields=title,year", ""))
+	if got := keysOf(one); !reflect.DeepEqual(got, []string{"id", "title", "year"}) {
+		t.Fatalf("single keys = %v", got)
+	}
+	if string(one["title"]) != `"Dune"` {
+		t.Fatalf("title = %s", one["title"])
+	}
+
+	rr := doJSON(t, r, http.MethodGet, "/books?fields=author", "")
+	list := decodeJSON[[]map[string]json.RawMessage](t, rr)
+	if len(list) != 1 || !reflect.DeepEqual(keysOf(list[0]), []string{"author", "id"}) {
+		t.Fatalf("list = %s", rr.Body.String())
+	}
+	if rr.Header().Get("ETag") == "" {
+		t.Fatal("sparse list lost its ETag")
+	}
+
+	// without fields the full book comes back unchanged
+	if full := decodeJSON[map[string]json.RawMessage](t, doJSON(t, r, http.MethodGet, path, "")); len(full) < 10 {
+		t.Fatalf("full book keys = %v", keysOf(full))
+	}
+
+	rr = doJSON(t, r, http.MethodGet, "/books?fields=title,password", "")
+	if rr.Code != http.StatusBadRequest || decodeJSON[errorResponse](t, rr).Code != "fields_invalid" {
+		t.Fatalf("bad field: %d %s", rr.Code, rr.Body.String())
+	}
+	rr = doJSON(t, r, http.MethodGet, path+"?expand=reviews", "")
+	if rr.Code != http.StatusBadRequest || decodeJSON[errorResponse](t, rr).Code != "expand_invalid" {
+		t.Fatalf("bad expand: %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestBooks_SparseFieldsetsKeepEmptyLocations(t *testing.T) {
+	r, db := setupTestRouter(t)
+	defer db.Close()
+
+	created := decodeJSON[Book](t, doJSON(t, r, http.MethodPost, "/books", `{"title":"Dune","author":"Frank Herbert","year":1965}`))
+	path := "/books/" + strconv.FormatInt(created.ID, 10)
+
+	one := decodeJSON[map[string]json.RawMessage](t, doJSON(t, r, http.MethodGet, path+"?fields=title,locations", ""))
+	if string(one["locations"]) != "[]" {
+		t.Fatalf("single locations = %s", one["locations"])
+	}
+	list := decodeJSON[[]map[string]json.RawMessage](t, doJSON(t, r, http.MethodGet, "/books?fields=locations", ""))
+	if len(list) != 1 || string(list[0]["locations"]) != "[]" {
+		t.Fatalf("list = %v", list)
+	}
+
+	// expand alone keeps every field, typed as in the full book
+	one = decodeJSON[map[string]json.RawMessage](t, doJSON(t, r, http.MethodGet, path+"?expand=stats", ""))
+	if len(one) != len(bookFields)+1 || string(one["year"]) != "1965" || string(one["locations"]) != "[]" {
+		t.Fatalf("expanded keys = %v", keysOf(one))
+	}
+}
+
+func TestBooks_SparseFieldsetsInOtherVersions(t *testing.T) {
+	r, cleanup := setupVersions(t)
+	defer cleanup()
+
+	created := decodeJSON[Book](t, doJSON(t, r, http.MethodPost, "/v1/books", `{"title":"Dune","author":"Frank Herbert","year":1965}`))
+	rr := doJSON(t, r, http.MethodGet, "/v2/books/"+strconv.FormatInt(created.ID, 10)+"?fields=title", "")
+	got := decodeJSON[struct{ Book bookV2 }](t, rr)
+	if got.Book.Data.ID != created.ID || got.Book.Data.Title != "Dune" || got.Book.Data.Author.Name != "" {
+		t.Fatalf("v2 sparse book = %s", rr.Body.String())
+	}
+}
+
+func TestBooks_Expand(t *testing.T) {
+	r, db := setupTestRouter(t)
+	defer db.Close()
+
+	var ids []int64
+	for _, body := range []string{
+		`{"title":"Dune","author":"Frank Herbert","year":1965}`,
+		`{"title":"Dune Messiah","author":"Frank Herbert","year":1969}`,
+		`{"title":"Children of Dune","author":"Frank Herbert","year":1976}`,
+		`{"title":"Hyperion","author":"Dan Simmons","year":1989}`,
+	} {
+		ids = append(ids, decodeJSON[Book](t, doJSON(t, r, http.MethodPost, "/books", body)).ID)
+	}
+	now := time.Now().UTC()
+	for _, stmt := range []struct {
+		q    string
+		args []any
+	}{
+		{`INSERT INTO reviews(book_id, user, rating, text, created_at, updated_at) VALUES (?, 'alice', 5, '', ?, ?)`, []any{ids[0], now, now}},
+		{`INSERT INTO reviews(book_id, user, rating, text, created_at, updated_at) VALUES (?, 'bob', 3, '', ?, ?)`, []any{ids[0], now, now}},
+		{`INSERT INTO loans(book_id, user, checked_out_at, due_at, returned_at) VALUES (?, 'alice', ?, ?, ?)`, []any{ids[0], now, now, now}},
+		{`INSERT INTO holds(book_id, user, status, created_at) VALUES (?, 'carol', 'waiting', ?)`, []any{ids[0], now}},
+		{`INSERT INTO book_metadata(book_id, source, isbn, publisher, fetched_at) VALUES (?, 'openlibrary', '9780441172719', 'Ace', ?)`, []any{ids[0], now}},
+	} {
+		if _, err := db.Exec(stmt.q, stmt.args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	type expandedBook struct {
+		ID          int64         `json:"id"`
+		Title       string        `json:"title"`
+		Author      *string       `json:"author"`
+		AuthorBooks []BookSummary `json:"author_books"`
+		Metadata    *BookMetadata `json:"metadata"`
+		Stats       *BookCounts   `json:"stats"`
+	}
+
+	rr := doJSON(t, r, http.MethodGet, "/books?fields=title&expand=author_books,metadata,stats", "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status %d %s", rr.Code, rr.Body.String())
+	}
+	if rr.Header().Get("ETag") != "" {
+		t.Fatal("expanded list must not be validated by the books version")
+	}
+	list := decodeJSON[[]expandedBook](t, rr)
+	if len(list) != 4 {
+		t.Fatalf("got %d books", len(list))
+	}
+
+	dune := list[0]
+	if dune.Author != nil {
+		t.Fatal("author was read for the expansion but not selected")
+	}
+	if len(dune.AuthorBooks) != 2 || dune.AuthorBooks[0].Title != "Dune Messiah" || dune.AuthorBooks[1].Title != "Children of Dune" {
+		t.Fatalf("author_books = %+v", dune.AuthorBooks)
+	}
+	if dune.Metadata == nil || dune.Metadata.Publisher != "Ace" {
+		t.Fatalf("metadata = %+v", dune.Metadata)
+	}
+	want := BookCounts{Ratings: map[int]int{1: 0, 2: 0, 3: 1, 4: 0, 5: 1}, Loans: 1, Holds: 1}
+	if dune.Stats == nil || !reflect.DeepEqual(*dune.Stats, want) {
+		t.Fatalf("stats = %+v", dune.Stats)
+	}
+
+	hyperion := list[3]
+	if hyperion.AuthorBooks == nil || len(hyperion.AuthorBooks) != 0 || hyperion.Metadata != nil || hyperion.Stats.Loans != 0 {
+		t.Fatalf("hyperion = %+v", hyperion)
+	}
+
+	one := decodeJSON[expandedBook](t, doJSON(t, r, http.MethodGet, "/books/"+strconv.FormatInt(ids[1], 10)+"?expand=author_books", ""))
+	if one.Author == nil || *one.Author != "Frank Herbert" || len(one.AuthorBooks) != 2 {
+		t.Fatalf("single = %+v", one)
+	}
+}
+
+// countingQueryer counts the queries run through it.
+type countingQueryer struct {
+	db *sql.DB
+	n  int
+}
+
+func (c *countingQueryer) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	c.n++
+	return c.db.QueryContext(ctx, query, args...)
+}
+
+func TestExpandBooks_QueriesDoNotGrowWithBooks(t *testing.T) {
+	r, db := setupTestRouter(t)
+	defer db.Close()
+
+	var books []Book
+	for i := 0; i < 40; i++ {
+		body := `{"title":"Book ` + strconv.Itoa(i) + `","author":"Author ` + strconv.Itoa(i%7) + `","year":2000}`
+		books = append(books, decodeJSON[Book](t, doJSON(t, r, http.MethodPost, "/books", body)))
+	}
+
+	all := []string{ExpandAuthorBooks, ExpandMetadata, ExpandStats}
+	for _, n := range []int{1, 40} {
+		q := &countingQueryer{db: db}
+		if _, err := expandBooks(context.Background(), q, books[:n], all); err != nil {
+			t.Fatal(err)
+		}
+		// author_books 1, metadata 1, stats 3
+		if q.n != 5 {
+			t.Fatalf("%d books took %d queries", n, q.n)
+		}
+	}
+}
@@ -1,60 +1,162 @@
 package main
 
 import (
-	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 )
 
 type BooksAPI struct {
 	store *BookStore
+
+	// CacheControl is sent with book reads; empty omits the header.
+	CacheControl string
 }
 
 func NewBooksAPI(store *BookStore) *BooksAPI {
-	return &BooksAPI{store: store}
+	return &BooksAPI{store: store, CacheControl: defaultCacheControl}
+}
+
+type bookExistsResponse struct {
+	Error    string `json:"error"`
+	Code     string `json:"code"`
+	Existing Book   `json:"existing"`
+}
+
+// writeBookExists answers 409 for an ISBN already held by existing.
+func writeBookExists(w http.ResponseWriter, r *http.Request, existing Book) {
+	e := localizeCode(w, r, "isbn_exists", nil)
+	if apiVersionFromContext(r.Context()).Book == nil {
+		writeJSON(w, http.StatusConflict, bookExistsResponse{Error: e.Error, Code: e.Code, Existing: existing})
+		return
+	}
+	writeJSON(w, http.StatusConflict, map[string]any{"error": e.Error, "code": e.Code, "existing": renderBook(r, existing)})
 }
 
 // GetBooksHandler godoc
 // @Summary List all books
 // @Tags books
 // @Produce json
+// @Param min_rating query number false "Only books with reviews averaging at least this (1-5)"
+// @Param sort query string false "id (default) or rating (best rated first)" Enums(id, rating)
+// @Param fields query string false "Comma-separated fields to return, e.g. id,title; the id is always included"
+// @Param expand query string false "Comma-separated related resources: author_books, metadata, stats"
+// @Param If-None-Match header string false "ETag from a previous response"
+// @Param If-Modified-Since header string false "Last-Modified from a previous response"
 // @Success 200 {array} Book
+// @Success 304 "Not Modified"
+// @Failure 400 {object} errorResponse
 // @Failure 500 {object} errorResponse
 // @Router /books [get]
 func (api *BooksAPI) GetBooksHandler(w http.ResponseWriter, r *http.Request) {
-	books, err := api.store.List(r.Context())
+	q := r.URL.Query()
+	var filter BookFilter
+	if raw := q.Get("min_rating"); raw != "" {
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil || v < 1 || v > 5 {
+			writeError(w, r, http.StatusBadRequest, "min_rating_invalid")
+			return
+		}
+		filter.MinRating = v
+	}
+	sort := q.Get("sort")
+	if sort != "" && !isValidBookSort(sort) {
+		writeError(w, r, http.StatusBadRequest, "sort_invalid")
+		return
+	}
+	sel, err := parseBookSelection(q)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, errorCode(err))
+		return
+	}
+
+	version, modified, err := api.store.ListVersion(r.Context())
+	if err != nil {
+		loggerFrom(r.Context()).Error("books version", "err", err)
+		writeError(w, r, http.StatusInternalServerError, "internal_error")
+		return
+	}
+
+	// Expansions read other tables, which the books version does not cover.
+	if len(sel.Expand) == 0 {
+		etag := collectionETag(versionedName(r, "books-"+strconv.FormatInt(workspaceFromContext(r.Context()), 10)), version, r.URL.RawQuery)
+		setValidators(w, etag, modified, api.CacheControl)
+		if notModified(r, etag, modified) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	books, err := api.store.Select(r.Context(), filter, sort, sel)
 	if err != nil {
-		writeJSON(w, http.StatusInternalServerError, errorResponse{Error: "internal error"})
+		loggerFrom(r.Context()).Error("list books", "err", err)
+		writeError(w, r, http.StatusInternalServerError, "internal_error")
 		return
 	}
-	writeJSON(w, http.StatusOK, books)
+	if sel.isZero() {
+		writeJSON(w, http.StatusOK, renderBooks(r, books))
+		return
+	}
+	expanded, err := api.store.Expand(r.Context(), books, sel.Expand)
+	if err != nil {
+		loggerFrom(r.Context()).Error("expand books", "err", err)
+		writeError(w, r, http.StatusInternalServerError, "internal_error")
+		return
+	}
+	out := make([]any, len(books))
+	for i, b := range books {
+		out[i] = renderSelected(r, b, sel, expanded[i])
+	}
+	writeJSON(w, http.StatusOK, out)
 }
 
 // CreateBookHandler godoc
 // @Summary Create a new book
+// @Description A duplicate ISBN answers 409 with the book that has it under existing.
 // @Tags books
 // @Accept json
 // @Produce json
 // @Param book body Book true "Book"
+// @Param Idempotency-Key header string false "Retry-safe key; a repeat with the same body replays the first response"
 // @Success 201 {object} Book
 // @Failure 400 {object} errorResponse
+// @Failure 403 {object} errorResponse
+// @Failure 409 {object} bookExistsResponse
+// @Failure 413 {object} errorResponse
+// @Failure 415 {object} errorResponse
+// @Failure 422 {object} errorResponse
 // @Failure 500 {object} errorResponse
 // @Router /books [post]
 func (api *BooksAPI) CreateBookHandler(w http.ResponseWriter, r *http.Request) {
 	var b Book
-	if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
-		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "invalid JSON body"})
+	if !readJSON(w, r, &b) {
 		return
 	}
 
 	created, err := api.store.Create(r.Context(), b)
+	var dup *DuplicateISBNError
+	if errors.As(err, &dup) {
+		writeBookExists(w, r, dup.Existing)
+		return
+	}
+	if err == ErrBookQuota {
+		writeError(w, r, http.StatusForbidden, "book_quota_reached")
+		return
+	}
+	var verr *ValidationError
+	if errors.As(err, &verr) {
+		writeError(w, r, http.StatusBadRequest, verr.Code)
+		return
+	}
 	if err != nil {
-		writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+		loggerFrom(r.Context()).Error("create book", "err", err)
+		writeError(w, r, http.StatusInternalServerError, "internal_error")
 		return
 	}
-	writeJSON(w, http.StatusCreated, created)
+	writeJSON(w, http.StatusCreated, renderBook(r, created))
 }
 
 // GetBookHandler godoc
@@ -62,7 +164,12 @@ func (api *BooksAPI) CreateBookHandler(w http.ResponseWriter, r *http.Request) {
 // @Tags books
 // @Produce json
 // @Param id path int true "Book ID"
+// @Param fields query string false "Comma-separated fields to return, e.g. id,title; the id is always included"
+// @Param expand query string false "Comma-separated related resources: author_books, metadata, stats"
+// @Param If-None-Match header string false "ETag from a previous response"
+// @Param If-Modified-Since header string false "Last-Modified from a previous response"
 // @Success 200 {object} Book
+// @Success 304 "Not Modified"
 // @Failure 400 {object} errorResponse
 // @Failure 404 {object} errorResponse
 // @Failure 500 {object} errorResponse
@@ -72,16 +179,67 @@ func (api *BooksAPI) GetBookHandler(w http.ResponseWriter, r *http.Request) {
 	if !ok {
 		return
 	}
-	b, err := api.store.Get(r.Context(), id)
+	sel, err := parseBookSelection(r.URL.Query())
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, errorCode(err))
+		return
+	}
+	b, err := api.store.GetSelected(r.Context(), id, sel)
 	if err == ErrNotFound {
-		writeJSON(w, http.StatusNotFound, errorResponse{Error: "book not found"})
+		writeError(w, r, http.StatusNotFound, "book_not_found")
 		return
 	}
 	if err != nil {
-		writeJSON(w, http.StatusInternalServerError, errorResponse{Error: "internal error"})
+		loggerFrom(r.Context()).Error("get book", "book_id", id, "err", err)
+		writeError(w, r, http.StatusInternalServerError, "internal_error")
 		return
 	}
-	writeJSON(w, http.StatusOK, b)
+	var expanded map[string]any
+	modified := b.UpdatedAt
+	if len(sel.Expand) > 0 {
+		all, err := api.store.Expand(r.Context(), []Book{b}, sel.Expand)
+		if err != nil {
+			loggerFrom(r.Context()).Error("expand book", "book_id", id, "err", err)
+			writeError(w, r, http.StatusInternalServerError, "internal_error")
+			return
+		}
+		// Expanded resources change without touching the book, so only the
+		// body-derived ETag validates them.
+		expanded, modified = all[0], time.Time{}
+	}
+	writeCachedJSON(w, r, modified, api.CacheControl, renderSelected(r, b, sel, expanded))
+}
+
+// GetBookByISBNHandler godoc
+// @Summary Get a book by ISBN
+// @Tags books
+// @Produce json
+// @Param isbn path string true "ISBN-10 or ISBN-13, hyphens allowed"
+// @Param If-None-Match header string false "ETag from a previous response"
+// @Param If-Modified-Since header string false "Last-Modified from a previous response"
+// @Success 200 {object} Book
+// @Success 304 "Not Modified"
+// @Failure 400 {object} errorResponse
+// @Failure 404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /books/by-isbn/{isbn} [get]
+func (api *BooksAPI) GetBookByISBNHandler(w http.ResponseWriter, r *http.Request) {
+	b, err := api.store.GetByISBN(r.Context(), chi.URLParam(r, "isbn"))
+	var verr *ValidationError
+	if errors.As(err, &verr) {
+		writeError(w, r, http.StatusBadRequest, verr.Code)
+		return
+	}
+	if err == ErrNotFound {
+		writeError(w, r, http.StatusNotFound, "book_not_found")
+		return
+	}
+	if err != nil {
+		loggerFrom(r.Context()).Error("get book by isbn", "err", err)
+		writeError(w, r, http.StatusInternalServerError, "internal_error")
+		return
+	}
+	writeCachedJSON(w, r, b.UpdatedAt, api.CacheControl, renderBook(r, b))
 }
 
 // UpdateBookHandler godoc
@@ -94,6 +252,9 @@ func (api *BooksAPI) GetBookHandler(w http.ResponseWriter, r *http.Request) {
 // @Success 200 {object} Book
 // @Failure 400 {object} errorResponse
 // @Failure 404 {object} errorResponse
+// @Failure 409 {object} bookExistsResponse
+// @Failure 413 {object} errorResponse
+// @Failure 415 {object} errorResponse
 // @Failure 500 {object} errorResponse
 // @Router /books/{id} [put]
 func (api *BooksAPI) UpdateBookHandler(w http.ResponseWriter, r *http.Request) {
@@ -103,21 +264,31 @@ func (api *BooksAPI) UpdateBookHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var b Book
-	if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
-		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "invalid JSON body"})
+	if !readJSON(w, r, &b) {
 		return
 	}
 
 	updated, err := api.store.Update(r.Context(), id, b)
 	if err == ErrNotFound {
-		writeJSON(w, http.StatusNotFound, errorResponse{Error: "book not found"})
+		writeError(w, r, http.StatusNotFound, "book_not_found")
+		return
+	}
+	var dup *DuplicateISBNError
+	if errors.As(err, &dup) {
+		writeBookExists(w, r, dup.Existing)
+		return
+	}
+	var verr *ValidationError
+	if errors.As(err, &verr) {
+		writeError(w, r, http.StatusBadRequest, verr.Code)
 		return
 	}
 	if err != nil {
-		writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+		loggerFrom(r.Context()).Error("update book", "book_id", id, "err", err)
+		writeError(w, r, http.StatusInternalServerError, "internal_error")
 		return
 	}
-	writeJSON(w, http.StatusOK, updated)
+	writeJSON(w, http.StatusOK, renderBook(r, updated))
 }
 
 // DeleteBookHandler godoc
@@ -136,21 +307,203 @@ func (api *BooksAPI) DeleteBookHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	err := api.store.Delete(r.Context(), id)
 	if err == ErrNotFound {
-		writeJSON(w, http.StatusNotFound, errorResponse{Error: "book not found"})
+		writeError(w, r, http.StatusNotFound, "book_not_found")
 		return
 	}
 	if err != nil {
-		writeJSON(w, http.StatusInternalServerError, errorResponse{Error: "internal error"})
+		loggerFrom(r.Context()).Error("delete book", "book_id", id, "err", err)
+		writeError(w, r, http.StatusInternalServerError, "internal_error")
 		return
 	}
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// StatsHandler godoc
+// @Summary Catalog statistics
+// @Description Totals, the most prolific authors, a publication histogram and how many of today's books were added per period. Cached until the next write.
+// @Tags books
+// @Produce json
+// @Param top query int false "Authors in top_authors (default 10, max 100)"
+// @Param by query string false "Publication buckets: decade (default) or year" Enums(decade, year)
+// @Param interval query string false "Addition periods: day, week (from Monday) or month (default)" Enums(day, week, month)
+// @Param If-None-Match header string false "ETag from a previous response"
+// @Success 200 {object} CatalogStats
+// @Success 304 "Not Modified"
+// @Failure 400 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /stats [get]
+func (api *BooksAPI) StatsHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	opts := StatsOptions{Publication: q.Get("by"), Additions: q.Get("interval")}
+	if raw := q.Get("top"); raw != "" {
+		v, err := strconv.Atoi(raw)
+		if err != nil || v < 1 {
+			writeError(w, r, http.StatusBadRequest, "top_range")
+			return
+		}
+		opts.TopAuthors = v
+	}
+
+	st, err := api.store.Stats(r.Context(), opts)
+	var verr *ValidationError
+	if errors.As(err, &verr) {
+		writeError(w, r, http.StatusBadRequest, verr.Code)
+		return
+	}
+	if err != nil {
+		loggerFrom(r.Context()).Error("catalog stats", "err", err)
+		writeError(w, r, http.StatusInternalServerError, "internal_error")
+		return
+	}
+
+	etag := collectionETag("stats-"+strconv.FormatInt(workspaceFromContext(r.Context()), 10), st.Version, r.URL.RawQuery)
+	setValidators(w, etag, st.Modified, api.CacheControl)
+	if notModified(r, etag, st.Modified) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	writeJSON(w, http.StatusOK, st)
+}
+
+// DuplicatesHandler godoc
+// @Summary Find probable duplicate books
+// @Description Groups books whose titles and authors match after normalization: case, punctuation, articles ("Hobbit, The") and word order are ignored, initials are dropped from authors, and the rest is compared by edit distance. Each cluster's score is its weakest link, from 0 to 1.
+// @Tags books
+// @Produce json
+// @Param min_score query number false "Lowest score that links two books (default 0.85)"
+// @Param limit query int false "Clusters returned, best first (default and max 100)"
+// @Success 200 {array} DuplicateCluster
+// @Failure 400 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /books/duplicates [get]
+func (api *BooksAPI) DuplicatesHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var opts DuplicateOptions
+	if raw := q.Get("min_score"); raw != "" {
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil || v <= 0 || v > 1 {
+			writeError(w, r, http.StatusBadRequest, "min_score_invalid")
+			return
+		}
+		opts.MinScore = v
+	}
+	if raw := q.Get("limit"); raw != "" {
+		v, err := strconv.Atoi(raw)
+		if err != nil || v < 1 || v > maxDuplicateClusters {
+			writeError(w, r, http.StatusBadRequest, "limit_range")
+			return
+		}
+		opts.Limit = v
+	}
+
+	clusters, err := api.store.Duplicates(r.Context(), opts)
+	var verr *ValidationError
+	if errors.As(err, &verr) {
+		writeError(w, r, http.StatusBadRequest, verr.Code)
+		return
+	}
+	if err != nil {
+		loggerFrom(r.Context()).Error("find duplicates", "err", err)
+		writeError(w, r, http.StatusInternalServerError, "internal_error")
+		return
+	}
+	writeJSON(w, http.StatusOK, renderDuplicates(r, clusters))
+}
+
+// renderDuplicates is clusters with their books in the request's version.
+func renderDuplicates(r *http.Request, clusters []DuplicateCluster) any {
+	if apiVersionFromContext(r.Context()).Book == nil {
+		return clusters
+	}
+	type cluster struct {
+		Score float64 `json:"score"`
+		Books any     `json:"books"`
+	}
+	out := make([]cluster, len(clusters))
+	for i, c := range clusters {
+		out[i] = cluster{Score: c.Score, Books: renderBooks(r, c.Books)}
+	}
+	return out
+}
+
+// MergeBooksHandler godoc
+// @Summary Merge duplicate books into one
+// @Description Keeps survivor_id (or the book with the most copies, then reviews, then the oldest) and moves the reviews, loans, copies, holds and metadata of the others onto it before deleting them, in one transaction. A missing ISBN is taken from a merged book and a fuller form of the author's name wins; title, author, year and isbn override the result.
+// @Tags books
+// @Accept json
+// @Produce json
+// @Param merge body MergeRequest true "Books to merge"
+// @Success 200 {object} MergeResult
+// @Failure 400 {object} errorResponse
+// @Failure 404 {object} errorResponse
+// @Failure 409 {object} bookExistsResponse
+// @Failure 500 {object} errorResponse
+// @Router /books/merge [post]
+func (api *BooksAPI) MergeBooksHandler(w http.ResponseWriter, r *http.Request) {
+	var req MergeRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	res, err := api.store.Merge(r.Context(), req)
+	var (
+		verr *ValidationError
+		dup  *DuplicateISBNError
+	)
+	switch {
+	case err == nil:
+		writeJSON(w, http.StatusOK, renderMerge(r, res))
+	case errors.As(err, &verr):
+		writeError(w, r, http.StatusBadRequest, verr.Code)
+	case errors.As(err, &dup):
+		writeBookExists(w, r, dup.Existing)
+	case err == ErrNotFound:
+		writeError(w, r, http.StatusNotFound, "book_not_found")
+	default:
+		loggerFrom(r.Context()).Error("merge books", "err", err)
+		writeError(w, r, http.StatusInternalServerError, "internal_error")
+	}
+}
+
+// renderMerge is res with the surviving book in the request's version.
+func renderMerge(r *http.Request, res MergeResult) any {
+	if apiVersionFromContext(r.Context()).Book == nil {
+		return res
+	}
+	return struct {
+		MergeResult
+		Book any `json:"book"`
+	}{res, renderBook(r, res.Book)}
+}
+
+// RequireBook answers 404 for sub-resources of a book outside the caller's
+// workspace, so reviews, copies, holds and loans of other tenants cannot be
+// reached by guessing ids.
+func (api *BooksAPI) RequireBook(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, ok := parseIDParam(w, r, "id")
+		if !ok {
+			return
+		}
+		err := api.store.Exists(r.Context(), id)
+		if err == ErrNotFound {
+			writeError(w, r, http.StatusNotFound, "book_not_found")
+			return
+		}
+		if err != nil {
+			loggerFrom(r.Context()).Error("check book", "book_id", id, "err", err)
+			writeError(w, r, http.StatusInternalServerError, "internal_error")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 func parseIDParam(w http.ResponseWriter, r *http.Request, name string) (int64, bool) {
 	raw := chi.URLParam(r, name)
 	id, err := strconv.ParseInt(raw, 10, 64)
 	if err != nil || id <= 0 {
-		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "invalid id"})
+		writeError(w, r, http.StatusBadRequest, "invalid_id")
 		return 0, false
 	}
 	return id, true
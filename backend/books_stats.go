@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Buckets for the publication histogram and the additions timeline.
+const (
+	StatsByDecade = "decade"
+	StatsByYear   = "year"
+
+	StatsPerDay   = "day"
+	StatsPerWeek  = "week"
+	StatsPerMonth = "month"
+
+	defaultTopAuthors = 10
+	maxTopAuthors     = 100
+	maxCachedStats    = 64
+)
+
+var (
+	publicationBuckets = map[string]string{
+		StatsByDecade: `(year / 10) * 10`,
+		StatsByYear:   `year`,
+	}
+	// Weeks start on Monday.
+	additionBuckets = map[string]string{
+		StatsPerDay:   `date(created_at)`,
+		StatsPerWeek:  `date(created_at, '-6 days', 'weekday 1')`,
+		StatsPerMonth: `strftime('%Y-%m', created_at)`,
+	}
+)
+
+// StatsOptions shape the catalog statistics.
+type StatsOptions struct {
+	TopAuthors  int    // authors listed in TopAuthors
+	Publication string // StatsByDecade or StatsByYear
+	Additions   string // StatsPerDay, StatsPerWeek or StatsPerMonth
+
+	workspace int64 // set by Stats, so cache entries are per workspace
+}
+
+func (o StatsOptions) withDefaults() StatsOptions {
+	if o.TopAuthors <= 0 {
+		o.TopAuthors = defaultTopAuthors
+	}
+	if o.Publication == "" {
+		o.Publication = StatsByDecade
+	}
+	if o.Additions == "" {
+		o.Additions = StatsPerMonth
+	}
+	return o
+}
+
+func validateStatsOptions(o StatsOptions) error {
+	if o.TopAuthors > maxTopAuthors {
+		return &ValidationError{Field: "top", Code: "top_range", Message: "top must be between 1 and 100"}
+	}
+	if _, ok := publicationBuckets[o.Publication]; !ok {
+		return &ValidationError{Field: "by", Code: "by_invalid", Message: "by must be one of: decade, year"}
+	}
+	if _, ok := additionBuckets[o.Additions]; !ok {
+		return &ValidationError{Field: "interval", Code: "interval_invalid", Message: "interval must be one of: day, week, month"}
+	}
+	return nil
+}
+
+// AuthorCount is how many books an author has in the catalog. Authors are
+// grouped case-insensitively.
+type AuthorCount struct {
+	Author string `json:"author"`
+	Books  int    `json:"books"`
+}
+
+// YearCount is a publication histogram bucket. Year is the first year of the
+// decade when grouping by decade.
+type YearCount struct {
+	Year  int `json:"year"`
+	Books int `json:"books"`
+}
+
+// PeriodCount is how many of today's books were added in a day, week
+// (starting Monday) or month.
+type PeriodCount struct {
+	Period string `json:"period"`
+	Books  int    `json:"books"`
+}
+
+// CatalogStats are aggregate figures over all books of a workspace.
+type CatalogStats struct {
+	TotalBooks   int           `json:"total_books"`
+	TotalAuthors int           `json:"total_authors"`
+	TopAuthors   []AuthorCount `json:"top_authors"`
+	Publication  []YearCount   `json:"publication"`
+	Additions    []PeriodCount `json:"additions"`
+	Version      int64         `json:"-"`
+	Modified     time.Time     `json:"-"`
+}
+
+// statsCache holds computed statistics for one version of the books table.
+// Every write bumps the version, which empties the cache.
+type statsCache struct {
+	mu      sync.Mutex
+	version int64
+	entries map[StatsOptions]CatalogStats
+}
+
+func (c *statsCache) get(version int64, o StatsOptions) (CatalogStats, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.version != version {
+		return CatalogStats{}, false
+	}
+	st, ok := c.entries[o]
+	return st, ok
+}
+
+func (c *statsCache) put(o StatsOptions, st CatalogStats) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil || c.version != st.Version || len(c.entries) >= maxCachedStats {
+		c.version = st.Version
+		c.entries = map[StatsOptions]CatalogStats{}
+	}
+	c.entries[o] = st
+}
+
+func (c *statsCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = nil
+}
+
+// Stats returns aggregate counts over the catalog. Results are cached until
+// the books change counter moves, so repeated reads cost one lookup.
+func (s *BookStore) Stats(ctx context.Context, o StatsOptions) (CatalogStats, error) {
+	o = o.withDefaults()
+	if err := validateStatsOptions(o); err != nil {
+		return CatalogStats{}, err
+	}
+	o.workspace = workspaceFromContext(ctx)
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	// One transaction, so the version matches the figures computed with it.
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return CatalogStats{}, err
+	}
+	defer tx.Rollback()
+
+	var st CatalogStats
+	if err := tx.QueryRowContext(ctx, `SELECT version, updated_at FROM change_counters WHERE name = 'books'`).
+		Scan(&st.Version, &st.Modified); err != nil {
+		return CatalogStats{}, err
+	}
+	if cached, ok := s.stats.get(st.Version, o); ok {
+		return cached, nil
+	}
+
+	if err := tx.QueryRowContext(ctx,
+		`SELECT COUNT(*), COUNT(DISTINCT lower(trim(author))) FROM books WHERE workspace_id = ?`, o.workspace,
+	).Scan(&st.TotalBooks, &st.TotalAuthors); err != nil {
+		return CatalogStats{}, err
+	}
+
+	st.TopAuthors = []AuthorCount{}
+	rows, err := tx.QueryContext(ctx, `
+		SELECT MIN(trim(author)), COUNT(*) AS n FROM books WHERE workspace_id = ?
+		GROUP BY lower(trim(author))
+		ORDER BY n DESC, lower(trim(author)) ASC
+		LIMIT ?`, o.workspace, o.TopAuthors)
+	if err != nil {
+		return CatalogStats{}, err
+	}
+	for rows.Next() {
+		var a AuthorCount
+		if err := rows.Scan(&a.Author, &a.Books); err != nil {
+			rows.Close()
+			return CatalogStats{}, err
+		}
+		st.TopAuthors = append(st.TopAuthors, a)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return CatalogStats{}, err
+	}
+
+	st.Publication = []YearCount{}
+	rows, err = tx.QueryContext(ctx, `
+		SELECT `+publicationBuckets[o.Publication]+` AS bucket, COUNT(*) FROM books WHERE workspace_id = ?
+		GROUP BY bucket ORDER BY bucket`, o.workspace)
+	if err != nil {
+		return CatalogStats{}, err
+	}
+	for rows.Next() {
+		var y YearCount
+		if err := rows.Scan(&y.Year, &y.Books); err != nil {
+			rows.Close()
+			return CatalogStats{}, err
+		}
+		st.Publication = append(st.Publication, y)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return CatalogStats{}, err
+	}
+
+	st.Additions = []PeriodCount{}
+	rows, err = tx.QueryContext(ctx, `
+		SELECT `+additionBuckets[o.Additions]+` AS bucket, COUNT(*) FROM books WHERE workspace_id = ?
+		GROUP BY bucket ORDER BY bucket`, o.workspace)
+	if err != nil {
+		return CatalogStats{}, err
+	}
+	for rows.Next() {
+		var p PeriodCount
+		if err := rows.Scan(&p.Period, &p.Books); err != nil {
+			rows.Close()
+			return CatalogStats{}, err
+		}
+		st.Additions = append(st.Additions, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return CatalogStats{}, err
+	}
+
+	s.stats.put(o, st)
+	return st, nil
+}
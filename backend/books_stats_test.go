@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStats_Aggregates(t *testing.T) {
+	r, db := setupTestRouter(t)
+	defer db.Close()
+	store := NewBookStore(db)
+	r.Get("/stats", NewBooksAPI(store).StatsHandler)
+
+	for _, body := range []string{
+		`{"title":"Dune","author":"Frank Herbert","year":1965}`,
+		`{"title":"Dune Messiah","author":"frank herbert","year":1969}`,
+		`{"title":"Children of Dune","author":"Frank Herbert ","year":1976}`,
+		`{"title":"Emma","author":"Jane Austen","year":1815}`,
+		`{"title":"Persuasion","author":"Jane Austen","year":1817}`,
+		`{"title":"Neuromancer","author":"William Gibson","year":1984}`,
+	} {
+		if rr := doJSON(t, r, http.MethodPost, "/books", body); rr.Code != http.StatusCreated {
+			t.Fatalf("create status %d", rr.Code)
+		}
+	}
+	for id, created := range map[int]string{
+		1: "2026-01-05 10:00:00+00:00", 2: "2026-01-06 10:00:00+00:00", 3: "2026-01-12 10:00:00+00:00",
+		4: "2026-02-01 10:00:00+00:00", 5: "2026-02-01 11:00:00+00:00", 6: "2026-03-31 23:59:59+00:00",
+	} {
+		if _, err := db.Exec(`UPDATE books SET created_at = ? WHERE id = ?`, created, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	st := decodeJSON[CatalogStats](t, doJSON(t, r, http.MethodGet, "/stats?top=2", ``))
+	if st.TotalBooks != 6 || st.TotalAuthors != 3 {
+		t.Fatalf("totals: %+v", st)
+	}
+	if got := fmt.Sprint(st.TopAuthors); got != "[{Frank Herbert 3} {Jane Austen 2}]" {
+		t.Fatalf("top authors %s", got)
+	}
+	if got := fmt.Sprint(st.Publication); got != "[{1810 2} {1960 2} {1970 1} {1980 1}]" {
+		t.Fatalf("decades %s", got)
+	}
+	if got := fmt.Sprint(st.Additions); got != "[{2026-01 3} {2026-02 2} {2026-03 1}]" {
+		t.Fatalf("months %s", got)
+	}
+
+	st = decodeJSON[CatalogStats](t, doJSON(t, r, http.MethodGet, "/stats?by=year&interval=week", ``))
+	if got := fmt.Sprint(st.Publication); got != "[{1815 1} {1817 1} {1965 1} {1969 1} {1976 1} {1984 1}]" {
+		t.Fatalf("years %s", got)
+	}
+	// 2026-01-05 and 2026-01-06 share the week starting Monday the 5th.
+	if got := fmt.Sprint(st.Additions); got != "[{2026-01-05 2} {2026-01-12 1} {2026-01-26 2} {2026-03-30 1}]" {
+		t.Fatalf("weeks %s", got)
+	}
+
+	for _, q := range []string{"top=0", "top=101", "top=x", "by=century", "interval=hour"} {
+		if rr := doJSON(t, r, http.MethodGet, "/stats?"+q, ``); rr.Code != http.StatusBadRequest {
+			t.Fatalf("%s: status %d", q, rr.Code)
+		}
+	}
+}
+
+func TestStats_CachedUntilWrite(t *testing.T) {
+	r, db := setupTestRouter(t)
+	defer db.Close()
+	store := NewBookStore(db)
+	r.Get("/stats", NewBooksAPI(store).StatsHandler)
+
+	doJSON(t, r, http.MethodPost, "/books", `{"title":"Dune","author":"Frank Herbert","year":1965}`)
+	first, err := store.Stats(t.Context(), StatsOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.stats.get(first.Version, StatsOptions{workspace: DefaultWorkspaceID}.withDefaults()); !ok {
+		t.Fatal("stats were not cached")
+	}
+
+	rr := doJSON(t, r, http.MethodGet, "/stats", ``)
+	etag := rr.Header().Get("ETag")
+	req := httptest.NewRequest(http.MethodGet, "/stats", nil)
+	req.Header.Set("If-None-Match", etag)
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotModified {
+		t.Fatalf("revalidation status %d", rec.Code)
+	}
+
+	// A write through the store drops the cache.
+	doJSON(t, r, http.MethodPost, "/books", `{"title":"Emma","author":"Jane Austen","year":1815}`)
+	if st := decodeJSON[CatalogStats](t, doJSON(t, r, http.MethodGet, "/stats", ``)); st.TotalBooks != 2 {
+		t.Fatalf("after create: %+v", st)
+	}
+
+	// So does one that bypasses it, such as an import, via the change counter.
+	if _, err := db.Exec(`INSERT INTO books(title, author, year) VALUES ('Persuasion', 'Jane Austen', 1817)`); err != nil {
+		t.Fatal(err)
+	}
+	st, err := store.Stats(t.Context(), StatsOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.TotalBooks != 3 || fmt.Sprint(st.TopAuthors[0]) != "{Jane Austen 2}" {
+		t.Fatalf("after direct insert: %+v", st)
+	}
+}
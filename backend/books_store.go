@@ -5,44 +5,335 @@ import (
 	"database/sql"
 	"errors"
 	"strings"
+	"sync"
 	"time"
 )
 
 type Book struct {
-	ID     int64  `json:"id"`
-	Title  string `json:"title"`
-	Author string `json:"author"`
-	Year   int    `json:"year"`
+	ID        int64     `json:"id"`
+	Title     string    `json:"title"`
+	Author    string    `json:"author"`
+	Year      int       `json:"year"`
+	ISBN      string    `json:"isbn" example:"9780441172719"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// RatingAvg and RatingCount aggregate the book's reviews. They are
+	// maintained by ReviewStore and ignored on create and update.
+	RatingAvg   float64 `json:"rating_avg"`
+	RatingCount int     `json:"rating_count"`
+
+	// Status and the copy counts summarize the book's copies. They are
+	// maintained by CopyStore and LoanStore and ignored on create and update.
+	Status          string `json:"status" example:"available"`
+	CopiesTotal     int    `json:"copies_total"`
+	CopiesAvailable int    `json:"copies_available"`
+
+	// Locations counts copies per location. Only listings and single-book
+	// reads fill it in.
+	Locations []LocationCount `json:"locations,omitempty"`
+
+	// WorkspaceID is the workspace the book belongs to. It is taken from the
+	// request, never from the body.
+	WorkspaceID int64 `json:"workspace_id"`
+}
+
+// LocationCount is how many of a book's copies are kept at one location.
+type LocationCount struct {
+	Location  string `json:"location" example:"Tokyo 3F"`
+	Total     int    `json:"total"`
+	Available int    `json:"available"`
 }
 
-var ErrNotFound = errors.New("not found")
+// Book availability statuses. A book is available while any copy is, on loan
+// while all remaining copies are out, and unavailable without copies.
+const (
+	BookAvailable   = "available"
+	BookOnLoan      = "on_loan"
+	BookUnavailable = "unavailable"
+)
+
+// bookColumns is the column list scanBook expects.
+const bookColumns = `id, title, author, year, isbn, created_at, updated_at, rating_avg, rating_count, status, copies_total, copies_available, workspace_id`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanBook(row rowScanner) (Book, error) {
+	var b Book
+	err := row.Scan(&b.ID, &b.Title, &b.Author, &b.Year, &b.ISBN, &b.CreatedAt, &b.UpdatedAt, &b.RatingAvg, &b.RatingCount, &b.Status, &b.CopiesTotal, &b.CopiesAvailable, &b.WorkspaceID)
+	return b, err
+}
+
+var ErrNotFound = newCodedError("not_found")
+
+// ValidationError reports invalid input for a single field.
+type ValidationError struct {
+	Field   string
+	Code    string // stable code of the message, see catalogs
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+func (e *ValidationError) ErrorCode() string {
+	return e.Code
+}
 
 func validateBook(b Book) error {
 	if strings.TrimSpace(b.Title) == "" {
-		return errors.New("title is required")
+		return &ValidationError{Field: "title", Code: "title_required", Message: "title is required"}
 	}
 	if strings.TrimSpace(b.Author) == "" {
-		return errors.New("author is required")
+		return &ValidationError{Field: "author", Code: "author_required", Message: "author is required"}
 	}
 	if b.Year <= 0 {
-		return errors.New("year must be > 0")
+		return &ValidationError{Field: "year", Code: "year_invalid", Message: "year must be > 0"}
+	}
+	if b.ISBN != "" {
+		if err := validateISBN(b.ISBN); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
+// DuplicateISBNError is returned when another book already has the ISBN.
+type DuplicateISBNError struct {
+	Existing Book
+}
+
+func (e *DuplicateISBNError) Error() string {
+	return "a book with this ISBN already exists"
+}
+
+// normalizeISBN drops the hyphens and spaces ISBNs are usually printed with
+// and turns a valid ISBN-10 into the equivalent ISBN-13. Anything else is
+// returned as is for validateISBN to reject.
+func normalizeISBN(s string) string {
+	isbn := strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(strings.TrimSpace(s)))
+	if len(isbn) == 10 && validateISBN(isbn) == nil {
+		isbn = "978" + isbn[:9]
+		isbn += string(isbnCheckDigit(isbn))
+	}
+	return isbn
+}
+
+// validateISBN checks an ISBN-10 or ISBN-13 without hyphens, including its
+// check digit.
+func validateISBN(isbn string) error {
+	if !isISBNShaped(isbn) {
+		return &ValidationError{Field: "isbn", Code: "isbn_length", Message: "isbn must have 10 or 13 digits (the last of 10 may be X)"}
+	}
+	if len(isbn) == 13 && !strings.HasPrefix(isbn, "978") && !strings.HasPrefix(isbn, "979") {
+		return &ValidationError{Field: "isbn", Code: "isbn_prefix", Message: "isbn-13 must start with 978 or 979"}
+	}
+	if isbnCheckDigit(isbn[:len(isbn)-1]) != isbn[len(isbn)-1] {
+		return &ValidationError{Field: "isbn", Code: "isbn_check_digit", Message: "isbn check digit does not match"}
+	}
+	return nil
+}
+
+func isISBNShaped(isbn string) bool {
+	if len(isbn) != 10 && len(isbn) != 13 {
+		return false
+	}
+	for i, c := range isbn {
+		if c == 'X' && len(isbn) == 10 && i == 9 {
+			continue
+		}
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// isbnCheckDigit computes the check digit following the first 9 digits of an
+// ISBN-10 (mod 11, where 10 is written X) or the first 12 of an ISBN-13
+// (mod 10, weights alternating 1 and 3).
+func isbnCheckDigit(digits string) byte {
+	sum := 0
+	if len(digits) == 9 {
+		for i := 0; i < 9; i++ {
+			sum += int(digits[i]-'0') * (10 - i)
+		}
+		if c := (11 - sum%11) % 11; c < 10 {
+			return byte('0' + c)
+		}
+		return 'X'
+	}
+	for i := 0; i < 12; i++ {
+		w := 1
+		if i%2 == 1 {
+			w = 3
+		}
+		sum += int(digits[i]-'0') * w
+	}
+	return byte('0' + (10-sum%10)%10)
+}
+
+// BookFilter narrows book listings. Zero values match everything.
+type BookFilter struct {
+	Title  string // case-insensitive substring
+	Author string // case-insensitive substring
+	// AuthorExact is the whole author name, case-insensitive and ignoring
+	// surrounding spaces.
+	AuthorExact string
+	YearFrom    int
+	YearTo      int
+
+	MinRating float64 // rating_avg >= MinRating; books without reviews never match
+
+	Text string // case-insensitive substring of the title or the author
+	Tag  string // one of the book's metadata subjects, case-insensitive
+}
+
+// where builds the condition for f within one workspace.
+func (f BookFilter) where(workspace int64) (string, []any) {
+	conds := []string{`workspace_id = ?`}
+	args := []any{workspace}
+	if f.Title != "" {
+		conds = append(conds, `title LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+escapeLike(f.Title)+"%")
+	}
+	if f.Author != "" {
+		conds = append(conds, `author LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+escapeLike(f.Author)+"%")
+	}
+	if f.AuthorExact != "" {
+		conds = append(conds, `lower(trim(author)) = lower(?)`)
+		args = append(args, strings.TrimSpace(f.AuthorExact))
+	}
+	if f.YearFrom > 0 {
+		conds = append(conds, `year >= ?`)
+		args = append(args, f.YearFrom)
+	}
+	if f.YearTo > 0 {
+		conds = append(conds, `year <= ?`)
+		args = append(args, f.YearTo)
+	}
+	if f.MinRating > 0 {
+		conds = append(conds, `rating_count > 0 AND rating_avg >= ?`)
+		args = append(args, f.MinRating)
+	}
+	if f.Text != "" {
+		conds = append(conds, `(title LIKE ? ESCAPE '\' OR author LIKE ? ESCAPE '\')`)
+		like := "%" + escapeLike(f.Text) + "%"
+		args = append(args, like, like)
+	}
+	if f.Tag != "" {
+		conds = append(conds, `id IN (
+			SELECT m.book_id FROM book_metadata m, json_each(m.subjects) j WHERE lower(j.value) = lower(?))`)
+		args = append(args, f.Tag)
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// BookQuery is a filtered page of books ordered by id, starting after AfterID.
+type BookQuery struct {
+	BookFilter
+	AfterID int64
+	Limit   int
+}
+
 type BookStore struct {
 	db *sql.DB
+
+	// HoldPickup is how long a hold stays ready when a merge brings a
+	// waiting hold and an available copy together.
+	HoldPickup time.Duration
+
+	// ChangeRetention is how long tombstones of deleted books stay in the
+	// change log; sync tokens older than the oldest pruned one must resync.
+	ChangeRetention time.Duration
+
+	mu        sync.RWMutex
+	listeners []func(BookEvent)
+
+	// publishMu is held from commit to notify, so listeners see events in
+	// outbox ID order: SQLite hands out IDs in commit order, and without it
+	// a later commit could be published first and skipped by a resume.
+	publishMu sync.Mutex
+
+	stats statsCache
 }
 
 func NewBookStore(db *sql.DB) *BookStore {
-	return &BookStore{db: db}
+	return &BookStore{db: db, HoldPickup: defaultHoldPickup, ChangeRetention: defaultChangeRetention}
+}
+
+// OnCommit registers fn to be called with every book event after its write
+// commits. fn runs on the writer's goroutine and must not block.
+func (s *BookStore) OnCommit(fn func(BookEvent)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listeners = append(s.listeners, fn)
+}
+
+// commitAndNotify commits tx and then notifies the listeners of events.
+func (s *BookStore) commitAndNotify(tx *sql.Tx, events ...BookEvent) error {
+	s.publishMu.Lock()
+	defer s.publishMu.Unlock()
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	for _, ev := range events {
+		s.notify(ev)
+	}
+	return nil
+}
+
+func (s *BookStore) notify(ev BookEvent) {
+	s.stats.invalidate()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, fn := range s.listeners {
+		fn(ev)
+	}
+}
+
+// Sort orders for List.
+const (
+	SortByID     = "id"
+	SortByRating = "rating" // best rated first, then most reviewed
+)
+
+var bookSortOrder = map[string]string{
+	SortByID:     `id ASC`,
+	SortByRating: `rating_avg DESC, rating_count DESC, id ASC`,
+}
+
+func isValidBookSort(sort string) bool {
+	_, ok := bookSortOrder[sort]
+	return ok
+}
+
+// List returns every book matching f in the given sort order (SortByID when
+// empty).
+func (s *BookStore) List(ctx context.Context, f BookFilter, sort string) ([]Book, error) {
+	return s.Select(ctx, f, sort, BookSelection{})
 }
 
-func (s *BookStore) List(ctx context.Context) ([]Book, error) {
+// Search returns one page of books matching q.
+func (s *BookStore) Search(ctx context.Context, q BookQuery) ([]Book, error) {
 	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
-	rows, err := s.db.QueryContext(ctx, `SELECT id, title, author, year FROM books ORDER BY id ASC`)
+	where, args := q.where(workspaceFromContext(ctx))
+	args = append(args, q.AfterID, q.Limit)
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+bookColumns+` FROM books
+		WHERE `+where+` AND id > ? ORDER BY id ASC LIMIT ?`, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -50,8 +341,8 @@ func (s *BookStore) List(ctx context.Context) ([]Book, error) {
 
 	var out []Book
 	for rows.Next() {
-		var b Book
-		if err := rows.Scan(&b.ID, &b.Title, &b.Author, &b.Year); err != nil {
+		b, err := scanBook(rows)
+		if err != nil {
 			return nil, err
 		}
 		out = append(out, b)
@@ -59,20 +350,116 @@ func (s *BookStore) List(ctx context.Context) ([]Book, error) {
 	return out, rows.Err()
 }
 
+// Count returns how many books match f.
+func (s *BookStore) Count(ctx context.Context, f BookFilter) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	where, args := f.where(workspaceFromContext(ctx))
+	var n int
+	err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM books WHERE `+where, args...).Scan(&n)
+	return n, err
+}
+
+// GetMany loads several books in one query. Missing ids are absent from the
+// result.
+func (s *BookStore) GetMany(ctx context.Context, ids []int64) (map[int64]Book, error) {
+	out := make(map[int64]Book, len(ids))
+	if len(ids) == 0 {
+		return out, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	args := []any{workspaceFromContext(ctx)}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+bookColumns+` FROM books WHERE workspace_id = ? AND id IN (`+placeholders+`)`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		b, err := scanBook(rows)
+		if err != nil {
+			return nil, err
+		}
+		out[b.ID] = b
+	}
+	return out, rows.Err()
+}
+
 func (s *BookStore) Get(ctx context.Context, id int64) (Book, error) {
+	return s.GetSelected(ctx, id, BookSelection{})
+}
+
+// Exists returns ErrNotFound unless the book is in the caller's workspace.
+func (s *BookStore) Exists(ctx context.Context, id int64) error {
 	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
+	return bookExists(ctx, s.db, id)
+}
 
-	var b Book
-	err := s.db.QueryRowContext(ctx, `SELECT id, title, author, year FROM books WHERE id = ?`, id).
-		Scan(&b.ID, &b.Title, &b.Author, &b.Year)
+// GetByISBN returns the book with an ISBN, given as ISBN-10 or ISBN-13 with
+// or without hyphens.
+func (s *BookStore) GetByISBN(ctx context.Context, isbn string) (Book, error) {
+	isbn = normalizeISBN(isbn)
+	if err := validateISBN(isbn); err != nil {
+		return Book{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	b, err := scanBook(s.db.QueryRowContext(ctx,
+		`SELECT `+bookColumns+` FROM books WHERE isbn = ? AND workspace_id = ?`, isbn, workspaceFromContext(ctx)))
 	if errors.Is(err, sql.ErrNoRows) {
 		return Book{}, ErrNotFound
 	}
-	return b, err
+	if err != nil {
+		return Book{}, err
+	}
+	books := []Book{b}
+	if err := loadLocations(ctx, s.db, books); err != nil {
+		return Book{}, err
+	}
+	return books[0], nil
+}
+
+// duplicateISBN turns a unique violation on the ISBN index into a
+// *DuplicateISBNError naming the book of the workspace that has it.
+func duplicateISBN(ctx context.Context, tx *sql.Tx, isbn string) error {
+	existing, err := scanBook(tx.QueryRowContext(ctx,
+		`SELECT `+bookColumns+` FROM books WHERE isbn = ? AND workspace_id = ?`, isbn, workspaceFromContext(ctx)))
+	if err != nil {
+		return err
+	}
+	return &DuplicateISBNError{Existing: existing}
+}
+
+// ListVersion reports the books change counter, which is bumped by triggers on
+// every insert, update and delete, and the time of the last change. It is a
+// single-row lookup, so callers can validate caches without scanning books.
+func (s *BookStore) ListVersion(ctx context.Context) (int64, time.Time, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var (
+		version  int64
+		modified time.Time
+	)
+	err := s.db.QueryRowContext(ctx, `SELECT version, updated_at FROM change_counters WHERE name = 'books'`).
+		Scan(&version, &modified)
+	return version, modified, err
 }
 
 func (s *BookStore) Create(ctx context.Context, b Book) (Book, error) {
+	b.ISBN = normalizeISBN(b.ISBN)
 	if err := validateBook(b); err != nil {
 		return Book{}, err
 	}
@@ -80,10 +467,28 @@ func (s *BookStore) Create(ctx context.Context, b Book) (Book, error) {
 	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
-	res, err := s.db.ExecContext(ctx,
-		`INSERT INTO books(title, author, year) VALUES(?, ?, ?)`,
-		b.Title, b.Author, b.Year,
+	now := time.Now().UTC()
+	b.CreatedAt, b.UpdatedAt = now, now
+	b.RatingAvg, b.RatingCount = 0, 0
+	b.Status, b.CopiesTotal, b.CopiesAvailable, b.Locations = BookUnavailable, 0, 0, nil
+	b.WorkspaceID = workspaceFromContext(ctx)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Book{}, err
+	}
+	defer tx.Rollback()
+
+	if err := checkBookQuota(ctx, tx, b.WorkspaceID); err != nil {
+		return Book{}, err
+	}
+	res, err := tx.ExecContext(ctx,
+		`INSERT INTO books(title, author, year, isbn, created_at, updated_at, status, workspace_id) VALUES(?, ?, ?, ?, ?, ?, ?, ?)`,
+		b.Title, b.Author, b.Year, b.ISBN, b.CreatedAt, b.UpdatedAt, b.Status, b.WorkspaceID,
 	)
+	if isUniqueViolation(err) {
+		return Book{}, duplicateISBN(ctx, tx, b.ISBN)
+	}
 	if err != nil {
 		return Book{}, err
 	}
@@ -92,11 +497,21 @@ func (s *BookStore) Create(ctx context.Context, b Book) (Book, error) {
 		return Book{}, err
 	}
 	b.ID = id
+
+	ev, err := recordBookEvent(ctx, tx, EventBookCreated, b)
+	if err != nil {
+		return Book{}, err
+	}
+	if err := s.commitAndNotify(tx, ev); err != nil {
+		return Book{}, err
+	}
+	loggerFrom(ctx).Debug("book created", "book_id", id)
 	return b, nil
 }
 
 func (s *BookStore) Update(ctx context.Context, id int64, b Book) (Book, error) {
 	b.ID = id
+	b.ISBN = normalizeISBN(b.ISBN)
 	if err := validateBook(b); err != nil {
 		return Book{}, err
 	}
@@ -104,20 +519,38 @@ func (s *BookStore) Update(ctx context.Context, id int64, b Book) (Book, error)
 	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
-	res, err := s.db.ExecContext(ctx,
-		`UPDATE books SET title = ?, author = ?, year = ? WHERE id = ?`,
-		b.Title, b.Author, b.Year, id,
-	)
+	b.UpdatedAt = time.Now().UTC()
+	b.Locations = nil
+	b.WorkspaceID = workspaceFromContext(ctx)
+
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return Book{}, err
 	}
-	n, err := res.RowsAffected()
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx,
+		`UPDATE books SET title = ?, author = ?, year = ?, isbn = ?, updated_at = ? WHERE id = ? AND workspace_id = ? RETURNING created_at, rating_avg, rating_count, status, copies_total, copies_available`,
+		b.Title, b.Author, b.Year, b.ISBN, b.UpdatedAt, id, b.WorkspaceID,
+	).Scan(&b.CreatedAt, &b.RatingAvg, &b.RatingCount, &b.Status, &b.CopiesTotal, &b.CopiesAvailable)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Book{}, ErrNotFound
+	}
+	if isUniqueViolation(err) {
+		return Book{}, duplicateISBN(ctx, tx, b.ISBN)
+	}
 	if err != nil {
 		return Book{}, err
 	}
-	if n == 0 {
-		return Book{}, ErrNotFound
+
+	ev, err := recordBookEvent(ctx, tx, EventBookUpdated, b)
+	if err != nil {
+		return Book{}, err
+	}
+	if err := s.commitAndNotify(tx, ev); err != nil {
+		return Book{}, err
 	}
+	loggerFrom(ctx).Debug("book updated", "book_id", id)
 	return b, nil
 }
 
@@ -125,16 +558,28 @@ func (s *BookStore) Delete(ctx context.Context, id int64) error {
 	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
-	res, err := s.db.ExecContext(ctx, `DELETE FROM books WHERE id = ?`, id)
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
-	n, err := res.RowsAffected()
+	defer tx.Rollback()
+
+	b, err := scanBook(tx.QueryRowContext(ctx,
+		`DELETE FROM books WHERE id = ? AND workspace_id = ? RETURNING `+bookColumns, id, workspaceFromContext(ctx)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
 	if err != nil {
 		return err
 	}
-	if n == 0 {
-		return ErrNotFound
+
+	ev, err := recordBookEvent(ctx, tx, EventBookDeleted, b)
+	if err != nil {
+		return err
+	}
+	if err := s.commitAndNotify(tx, ev); err != nil {
+		return err
 	}
+	loggerFrom(ctx).Debug("book deleted", "book_id", id)
 	return nil
 }
@@ -36,6 +36,7 @@ func setupTestRouter(t *testing.T) (*chi.Mux, *sql.DB) {
 	r.Route("/books", func(r chi.Router) {
 		r.Get("/", api.GetBooksHandler)
 		r.Post("/", api.CreateBookHandler)
+		r.Get("/by-isbn/{isbn}", api.GetBookByISBNHandler)
 		r.Get("/{id}", api.GetBookHandler)
 		r.Put("/{id}", api.UpdateBookHandler)
 		r.Delete("/{id}", api.DeleteBookHandler)
@@ -141,6 +142,10 @@ func TestBooks_CreateValidationFailures(t *testing.T) {
 		{"missing author", `{"title":"T","year":2000}`, "author is required"},
 		{"year zero", `{"title":"T","author":"A","year":0}`, "year must be > 0"},
 		{"year negative", `{"title":"T","author":"A","year":-5}`, "year must be > 0"},
+		{"isbn length", `{"title":"T","author":"A","year":2000,"isbn":"12345"}`, "isbn must have 10 or 13 digits (the last of 10 may be X)"},
+		{"isbn-10 check digit", `{"title":"T","author":"A","year":2000,"isbn":"0-441-17271-8"}`, "isbn check digit does not match"},
+		{"isbn-13 check digit", `{"title":"T","author":"A","year":2000,"isbn":"978-0-441-17271-0"}`, "isbn check digit does not match"},
+		{"isbn-13 prefix", `{"title":"T","author":"A","year":2000,"isbn":"1234567890128"}`, "isbn-13 must start with 978 or 979"},
 	}
 
 	for _, tc := range cases {
@@ -157,6 +162,53 @@ func TestBooks_CreateValidationFailures(t *testing.T) {
 	}
 }
 
+func TestBooks_ISBN(t *testing.T) {
+	r, db := setupTestRouter(t)
+	defer db.Close()
+
+	// ISBN-10 is stored as the equivalent ISBN-13.
+	dune := decodeJSON[Book](t, doJSON(t, r, http.MethodPost, "/books", `{"title":"Dune","author":"Frank Herbert","year":1965,"isbn":"0-441-17271-7"}`))
+	if dune.ISBN != "9780441172719" {
+		t.Fatalf("isbn stored as %q", dune.ISBN)
+	}
+	for _, isbn := range []string{"9780441172719", "978-0-441-17271-9", "0441172717"} {
+		rr := doJSON(t, r, http.MethodGet, "/books/by-isbn/"+isbn, ``)
+		if rr.Code != http.StatusOK || decodeJSON[Book](t, rr).ID != dune.ID {
+			t.Fatalf("by-isbn %s: status %d body=%s", isbn, rr.Code, rr.Body.String())
+		}
+	}
+	if rr := doJSON(t, r, http.MethodGet, "/books/by-isbn/9780000000002", ``); rr.Code != http.StatusNotFound {
+		t.Fatalf("unknown isbn status %d", rr.Code)
+	}
+	if rr := doJSON(t, r, http.MethodGet, "/books/by-isbn/9780000000003", ``); rr.Code != http.StatusBadRequest {
+		t.Fatalf("bad check digit status %d", rr.Code)
+	}
+
+	// Another book with the same ISBN, in either form, points at the first.
+	rr := doJSON(t, r, http.MethodPost, "/books", `{"title":"Dune (reprint)","author":"Frank Herbert","year":1990,"isbn":"9780441172719"}`)
+	if rr.Code != http.StatusConflict {
+		t.Fatalf("duplicate create status %d body=%s", rr.Code, rr.Body.String())
+	}
+	if got := decodeJSON[bookExistsResponse](t, rr); got.Existing.ID != dune.ID || got.Existing.Title != "Dune" {
+		t.Fatalf("conflict should point at the existing book: %+v", got)
+	}
+
+	emma := decodeJSON[Book](t, doJSON(t, r, http.MethodPost, "/books", `{"title":"Emma","author":"Jane Austen","year":1815}`))
+	path := fmt.Sprintf("/books/%d", emma.ID)
+	rr = doJSON(t, r, http.MethodPut, path, `{"title":"Emma","author":"Jane Austen","year":1815,"isbn":"0441172717"}`)
+	if rr.Code != http.StatusConflict || decodeJSON[bookExistsResponse](t, rr).Existing.ID != dune.ID {
+		t.Fatalf("duplicate update status %d body=%s", rr.Code, rr.Body.String())
+	}
+
+	// Books without an ISBN never conflict, and a book keeps its own.
+	if rr := doJSON(t, r, http.MethodPost, "/books", `{"title":"Persuasion","author":"Jane Austen","year":1817}`); rr.Code != http.StatusCreated {
+		t.Fatalf("second book without isbn status %d", rr.Code)
+	}
+	if rr := doJSON(t, r, http.MethodPut, fmt.Sprintf("/books/%d", dune.ID), `{"title":"Dune","author":"Frank Herbert","year":1965,"isbn":"9780441172719"}`); rr.Code != http.StatusOK {
+		t.Fatalf("resave status %d body=%s", rr.Code, rr.Body.String())
+	}
+}
+
 func TestBooks_BadJSON(t *testing.T) {
 	r, db := setupTestRouter(t)
 	defer db.Close()
@@ -166,8 +218,8 @@ func TestBooks_BadJSON(t *testing.T) {
 		t.Fatalf("status got %d body=%s", rr.Code, rr.Body.String())
 	}
 	er := decodeJSON[errResp](t, rr)
-	if er.Error != "invalid JSON body" {
-		t.Fatalf("error got %q want %q", er.Error, "invalid JSON body")
+	if want := "JSON body ends unexpectedly at offset 9"; er.Error != want {
+		t.Fatalf("error got %q want %q", er.Error, want)
 	}
 }
 
@@ -196,6 +248,26 @@ func TestBooks_NotFound(t *testing.T) {
 	}
 }
 
+func TestBooks_StoreFailureIs500(t *testing.T) {
+	r, db := setupTestRouter(t)
+	created := decodeJSON[Book](t, doJSON(t, r, http.MethodPost, "/books", `{"title":"Dune","author":"Frank Herbert","year":1965}`))
+	db.Close()
+
+	// A broken database is not the client's fault, and its error stays in the log.
+	for _, tc := range []struct{ method, path string }{
+		{http.MethodPost, "/books"},
+		{http.MethodPut, fmt.Sprintf("/books/%d", created.ID)},
+	} {
+		rr := doJSON(t, r, tc.method, tc.path, `{"title":"Dune","author":"Frank Herbert","year":1965}`)
+		if rr.Code != http.StatusInternalServerError {
+			t.Fatalf("%s status got %d body=%s", tc.method, rr.Code, rr.Body.String())
+		}
+		if er := decodeJSON[errResp](t, rr); er.Error != "internal error" {
+			t.Fatalf("%s error got %q", tc.method, er.Error)
+		}
+	}
+}
+
 func TestBooks_InvalidIDParam(t *testing.T) {
 	r, db := setupTestRouter(t)
 	defer db.Close()
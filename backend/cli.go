@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const cliUsage = `Usage: backend [-db path] <command> [args]
+
+Commands:
+  serve [-addr :8080] [-grpc-addr :9090]    run the HTTP and gRPC servers (default)
+  migrate up|down [-steps n]|status         apply, revert or list schema migrations
+  books list [filters] [-format table|json|csv]
+  books export [-format json|csv] [-o file]
+  books import [-format json|csv] [file]    read from stdin when file is omitted or "-"
+  db backup [-dir backups] [-keep 7]        snapshot into the backup directory and rotate
+  db backup -o file                         write a single snapshot to file
+  db restore file                           verify a backup and swap it in (server stopped)
+  db vacuum                                 rebuild the database file
+  url process [-operation all]              process one URL per line from stdin
+`
+
+// errUsage marks errors caused by bad arguments; they exit with status 2.
+var errUsage = errors.New("usage")
+
+func usageError(format string, args ...any) error {
+	return fmt.Errorf("%w: "+format, append([]any{errUsage}, args...)...)
+}
+
+// cli holds what every subcommand shares: the database location and the
+// standard streams, so tests can drive commands without a process.
+type cli struct {
+	dbPath string
+	logger *slog.Logger
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// runCLI dispatches args to a subcommand and returns the exit status.
+// Without a command it serves, so `go run .` keeps working.
+func runCLI(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("backend", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() { fmt.Fprint(stderr, cliUsage) }
+	dbPath := fs.String("db", getenv("DB_PATH", "books.db"), "SQLite database file")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	logger, err := NewLogger(stderr, getenv("LOG_FORMAT", "json"), getenv("LOG_LEVEL", "info"))
+	if err != nil {
+		fmt.Fprintln(stderr, "configure logger:", err)
+		return 1
+	}
+	slog.SetDefault(logger)
+
+	c := &cli{dbPath: *dbPath, logger: logger, stdin: stdin, stdout: stdout, stderr: stderr}
+
+	args = fs.Args()
+	cmd := "serve"
+	if len(args) > 0 {
+		cmd, args = args[0], args[1:]
+	}
+
+	switch cmd {
+	case "serve":
+		err = c.serve(ctx, args)
+	case "migrate":
+		err = c.migrate(args)
+	case "books":
+		err = c.books(ctx, args)
+	case "db":
+		err = c.db(ctx, args)
+	case "url":
+		err = c.url(args)
+	case "help", "-h", "--help":
+		fmt.Fprint(stdout, cliUsage)
+		return 0
+	default:
+		err = usageError("unknown command %q", cmd)
+	}
+
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, flag.ErrHelp):
+		return 0
+	case errors.Is(err, errUsage):
+		fmt.Fprintln(stderr, err)
+		fmt.Fprint(stderr, cliUsage)
+		return 2
+	default:
+		fmt.Fprintln(stderr, "error:", err)
+		return 1
+	}
+}
+
+func (c *cli) openDB() (*sql.DB, error) {
+	return OpenDB("file:" + c.dbPath + "?_pragma=busy_timeout(5000)")
+}
+
+func backupDir() string {
+	return getenv("BACKUP_DIR", defaultBackupDir)
+}
+
+func backupKeep() int {
+	keep, err := strconv.Atoi(getenv("BACKUP_KEEP", ""))
+	if err != nil {
+		return defaultBackupKeep
+	}
+	return keep
+}
+
+// adminUsers reads ADMIN_USERS, a comma-separated list of the X-User
+// identities allowed on /admin.
+func adminUsers() []string {
+	var users []string
+	for _, u := range strings.Split(getenv("ADMIN_USERS", ""), ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			users = append(users, u)
+		}
+	}
+	return users
+}
+
+// holdPickup reads HOLD_PICKUP_DAYS, the days a ready hold waits to be
+// collected.
+func holdPickup() time.Duration {
+	days, err := strconv.Atoi(getenv("HOLD_PICKUP_DAYS", ""))
+	if err != nil || days <= 0 {
+		return defaultHoldPickup
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+func (c *cli) flags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	return fs
+}
+
+// parseFlags parses args and maps flag errors onto errUsage.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return usageError("%s: %v", fs.Name(), err)
+	}
+	return nil
+}
+
+func (c *cli) serve(ctx context.Context, args []string) error {
+	fs := c.flags("serve")
+	addr := fs.String("addr", getenv("HTTP_ADDR", ":8080"), "HTTP listen address")
+	grpcAddr := fs.String("grpc-addr", getenv("GRPC_ADDR", ":9090"), "gRPC listen address")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	db, err := c.openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	backups := NewBackupManager(db, backupDir(), backupKeep())
+	return serve(ctx, db, c.logger, *addr, *grpcAddr, backups)
+}
+
+func (c *cli) migrate(args []string) error {
+	if len(args) == 0 {
+		return usageError("migrate: expected up, down or status")
+	}
+	sub, args := args[0], args[1:]
+
+	fs := c.flags("migrate " + sub)
+	steps := 1
+	if sub == "down" {
+		fs.IntVar(&steps, "steps", 1, "number of migrations to revert")
+	}
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	db, err := c.openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	switch sub {
+	case "up":
+		if err := Migrate(db); err != nil {
+			return err
+		}
+	case "down":
+		if steps < 1 {
+			return usageError("migrate down: -steps must be >= 1")
+		}
+		if err := MigrateDown(db, steps); err != nil {
+			return err
+		}
+	case "status":
+	default:
+		return usageError("migrate: unknown subcommand %q", sub)
+	}
+
+	states, err := MigrationStatus(db)
+	if err != nil {
+		return err
+	}
+	for _, s := range states {
+		applied := "pending"
+		if !s.AppliedAt.IsZero() {
+			applied = "applied " + s.AppliedAt.UTC().Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(c.stdout, "%3d  %-40s %s\n", s.Version, s.Name, applied)
+	}
+	return nil
+}
+
+func (c *cli) db(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return usageError("db: expected backup, restore or vacuum")
+	}
+	sub, args := args[0], args[1:]
+
+	fs := c.flags("db " + sub)
+	var (
+		out  string
+		dir  string
+		keep int
+	)
+	if sub == "backup" {
+		fs.StringVar(&out, "o", "", "write a single snapshot to this file instead")
+		fs.StringVar(&dir, "dir", backupDir(), "backup directory")
+		fs.IntVar(&keep, "keep", backupKeep(), "number of backups to keep (0 keeps all)")
+	}
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if sub == "restore" {
+		if fs.NArg() != 1 {
+			return usageError("db restore: expected one backup file")
+		}
+		if err := RestoreBackup(ctx, fs.Arg(0), c.dbPath); err != nil {
+			return err
+		}
+		fmt.Fprintf(c.stdout, "restored %s (previous database kept as %s)\n", fs.Arg(0), c.dbPath+preRestoreSuffix)
+		return nil
+	}
+
+	db, err := c.openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	switch sub {
+	case "backup":
+		if out != "" {
+			if err := BackupTo(ctx, db, out); err != nil {
+				return err
+			}
+			fmt.Fprintln(c.stdout, "backup written to", out)
+			return nil
+		}
+		info, err := NewBackupManager(db, dir, keep).Create(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(c.stdout, "backup written to %s (sha256 %s)\n", filepath.Join(dir, info.Name), info.SHA256)
+	case "vacuum":
+		if err := Vacuum(ctx, db); err != nil {
+			return err
+		}
+	default:
+		return usageError("db: unknown subcommand %q", sub)
+	}
+	return nil
+}
+
+// url processes one URL per stdin line, printing one result per line.
+// Failures go to stderr with their line number and do not stop the run.
+func (c *cli) url(args []string) error {
+	if len(args) == 0 || args[0] != "process" {
+		return usageError("url: expected process")
+	}
+	fs := c.flags("url process")
+	op := fs.String("operation", "all", "canonical, redirection or all")
+	if err := parseFlags(fs, args[1:]); err != nil {
+		return err
+	}
+	if !isValidOperation(*op) {
+		return usageError("url process: -operation must be one of: canonical, redirection, all")
+	}
+
+	var (
+		sc     = bufio.NewScanner(c.stdin)
+		line   int
+		failed int
+	)
+	for sc.Scan() {
+		line++
+		req := processURLRequest{URL: sc.Text(), Operation: *op}
+		if strings.TrimSpace(req.URL) == "" {
+			continue
+		}
+		parsed, err := validateProcessURLRequest(&req)
+		if err != nil {
+			fmt.Fprintf(c.stderr, "line %d: %v\n", line, err)
+			failed++
+			continue
+		}
+		processed, err := processURL(parsed, req.Operation)
+		if err != nil {
+			fmt.Fprintf(c.stderr, "line %d: %v\n", line, err)
+			failed++
+			continue
+		}
+		fmt.Fprintln(c.stdout, processed)
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d lines failed", failed, line)
+	}
+	return nil
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+var bookCSVHeader = []string{"id", "title", "author", "year", "created_at", "updated_at"}
+
+func (c *cli) books(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return usageError("books: expected list, export or import")
+	}
+	switch args[0] {
+	case "list":
+		return c.booksList(ctx, args[1:])
+	case "export":
+		return c.booksExport(ctx, args[1:])
+	case "import":
+		return c.booksImport(ctx, args[1:])
+	default:
+		return usageError("books: unknown subcommand %q", args[0])
+	}
+}
+
+func (c *cli) booksList(ctx context.Context, args []string) error {
+	fs := c.flags("books list")
+	var f BookFilter
+	fs.StringVar(&f.Title, "title", "", "title contains (case-insensitive)")
+	fs.StringVar(&f.Author, "author", "", "author contains (case-insensitive)")
+	fs.IntVar(&f.YearFrom, "year-from", 0, "earliest year")
+	fs.IntVar(&f.YearTo, "year-to", 0, "latest year")
+	limit := fs.Int("limit", 0, "maximum number of books (0 for all)")
+	format := fs.String("format", "table", "table, json or csv")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *format != "table" && *format != "json" && *format != "csv" {
+		return usageError("books list: -format must be one of: table, json, csv")
+	}
+
+	books, err := c.searchBooks(ctx, f, *limit)
+	if err != nil {
+		return err
+	}
+	if *format == "table" {
+		return writeBooksTable(c.stdout, books)
+	}
+	return writeBooks(c.stdout, *format, books)
+}
+
+func (c *cli) booksExport(ctx context.Context, args []string) error {
+	fs := c.flags("books export")
+	format := fs.String("format", "", "json or csv (default: from -o extension, else json)")
+	out := fs.String("o", "-", "destination file, - for stdout")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	fmtName, err := bookFileFormat(*format, *out)
+	if err != nil {
+		return err
+	}
+
+	books, err := c.searchBooks(ctx, BookFilter{}, 0)
+	if err != nil {
+		return err
+	}
+
+	w := c.stdout
+	if *out != "-" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := writeBooks(w, fmtName, books); err != nil {
+		return err
+	}
+	if *out != "-" {
+		fmt.Fprintf(c.stderr, "exported %d books to %s\n", len(books), *out)
+	}
+	return nil
+}
+
+// booksImport creates every book in the input. Rows are validated before any
+// is written, so a bad file imports nothing. IDs and timestamps in the input
+// are ignored; imported books get new ones.
+func (c *cli) booksImport(ctx context.Context, args []string) error {
+	fs := c.flags("books import")
+	format := fs.String("format", "", "json or csv (default: from file extension, else json)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		return usageError("books import: expected at most one file")
+	}
+	src := fs.Arg(0)
+	if src == "" {
+		src = "-"
+	}
+	fmtName, err := bookFileFormat(*format, src)
+	if err != nil {
+		return err
+	}
+
+	r := c.stdin
+	if src != "-" {
+		f, err := os.Open(src)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	books, err := readBooks(r, fmtName)
+	if err != nil {
+		return err
+	}
+	for i, b := range books {
+		if err := validateBook(b); err != nil {
+			return fmt.Errorf("record %d: %w", i+1, err)
+		}
+	}
+
+	db, err := c.openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if err := Migrate(db); err != nil {
+		return err
+	}
+
+	store := NewBookStore(db)
+	for i, b := range books {
+		if _, err := store.Create(ctx, b); err != nil {
+			return fmt.Errorf("record %d: %w (%d imported)", i+1, err, i)
+		}
+	}
+	fmt.Fprintf(c.stderr, "imported %d books\n", len(books))
+	return nil
+}
+
+// searchBooks pages through every book matching f, up to limit (0 = all).
+func (c *cli) searchBooks(ctx context.Context, f BookFilter, limit int) ([]Book, error) {
+	db, err := c.openDB()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	store := NewBookStore(db)
+	q := BookQuery{BookFilter: f, Limit: 500}
+	out := []Book{}
+	for {
+		if limit > 0 && limit-len(out) < q.Limit {
+			q.Limit = limit - len(out)
+		}
+		page, err := store.Search(ctx, q)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, page...)
+		if len(page) < q.Limit || (limit > 0 && len(out) >= limit) {
+			return out, nil
+		}
+		q.AfterID = page[len(page)-1].ID
+	}
+}
+
+// bookFileFormat picks json or csv from an explicit flag or the file name.
+func bookFileFormat(format, path string) (string, error) {
+	if format == "" {
+		if strings.EqualFold(filepath.Ext(path), ".csv") {
+			return "csv", nil
+		}
+		return "json", nil
+	}
+	if format != "json" && format != "csv" {
+		return "", usageError("-format must be json or csv")
+	}
+	return format, nil
+}
+
+func writeBooksTable(w io.Writer, books []Book) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tTITLE\tAUTHOR\tYEAR")
+	for _, b := range books {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%d\n", b.ID, b.Title, b.Author, b.Year)
+	}
+	return tw.Flush()
+}
+
+func writeBooks(w io.Writer, format string, books []Book) error {
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(books)
+	}
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(bookCSVHeader); err != nil {
+		return err
+	}
+	for _, b := range books {
+		if err := cw.Write([]string{
+			strconv.FormatInt(b.ID, 10),
+			b.Title,
+			b.Author,
+			strconv.Itoa(b.Year),
+			b.CreatedAt.UTC().Format(time.RFC3339),
+			b.UpdatedAt.UTC().Format(time.RFC3339),
+		}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func readBooks(r io.Reader, format string) ([]Book, error) {
+	if format == "json" {
+		var books []Book
+		if err := json.NewDecoder(r).Decode(&books); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %w", err)
+		}
+		return books, nil
+	}
+
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV: %w", err)
+	}
+	col := map[string]int{}
+	for i, name := range header {
+		col[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range []string{"title", "author", "year"} {
+		if _, ok := col[name]; !ok {
+			return nil, fmt.Errorf("invalid CSV: missing %q column", name)
+		}
+	}
+
+	var books []Book
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return books, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV: %w", err)
+		}
+		line, _ := cr.FieldPos(0)
+		year, err := strconv.Atoi(strings.TrimSpace(rec[col["year"]]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: year must be a number", line)
+		}
+		books = append(books, Book{Title: rec[col["title"]], Author: rec[col["author"]], Year: year})
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// runTestCLI runs the CLI against dbPath and returns its exit code and output.
+func runTestCLI(t *testing.T, dbPath, stdin string, args ...string) (int, string, string) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	t.Setenv("LOG_LEVEL", "error")
+	code := runCLI(t.Context(), append([]string{"-db", dbPath}, args...), strings.NewReader(stdin), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestCLI_ImportListExport(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "cli.db")
+
+	csvIn := "title,author,year\nDune,Frank Herbert,1965\n\"Hello, World\",Jane Doe,2001\n"
+	if code, _, stderr := runTestCLI(t, dbPath, csvIn, "books", "import", "-format", "csv"); code != 0 {
+		t.Fatalf("import exit %d: %s", code, stderr)
+	}
+
+	code, stdout, _ := runTestCLI(t, dbPath, "", "books", "list", "-format", "json", "-author", "herbert")
+	if code != 0 {
+		t.Fatalf("list exit %d", code)
+	}
+	var listed []Book
+	if err := json.Unmarshal([]byte(stdout), &listed); err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 1 || listed[0].Title != "Dune" {
+		t.Fatalf("unexpected filtered list: %+v", listed)
+	}
+
+	out := filepath.Join(dir, "books.csv")
+	if code, _, stderr := runTestCLI(t, dbPath, "", "books", "export", "-o", out); code != 0 {
+		t.Fatalf("export exit %d: %s", code, stderr)
+	}
+	exported, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(exported), "id,title,author,year,") || !strings.Contains(string(exported), `"Hello, World",Jane Doe,2001`) {
+		t.Fatalf("unexpected export:\n%s", exported)
+	}
+
+	// The export re-imports into a fresh database.
+	if code, _, stderr := runTestCLI(t, filepath.Join(dir, "copy.db"), "", "books", "import", out); code != 0 {
+		t.Fatalf("re-import exit %d: %s", code, stderr)
+	}
+}
+
+func TestCLI_ImportRejectsInvalidFileAtomically(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "cli.db")
+	runTestCLI(t, dbPath, "", "migrate", "up")
+
+	in := `[{"title":"Dune","author":"Frank Herbert","year":1965},{"title":"","author":"X","year":2000}]`
+	code, _, stderr := runTestCLI(t, dbPath, in, "books", "import")
+	if code != 1 || !strings.Contains(stderr, "record 2: title is required") {
+		t.Fatalf("exit %d stderr=%q", code, stderr)
+	}
+
+	_, stdout, _ := runTestCLI(t, dbPath, "", "books", "list", "-format", "csv")
+	if strings.Count(stdout, "\n") != 1 {
+		t.Fatalf("expected only the header, got:\n%s", stdout)
+	}
+}
+
+func TestCLI_MigrateAndDB(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "cli.db")
+
+	if code, _, _ := runTestCLI(t, dbPath, "", "migrate", "up"); code != 0 {
+		t.Fatalf("migrate up exit %d", code)
+	}
+	code, stdout, _ := runTestCLI(t, dbPath, "", "migrate", "down")
+	if code != 0 || !strings.Contains(stdout, "webhooks and outbox") || !strings.Contains(stdout, "pending") {
+		t.Fatalf("migrate down exit %d:\n%s", code, stdout)
+	}
+	if code, _, _ := runTestCLI(t, dbPath, "", "migrate", "up"); code != 0 {
+		t.Fatalf("migrate up exit %d", code)
+	}
+
+	backup := filepath.Join(dir, "backup.db")
+	if code, _, stderr := runTestCLI(t, dbPath, "", "db", "backup", "-o", backup); code != 0 {
+		t.Fatalf("backup exit %d: %s", code, stderr)
+	}
+	if _, err := os.Stat(backup); err != nil {
+		t.Fatal(err)
+	}
+	if code, _, _ := runTestCLI(t, dbPath, "", "db", "vacuum"); code != 0 {
+		t.Fatalf("vacuum exit %d", code)
+	}
+
+	if code, _, _ := runTestCLI(t, dbPath, "", "migrate", "sideways"); code != 2 {
+		t.Fatalf("unknown subcommand exit %d", code)
+	}
+}
+
+func TestCLI_URLProcess(t *testing.T) {
+	in := "https://BYFOOD.com/food-EXPeriences?query=abc/\n\nnot a url\nhttps://byfood.com/x?y=1\n"
+	code, stdout, stderr := runTestCLI(t, "unused.db", in, "url", "process", "-operation", "canonical")
+	if code != 1 {
+		t.Fatalf("expected exit 1 for a bad line, got %d", code)
+	}
+	if stdout != "https://BYFOOD.com/food-EXPeriences\nhttps://byfood.com/x\n" {
+		t.Fatalf("unexpected output:\n%s", stdout)
+	}
+	if !strings.Contains(stderr, "line 3: invalid URL") {
+		t.Fatalf("unexpected stderr: %q", stderr)
+	}
+}
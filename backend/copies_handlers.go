@@ -0,0 +1,229 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+type CopiesAPI struct {
+	store *CopyStore
+}
+
+func NewCopiesAPI(store *CopyStore) *CopiesAPI {
+	return &CopiesAPI{store: store}
+}
+
+// copyInput describes a new copy.
+type copyInput struct {
+	Barcode    string `json:"barcode" example:"LIB-000123"`
+	Location   string `json:"location" example:"Tokyo 3F"`
+	Condition  string `json:"condition" example:"good"`
+	AcquiredOn string `json:"acquired_on" example:"2024-04-01"`
+}
+
+// copyPatchInput changes a copy's condition or marks it lost or found.
+type copyPatchInput struct {
+	Condition *string `json:"condition" example:"fair"`
+	Status    *string `json:"status" example:"lost"`
+}
+
+type moveCopyInput struct {
+	Location string `json:"location" example:"Osaka"`
+}
+
+// ListCopiesHandler godoc
+// @Summary List a book's copies
+// @Tags copies
+// @Produce json
+// @Param id path int true "Book ID"
+// @Param include_retired query bool false "Include retired copies"
+// @Success 200 {array} Copy
+// @Failure 400 {object} errorResponse
+// @Failure 404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /books/{id}/copies [get]
+func (api *CopiesAPI) ListCopiesHandler(w http.ResponseWriter, r *http.Request) {
+	bookID, ok := parseIDParam(w, r, "id")
+	if !ok {
+		return
+	}
+	includeRetired := false
+	if raw := r.URL.Query().Get("include_retired"); raw != "" {
+		v, err := strconv.ParseBool(raw)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "include_retired_invalid")
+			return
+		}
+		includeRetired = v
+	}
+
+	copies, err := api.store.List(r.Context(), bookID, includeRetired)
+	api.writeResult(w, r, http.StatusOK, copies, err)
+}
+
+// GetCopyHandler godoc
+// @Summary Get a copy
+// @Tags copies
+// @Produce json
+// @Param id path int true "Book ID"
+// @Param copyID path int true "Copy ID"
+// @Success 200 {object} Copy
+// @Failure 400 {object} errorResponse
+// @Failure 404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /books/{id}/copies/{copyID} [get]
+func (api *CopiesAPI) GetCopyHandler(w http.ResponseWriter, r *http.Request) {
+	bookID, id, ok := parseCopyParams(w, r)
+	if !ok {
+		return
+	}
+	c, err := api.store.Get(r.Context(), bookID, id)
+	api.writeResult(w, r, http.StatusOK, c, err)
+}
+
+// CreateCopyHandler godoc
+// @Summary Add a copy of a book
+// @Description Barcodes are unique across all books. Condition is one of new, good (default), fair, poor.
+// @Tags copies
+// @Accept json
+// @Produce json
+// @Param id path int true "Book ID"
+// @Param copy body copyInput true "Copy"
+// @Success 201 {object} Copy
+// @Failure 400 {object} errorResponse
+// @Failure 404 {object} errorResponse
+// @Failure 409 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /books/{id}/copies [post]
+func (api *CopiesAPI) CreateCopyHandler(w http.ResponseWriter, r *http.Request) {
+	bookID, ok := parseIDParam(w, r, "id")
+	if !ok {
+		return
+	}
+
+	var in copyInput
+	if !readJSON(w, r, &in) {
+		return
+	}
+
+	c, err := api.store.Create(r.Context(), bookID, Copy{
+		Barcode:    in.Barcode,
+		Location:   in.Location,
+		Condition:  in.Condition,
+		AcquiredOn: in.AcquiredOn,
+	})
+	api.writeResult(w, r, http.StatusCreated, c, err)
+}
+
+// UpdateCopyHandler godoc
+// @Summary Change a copy's condition, or mark it lost or found
+// @Description Status can be set to available or lost. Copies on loan have to be returned first.
+// @Tags copies
+// @Accept json
+// @Produce json
+// @Param id path int true "Book ID"
+// @Param copyID path int true "Copy ID"
+// @Param copy body copyPatchInput true "Changes"
+// @Success 200 {object} Copy
+// @Failure 400 {object} errorResponse
+// @Failure 404 {object} errorResponse
+// @Failure 409 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /books/{id}/copies/{copyID} [patch]
+func (api *CopiesAPI) UpdateCopyHandler(w http.ResponseWriter, r *http.Request) {
+	bookID, id, ok := parseCopyParams(w, r)
+	if !ok {
+		return
+	}
+
+	var in copyPatchInput
+	if !readJSON(w, r, &in) {
+		return
+	}
+
+	c, err := api.store.Update(r.Context(), bookID, id, CopyPatch{Condition: in.Condition, Status: in.Status})
+	api.writeResult(w, r, http.StatusOK, c, err)
+}
+
+// MoveCopyHandler godoc
+// @Summary Move a copy to another location
+// @Tags copies
+// @Accept json
+// @Produce json
+// @Param id path int true "Book ID"
+// @Param copyID path int true "Copy ID"
+// @Param move body moveCopyInput true "New location"
+// @Success 200 {object} Copy
+// @Failure 400 {object} errorResponse
+// @Failure 404 {object} errorResponse
+// @Failure 409 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /books/{id}/copies/{copyID}/move [post]
+func (api *CopiesAPI) MoveCopyHandler(w http.ResponseWriter, r *http.Request) {
+	bookID, id, ok := parseCopyParams(w, r)
+	if !ok {
+		return
+	}
+
+	var in moveCopyInput
+	if !readJSON(w, r, &in) {
+		return
+	}
+
+	c, err := api.store.Move(r.Context(), bookID, id, in.Location)
+	api.writeResult(w, r, http.StatusOK, c, err)
+}
+
+// RetireCopyHandler godoc
+// @Summary Retire a copy
+// @Description Takes the copy out of circulation. It stays in the loan history and in listings with include_retired=true.
+// @Tags copies
+// @Produce json
+// @Param id path int true "Book ID"
+// @Param copyID path int true "Copy ID"
+// @Success 200 {object} Copy
+// @Failure 400 {object} errorResponse
+// @Failure 404 {object} errorResponse
+// @Failure 409 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /books/{id}/copies/{copyID}/retire [post]
+func (api *CopiesAPI) RetireCopyHandler(w http.ResponseWriter, r *http.Request) {
+	bookID, id, ok := parseCopyParams(w, r)
+	if !ok {
+		return
+	}
+	c, err := api.store.Retire(r.Context(), bookID, id)
+	api.writeResult(w, r, http.StatusOK, c, err)
+}
+
+func parseCopyParams(w http.ResponseWriter, r *http.Request) (bookID, id int64, ok bool) {
+	if bookID, ok = parseIDParam(w, r, "id"); !ok {
+		return 0, 0, false
+	}
+	if id, ok = parseIDParam(w, r, "copyID"); !ok {
+		return 0, 0, false
+	}
+	return bookID, id, true
+}
+
+// writeResult maps copy store errors onto responses, or writes v with status
+// on success.
+func (api *CopiesAPI) writeResult(w http.ResponseWriter, r *http.Request, status int, v any, err error) {
+	var verr *ValidationError
+	switch {
+	case err == nil:
+		writeJSON(w, status, v)
+	case errors.As(err, &verr):
+		writeError(w, r, http.StatusBadRequest, verr.Code)
+	case err == ErrNotFound:
+		writeError(w, r, http.StatusNotFound, "book_not_found")
+	case err == ErrCopyNotFound:
+		writeError(w, r, http.StatusNotFound, errorCode(err))
+	case err == ErrDuplicateBarcode, err == ErrCopyOnLoan, err == ErrCopyRetired:
+		writeError(w, r, http.StatusConflict, errorCode(err))
+	default:
+		loggerFrom(r.Context()).Error("write copy", "err", err)
+		writeError(w, r, http.StatusInternalServerError, "internal_error")
+	}
+}
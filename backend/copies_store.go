@@ -0,0 +1,389 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Copy statuses. A copy on hold is set aside for the first hold in the
+// queue. Retiring a copy is recorded separately in RetiredAt, so a retired
+// copy keeps the status it had.
+const (
+	CopyAvailable = "available"
+	CopyOnLoan    = "on_loan"
+	CopyOnHold    = "on_hold"
+	CopyLost      = "lost"
+)
+
+var copyConditions = map[string]bool{"new": true, "good": true, "fair": true, "poor": true}
+
+var (
+	// ErrCopyNotFound means the book has no copy with that id.
+	ErrCopyNotFound = newCodedError("copy_not_found")
+	// ErrCopyOnLoan means the change has to wait until the copy is returned
+	// or its hold is collected.
+	ErrCopyOnLoan = newCodedError("copy_on_loan")
+	// ErrCopyRetired means the copy was retired and can no longer change.
+	ErrCopyRetired = newCodedError("copy_retired")
+	// ErrDuplicateBarcode means another copy already has the barcode.
+	ErrDuplicateBarcode = newCodedError("barcode_taken")
+)
+
+// Copy is one physical copy of a book.
+type Copy struct {
+	ID         int64      `json:"id"`
+	BookID     int64      `json:"book_id"`
+	Barcode    string     `json:"barcode" example:"LIB-000123"`
+	Location   string     `json:"location" example:"Tokyo 3F"`
+	Condition  string     `json:"condition" example:"good"`
+	AcquiredOn string     `json:"acquired_on,omitempty" example:"2024-04-01"`
+	Status     string     `json:"status" example:"available"`
+	RetiredAt  *time.Time `json:"retired_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+func validateCopy(c Copy) error {
+	if c.Barcode == "" {
+		return &ValidationError{Field: "barcode", Code: "barcode_required", Message: "barcode is required"}
+	}
+	if len(c.Barcode) > 64 || strings.ContainsAny(c.Barcode, " \t\r\n") {
+		return &ValidationError{Field: "barcode", Code: "barcode_invalid", Message: "barcode must be at most 64 characters without spaces"}
+	}
+	if utf8.RuneCountInString(c.Location) > 100 {
+		return &ValidationError{Field: "location", Code: "location_too_long", Message: "location must be at most 100 characters"}
+	}
+	if !copyConditions[c.Condition] {
+		return &ValidationError{Field: "condition", Code: "condition_invalid", Message: "condition must be one of new, good, fair, poor"}
+	}
+	if c.AcquiredOn != "" {
+		d, err := time.Parse(time.DateOnly, c.AcquiredOn)
+		if err != nil {
+			return &ValidationError{Field: "acquired_on", Code: "acquired_on_invalid", Message: "acquired_on must be a date like 2024-04-01"}
+		}
+		if d.After(time.Now().UTC()) {
+			return &ValidationError{Field: "acquired_on", Code: "acquired_on_future", Message: "acquired_on cannot be in the future"}
+		}
+	}
+	return nil
+}
+
+const copyColumns = `id, book_id, barcode, location, condition, acquired_on, status, retired_at, created_at, updated_at`
+
+func scanCopy(row rowScanner) (Copy, error) {
+	var (
+		c       Copy
+		retired sql.NullTime
+	)
+	err := row.Scan(&c.ID, &c.BookID, &c.Barcode, &c.Location, &c.Condition, &c.AcquiredOn, &c.Status, &retired, &c.CreatedAt, &c.UpdatedAt)
+	if retired.Valid {
+		c.RetiredAt = &retired.Time
+	}
+	return c, err
+}
+
+// CopyPatch holds the fields an update changes; nil fields are left alone.
+type CopyPatch struct {
+	Condition *string
+	Status    *string // CopyAvailable or CopyLost
+}
+
+type CopyStore struct {
+	db *sql.DB
+
+	// HoldPickup is how long a hold stays ready when a new or found copy
+	// is set aside for it.
+	HoldPickup time.Duration
+}
+
+func NewCopyStore(db *sql.DB) *CopyStore {
+	return &CopyStore{db: db, HoldPickup: defaultHoldPickup}
+}
+
+// List returns the book's copies ordered by location and barcode. Retired
+// copies are left out unless includeRetired is set.
+func (s *CopyStore) List(ctx context.Context, bookID int64, includeRetired bool) ([]Copy, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	if err := bookExists(ctx, s.db, bookID); err != nil {
+		return nil, err
+	}
+
+	query := `SELECT ` + copyColumns + ` FROM copies WHERE book_id = ?`
+	if !includeRetired {
+		query += ` AND retired_at IS NULL`
+	}
+	rows, err := s.db.QueryContext(ctx, query+` ORDER BY location, barcode`, bookID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []Copy{}
+	for rows.Next() {
+		c, err := scanCopy(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+func (s *CopyStore) Get(ctx context.Context, bookID, id int64) (Copy, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	c, err := scanCopy(s.db.QueryRowContext(ctx,
+		`SELECT `+copyColumns+` FROM copies WHERE id = ? AND book_id = ?`, id, bookID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Copy{}, ErrCopyNotFound
+	}
+	return c, err
+}
+
+// Create adds a copy of the book. Barcodes are unique within the workspace.
+func (s *CopyStore) Create(ctx context.Context, bookID int64, c Copy) (Copy, error) {
+	c.Barcode = strings.TrimSpace(c.Barcode)
+	c.Location = strings.TrimSpace(c.Location)
+	if c.Condition == "" {
+		c.Condition = "good"
+	}
+	if err := validateCopy(c); err != nil {
+		return Copy{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Copy{}, err
+	}
+	defer tx.Rollback()
+
+	if err := bookExists(ctx, tx, bookID); err != nil {
+		return Copy{}, err
+	}
+
+	now := time.Now().UTC()
+	c.BookID, c.Status, c.RetiredAt, c.CreatedAt, c.UpdatedAt = bookID, CopyAvailable, nil, now, now
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO copies(book_id, workspace_id, barcode, location, condition, acquired_on, status, created_at, updated_at)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING id`,
+		c.BookID, workspaceFromContext(ctx), c.Barcode, c.Location, c.Condition, c.AcquiredOn, c.Status, c.CreatedAt, c.UpdatedAt,
+	).Scan(&c.ID)
+	if isUniqueViolation(err) {
+		return Copy{}, ErrDuplicateBarcode
+	}
+	if err != nil {
+		return Copy{}, err
+	}
+
+	// The new copy goes to the first hold in the queue, if any.
+	promoted, err := promoteHolds(ctx, tx, bookID, now, s.HoldPickup)
+	if err != nil {
+		return Copy{}, err
+	}
+	if len(promoted) > 0 {
+		if err := tx.QueryRowContext(ctx, `SELECT status FROM copies WHERE id = ?`, c.ID).Scan(&c.Status); err != nil {
+			return Copy{}, err
+		}
+	}
+	if err := refreshBookCopies(ctx, tx, bookID); err != nil {
+		return Copy{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Copy{}, err
+	}
+	loggerFrom(ctx).Debug("copy created", "book_id", bookID, "copy_id", c.ID)
+	return c, nil
+}
+
+// Move changes where the copy is kept. Copies on loan can move too, so the
+// return lands at the new location.
+func (s *CopyStore) Move(ctx context.Context, bookID, id int64, location string) (Copy, error) {
+	location = strings.TrimSpace(location)
+	return s.modify(ctx, bookID, id, func(c *Copy) error {
+		if c.RetiredAt != nil {
+			return ErrCopyRetired
+		}
+		c.Location = location
+		return validateCopy(*c)
+	})
+}
+
+// Update changes the copy's condition or marks it lost or found. Copies on
+// loan have to be returned first.
+func (s *CopyStore) Update(ctx context.Context, bookID, id int64, p CopyPatch) (Copy, error) {
+	return s.modify(ctx, bookID, id, func(c *Copy) error {
+		if c.RetiredAt != nil {
+			return ErrCopyRetired
+		}
+		if p.Condition != nil {
+			c.Condition = *p.Condition
+		}
+		if p.Status != nil && *p.Status != c.Status {
+			if c.Status == CopyOnLoan || c.Status == CopyOnHold {
+				return ErrCopyOnLoan
+			}
+			if *p.Status != CopyAvailable && *p.Status != CopyLost {
+				return &ValidationError{Field: "status", Code: "status_invalid", Message: "status must be available or lost"}
+			}
+			c.Status = *p.Status
+		}
+		return validateCopy(*c)
+	})
+}
+
+// Retire takes the copy out of circulation for good. Retiring a retired copy
+// changes nothing.
+func (s *CopyStore) Retire(ctx context.Context, bookID, id int64) (Copy, error) {
+	return s.modify(ctx, bookID, id, func(c *Copy) error {
+		if c.RetiredAt != nil {
+			return nil
+		}
+		if c.Status == CopyOnLoan || c.Status == CopyOnHold {
+			return ErrCopyOnLoan
+		}
+		now := time.Now().UTC()
+		c.RetiredAt = &now
+		return nil
+	})
+}
+
+// modify loads the copy, applies fn and writes it back together with the
+// book's copy counts, all in one transaction.
+func (s *CopyStore) modify(ctx context.Context, bookID, id int64, fn func(*Copy) error) (Copy, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Copy{}, err
+	}
+	defer tx.Rollback()
+
+	c, err := scanCopy(tx.QueryRowContext(ctx,
+		`SELECT `+copyColumns+` FROM copies WHERE id = ? AND book_id = ?`, id, bookID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Copy{}, ErrCopyNotFound
+	}
+	if err != nil {
+		return Copy{}, err
+	}
+
+	before := c
+	if err := fn(&c); err != nil {
+		return Copy{}, err
+	}
+	if c == before {
+		return c, nil
+	}
+
+	c.UpdatedAt = time.Now().UTC()
+	// The status guard keeps a concurrent checkout from being overwritten.
+	res, err := tx.ExecContext(ctx, `
+		UPDATE copies SET location = ?, condition = ?, status = ?, retired_at = ?, updated_at = ?
+		WHERE id = ? AND status = ?`,
+		c.Location, c.Condition, c.Status, c.RetiredAt, c.UpdatedAt, id, before.Status,
+	)
+	if err != nil {
+		return Copy{}, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return Copy{}, err
+	} else if n == 0 {
+		return Copy{}, ErrCopyOnLoan
+	}
+
+	// A found copy goes to the first hold in the queue, if any.
+	if c.Status == CopyAvailable && before.Status != CopyAvailable {
+		promoted, err := promoteHolds(ctx, tx, bookID, c.UpdatedAt, s.HoldPickup)
+		if err != nil {
+			return Copy{}, err
+		}
+		if len(promoted) > 0 {
+			if err := tx.QueryRowContext(ctx, `SELECT status FROM copies WHERE id = ?`, id).Scan(&c.Status); err != nil {
+				return Copy{}, err
+			}
+		}
+	}
+	if err := refreshBookCopies(ctx, tx, bookID); err != nil {
+		return Copy{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Copy{}, err
+	}
+	loggerFrom(ctx).Debug("copy updated", "book_id", bookID, "copy_id", id)
+	return c, nil
+}
+
+// refreshBookCopies recomputes the book's status and copy counts from its
+// copies, in the same transaction as the copy change, and bumps updated_at
+// since they are part of the book.
+func refreshBookCopies(ctx context.Context, tx *sql.Tx, bookID int64) error {
+	_, err := tx.ExecContext(ctx, `
+		UPDATE books SET
+			copies_total = (SELECT COUNT(*) FROM copies WHERE book_id = ?1 AND retired_at IS NULL),
+			copies_available = (SELECT COUNT(*) FROM copies WHERE book_id = ?1 AND retired_at IS NULL AND status = 'available'),
+			status = CASE
+				WHEN EXISTS (SELECT 1 FROM copies WHERE book_id = ?1 AND retired_at IS NULL AND status = 'available') THEN 'available'
+				WHEN EXISTS (SELECT 1 FROM copies WHERE book_id = ?1 AND retired_at IS NULL AND status IN ('on_loan', 'on_hold')) THEN 'on_loan'
+				ELSE 'unavailable' END,
+			updated_at = ?2
+		WHERE id = ?1`, bookID, time.Now().UTC())
+	return err
+}
+
+// locationBatch bounds the number of ids per query in loadLocations.
+const locationBatch = 500
+
+// loadLocations fills in Locations for books with per-location counts of
+// their copies in service.
+func loadLocations(ctx context.Context, db queryer, books []Book) error {
+	index := make(map[int64]int, len(books))
+	for i := range books {
+		index[books[i].ID] = i
+	}
+
+	for start := 0; start < len(books); start += locationBatch {
+		batch := books[start:min(start+locationBatch, len(books))]
+		args := make([]any, len(batch))
+		for i, b := range batch {
+			args[i] = b.ID
+		}
+		placeholders := strings.TrimSuffix(strings.Repeat("?,", len(batch)), ",")
+
+		rows, err := db.QueryContext(ctx, `
+			SELECT book_id, location, COUNT(*), SUM(status = 'available') FROM copies
+			WHERE retired_at IS NULL AND book_id IN (`+placeholders+`)
+			GROUP BY book_id, location ORDER BY book_id, location`, args...)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var (
+				id int64
+				lc LocationCount
+			)
+			if err := rows.Scan(&id, &lc.Location, &lc.Total, &lc.Available); err != nil {
+				rows.Close()
+				return err
+			}
+			b := &books[index[id]]
+			b.Locations = append(b.Locations, lc)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
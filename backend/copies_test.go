@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestCopies_InventoryAndLocationCounts(t *testing.T) {
+	r, _, cleanup := setupLoans(t)
+	defer cleanup()
+
+	book := decodeJSON[Book](t, doJSON(t, r, http.MethodPost, "/books", `{"title":"Dune","author":"Frank Herbert","year":1965}`))
+	bookPath := fmt.Sprintf("/books/%d", book.ID)
+
+	tokyo1 := addCopy(t, r, book.ID, "LIB-1", "Tokyo")
+	tokyo2 := addCopy(t, r, book.ID, "LIB-2", "Tokyo")
+	osaka := addCopy(t, r, book.ID, "LIB-3", "Osaka")
+	if tokyo1.Status != CopyAvailable || tokyo1.Condition != "good" {
+		t.Fatalf("unexpected new copy: %+v", tokyo1)
+	}
+
+	if rr := doJSON(t, r, http.MethodPost, bookPath+"/copies", `{"barcode":"LIB-1","location":"Osaka"}`); rr.Code != http.StatusConflict {
+		t.Fatalf("duplicate barcode status %d", rr.Code)
+	}
+
+	// Lend one Tokyo copy, lose the Osaka one.
+	rr := doAs(t, r, "alice", http.MethodPost, bookPath+"/checkout", fmt.Sprintf(`{"copy_id":%d}`, tokyo2.ID))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("checkout status %d body=%s", rr.Code, rr.Body.String())
+	}
+	if rr := doJSON(t, r, http.MethodPatch, fmt.Sprintf("%s/copies/%d", bookPath, osaka.ID), `{"status":"lost"}`); rr.Code != http.StatusOK {
+		t.Fatalf("mark lost status %d body=%s", rr.Code, rr.Body.String())
+	}
+
+	books := decodeJSON[[]Book](t, doJSON(t, r, http.MethodGet, "/books", ``))
+	if len(books) != 1 {
+		t.Fatalf("expected one book, got %d", len(books))
+	}
+	got := books[0]
+	if got.Status != BookAvailable || got.CopiesTotal != 3 || got.CopiesAvailable != 1 {
+		t.Fatalf("book summary: status=%s total=%d available=%d", got.Status, got.CopiesTotal, got.CopiesAvailable)
+	}
+	want := []LocationCount{{"Osaka", 1, 0}, {"Tokyo", 2, 1}}
+	if fmt.Sprint(got.Locations) != fmt.Sprint(want) {
+		t.Fatalf("locations got %v want %v", got.Locations, want)
+	}
+
+	// Moving a copy shows up in the counts of the single-book read too.
+	if rr := doJSON(t, r, http.MethodPost, fmt.Sprintf("%s/copies/%d/move", bookPath, tokyo1.ID), `{"location":"Osaka"}`); rr.Code != http.StatusOK {
+		t.Fatalf("move status %d body=%s", rr.Code, rr.Body.String())
+	}
+	got = decodeJSON[Book](t, doJSON(t, r, http.MethodGet, bookPath, ``))
+	want = []LocationCount{{"Osaka", 2, 1}, {"Tokyo", 1, 0}}
+	if fmt.Sprint(got.Locations) != fmt.Sprint(want) {
+		t.Fatalf("locations after move got %v want %v", got.Locations, want)
+	}
+
+	// A copy on loan cannot be retired; the others can.
+	if rr := doJSON(t, r, http.MethodPost, fmt.Sprintf("%s/copies/%d/retire", bookPath, tokyo2.ID), ``); rr.Code != http.StatusConflict {
+		t.Fatalf("retire on-loan copy status %d", rr.Code)
+	}
+	if rr := doJSON(t, r, http.MethodPost, fmt.Sprintf("%s/copies/%d/retire", bookPath, tokyo1.ID), ``); rr.Code != http.StatusOK {
+		t.Fatalf("retire status %d", rr.Code)
+	}
+	got = decodeJSON[Book](t, doJSON(t, r, http.MethodGet, bookPath, ``))
+	if got.Status != BookOnLoan || got.CopiesTotal != 2 || got.CopiesAvailable != 0 {
+		t.Fatalf("after retire: status=%s total=%d available=%d", got.Status, got.CopiesTotal, got.CopiesAvailable)
+	}
+	if rr := doJSON(t, r, http.MethodPost, fmt.Sprintf("%s/copies/%d/move", bookPath, tokyo1.ID), `{"location":"Tokyo"}`); rr.Code != http.StatusConflict {
+		t.Fatalf("moving a retired copy status %d", rr.Code)
+	}
+
+	if active := decodeJSON[[]Copy](t, doJSON(t, r, http.MethodGet, bookPath+"/copies", ``)); len(active) != 2 {
+		t.Fatalf("active copies: %+v", active)
+	}
+	if all := decodeJSON[[]Copy](t, doJSON(t, r, http.MethodGet, bookPath+"/copies?include_retired=true", ``)); len(all) != 3 {
+		t.Fatalf("all copies: %+v", all)
+	}
+
+	// Once the last copy in service is returned and lost, nothing is left.
+	doAs(t, r, "alice", http.MethodPost, bookPath+"/return", ``)
+	doJSON(t, r, http.MethodPatch, fmt.Sprintf("%s/copies/%d", bookPath, tokyo2.ID), `{"status":"lost"}`)
+	if got := decodeJSON[Book](t, doJSON(t, r, http.MethodGet, bookPath, ``)); got.Status != BookUnavailable {
+		t.Fatalf("status with every copy lost %q", got.Status)
+	}
+}
+
+func TestCopies_Validation(t *testing.T) {
+	r, _, cleanup := setupLoans(t)
+	defer cleanup()
+
+	book := decodeJSON[Book](t, doJSON(t, r, http.MethodPost, "/books", `{"title":"Dune","author":"Frank Herbert","year":1965}`))
+	copies := fmt.Sprintf("/books/%d/copies", book.ID)
+	c := addCopy(t, r, book.ID, "LIB-1", "Tokyo")
+	copyPath := fmt.Sprintf("%s/%d", copies, c.ID)
+
+	cases := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{"missing barcode", http.MethodPost, copies, `{"location":"Tokyo"}`, http.StatusBadRequest},
+		{"bad condition", http.MethodPost, copies, `{"barcode":"LIB-2","condition":"mint"}`, http.StatusBadRequest},
+		{"bad date", http.MethodPost, copies, `{"barcode":"LIB-2","acquired_on":"04/01/2024"}`, http.StatusBadRequest},
+		{"future date", http.MethodPost, copies, `{"barcode":"LIB-2","acquired_on":"2999-01-01"}`, http.StatusBadRequest},
+		{"unknown book", http.MethodPost, "/books/9999/copies", `{"barcode":"LIB-2"}`, http.StatusNotFound},
+		{"bad status", http.MethodPatch, copyPath, `{"status":"on_loan"}`, http.StatusBadRequest},
+		{"unknown copy", http.MethodPatch, copies + "/9999", `{"condition":"fair"}`, http.StatusNotFound},
+		{"condition change", http.MethodPatch, copyPath, `{"condition":"fair"}`, http.StatusOK},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := doJSON(t, r, tc.method, tc.path, tc.body)
+			if rr.Code != tc.status {
+				t.Fatalf("status got %d want %d body=%s", rr.Code, tc.status, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestCopies_MigrationTurnsBooksIntoCopies(t *testing.T) {
+	_, db := setupTestRouter(t)
+	defer db.Close()
+
+	// Back to version 6, where a book was a single lendable item.
+	if err := MigrateDown(db, len(migrations)-6); err != nil {
+		t.Fatal(err)
+	}
+	for _, stmt := range []string{
+		`INSERT INTO books(id, title, author, year, status) VALUES (1, 'Dune', 'Frank Herbert', 1965, 'on_loan'), (2, 'Emma', 'Jane Austen', 1815, 'available')`,
+		`INSERT INTO loans(book_id, user, checked_out_at, due_at) VALUES (1, 'alice', '2026-01-01 09:00:00+00:00', '2026-01-15 09:00:00+00:00')`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+
+	books, err := NewBookStore(db).List(t.Context(), BookFilter{}, SortByID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if books[0].Status != BookOnLoan || books[0].CopiesTotal != 1 || books[0].CopiesAvailable != 0 {
+		t.Fatalf("book on loan after migration: %+v", books[0])
+	}
+	if books[1].Status != BookAvailable || books[1].CopiesTotal != 1 || books[1].CopiesAvailable != 1 {
+		t.Fatalf("available book after migration: %+v", books[1])
+	}
+
+	// The open loan moved onto the new copy and can be returned.
+	loan, err := NewLoanStore(db).Return(t.Context(), 1, "alice", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copies, err := NewCopyStore(db).List(t.Context(), 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(copies) != 1 || copies[0].ID != loan.CopyID || copies[0].Barcode != "BOOK-000001" || copies[0].Status != CopyAvailable {
+		t.Fatalf("copies after return: %+v", copies)
+	}
+}
@@ -1,14 +1,29 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"strings"
+	"time"
 
-	_ "modernc.org/sqlite"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
 )
 
 func OpenDB(path string) (*sql.DB, error) {
 
+	// Store time.Time as "2006-01-02 15:04:05.999999999-07:00" so stored
+	// timestamps compare correctly and SQLite date functions understand them.
+	if !strings.Contains(path, "_time_format=") {
+		sep := "?"
+		if strings.Contains(path, "?") {
+			sep = "&"
+		}
+		path += sep + "_time_format=sqlite"
+	}
+
 	db, err := sql.Open("sqlite", path)
 	if err != nil {
 		return nil, err
@@ -23,18 +38,734 @@ func OpenDB(path string) (*sql.DB, error) {
 	return db, nil
 }
 
-func Migrate(db *sql.DB) error {
-	schema := `
+// sqlNow is the SQLite expression for the current UTC time, in the same
+// format the driver writes time.Time values.
+const sqlNow = `strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')`
+
+// isUniqueViolation reports whether err is a UNIQUE constraint failure.
+func isUniqueViolation(err error) bool {
+	var serr *sqlite.Error
+	return errors.As(err, &serr) && serr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE
+}
+
+type migration struct {
+	version int
+	name    string
+	up      string
+	down    string
+}
+
+// migrations are applied in order and recorded in schema_migrations.
+// Never edit an entry that has shipped; append a new one instead.
+var migrations = []migration{
+	{
+		version: 1,
+		name:    "create books",
+		up: `
 	CREATE TABLE IF NOT EXISTS books (
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
 		title TEXT NOT NULL,
 		author TEXT NOT NULL,
 		year INTEGER NOT NULL CHECK (year > 0)
 	);
-	`
-	_, err := db.Exec(schema)
+	`,
+		down: `DROP TABLE books;`,
+	},
+	{
+		version: 2,
+		name:    "book timestamps and change counter",
+		up: `
+	ALTER TABLE books ADD COLUMN created_at DATETIME NOT NULL DEFAULT '1970-01-01 00:00:00+00:00';
+	ALTER TABLE books ADD COLUMN updated_at DATETIME NOT NULL DEFAULT '1970-01-01 00:00:00+00:00';
+	UPDATE books SET created_at = ` + sqlNow + `, updated_at = ` + sqlNow + `;
+
+	CREATE TABLE change_counters (
+		name TEXT PRIMARY KEY,
+		version INTEGER NOT NULL,
+		updated_at DATETIME NOT NULL
+	);
+	INSERT INTO change_counters(name, version, updated_at) VALUES ('books', 0, ` + sqlNow + `);
+
+	CREATE TRIGGER books_changed_insert AFTER INSERT ON books BEGIN
+		UPDATE change_counters SET version = version + 1, updated_at = ` + sqlNow + ` WHERE name = 'books';
+	END;
+	CREATE TRIGGER books_changed_update AFTER UPDATE ON books BEGIN
+		UPDATE change_counters SET version = version + 1, updated_at = ` + sqlNow + ` WHERE name = 'books';
+	END;
+	CREATE TRIGGER books_changed_delete AFTER DELETE ON books BEGIN
+		UPDATE change_counters SET version = version + 1, updated_at = ` + sqlNow + ` WHERE name = 'books';
+	END;
+	`,
+		down: `
+	DROP TRIGGER books_changed_insert;
+	DROP TRIGGER books_changed_update;
+	DROP TRIGGER books_changed_delete;
+	DROP TABLE change_counters;
+	ALTER TABLE books DROP COLUMN updated_at;
+	ALTER TABLE books DROP COLUMN created_at;
+	`,
+	},
+	{
+		version: 3,
+		name:    "webhooks and outbox",
+		up: `
+	CREATE TABLE outbox_events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		event_type TEXT NOT NULL,
+		book_id INTEGER NOT NULL,
+		payload TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		dispatched_at DATETIME
+	);
+	CREATE INDEX outbox_events_pending ON outbox_events(id) WHERE dispatched_at IS NULL;
+
+	CREATE TABLE webhooks (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		url TEXT NOT NULL,
+		secret TEXT NOT NULL,
+		event_types TEXT NOT NULL DEFAULT '',
+		created_at DATETIME NOT NULL
+	);
+
+	CREATE TABLE webhook_deliveries (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		webhook_id INTEGER NOT NULL,
+		event_id INTEGER NOT NULL,
+		status TEXT NOT NULL CHECK (status IN ('pending', 'delivered', 'dead')),
+		attempts INTEGER NOT NULL DEFAULT 0,
+		next_attempt_at DATETIME NOT NULL,
+		last_error TEXT NOT NULL DEFAULT '',
+		updated_at DATETIME NOT NULL,
+		UNIQUE (webhook_id, event_id)
+	);
+	CREATE INDEX webhook_deliveries_due ON webhook_deliveries(status, next_attempt_at);
+	`,
+		down: `
+	DROP TABLE webhook_deliveries;
+	DROP TABLE webhooks;
+	DROP TABLE outbox_events;
+	`,
+	},
+	{
+		version: 4,
+		name:    "idempotency keys",
+		up: `
+	CREATE TABLE idempotency_keys (
+		scope TEXT NOT NULL,
+		key TEXT NOT NULL,
+		fingerprint TEXT NOT NULL,
+		status INTEGER NOT NULL DEFAULT 0,
+		headers TEXT NOT NULL DEFAULT '{}',
+		body BLOB,
+		created_at DATETIME NOT NULL,
+		expires_at DATETIME NOT NULL,
+		PRIMARY KEY (scope, key)
+	);
+	CREATE INDEX idempotency_keys_expires ON idempotency_keys(expires_at);
+	`,
+		down: `DROP TABLE idempotency_keys;`,
+	},
+	{
+		version: 5,
+		name:    "reviews and book ratings",
+		up: `
+	CREATE TABLE reviews (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		book_id INTEGER NOT NULL,
+		user TEXT NOT NULL,
+		rating INTEGER NOT NULL CHECK (rating BETWEEN 1 AND 5),
+		text TEXT NOT NULL DEFAULT '',
+		created_at DATETIME NOT NULL,
+		updated_at DATETIME NOT NULL,
+		UNIQUE (book_id, user)
+	);
+	CREATE INDEX reviews_book_rating ON reviews(book_id, rating);
+	CREATE TRIGGER books_delete_reviews AFTER DELETE ON books BEGIN
+		DELETE FROM reviews WHERE book_id = OLD.id;
+	END;
+
+	ALTER TABLE books ADD COLUMN rating_avg REAL NOT NULL DEFAULT 0;
+	ALTER TABLE books ADD COLUMN rating_count INTEGER NOT NULL DEFAULT 0;
+	CREATE INDEX books_rating ON books(rating_avg, rating_count);
+	`,
+		down: `
+	DROP INDEX books_rating;
+	ALTER TABLE books DROP COLUMN rating_count;
+	ALTER TABLE books DROP COLUMN rating_avg;
+	DROP TRIGGER books_delete_reviews;
+	DROP TABLE reviews;
+	`,
+	},
+	{
+		version: 6,
+		name:    "loans and book status",
+		up: `
+	CREATE TABLE loans (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		book_id INTEGER NOT NULL,
+		user TEXT NOT NULL,
+		checked_out_at DATETIME NOT NULL,
+		due_at DATETIME NOT NULL,
+		returned_at DATETIME
+	);
+	-- At most one open loan per book, whatever the application does.
+	CREATE UNIQUE INDEX loans_open_book ON loans(book_id) WHERE returned_at IS NULL;
+	CREATE INDEX loans_open_due ON loans(due_at) WHERE returned_at IS NULL;
+	CREATE INDEX loans_user ON loans(user, checked_out_at);
+	CREATE TRIGGER books_delete_loans AFTER DELETE ON books BEGIN
+		DELETE FROM loans WHERE book_id = OLD.id;
+	END;
+
+	ALTER TABLE books ADD COLUMN status TEXT NOT NULL DEFAULT 'available';
+	`,
+		down: `
+	ALTER TABLE books DROP COLUMN status;
+	DROP TRIGGER books_delete_loans;
+	DROP TABLE loans;
+	`,
+	},
+	{
+		version: 7,
+		name:    "book copies",
+		up: `
+	CREATE TABLE copies (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		book_id INTEGER NOT NULL,
+		barcode TEXT NOT NULL UNIQUE,
+		location TEXT NOT NULL DEFAULT '',
+		condition TEXT NOT NULL DEFAULT 'good' CHECK (condition IN ('new', 'good', 'fair', 'poor')),
+		acquired_on TEXT NOT NULL DEFAULT '',
+		status TEXT NOT NULL DEFAULT 'available' CHECK (status IN ('available', 'on_loan', 'lost')),
+		retired_at DATETIME,
+		created_at DATETIME NOT NULL,
+		updated_at DATETIME NOT NULL
+	);
+	CREATE INDEX copies_book ON copies(book_id, status);
+	CREATE TRIGGER books_delete_copies AFTER DELETE ON books BEGIN
+		DELETE FROM copies WHERE book_id = OLD.id;
+	END;
+
+	-- Until now every book stood for a single copy.
+	INSERT INTO copies(book_id, barcode, status, created_at, updated_at)
+		SELECT id, printf('BOOK-%06d', id), status, ` + sqlNow + `, ` + sqlNow + ` FROM books;
+
+	ALTER TABLE loans ADD COLUMN copy_id INTEGER NOT NULL DEFAULT 0;
+	UPDATE loans SET copy_id = (SELECT id FROM copies WHERE copies.book_id = loans.book_id);
+	DROP INDEX loans_open_book;
+	CREATE UNIQUE INDEX loans_open_copy ON loans(copy_id) WHERE returned_at IS NULL;
+	CREATE INDEX loans_open_book ON loans(book_id) WHERE returned_at IS NULL;
+
+	ALTER TABLE books ADD COLUMN copies_total INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE books ADD COLUMN copies_available INTEGER NOT NULL DEFAULT 0;
+	UPDATE books SET copies_total = 1, copies_available = (status = 'available');
+	`,
+		down: `
+	UPDATE books SET status = CASE
+		WHEN EXISTS (SELECT 1 FROM loans WHERE book_id = books.id AND returned_at IS NULL) THEN 'on_loan'
+		ELSE 'available' END;
+	ALTER TABLE books DROP COLUMN copies_available;
+	ALTER TABLE books DROP COLUMN copies_total;
+
+	DROP INDEX loans_open_book;
+	DROP INDEX loans_open_copy;
+	CREATE UNIQUE INDEX loans_open_book ON loans(book_id) WHERE returned_at IS NULL;
+	ALTER TABLE loans DROP COLUMN copy_id;
+
+	DROP TRIGGER books_delete_copies;
+	DROP TABLE copies;
+	`,
+	},
+	{
+		version: 8,
+		name:    "holds",
+		up: `
+	-- Copies set aside for a hold get their own status; SQLite can only
+	-- change a CHECK constraint by rebuilding the table.
+	DROP TRIGGER books_delete_copies;
+	CREATE TABLE copies_new (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		book_id INTEGER NOT NULL,
+		barcode TEXT NOT NULL UNIQUE,
+		location TEXT NOT NULL DEFAULT '',
+		condition TEXT NOT NULL DEFAULT 'good' CHECK (condition IN ('new', 'good', 'fair', 'poor')),
+		acquired_on TEXT NOT NULL DEFAULT '',
+		status TEXT NOT NULL DEFAULT 'available' CHECK (status IN ('available', 'on_loan', 'on_hold', 'lost')),
+		retired_at DATETIME,
+		created_at DATETIME NOT NULL,
+		updated_at DATETIME NOT NULL
+	);
+	INSERT INTO copies_new SELECT * FROM copies;
+	DROP TABLE copies;
+	ALTER TABLE copies_new RENAME TO copies;
+	CREATE INDEX copies_book ON copies(book_id, status);
+	CREATE TRIGGER books_delete_copies AFTER DELETE ON books BEGIN
+		DELETE FROM copies WHERE book_id = OLD.id;
+	END;
+
+	CREATE TABLE holds (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		book_id INTEGER NOT NULL,
+		user TEXT NOT NULL,
+		status TEXT NOT NULL CHECK (status IN ('waiting', 'ready', 'collected', 'cancelled', 'expired')),
+		copy_id INTEGER,
+		created_at DATETIME NOT NULL,
+		ready_at DATETIME,
+		expires_at DATETIME,
+		closed_at DATETIME
+	);
+	-- One active hold per user and book; the queue is ordered by id.
+	CREATE UNIQUE INDEX holds_active_user ON holds(book_id, user) WHERE status IN ('waiting', 'ready');
+	CREATE INDEX holds_queue ON holds(book_id, status, id);
+	CREATE INDEX holds_ready_expiry ON holds(expires_at) WHERE status = 'ready';
+	CREATE TRIGGER books_delete_holds AFTER DELETE ON books BEGIN
+		DELETE FROM holds WHERE book_id = OLD.id;
+	END;
+	`,
+		down: `
+	DROP TRIGGER books_delete_holds;
+	DROP TABLE holds;
+
+	DROP TRIGGER books_delete_copies;
+	CREATE TABLE copies_old (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		book_id INTEGER NOT NULL,
+		barcode TEXT NOT NULL UNIQUE,
+		location TEXT NOT NULL DEFAULT '',
+		condition TEXT NOT NULL DEFAULT 'good' CHECK (condition IN ('new', 'good', 'fair', 'poor')),
+		acquired_on TEXT NOT NULL DEFAULT '',
+		status TEXT NOT NULL DEFAULT 'available' CHECK (status IN ('available', 'on_loan', 'lost')),
+		retired_at DATETIME,
+		created_at DATETIME NOT NULL,
+		updated_at DATETIME NOT NULL
+	);
+	INSERT INTO copies_old
+		SELECT id, book_id, barcode, location, condition, acquired_on,
+			CASE status WHEN 'on_hold' THEN 'available' ELSE status END,
+			retired_at, created_at, updated_at
+		FROM copies;
+	DROP TABLE copies;
+	ALTER TABLE copies_old RENAME TO copies;
+	CREATE INDEX copies_book ON copies(book_id, status);
+	CREATE TRIGGER books_delete_copies AFTER DELETE ON books BEGIN
+		DELETE FROM copies WHERE book_id = OLD.id;
+	END;
+	UPDATE books SET
+		copies_available = (SELECT COUNT(*) FROM copies WHERE book_id = books.id AND retired_at IS NULL AND status = 'available'),
+		status = CASE
+			WHEN EXISTS (SELECT 1 FROM copies WHERE book_id = books.id AND retired_at IS NULL AND status = 'available') THEN 'available'
+			WHEN EXISTS (SELECT 1 FROM copies WHERE book_id = books.id AND retired_at IS NULL AND status = 'on_loan') THEN 'on_loan'
+			ELSE 'unavailable' END;
+	`,
+	},
+	{
+		version: 9,
+		name:    "notifications",
+		up: `
+	-- Users without a row get the defaults and, lacking an email, no mail.
+	CREATE TABLE notification_prefs (
+		user TEXT PRIMARY KEY,
+		email TEXT NOT NULL DEFAULT '',
+		due_soon INTEGER NOT NULL DEFAULT 1,
+		overdue INTEGER NOT NULL DEFAULT 1,
+		hold_ready INTEGER NOT NULL DEFAULT 1,
+		new_books INTEGER NOT NULL DEFAULT 1,
+		updated_at DATETIME NOT NULL
+	);
+	CREATE TABLE author_follows (
+		user TEXT NOT NULL,
+		author TEXT NOT NULL COLLATE NOCASE,
+		PRIMARY KEY (user, author)
+	);
+	CREATE INDEX author_follows_author ON author_follows(author);
+
+	-- Every message ever queued; dedupe_key makes the scheduler idempotent.
+	CREATE TABLE notifications (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user TEXT NOT NULL,
+		kind TEXT NOT NULL,
+		dedupe_key TEXT NOT NULL UNIQUE,
+		email TEXT NOT NULL,
+		subject TEXT NOT NULL,
+		body_text TEXT NOT NULL,
+		body_html TEXT NOT NULL,
+		status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'sent', 'failed')),
+		attempts INTEGER NOT NULL DEFAULT 0,
+		last_error TEXT NOT NULL DEFAULT '',
+		created_at DATETIME NOT NULL,
+		sent_at DATETIME
+	);
+	CREATE INDEX notifications_user ON notifications(user, id);
+	CREATE INDEX notifications_pending ON notifications(id) WHERE status = 'pending';
+
+	-- The last outbox event scanned for new books by followed authors.
+	-- Starting at the current end keeps old books from being announced.
+	CREATE TABLE notification_cursor (
+		id INTEGER PRIMARY KEY CHECK (id = 1),
+		event_id INTEGER NOT NULL
+	);
+	INSERT INTO notification_cursor(id, event_id) SELECT 1, COALESCE(MAX(id), 0) FROM outbox_events;
+	`,
+		down: `
+	DROP TABLE notification_cursor;
+	DROP TABLE notifications;
+	DROP TABLE author_follows;
+	DROP TABLE notification_prefs;
+	`,
+	},
+	{
+		version: 10,
+		name:    "book isbn and metadata",
+		up: `
+	ALTER TABLE books ADD COLUMN isbn TEXT NOT NULL DEFAULT '';
+
+	-- Fields fetched from a bibliographic catalog, kept apart from what users
+	-- entered so the source of every value stays visible.
+	CREATE TABLE book_metadata (
+		book_id INTEGER PRIMARY KEY,
+		source TEXT NOT NULL,
+		isbn TEXT NOT NULL,
+		publisher TEXT NOT NULL DEFAULT '',
+		page_count INTEGER NOT NULL DEFAULT 0,
+		subjects TEXT NOT NULL DEFAULT '[]',
+		description TEXT NOT NULL DEFAULT '',
+		cover_url TEXT NOT NULL DEFAULT '',
+		fetched_at DATETIME NOT NULL
+	);
+	CREATE TRIGGER books_delete_metadata AFTER DELETE ON books BEGIN
+		DELETE FROM book_metadata WHERE book_id = OLD.id;
+	END;
+	`,
+		down: `
+	DROP TRIGGER books_delete_metadata;
+	DROP TABLE book_metadata;
+	ALTER TABLE books DROP COLUMN isbn;
+	`,
+	},
+	{
+		version: 11,
+		name:    "unique normalized isbn",
+		up: `
+	-- ISBNs are stored as ISBN-13. An ISBN-10 becomes 978 + its first nine
+	-- digits + a new mod 10 check digit; 38 is the weighted sum of 978.
+	UPDATE books SET isbn = '978' || substr(isbn, 1, 9) || ((10 - (38
+		+ 3 * CAST(substr(isbn, 1, 1) AS INTEGER) + CAST(substr(isbn, 2, 1) AS INTEGER)
+		+ 3 * CAST(substr(isbn, 3, 1) AS INTEGER) + CAST(substr(isbn, 4, 1) AS INTEGER)
+		+ 3 * CAST(substr(isbn, 5, 1) AS INTEGER) + CAST(substr(isbn, 6, 1) AS INTEGER)
+		+ 3 * CAST(substr(isbn, 7, 1) AS INTEGER) + CAST(substr(isbn, 8, 1) AS INTEGER)
+		+ 3 * CAST(substr(isbn, 9, 1) AS INTEGER)) % 10) % 10)
+	WHERE length(isbn) = 10;
+
+	-- Only one book may keep an ISBN; later duplicates lose theirs.
+	UPDATE books SET isbn = ''
+	WHERE isbn <> '' AND id > (SELECT MIN(id) FROM books b WHERE b.isbn = books.isbn);
+
+	CREATE UNIQUE INDEX books_isbn ON books(isbn) WHERE isbn <> '';
+	`,
+		down: `
+	DROP INDEX books_isbn;
+	`,
+	},
+	{
+		version: 12,
+		name:    "workspaces",
+		up: `
+	-- max_books = 0 means no quota.
+	CREATE TABLE workspaces (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		slug TEXT NOT NULL UNIQUE,
+		name TEXT NOT NULL,
+		max_books INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME NOT NULL,
+		archived_at DATETIME
+	);
+	-- Existing books all move into the default workspace.
+	INSERT INTO workspaces(id, slug, name, created_at) VALUES (1, 'default', 'Default', ` + sqlNow + `);
+
+	CREATE TABLE workspace_members (
+		workspace_id INTEGER NOT NULL REFERENCES workspaces(id),
+		user TEXT NOT NULL,
+		PRIMARY KEY (workspace_id, user)
+	);
+	CREATE INDEX workspace_members_user ON workspace_members(user);
+
+	ALTER TABLE books ADD COLUMN workspace_id INTEGER NOT NULL DEFAULT 1;
+	CREATE INDEX books_workspace ON books(workspace_id);
+
+	-- An ISBN is unique within a workspace; two tenants may own the same edition.
+	DROP INDEX books_isbn;
+	CREATE UNIQUE INDEX books_isbn ON books(workspace_id, isbn) WHERE isbn <> '';
+
+	-- Webhooks only hear about events in their own workspace.
+	ALTER TABLE webhooks ADD COLUMN workspace_id INTEGER NOT NULL DEFAULT 1;
+	ALTER TABLE outbox_events ADD COLUMN workspace_id INTEGER NOT NULL DEFAULT 1;
+	`,
+		down: `
+	DROP INDEX books_isbn;
+	UPDATE books SET isbn = ''
+	WHERE isbn <> '' AND id > (SELECT MIN(id) FROM books b WHERE b.isbn = books.isbn);
+	CREATE UNIQUE INDEX books_isbn ON books(isbn) WHERE isbn <> '';
+
+	ALTER TABLE outbox_events DROP COLUMN workspace_id;
+	ALTER TABLE webhooks DROP COLUMN workspace_id;
+	DROP INDEX books_workspace;
+	ALTER TABLE books DROP COLUMN workspace_id;
+	DROP TABLE workspace_members;
+	DROP TABLE workspaces;
+	`,
+	},
+	{
+		version: 13,
+		name:    "book change log",
+		up: `
+	-- The latest change of every book, in the order changes happened: seq
+	-- grows with every write and a book's row moves to the end each time it
+	-- changes. Deleted books leave a tombstone until it is pruned.
+	CREATE TABLE book_changes (
+		seq INTEGER PRIMARY KEY AUTOINCREMENT,
+		book_id INTEGER NOT NULL UNIQUE,
+		workspace_id INTEGER NOT NULL,
+		deleted INTEGER NOT NULL DEFAULT 0,
+		changed_at DATETIME NOT NULL
+	);
+	CREATE INDEX book_changes_workspace ON book_changes(workspace_id, seq);
+
+	-- Tombstones up to seq have been pruned; older sync tokens cannot be served.
+	CREATE TABLE book_changes_horizon (
+		id INTEGER PRIMARY KEY CHECK (id = 1),
+		seq INTEGER NOT NULL
+	);
+	INSERT INTO book_changes_horizon(id, seq) VALUES (1, 0);
+
+	INSERT INTO book_changes(book_id, workspace_id, changed_at)
+	SELECT id, workspace_id, updated_at FROM books ORDER BY updated_at, id;
+
+	CREATE TRIGGER books_log_insert AFTER INSERT ON books BEGIN
+		INSERT OR REPLACE INTO book_changes(book_id, workspace_id, deleted, changed_at)
+		VALUES (NEW.id, NEW.workspace_id, 0, ` + sqlNow + `);
+	END;
+	CREATE TRIGGER books_log_update AFTER UPDATE ON books BEGIN
+		INSERT OR REPLACE INTO book_changes(book_id, workspace_id, deleted, changed_at)
+		VALUES (NEW.id, NEW.workspace_id, 0, ` + sqlNow + `);
+	END;
+	CREATE TRIGGER books_log_delete AFTER DELETE ON books BEGIN
+		INSERT OR REPLACE INTO book_changes(book_id, workspace_id, deleted, changed_at)
+		VALUES (OLD.id, OLD.workspace_id, 1, ` + sqlNow + `);
+	END;
+	`,
+		down: `
+	DROP TRIGGER books_log_delete;
+	DROP TRIGGER books_log_update;
+	DROP TRIGGER books_log_insert;
+	DROP TABLE book_changes_horizon;
+	DROP TABLE book_changes;
+	`,
+	},
+	{
+		version: 14,
+		name:    "per-workspace barcodes",
+		up: `
+	-- A barcode is unique within the workspace of the copy's book, so two
+	-- tenants may both label a copy LIB-000001. The workspace is copied onto
+	-- the copy for the index, and dropping the global UNIQUE means a rebuild.
+	DROP TRIGGER books_delete_copies;
+	CREATE TABLE copies_new (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		book_id INTEGER NOT NULL,
+		workspace_id INTEGER NOT NULL DEFAULT 1,
+		barcode TEXT NOT NULL,
+		location TEXT NOT NULL DEFAULT '',
+		condition TEXT NOT NULL DEFAULT 'good' CHECK (condition IN ('new', 'good', 'fair', 'poor')),
+		acquired_on TEXT NOT NULL DEFAULT '',
+		status TEXT NOT NULL DEFAULT 'available' CHECK (status IN ('available', 'on_loan', 'on_hold', 'lost')),
+		retired_at DATETIME,
+		created_at DATETIME NOT NULL,
+		updated_at DATETIME NOT NULL
+	);
+	INSERT INTO copies_new(id, book_id, workspace_id, barcode, location, condition, acquired_on, status, retired_at, created_at, updated_at)
+		SELECT c.id, c.book_id, COALESCE((SELECT workspace_id FROM books WHERE id = c.book_id), 1),
+			c.barcode, c.location, c.condition, c.acquired_on, c.status, c.retired_at, c.created_at, c.updated_at
+		FROM copies c;
+	DROP TABLE copies;
+	ALTER TABLE copies_new RENAME TO copies;
+	CREATE INDEX copies_book ON copies(book_id, status);
+	CREATE UNIQUE INDEX copies_barcode ON copies(workspace_id, barcode);
+	CREATE TRIGGER books_delete_copies AFTER DELETE ON books BEGIN
+		DELETE FROM copies WHERE book_id = OLD.id;
+	END;
+	`,
+		down: `
+	-- Later copies reusing another workspace's barcode get their id appended.
+	DROP TRIGGER books_delete_copies;
+	CREATE TABLE copies_old (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		book_id INTEGER NOT NULL,
+		barcode TEXT NOT NULL UNIQUE,
+		location TEXT NOT NULL DEFAULT '',
+		condition TEXT NOT NULL DEFAULT 'good' CHECK (condition IN ('new', 'good', 'fair', 'poor')),
+		acquired_on TEXT NOT NULL DEFAULT '',
+		status TEXT NOT NULL DEFAULT 'available' CHECK (status IN ('available', 'on_loan', 'on_hold', 'lost')),
+		retired_at DATETIME,
+		created_at DATETIME NOT NULL,
+		updated_at DATETIME NOT NULL
+	);
+	INSERT INTO copies_old
+		SELECT id, book_id,
+			CASE WHEN id > (SELECT MIN(id) FROM copies c WHERE c.barcode = copies.barcode)
+				THEN barcode || '-' || id ELSE barcode END,
+			location, condition, acquired_on, status, retired_at, created_at, updated_at
+		FROM copies;
+	DROP TABLE copies;
+	ALTER TABLE copies_old RENAME TO copies;
+	CREATE INDEX copies_book ON copies(book_id, status);
+	CREATE TRIGGER books_delete_copies AFTER DELETE ON books BEGIN
+		DELETE FROM copies WHERE book_id = OLD.id;
+	END;
+	`,
+	},
+}
+
+func Migrate(db *sql.DB) error {
+	ctx := context.Background()
+
+	applied, err := appliedMigrations(ctx, db)
 	if err != nil {
 		return fmt.Errorf("migrate: %w", err)
 	}
+
+	for _, m := range migrations {
+		if _, ok := applied[m.version]; ok {
+			continue
+		}
+		if err := applyMigration(ctx, db, m); err != nil {
+			return fmt.Errorf("migrate %d (%s): %w", m.version, m.name, err)
+		}
+	}
+	return nil
+}
+
+// MigrateDown reverts the newest steps applied migrations, newest first.
+func MigrateDown(db *sql.DB, steps int) error {
+	ctx := context.Background()
+
+	applied, err := appliedMigrations(ctx, db)
+	if err != nil {
+		return fmt.Errorf("migrate down: %w", err)
+	}
+
+	for i := len(migrations) - 1; i >= 0 && steps > 0; i-- {
+		m := migrations[i]
+		if _, ok := applied[m.version]; !ok {
+			continue
+		}
+		if m.down == "" {
+			return fmt.Errorf("migrate down %d (%s): irreversible", m.version, m.name)
+		}
+		if err := revertMigration(ctx, db, m); err != nil {
+			return fmt.Errorf("migrate down %d (%s): %w", m.version, m.name, err)
+		}
+		steps--
+	}
 	return nil
 }
+
+// MigrationState reports whether a known migration has been applied.
+type MigrationState struct {
+	Version   int
+	Name      string
+	AppliedAt time.Time // zero when pending
+}
+
+func MigrationStatus(db *sql.DB) ([]MigrationState, error) {
+	applied, err := appliedMigrations(context.Background(), db)
+	if err != nil {
+		return nil, fmt.Errorf("migration status: %w", err)
+	}
+
+	out := make([]MigrationState, 0, len(migrations))
+	for _, m := range migrations {
+		out = append(out, MigrationState{Version: m.version, Name: m.name, AppliedAt: applied[m.version]})
+	}
+	return out, nil
+}
+
+// appliedMigrations returns when each applied version ran, creating the
+// bookkeeping table on first use.
+func appliedMigrations(ctx context.Context, db *sql.DB) (map[int]time.Time, error) {
+	_, err := db.ExecContext(ctx, `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at DATETIME NOT NULL
+	);
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := map[int]time.Time{}
+	for rows.Next() {
+		var (
+			v  int
+			at time.Time
+		)
+		if err := rows.Scan(&v, &at); err != nil {
+			return nil, err
+		}
+		out[v] = at
+	}
+	return out, rows.Err()
+}
+
+func applyMigration(ctx context.Context, db *sql.DB, m migration) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, m.up); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO schema_migrations(version, name, applied_at) VALUES(?, ?, `+sqlNow+`)`,
+		m.version, m.name,
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func revertMigration(ctx context.Context, db *sql.DB, m migration) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, m.down); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = ?`, m.version); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Vacuum rebuilds the database file, reclaiming free pages.
+func Vacuum(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, `VACUUM`)
+	return err
+}
+
+// BackupTo writes a consistent copy of the live database to path, which must
+// not exist yet.
+func BackupTo(ctx context.Context, db *sql.DB, path string) error {
+	_, err := db.ExecContext(ctx, `VACUUM INTO ?`, path)
+	return err
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestMigrate_UpgradesLegacySchema(t *testing.T) {
+	db, err := OpenDB("file:" + filepath.Join(t.TempDir(), "legacy.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// Schema shipped before versioned migrations existed.
+	if _, err := db.Exec(`
+	CREATE TABLE books (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		title TEXT NOT NULL,
+		author TEXT NOT NULL,
+		year INTEGER NOT NULL CHECK (year > 0)
+	);
+	INSERT INTO books(title, author, year) VALUES ('Dune', 'Frank Herbert', 1965);
+	`); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	// Running again must be a no-op.
+	if err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := NewBookStore(db).Get(t.Context(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Title != "Dune" || b.CreatedAt.IsZero() || b.UpdatedAt.IsZero() {
+		t.Fatalf("unexpected book after upgrade: %+v", b)
+	}
+}
+
+func TestMigrateDown_RoundTrip(t *testing.T) {
+	db, err := OpenDB("file:" + filepath.Join(t.TempDir(), "down.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := MigrateDown(db, len(migrations)); err != nil {
+		t.Fatal(err)
+	}
+
+	states, err := MigrationStatus(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range states {
+		if !s.AppliedAt.IsZero() {
+			t.Fatalf("migration %d still applied after down", s.Version)
+		}
+	}
+	var tables int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name NOT IN ('schema_migrations', 'sqlite_sequence')`).Scan(&tables); err != nil {
+		t.Fatal(err)
+	}
+	if tables != 0 {
+		t.Fatalf("expected no tables left, got %d", tables)
+	}
+
+	// Every down must leave a schema the ups can rebuild.
+	if err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewBookStore(db).Create(t.Context(), Book{Title: "Dune", Author: "Frank Herbert", Year: 1965}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMigrate_NormalizesISBNs(t *testing.T) {
+	_, db := setupTestRouter(t)
+	defer db.Close()
+
+	// Back to version 10, where ISBNs were stored as entered.
+	if err := MigrateDown(db, len(migrations)-10); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO books(id, title, author, year, isbn) VALUES
+		(1, 'Dune', 'Frank Herbert', 1965, '0441172717'),
+		(2, 'Dune', 'Frank Herbert', 1965, '9780441172719'),
+		(3, 'Dune', 'Frank Herbert', 2019, '059309932X'),
+		(4, 'Emma', 'Jane Austen', 1815, '')`); err != nil {
+		t.Fatal(err)
+	}
+	if err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := db.Query(`SELECT isbn FROM books ORDER BY id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var isbn string
+		if err := rows.Scan(&isbn); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, isbn)
+	}
+	if fmt.Sprint(got) != "[9780441172719  9780593099322 ]" {
+		t.Fatalf("isbns after migration: %q", got)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// maxJSONBody caps request bodies read by readJSON.
+const maxJSONBody = 1 << 20
+
+// bodyError is why a request body was refused. code names a catalog message
+// whose placeholders are filled from params.
+type bodyError struct {
+	status int
+	code   string
+	field  string
+	offset int64
+	params map[string]string
+}
+
+// readJSON decodes r's body, which must be a single JSON value, into dst.
+// It answers the request itself and returns false when the body is refused:
+// 415 unless Content-Type is application/json, 413 over maxJSONBody, and 400
+// for malformed JSON, unknown fields, values of the wrong type and trailing
+// data, with the field and byte offset where they are known.
+func readJSON(w http.ResponseWriter, r *http.Request, dst any) bool {
+	return decodeBody(w, r, dst, false)
+}
+
+// readOptionalJSON is readJSON for endpoints whose body may be left out;
+// dst is unchanged then.
+func readOptionalJSON(w http.ResponseWriter, r *http.Request, dst any) bool {
+	return decodeBody(w, r, dst, true)
+}
+
+func decodeBody(w http.ResponseWriter, r *http.Request, dst any, optional bool) bool {
+	if optional && r.ContentLength == 0 {
+		return true
+	}
+	if berr := decodeJSONBody(w, r, dst); berr != nil {
+		if optional && berr.code == "body_empty" {
+			return true
+		}
+		writeBodyError(w, r, berr)
+		return false
+	}
+	return true
+}
+
+func writeBodyError(w http.ResponseWriter, r *http.Request, berr *bodyError) {
+	e := localizeCode(w, r, berr.code, berr.params)
+	e.Field, e.Offset = berr.field, berr.offset
+	writeJSON(w, berr.status, e)
+}
+
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, dst any) *bodyError {
+	if mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mt != "application/json" {
+		return &bodyError{status: http.StatusUnsupportedMediaType, code: "unsupported_media_type"}
+	}
+
+	body := &countingReader{r: http.MaxBytesReader(w, r.Body, maxJSONBody)}
+	dec := json.NewDecoder(body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(dst); err != nil {
+		return describeDecodeError(err, body.n)
+	}
+
+	// Anything after the value, even well-formed JSON, is refused.
+	end := dec.InputOffset()
+	var extra json.RawMessage
+	switch err := dec.Decode(&extra); {
+	case err == io.EOF:
+		return nil
+	case isTooLarge(err):
+		return tooLarge()
+	default:
+		return &bodyError{status: http.StatusBadRequest, code: "json_trailing_data", offset: end, params: offsetParams(end)}
+	}
+}
+
+// describeDecodeError explains a Decode error; read is how much of the body
+// had been read, which is where a truncated body ends.
+func describeDecodeError(err error, read int64) *bodyError {
+	var (
+		syntax  *json.SyntaxError
+		typeErr *json.UnmarshalTypeError
+	)
+	switch {
+	case isTooLarge(err):
+		return tooLarge()
+	case err == io.EOF:
+		return &bodyError{status: http.StatusBadRequest, code: "body_empty"}
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return &bodyError{status: http.StatusBadRequest, code: "json_truncated", offset: read, params: offsetParams(read)}
+	case errors.As(err, &syntax):
+		return &bodyError{status: http.StatusBadRequest, code: "json_syntax", offset: syntax.Offset, params: offsetParams(syntax.Offset)}
+	case errors.As(err, &typeErr):
+		field := typeErr.Field
+		if field == "" {
+			field = "body"
+		}
+		p := offsetParams(typeErr.Offset)
+		value := typeErr.Value
+		if value == "bool" {
+			value = "boolean"
+		}
+		p["field"], p["type"], p["value"] = field, jsonTypeName(typeErr.Type), value
+		return &bodyError{status: http.StatusBadRequest, code: "json_type", field: typeErr.Field, offset: typeErr.Offset, params: p}
+	}
+	// encoding/json has no type for unknown fields; the name is only in the text.
+	if name, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		if unquoted, err := strconv.Unquote(name); err == nil {
+			name = unquoted
+		}
+		return &bodyError{status: http.StatusBadRequest, code: "json_unknown_field", field: name, params: map[string]string{"field": name}}
+	}
+	return &bodyError{status: http.StatusBadRequest, code: "invalid_json"}
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func isTooLarge(err error) bool {
+	var tooBig *http.MaxBytesError
+	return errors.As(err, &tooBig)
+}
+
+func tooLarge() *bodyError {
+	return &bodyError{
+		status: http.StatusRequestEntityTooLarge,
+		code:   "body_too_large",
+		params: map[string]string{"limit": strconv.Itoa(maxJSONBody)},
+	}
+}
+
+func offsetParams(off int64) map[string]string {
+	return map[string]string{"offset": strconv.FormatInt(off, 10)}
+}
+
+// jsonTypeName names the JSON type a Go type decodes from.
+func jsonTypeName(t reflect.Type) string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	default:
+		return "object"
+	}
+}
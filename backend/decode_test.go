@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func doWithType(t *testing.T, r http.Handler, method, path, contentType, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestReadJSON_RefusesBadBodies(t *testing.T) {
+	r, db := setupTestRouter(t)
+	defer db.Close()
+
+	created := decodeJSON[Book](t, doJSON(t, r, http.MethodPost, "/books", `{"title":"Dune","author":"Frank Herbert","year":1965}`))
+	bookPath := "/books/" + strconv.FormatInt(created.ID, 10)
+
+	huge := `{"title":"` + strings.Repeat("x", maxJSONBody) + `","author":"A","year":1}`
+
+	cases := []struct {
+		name, method, path, contentType, body string
+		wantStatus                            int
+		want                                  errorResponse
+	}{
+		{
+			name: "wrong content type", method: http.MethodPost, path: "/books", contentType: "text/plain",
+			body:       `{"title":"Dune","author":"A","year":1}`,
+			wantStatus: http.StatusUnsupportedMediaType,
+			want:       errorResponse{Code: "unsupported_media_type", Error: "Content-Type must be application/json"},
+		},
+		{
+			name: "missing content type", method: http.MethodPost, path: "/process-url",
+			body:       `{"url":"https://byfood.com","operation":"all"}`,
+			wantStatus: http.StatusUnsupportedMediaType,
+			want:       errorResponse{Code: "unsupported_media_type", Error: "Content-Type must be application/json"},
+		},
+		{
+			name: "too large", method: http.MethodPost, path: "/books", contentType: "application/json",
+			body:       huge,
+			wantStatus: http.StatusRequestEntityTooLarge,
+			want:       errorResponse{Code: "body_too_large", Error: "request body must be at most 1048576 bytes"},
+		},
+		{
+			name: "unknown field", method: http.MethodPut, path: bookPath, contentType: "application/json; charset=utf-8",
+			body:       `{"title":"Dune","author":"A","year":1,"colour":"red"}`,
+			wantStatus: http.StatusBadRequest,
+			want:       errorResponse{Code: "json_unknown_field", Error: "unknown field colour", Field: "colour"},
+		},
+		{
+			name: "wrong type", method: http.MethodPost, path: "/books", contentType: "application/json",
+			body:       `{"title":"Dune","author":"A","year":"1965"}`,
+			wantStatus: http.StatusBadRequest,
+			want:       errorResponse{Code: "json_type", Error: "year must be a JSON number, not string (offset 42)", Field: "year", Offset: 42},
+		},
+		{
+			name: "syntax error", method: http.MethodPost, path: "/process-url", contentType: "application/json",
+			body:       `{"url":"https://byfood.com",,"operation":"all"}`,
+			wantStatus: http.StatusBadRequest,
+			want:       errorResponse{Code: "json_syntax", Error: "malformed JSON at offset 29", Offset: 29},
+		},
+		{
+			name: "trailing value", method: http.MethodPost, path: "/process-url", contentType: "application/json",
+			body:       `{"url":"https://byfood.com","operation":"all"} {"url":"x"}`,
+			wantStatus: http.StatusBadRequest,
+			want:       errorResponse{Code: "json_trailing_data", Error: "request body must hold a single JSON value; found more at offset 46", Offset: 46},
+		},
+		{
+			name: "empty body", method: http.MethodPost, path: "/books", contentType: "application/json",
+			wantStatus: http.StatusBadRequest,
+			want:       errorResponse{Code: "body_empty", Error: "request body is empty"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := doWithType(t, r, tc.method, tc.path, tc.contentType, tc.body)
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("status = %d, want %d body=%s", rr.Code, tc.wantStatus, rr.Body.String())
+			}
+			if got := decodeJSON[errorResponse](t, rr); got != tc.want {
+				t.Fatalf("got %+v\nwant %+v", got, tc.want)
+			}
+		})
+	}
+
+	// nothing was written by the refused requests
+	if got := decodeJSON[Book](t, doJSON(t, r, http.MethodGet, bookPath, "")); got.Author != "Frank Herbert" {
+		t.Fatalf("book changed: %+v", got)
+	}
+}
+
+func TestReadJSON_TranslatesDetails(t *testing.T) {
+	r, db := setupTestRouter(t)
+	defer db.Close()
+
+	rr := doLocalized(t, r, "ja", http.MethodPost, "/books", `{"title":"Dune","author":"A","year":true}`)
+	got := decodeJSON[errorResponse](t, rr)
+	want := "year には JSON の number を指定してください（boolean は指定できません、オフセット 40）"
+	if got.Code != "json_type" || got.Error != want {
+		t.Fatalf("got %+v", got)
+	}
+}
+
+func TestIdempotency_BodyTooLarge(t *testing.T) {
+	r, _, cleanup := setupIdempotency(t)
+	defer cleanup()
+
+	rr := postIdempotent(t, r, "/process-url", "big", `{"url":"`+strings.Repeat("x", maxJSONBody)+`"}`)
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status = %d body=%s", rr.Code, rr.Body.String())
+	}
+}
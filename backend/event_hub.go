@@ -0,0 +1,100 @@
+package main
+
+import "sync"
+
+// EventHub fans book events out to live subscribers and keeps a bounded log
+// of recent events so reconnecting clients can resume. Publish never blocks:
+// a subscriber whose buffer is full is dropped and has to reconnect.
+type EventHub struct {
+	mu      sync.Mutex
+	log     []BookEvent
+	logSize int
+	subs    map[*Subscription]struct{}
+}
+
+// Subscription receives events on C until it is closed, either by
+// Unsubscribe or because the subscriber fell behind.
+type Subscription struct {
+	C      <-chan BookEvent
+	ch     chan BookEvent
+	closed bool
+}
+
+const subscriberBuffer = 64
+
+func NewEventHub(logSize int) *EventHub {
+	return &EventHub{logSize: logSize, subs: map[*Subscription]struct{}{}}
+}
+
+// Seed preloads the log, e.g. from the outbox on startup. Events must be
+// ordered by ID.
+func (h *EventHub) Seed(events []BookEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, ev := range events {
+		h.appendLocked(ev)
+	}
+}
+
+func (h *EventHub) Publish(ev BookEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.appendLocked(ev)
+	for sub := range h.subs {
+		select {
+		case sub.ch <- ev:
+		default:
+			h.closeLocked(sub)
+		}
+	}
+}
+
+func (h *EventHub) appendLocked(ev BookEvent) {
+	h.log = append(h.log, ev)
+	if over := len(h.log) - h.logSize; over > 0 {
+		h.log = append(h.log[:0:0], h.log[over:]...)
+	}
+}
+
+// Subscribe registers a subscriber and returns the logged events after
+// lastID; lastID 0 means "live events only". complete is false when lastID
+// has already fallen out of the log, in which case the client must reload its
+// state.
+func (h *EventHub) Subscribe(lastID int64) (sub *Subscription, replay []BookEvent, complete bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ch := make(chan BookEvent, subscriberBuffer)
+	sub = &Subscription{C: ch, ch: ch}
+	h.subs[sub] = struct{}{}
+
+	if lastID <= 0 {
+		return sub, nil, true
+	}
+	complete = true
+	if len(h.log) > 0 && h.log[0].ID > lastID+1 {
+		complete = false
+	}
+	for _, ev := range h.log {
+		if ev.ID > lastID {
+			replay = append(replay, ev)
+		}
+	}
+	return sub, replay, complete
+}
+
+func (h *EventHub) Unsubscribe(sub *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closeLocked(sub)
+}
+
+func (h *EventHub) closeLocked(sub *Subscription) {
+	if sub.closed {
+		return
+	}
+	sub.closed = true
+	delete(h.subs, sub)
+	close(sub.ch)
+}
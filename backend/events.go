@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+const (
+	EventBookCreated = "book.created"
+	EventBookUpdated = "book.updated"
+	EventBookDeleted = "book.deleted"
+)
+
+var bookEventTypes = []string{EventBookCreated, EventBookUpdated, EventBookDeleted}
+
+func isValidEventType(t string) bool {
+	for _, v := range bookEventTypes {
+		if t == v {
+			return true
+		}
+	}
+	return false
+}
+
+// BookEvent describes a committed change to a book. ID is the outbox row id
+// and increases with every write.
+type BookEvent struct {
+	ID         int64     `json:"id"`
+	Type       string    `json:"type"`
+	OccurredAt time.Time `json:"occurred_at"`
+	Book       Book      `json:"book"`
+}
+
+// recordBookEvent appends the event to the outbox inside tx, so it is stored
+// if and only if the book write commits.
+func recordBookEvent(ctx context.Context, tx *sql.Tx, eventType string, b Book) (BookEvent, error) {
+	payload, err := json.Marshal(b)
+	if err != nil {
+		return BookEvent{}, err
+	}
+
+	ev := BookEvent{Type: eventType, OccurredAt: time.Now().UTC(), Book: b}
+	res, err := tx.ExecContext(ctx,
+		`INSERT INTO outbox_events(event_type, book_id, payload, created_at, workspace_id) VALUES(?, ?, ?, ?, ?)`,
+		ev.Type, b.ID, string(payload), ev.OccurredAt, b.WorkspaceID,
+	)
+	if err != nil {
+		return BookEvent{}, err
+	}
+	ev.ID, err = res.LastInsertId()
+	return ev, err
+}
+
+// RecentEvents returns up to limit of the latest book events, oldest first.
+// It is used to seed the in-memory event log on startup.
+func (s *BookStore) RecentEvents(ctx context.Context, limit int) ([]BookEvent, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, event_type, created_at, payload FROM (
+			SELECT id, event_type, created_at, payload FROM outbox_events ORDER BY id DESC LIMIT ?
+		) ORDER BY id ASC`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []BookEvent
+	for rows.Next() {
+		var (
+			ev      BookEvent
+			payload string
+		)
+		if err := rows.Scan(&ev.ID, &ev.Type, &ev.OccurredAt, &payload); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(payload), &ev.Book); err != nil {
+			return nil, err
+		}
+		// Events recorded before workspaces existed.
+		if ev.Book.WorkspaceID == 0 {
+			ev.Book.WorkspaceID = DefaultWorkspaceID
+		}
+		out = append(out, ev)
+	}
+	return out, rows.Err()
+}
@@ -12,6 +12,7 @@ require (
 	github.com/go-openapi/spec v0.20.6
 	github.com/go-openapi/swag v0.19.15
 	github.com/google/uuid v1.6.0
+	github.com/graphql-go/graphql v0.8.1
 	github.com/josharian/intern v1.0.0
 	github.com/mailru/easyjson v0.7.6
 	github.com/mattn/go-isatty v0.0.20
@@ -21,10 +22,15 @@ require (
 	github.com/swaggo/http-swagger/v2 v2.0.2
 	github.com/swaggo/swag v1.16.6
 	golang.org/x/exp v0.0.0-20251023183803-a4bb9ffd2546
-	golang.org/x/mod v0.29.0
-	golang.org/x/sync v0.17.0
-	golang.org/x/sys v0.37.0
-	golang.org/x/tools v0.38.0
+	golang.org/x/mod v0.32.0
+	golang.org/x/net v0.51.0
+	golang.org/x/sync v0.20.0
+	golang.org/x/sys v0.42.0
+	golang.org/x/text v0.34.0
+	golang.org/x/tools v0.41.0
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20260226221140-a57be14db171
+	google.golang.org/grpc v1.81.0
+	google.golang.org/protobuf v1.36.12
 	gopkg.in/yaml.v2 v2.4.0
 	modernc.org/libc v1.67.6
 	modernc.org/mathutil v1.7.1
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/location"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+)
+
+type graphQLRequest struct {
+	Query         string         `json:"query"`
+	Variables     map[string]any `json:"variables"`
+	OperationName string         `json:"operationName"`
+	Extensions    map[string]any `json:"extensions,omitempty"`
+}
+
+type GraphQLAPI struct {
+	store  *BookStore
+	schema graphql.Schema
+
+	// MaxDepth and MaxComplexity bound a single operation. Complexity counts
+	// one per field, multiplied by the page size of enclosing connections.
+	MaxDepth      int
+	MaxComplexity int
+}
+
+func NewGraphQLAPI(store *BookStore) (*GraphQLAPI, error) {
+	schema, err := newGraphQLSchema(store)
+	if err != nil {
+		return nil, fmt.Errorf("graphql schema: %w", err)
+	}
+	return &GraphQLAPI{store: store, schema: schema, MaxDepth: 10, MaxComplexity: 2000}, nil
+}
+
+// GraphQLHandler godoc
+// @Summary GraphQL endpoint for books
+// @Description Accepts {query, variables, operationName} as a JSON POST, or query parameters on GET (queries only). Browsers requesting GET without a query get the GraphiQL playground.
+// @Tags graphql
+// @Accept json
+// @Produce json
+// @Param payload body graphQLRequest true "GraphQL request"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 413 {object} map[string]interface{}
+// @Failure 415 {object} map[string]interface{}
+// @Router /graphql [post]
+func (api *GraphQLAPI) GraphQLHandler(w http.ResponseWriter, r *http.Request) {
+	var req graphQLRequest
+	switch r.Method {
+	case http.MethodGet:
+		q := r.URL.Query()
+		if q.Get("query") == "" && strings.Contains(r.Header.Get("Accept"), "text/html") {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			_, _ = w.Write([]byte(graphiQLPage))
+			return
+		}
+		req.Query = q.Get("query")
+		req.OperationName = q.Get("operationName")
+		if raw := q.Get("variables"); raw != "" {
+			if err := json.Unmarshal([]byte(raw), &req.Variables); err != nil {
+				writeGraphQLErrors(w, http.StatusBadRequest, &gqlError{message: "invalid variables", code: "BAD_REQUEST"})
+				return
+			}
+		}
+	default:
+		// The body goes through the same checks as the REST routes, but the
+		// refusal is reported in the GraphQL error shape.
+		if berr := decodeJSONBody(w, r, &req); berr != nil {
+			writeGraphQLErrors(w, berr.status, &gqlError{
+				message: localizeCode(w, r, berr.code, berr.params).Error,
+				code:    strings.ToUpper(berr.code),
+				field:   berr.field,
+			})
+			return
+		}
+	}
+
+	if strings.TrimSpace(req.Query) == "" {
+		writeGraphQLErrors(w, http.StatusBadRequest, &gqlError{message: "query is required", code: "BAD_REQUEST"})
+		return
+	}
+
+	doc, err := parser.Parse(parser.ParseParams{Source: source.NewSource(&source.Source{
+		Body: []byte(req.Query),
+		Name: "GraphQL request",
+	})})
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, &graphql.Result{Errors: gqlerrors.FormatErrors(err)})
+		return
+	}
+
+	op, depth, complexity := analyzeOperation(doc, req.OperationName, req.Variables, api.MaxComplexity)
+	if op != nil && op.Operation == ast.OperationTypeMutation && r.Method == http.MethodGet {
+		writeGraphQLErrors(w, http.StatusMethodNotAllowed, &gqlError{message: "mutations require POST", code: "BAD_REQUEST"})
+		return
+	}
+	if depth > api.MaxDepth {
+		writeGraphQLErrors(w, http.StatusBadRequest, &gqlError{
+			message: fmt.Sprintf("query depth %d exceeds the limit of %d", depth, api.MaxDepth),
+			code:    "QUERY_TOO_DEEP",
+		})
+		return
+	}
+	if complexity > api.MaxComplexity {
+		writeGraphQLErrors(w, http.StatusBadRequest, &gqlError{
+			message: fmt.Sprintf("query complexity %d exceeds the limit of %d", complexity, api.MaxComplexity),
+			code:    "QUERY_TOO_COMPLEX",
+		})
+		return
+	}
+
+	ctx := r.Context()
+	ctx = context.WithValue(ctx, bookLoaderCtxKey{}, newBookLoader(ctx, api.store))
+	result := graphql.Do(graphql.Params{
+		Schema:         api.schema,
+		RequestString:  req.Query,
+		VariableValues: req.Variables,
+		OperationName:  req.OperationName,
+		Context:        ctx,
+	})
+	result.Errors = withErrorExtensions(result.Errors)
+	writeJSON(w, http.StatusOK, result)
+}
+
+func writeGraphQLErrors(w http.ResponseWriter, status int, err *gqlError) {
+	writeJSON(w, status, &graphql.Result{Errors: []gqlerrors.FormattedError{{
+		Message:    err.message,
+		Locations:  []location.SourceLocation{},
+		Extensions: err.Extensions(),
+	}}})
+}
+
+// analyzeOperation finds the operation to run and measures its depth and
+// complexity. Introspection fields are not counted so tooling keeps working.
+// Measuring stops once complexity passes limit, so the figures returned for
+// a rejected operation are lower bounds.
+func analyzeOperation(doc *ast.Document, name string, vars map[string]any, limit int) (*ast.OperationDefinition, int, int) {
+	var op *ast.OperationDefinition
+	fragments := map[string]*ast.FragmentDefinition{}
+	for _, def := range doc.Definitions {
+		switch d := def.(type) {
+		case *ast.OperationDefinition:
+			if op == nil && (name == "" || (d.Name != nil && d.Name.Value == name)) {
+				op = d
+			}
+		case *ast.FragmentDefinition:
+			fragments[d.Name.Value] = d
+		}
+	}
+	if op == nil {
+		// Let graphql-go report the unknown operation.
+		return nil, 0, 0
+	}
+
+	a := &queryAnalyzer{
+		fragments: fragments,
+		vars:      vars,
+		limit:     limit,
+		visiting:  map[string]bool{},
+		costs:     map[string]fragmentCost{},
+	}
+	depth, complexity := a.walk(op.SelectionSet, 1)
+	return op, depth, complexity
+}
+
+type queryAnalyzer struct {
+	fragments map[string]*ast.FragmentDefinition
+	vars      map[string]any
+	limit     int
+	visiting  map[string]bool
+	// costs memoizes each fragment at a multiplier of one, so a fragment
+	// spread many times is only walked once.
+	costs map[string]fragmentCost
+}
+
+type fragmentCost struct {
+	depth, complexity int
+}
+
+// walk returns the depth and complexity of set when each field in it counts
+// multiplier times.
+func (a *queryAnalyzer) walk(set *ast.SelectionSet, multiplier int) (depth, complexity int) {
+	if set == nil {
+		return 0, 0
+	}
+	for _, sel := range set.Selections {
+		if complexity > a.limit {
+			break
+		}
+		switch s := sel.(type) {
+		case *ast.Field:
+			if strings.HasPrefix(s.Name.Value, "__") {
+				continue
+			}
+			child := multiplier
+			if s.Name.Value == "books" {
+				child = a.saturate(child * a.pageSize(s))
+			}
+			d, c := a.walk(s.SelectionSet, child)
+			depth = max(depth, d+1)
+			complexity += multiplier + c
+		case *ast.InlineFragment:
+			d, c := a.walk(s.SelectionSet, multiplier)
+			depth = max(depth, d)
+			complexity += c
+		case *ast.FragmentSpread:
+			cost := a.fragment(s.Name.Value)
+			depth = max(depth, cost.depth)
+			complexity += a.saturate(cost.complexity * multiplier)
+		}
+	}
+	return depth, complexity
+}
+
+func (a *queryAnalyzer) fragment(name string) fragmentCost {
+	if cost, ok := a.costs[name]; ok {
+		return cost
+	}
+	frag, ok := a.fragments[name]
+	if !ok || a.visiting[name] {
+		return fragmentCost{} // unknown or cyclic: rejected by validation
+	}
+	a.visiting[name] = true
+	d, c := a.walk(frag.SelectionSet, 1)
+	delete(a.visiting, name)
+	a.costs[name] = fragmentCost{depth: d, complexity: c}
+	return a.costs[name]
+}
+
+// saturate keeps multipliers just past limit so products cannot overflow.
+func (a *queryAnalyzer) saturate(n int) int {
+	return min(n, a.limit+1)
+}
+
+// pageSize reads the "first" argument of a connection field.
+func (a *queryAnalyzer) pageSize(f *ast.Field) int {
+	for _, arg := range f.Arguments {
+		if arg.Name.Value != "first" {
+			continue
+		}
+		switch v := arg.Value.(type) {
+		case *ast.IntValue:
+			if n, err := strconv.Atoi(v.Value); err == nil && n > 0 {
+				return n
+			}
+		case *ast.Variable:
+			switch n := a.vars[v.Name.Value].(type) {
+			case float64:
+				if n > 0 {
+					return int(n)
+				}
+			case int:
+				if n > 0 {
+					return n
+				}
+			}
+		}
+	}
+	return defaultPageSize
+}
+
+const graphiQLPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>byFood GraphQL playground</title>
+  <link rel="stylesheet" href="https://unpkg.com/graphiql@3/graphiql.min.css">
+  <style>body { margin: 0; height: 100vh; } #graphiql { height: 100vh; }</style>
+</head>
+<body>
+  <div id="graphiql">Loading…</div>
+  <script crossorigin src="https://unpkg.com/react@18/umd/react.production.min.js"></script>
+  <script crossorigin src="https://unpkg.com/react-dom@18/umd/react-dom.production.min.js"></script>
+  <script crossorigin src="https://unpkg.com/graphiql@3/graphiql.min.js"></script>
+  <script>
+    const fetcher = GraphiQL.createFetcher({ url: window.location.pathname });
+    ReactDOM.createRoot(document.getElementById("graphiql")).render(
+      React.createElement(GraphiQL, {
+        fetcher,
+        defaultQuery: "{\n  books(first: 10) {\n    totalCount\n    edges { cursor node { id title author year } }\n    pageInfo { hasNextPage endCursor }\n  }\n}\n",
+      })
+    );
+  </script>
+</body>
+</html>
+`
@@ -0,0 +1,493 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// gqlError carries a stable code (and the offending field for validation
+// errors) in the GraphQL error extensions.
+type gqlError struct {
+	message string
+	code    string
+	field   string
+}
+
+func (e *gqlError) Error() string {
+	return e.message
+}
+
+func (e *gqlError) Extensions() map[string]interface{} {
+	ext := map[string]interface{}{"code": e.code}
+	if e.field != "" {
+		ext["field"] = e.field
+	}
+	return ext
+}
+
+// toGraphQLError maps store errors onto client-facing GraphQL errors.
+// Unexpected errors are logged and hidden.
+func toGraphQLError(ctx context.Context, err error) error {
+	var (
+		ve  *ValidationError
+		dup *DuplicateISBNError
+	)
+	switch {
+	case errors.As(err, &ve):
+		return &gqlError{message: ve.Message, code: "BAD_USER_INPUT", field: ve.Field}
+	case errors.As(err, &dup):
+		return &gqlError{message: dup.Error() + ": book " + strconv.FormatInt(dup.Existing.ID, 10), code: "CONFLICT", field: "isbn"}
+	case errors.Is(err, ErrNotFound):
+		return &gqlError{message: "book not found", code: "NOT_FOUND"}
+	case errors.Is(err, ErrBookQuota):
+		return &gqlError{message: err.Error(), code: "FORBIDDEN"}
+	default:
+		loggerFrom(ctx).Error("graphql resolver", "err", err)
+		return &gqlError{message: "internal error", code: "INTERNAL"}
+	}
+}
+
+// withErrorExtensions restores extensions that graphql-go drops when an error
+// comes out of a thunk: it walks the wrapped errors down to the gqlError.
+func withErrorExtensions(errs []gqlerrors.FormattedError) []gqlerrors.FormattedError {
+	for i, fe := range errs {
+		if fe.Extensions != nil {
+			continue
+		}
+		var err error = fe
+		for err != nil {
+			if ext, ok := err.(gqlerrors.ExtendedError); ok {
+				errs[i].Extensions = ext.Extensions()
+				break
+			}
+			switch e := err.(type) {
+			case gqlerrors.FormattedError:
+				err = e.OriginalError()
+			case *gqlerrors.Error:
+				err = e.OriginalError
+			default:
+				err = errors.Unwrap(err)
+			}
+		}
+	}
+	return errs
+}
+
+// bookLoader batches the Book lookups of one GraphQL request: every Load
+// issued while a level of the query is being resolved becomes a single
+// GetMany when the first result is needed.
+type bookLoader struct {
+	store *BookStore
+	ctx   context.Context
+
+	mu      sync.Mutex
+	pending []int64
+	fetched map[int64]bool
+	cache   map[int64]Book
+	batches int
+}
+
+func newBookLoader(ctx context.Context, store *BookStore) *bookLoader {
+	return &bookLoader{store: store, ctx: ctx, fetched: map[int64]bool{}, cache: map[int64]Book{}}
+}
+
+// Load queues id and returns a thunk resolving to the book.
+func (l *bookLoader) Load(id int64) func() (interface{}, error) {
+	l.mu.Lock()
+	if !l.fetched[id] {
+		l.pending = append(l.pending, id)
+	}
+	l.mu.Unlock()
+
+	return func() (interface{}, error) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		if err := l.flushLocked(); err != nil {
+			return nil, toGraphQLError(l.ctx, err)
+		}
+		b, ok := l.cache[id]
+		if !ok {
+			return nil, toGraphQLError(l.ctx, ErrNotFound)
+		}
+		return b, nil
+	}
+}
+
+func (l *bookLoader) flushLocked() error {
+	if len(l.pending) == 0 {
+		return nil
+	}
+	ids := l.pending
+	l.pending = nil
+
+	books, err := l.store.GetMany(l.ctx, ids)
+	if err != nil {
+		return err
+	}
+	l.batches++
+	for _, id := range ids {
+		l.fetched[id] = true
+	}
+	for id, b := range books {
+		l.cache[id] = b
+	}
+	return nil
+}
+
+// prime stores books loaded by other queries so later lookups are free.
+func (l *bookLoader) prime(books ...Book) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, b := range books {
+		l.cache[b.ID] = b
+		l.fetched[b.ID] = true
+	}
+}
+
+type bookLoaderCtxKey struct{}
+
+func loaderFrom(ctx context.Context) *bookLoader {
+	return ctx.Value(bookLoaderCtxKey{}).(*bookLoader)
+}
+
+type bookConnection struct {
+	filter  BookFilter
+	books   []Book
+	hasNext bool
+}
+
+func encodeCursor(id int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte("book:" + strconv.FormatInt(id, 10)))
+}
+
+func decodeCursor(cursor string) (int64, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	rest, ok := strings.CutPrefix(string(raw), "book:")
+	id, perr := strconv.ParseInt(rest, 10, 64)
+	if err != nil || !ok || perr != nil {
+		return 0, &gqlError{message: "invalid cursor", code: "BAD_USER_INPUT", field: "after"}
+	}
+	return id, nil
+}
+
+func parseGraphQLID(v interface{}) (int64, error) {
+	s, _ := v.(string)
+	id, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || id <= 0 {
+		return 0, &gqlError{message: "invalid id", code: "BAD_USER_INPUT", field: "id"}
+	}
+	return id, nil
+}
+
+func bookFromInput(v interface{}) Book {
+	in, _ := v.(map[string]interface{})
+	var b Book
+	b.Title, _ = in["title"].(string)
+	b.Author, _ = in["author"].(string)
+	b.Year, _ = in["year"].(int)
+	b.ISBN, _ = in["isbn"].(string)
+	return b
+}
+
+func filterFromInput(v interface{}) BookFilter {
+	in, _ := v.(map[string]interface{})
+	var f BookFilter
+	f.Title, _ = in["titleContains"].(string)
+	f.Author, _ = in["authorContains"].(string)
+	f.YearFrom, _ = in["yearFrom"].(int)
+	f.YearTo, _ = in["yearTo"].(int)
+	return f
+}
+
+func timeField(get func(Book) time.Time) *graphql.Field {
+	return &graphql.Field{
+		Type:        graphql.NewNonNull(graphql.DateTime),
+		Description: "RFC 3339 timestamp",
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			return get(p.Source.(Book)), nil
+		},
+	}
+}
+
+// newGraphQLSchema builds the schema on top of BookStore, so validation and
+// persistence are shared with the REST handlers.
+func newGraphQLSchema(store *BookStore) (graphql.Schema, error) {
+	bookType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Book",
+		Fields: graphql.Fields{
+			"id": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.ID),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return strconv.FormatInt(p.Source.(Book).ID, 10), nil
+				},
+			},
+			"title": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.String),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(Book).Title, nil
+				},
+			},
+			"author": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.String),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(Book).Author, nil
+				},
+			},
+			"year": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.Int),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(Book).Year, nil
+				},
+			},
+			"isbn": &graphql.Field{
+				Type:        graphql.NewNonNull(graphql.String),
+				Description: "empty when unknown",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(Book).ISBN, nil
+				},
+			},
+			"createdAt": timeField(func(b Book) time.Time { return b.CreatedAt }),
+			"updatedAt": timeField(func(b Book) time.Time { return b.UpdatedAt }),
+			"ratingAvg": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.Float),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(Book).RatingAvg, nil
+				},
+			},
+			"ratingCount": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.Int),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(Book).RatingCount, nil
+				},
+			},
+			"status": &graphql.Field{
+				Type:        graphql.NewNonNull(graphql.String),
+				Description: "available, on_loan or unavailable",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(Book).Status, nil
+				},
+			},
+			"copiesTotal": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.Int),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(Book).CopiesTotal, nil
+				},
+			},
+			"copiesAvailable": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.Int),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(Book).CopiesAvailable, nil
+				},
+			},
+		},
+	})
+
+	edgeType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "BookEdge",
+		Fields: graphql.Fields{
+			"cursor": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.String),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return encodeCursor(p.Source.(Book).ID), nil
+				},
+			},
+			"node": &graphql.Field{
+				Type: graphql.NewNonNull(bookType),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source, nil
+				},
+			},
+		},
+	})
+
+	pageInfoType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "PageInfo",
+		Fields: graphql.Fields{
+			"hasNextPage": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.Boolean),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*bookConnection).hasNext, nil
+				},
+			},
+			"endCursor": &graphql.Field{
+				Type: graphql.String,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					c := p.Source.(*bookConnection)
+					if len(c.books) == 0 {
+						return nil, nil
+					}
+					return encodeCursor(c.books[len(c.books)-1].ID), nil
+				},
+			},
+		},
+	})
+
+	connectionType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "BookConnection",
+		Fields: graphql.Fields{
+			"edges": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(edgeType))),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*bookConnection).books, nil
+				},
+			},
+			"pageInfo": &graphql.Field{
+				Type: graphql.NewNonNull(pageInfoType),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source, nil
+				},
+			},
+			"totalCount": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.Int),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					n, err := store.Count(p.Context, p.Source.(*bookConnection).filter)
+					if err != nil {
+						return nil, toGraphQLError(p.Context, err)
+					}
+					return n, nil
+				},
+			},
+		},
+	})
+
+	filterType := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name: "BookFilter",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"titleContains":  &graphql.InputObjectFieldConfig{Type: graphql.String},
+			"authorContains": &graphql.InputObjectFieldConfig{Type: graphql.String},
+			"yearFrom":       &graphql.InputObjectFieldConfig{Type: graphql.Int},
+			"yearTo":         &graphql.InputObjectFieldConfig{Type: graphql.Int},
+		},
+	})
+
+	bookInputType := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name: "BookInput",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"title":  &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+			"author": &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+			"year":   &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.Int)},
+			"isbn":   &graphql.InputObjectFieldConfig{Type: graphql.String},
+		},
+	})
+
+	queryType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"book": &graphql.Field{
+				Type: bookType,
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					id, err := parseGraphQLID(p.Args["id"])
+					if err != nil {
+						return nil, err
+					}
+					return loaderFrom(p.Context).Load(id), nil
+				},
+			},
+			"books": &graphql.Field{
+				Type: graphql.NewNonNull(connectionType),
+				Args: graphql.FieldConfigArgument{
+					"first":  &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: defaultPageSize},
+					"after":  &graphql.ArgumentConfig{Type: graphql.String},
+					"filter": &graphql.ArgumentConfig{Type: filterType},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					first, _ := p.Args["first"].(int)
+					if first <= 0 || first > maxPageSize {
+						return nil, &gqlError{message: "first must be between 1 and " + strconv.Itoa(maxPageSize), code: "BAD_USER_INPUT", field: "first"}
+					}
+					q := BookQuery{BookFilter: filterFromInput(p.Args["filter"]), Limit: first + 1}
+					if after, ok := p.Args["after"].(string); ok {
+						id, err := decodeCursor(after)
+						if err != nil {
+							return nil, err
+						}
+						q.AfterID = id
+					}
+
+					books, err := store.Search(p.Context, q)
+					if err != nil {
+						return nil, toGraphQLError(p.Context, err)
+					}
+					c := &bookConnection{filter: q.BookFilter, books: books}
+					if len(books) > first {
+						c.books, c.hasNext = books[:first], true
+					}
+					loaderFrom(p.Context).prime(c.books...)
+					return c, nil
+				},
+			},
+		},
+	})
+
+	mutationType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Mutation",
+		Fields: graphql.Fields{
+			"createBook": &graphql.Field{
+				Type: graphql.NewNonNull(bookType),
+				Args: graphql.FieldConfigArgument{
+					"input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(bookInputType)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					b, err := store.Create(p.Context, bookFromInput(p.Args["input"]))
+					if err != nil {
+						return nil, toGraphQLError(p.Context, err)
+					}
+					return b, nil
+				},
+			},
+			"updateBook": &graphql.Field{
+				Type: graphql.NewNonNull(bookType),
+				Args: graphql.FieldConfigArgument{
+					"id":    &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+					"input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(bookInputType)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					id, err := parseGraphQLID(p.Args["id"])
+					if err != nil {
+						return nil, err
+					}
+					b, err := store.Update(p.Context, id, bookFromInput(p.Args["input"]))
+					if err != nil {
+						return nil, toGraphQLError(p.Context, err)
+					}
+					return b, nil
+				},
+			},
+			"deleteBook": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.Boolean),
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					id, err := parseGraphQLID(p.Args["id"])
+					if err != nil {
+						return nil, err
+					}
+					if err := store.Delete(p.Context, id); err != nil {
+						return nil, toGraphQLError(p.Context, err)
+					}
+					return true, nil
+				},
+			},
+		},
+	})
+
+	return graphql.NewSchema(graphql.SchemaConfig{Query: queryType, Mutation: mutationType})
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type gqlResponse struct {
+	Data   map[string]json.RawMessage `json:"data"`
+	Errors []struct {
+		Message    string         `json:"message"`
+		Extensions map[string]any `json:"extensions"`
+	} `json:"errors"`
+}
+
+func setupGraphQL(t *testing.T) (*chi.Mux, *GraphQLAPI, func()) {
+	t.Helper()
+	r, db := setupTestRouter(t)
+	api, err := NewGraphQLAPI(NewBookStore(db))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Post("/graphql", api.GraphQLHandler)
+	r.Get("/graphql", api.GraphQLHandler)
+	return r, api, func() { db.Close() }
+}
+
+func doGraphQL(t *testing.T, r http.Handler, query string, vars map[string]any) (int, gqlResponse) {
+	t.Helper()
+	body, _ := json.Marshal(graphQLRequest{Query: query, Variables: vars})
+	rr := doJSON(t, r, http.MethodPost, "/graphql", string(body))
+	return rr.Code, decodeJSON[gqlResponse](t, rr)
+}
+
+func TestGraphQL_MutationsAndQueries(t *testing.T) {
+	r, _, cleanup := setupGraphQL(t)
+	defer cleanup()
+
+	_, res := doGraphQL(t, r, `mutation($in: BookInput!) { createBook(input: $in) { id title } }`,
+		map[string]any{"in": map[string]any{"title": "Dune", "author": "Frank Herbert", "year": 1965}})
+	if len(res.Errors) != 0 {
+		t.Fatalf("unexpected errors: %+v", res.Errors)
+	}
+	var created struct{ ID, Title string }
+	_ = json.Unmarshal(res.Data["createBook"], &created)
+
+	_, res = doGraphQL(t, r, fmt.Sprintf(`mutation { updateBook(id: %q, input: {title: "Dune Messiah", author: "Frank Herbert", year: 1969}) { year } }`, created.ID), nil)
+	if len(res.Errors) != 0 || string(res.Data["updateBook"]) != `{"year":1969}` {
+		t.Fatalf("update: %s %+v", res.Data["updateBook"], res.Errors)
+	}
+
+	// Same store as REST.
+	rr := doJSON(t, r, http.MethodGet, "/books/"+created.ID, ``)
+	if got := decodeJSON[Book](t, rr); got.Title != "Dune Messiah" {
+		t.Fatalf("REST sees %+v", got)
+	}
+
+	_, res = doGraphQL(t, r, fmt.Sprintf(`mutation { deleteBook(id: %q) }`, created.ID), nil)
+	if string(res.Data["deleteBook"]) != "true" {
+		t.Fatalf("delete: %s %+v", res.Data["deleteBook"], res.Errors)
+	}
+
+	_, res = doGraphQL(t, r, fmt.Sprintf(`{ book(id: %q) { title } }`, created.ID), nil)
+	if len(res.Errors) != 1 || res.Errors[0].Extensions["code"] != "NOT_FOUND" {
+		t.Fatalf("expected NOT_FOUND, got %+v", res.Errors)
+	}
+}
+
+func TestGraphQL_ValidationErrorExtensions(t *testing.T) {
+	r, _, cleanup := setupGraphQL(t)
+	defer cleanup()
+
+	_, res := doGraphQL(t, r, `mutation { createBook(input: {title: " ", author: "A", year: 2000}) { id } }`, nil)
+	if len(res.Errors) != 1 {
+		t.Fatalf("expected one error, got %+v", res.Errors)
+	}
+	e := res.Errors[0]
+	if e.Message != "title is required" || e.Extensions["code"] != "BAD_USER_INPUT" || e.Extensions["field"] != "title" {
+		t.Fatalf("unexpected error: %+v", e)
+	}
+}
+
+func TestGraphQL_BooksConnectionPaginationAndFilter(t *testing.T) {
+	r, _, cleanup := setupGraphQL(t)
+	defer cleanup()
+
+	for _, b := range []string{
+		`{"title":"Dune","author":"Frank Herbert","year":1965}`,
+		`{"title":"Dune Messiah","author":"Frank Herbert","year":1969}`,
+		`{"title":"Emma","author":"Jane Austen","year":1815}`,
+		`{"title":"Children of Dune","author":"Frank Herbert","year":1976}`,
+	} {
+		doJSON(t, r, http.MethodPost, "/books", b)
+	}
+
+	query := `query($after: String) {
+		books(first: 2, after: $after, filter: {authorContains: "herbert"}) {
+			totalCount
+			edges { node { title } }
+			pageInfo { hasNextPage endCursor }
+		}
+	}`
+	type page struct {
+		TotalCount int
+		Edges      []struct{ Node struct{ Title string } }
+		PageInfo   struct {
+			HasNextPage bool
+			EndCursor   string
+		}
+	}
+
+	_, res := doGraphQL(t, r, query, nil)
+	var p1 page
+	_ = json.Unmarshal(res.Data["books"], &p1)
+	if p1.TotalCount != 3 || len(p1.Edges) != 2 || !p1.PageInfo.HasNextPage {
+		t.Fatalf("page 1: %+v %+v", p1, res.Errors)
+	}
+
+	_, res = doGraphQL(t, r, query, map[string]any{"after": p1.PageInfo.EndCursor})
+	var p2 page
+	_ = json.Unmarshal(res.Data["books"], &p2)
+	if len(p2.Edges) != 1 || p2.Edges[0].Node.Title != "Children of Dune" || p2.PageInfo.HasNextPage {
+		t.Fatalf("page 2: %+v %+v", p2, res.Errors)
+	}
+}
+
+func TestGraphQL_BookLoaderBatches(t *testing.T) {
+	_, db := setupTestRouter(t)
+	defer db.Close()
+
+	store := NewBookStore(db)
+	for i := 0; i < 3; i++ {
+		if _, err := store.Create(t.Context(), Book{Title: "T", Author: "A", Year: 2000}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	l := newBookLoader(t.Context(), store)
+	thunks := []func() (interface{}, error){l.Load(1), l.Load(2), l.Load(3), l.Load(99)}
+	for i, th := range thunks {
+		_, err := th()
+		if (i == 3) != (err != nil) {
+			t.Fatalf("thunk %d err=%v", i, err)
+		}
+	}
+	if l.batches != 1 {
+		t.Fatalf("expected 1 batch, got %d", l.batches)
+	}
+}
+
+func TestGraphQL_DepthAndComplexityLimits(t *testing.T) {
+	r, api, cleanup := setupGraphQL(t)
+	defer cleanup()
+
+	api.MaxDepth = 3
+	code, res := doGraphQL(t, r, `{ books { edges { node { title } } } }`, nil)
+	if code != http.StatusBadRequest || len(res.Errors) != 1 || res.Errors[0].Extensions["code"] != "QUERY_TOO_DEEP" {
+		t.Fatalf("depth: %d %+v", code, res.Errors)
+	}
+
+	api.MaxDepth = 10
+	api.MaxComplexity = 50
+	code, res = doGraphQL(t, r, `query($n: Int) { books(first: $n) { edges { node { id title } } } }`, map[string]any{"n": 100})
+	if code != http.StatusBadRequest || res.Errors[0].Extensions["code"] != "QUERY_TOO_COMPLEX" {
+		t.Fatalf("complexity: %d %+v", code, res.Errors)
+	}
+
+	code, _ = doGraphQL(t, r, `{ books(first: 2) { edges { node { id title } } } }`, nil)
+	if code != http.StatusOK {
+		t.Fatalf("small query rejected: %d", code)
+	}
+}
+
+func TestGraphQL_NestedFragmentsRejectedQuickly(t *testing.T) {
+	r, _, cleanup := setupGraphQL(t)
+	defer cleanup()
+
+	// Eight levels of fragments, each spreading the next ten times, expand to
+	// some 10^8 fields; measuring must not expand them.
+	var q strings.Builder
+	q.WriteString("{ books { edges { node { ...F0 } } } }\n")
+	for i := 0; i < 8; i++ {
+		fmt.Fprintf(&q, "fragment F%d on Book {", i)
+		for j := 0; j < 10; j++ {
+			if i == 7 {
+				fmt.Fprintf(&q, " a%d: title", j)
+			} else {
+				fmt.Fprintf(&q, " ...F%d", i+1)
+			}
+		}
+		q.WriteString(" }\n")
+	}
+
+	start := time.Now()
+	code, res := doGraphQL(t, r, q.String(), nil)
+	if code != http.StatusBadRequest || len(res.Errors) != 1 || res.Errors[0].Extensions["code"] != "QUERY_TOO_COMPLEX" {
+		t.Fatalf("nested fragments: %d %+v", code, res.Errors)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("rejecting nested fragments took %s", elapsed)
+	}
+}
+
+func TestGraphQL_BodyChecks(t *testing.T) {
+	r, _, cleanup := setupGraphQL(t)
+	defer cleanup()
+
+	body, _ := json.Marshal(graphQLRequest{Query: "{ books { totalCount } }" + strings.Repeat(" ", maxJSONBody)})
+	rr := doJSON(t, r, http.MethodPost, "/graphql", string(body))
+	res := decodeJSON[gqlResponse](t, rr)
+	if rr.Code != http.StatusRequestEntityTooLarge || len(res.Errors) != 1 || res.Errors[0].Extensions["code"] != "BODY_TOO_LARGE" {
+		t.Fatalf("oversized body: %d %+v", rr.Code, res.Errors)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{"query":"{ books { totalCount } }"}`))
+	req.Header.Set("Content-Type", "text/plain")
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	res = decodeJSON[gqlResponse](t, rr)
+	if rr.Code != http.StatusUnsupportedMediaType || len(res.Errors) != 1 || res.Errors[0].Extensions["code"] != "UNSUPPORTED_MEDIA_TYPE" {
+		t.Fatalf("text/plain body: %d %+v", rr.Code, res.Errors)
+	}
+
+	rr = doJSON(t, r, http.MethodPost, "/graphql", `{"query":"{ books { totalCount } }","queryId":"x"}`)
+	res = decodeJSON[gqlResponse](t, rr)
+	if rr.Code != http.StatusBadRequest || len(res.Errors) != 1 || res.Errors[0].Extensions["code"] != "JSON_UNKNOWN_FIELD" {
+		t.Fatalf("unknown field: %d %+v", rr.Code, res.Errors)
+	}
+
+	rr = doJSON(t, r, http.MethodPost, "/graphql", `{"query":"{ books { totalCount } }","extensions":{"trace":true}}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("extensions rejected: %d %s", rr.Code, rr.Body)
+	}
+}
+
+func TestGraphQL_Playground(t *testing.T) {
+	r, _, cleanup := setupGraphQL(t)
+	defer cleanup()
+
+	req := httptest.NewRequest(http.MethodGet, "/graphql", nil)
+	req.Header.Set("Accept", "text/html")
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "graphiql") {
+		t.Fatalf("playground: %d", rr.Code)
+	}
+
+	rr = doJSON(t, r, http.MethodGet, `/graphql?query=mutation%7BdeleteBook(id:%221%22)%7D`, ``)
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("mutation over GET: %d", rr.Code)
+	}
+}
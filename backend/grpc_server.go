@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"byfood/backend/pb"
+)
+
+//go:generate protoc -I proto --go_out=pb --go_opt=paths=source_relative --go-grpc_out=pb --go-grpc_opt=paths=source_relative proto/byfood.proto
+
+// grpcListPageSize is how many rows BookService.List reads per query.
+const grpcListPageSize = 100
+
+// NewGRPCServer serves BookService and URLService on top of the same store
+// and URL processor as the REST API, plus health checks and reflection.
+// BookService calls are scoped to a workspace like REST requests.
+func NewGRPCServer(store *BookStore, workspaces *WorkspaceStore, logger *slog.Logger) *grpc.Server {
+	srv := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(unaryLogger(logger), unaryWorkspace(workspaces)),
+		grpc.ChainStreamInterceptor(streamLogger(logger), streamWorkspace(workspaces)),
+	)
+	pb.RegisterBookServiceServer(srv, &bookServiceServer{store: store})
+	pb.RegisterURLServiceServer(srv, &urlServiceServer{})
+
+	hs := health.NewServer()
+	hs.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	hs.SetServingStatus(pb.BookService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	hs.SetServingStatus(pb.URLService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(srv, hs)
+	reflection.Register(srv)
+	return srv
+}
+
+type bookServiceServer struct {
+	pb.UnimplementedBookServiceServer
+	store *BookStore
+}
+
+func (s *bookServiceServer) Get(ctx context.Context, req *pb.GetBookRequest) (*pb.Book, error) {
+	b, err := s.store.Get(ctx, req.GetId())
+	if err != nil {
+		return nil, grpcError(ctx, "get book", err)
+	}
+	return toPBBook(b), nil
+}
+
+func (s *bookServiceServer) List(req *pb.ListBooksRequest, stream grpc.ServerStreamingServer[pb.Book]) error {
+	ctx := stream.Context()
+	q := BookQuery{
+		BookFilter: BookFilter{
+			Title:    req.GetTitleContains(),
+			Author:   req.GetAuthorContains(),
+			YearFrom: int(req.GetYearFrom()),
+			YearTo:   int(req.GetYearTo()),
+		},
+		Limit: grpcListPageSize,
+	}
+	for {
+		page, err := s.store.Search(ctx, q)
+		if err != nil {
+			return grpcError(ctx, "list books", err)
+		}
+		for _, b := range page {
+			if err := stream.Send(toPBBook(b)); err != nil {
+				return err
+			}
+		}
+		if len(page) < q.Limit {
+			return nil
+		}
+		q.AfterID = page[len(page)-1].ID
+	}
+}
+
+func (s *bookServiceServer) Create(ctx context.Context, req *pb.CreateBookRequest) (*pb.Book, error) {
+	b, err := s.store.Create(ctx, fromPBInput(req.GetBook()))
+	if err != nil {
+		return nil, grpcError(ctx, "create book", err)
+	}
+	return toPBBook(b), nil
+}
+
+func (s *bookServiceServer) Update(ctx context.Context, req *pb.UpdateBookRequest) (*pb.Book, error) {
+	b, err := s.store.Update(ctx, req.GetId(), fromPBInput(req.GetBook()))
+	if err != nil {
+		return nil, grpcError(ctx, "update book", err)
+	}
+	return toPBBook(b), nil
+}
+
+func (s *bookServiceServer) Delete(ctx context.Context, req *pb.DeleteBookRequest) (*emptypb.Empty, error) {
+	if err := s.store.Delete(ctx, req.GetId()); err != nil {
+		return nil, grpcError(ctx, "delete book", err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+type urlServiceServer struct {
+	pb.UnimplementedURLServiceServer
+}
+
+func (s *urlServiceServer) Process(ctx context.Context, req *pb.ProcessURLRequest) (*pb.ProcessURLResponse, error) {
+	processed, err := processURLMessage(req)
+	if err != nil {
+		return nil, grpcError(ctx, "process url", err)
+	}
+	return &pb.ProcessURLResponse{ProcessedUrl: processed}, nil
+}
+
+func (s *urlServiceServer) ProcessStream(stream grpc.BidiStreamingServer[pb.ProcessURLRequest, pb.ProcessURLResult]) error {
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		res := &pb.ProcessURLResult{RequestId: req.GetRequestId()}
+		if processed, err := processURLMessage(req); err != nil {
+			res.Error = err.Error()
+		} else {
+			res.ProcessedUrl = processed
+		}
+		if err := stream.Send(res); err != nil {
+			return err
+		}
+	}
+}
+
+func processURLMessage(req *pb.ProcessURLRequest) (string, error) {
+	in := processURLRequest{URL: req.GetUrl(), Operation: req.GetOperation()}
+	parsed, err := validateProcessURLRequest(&in)
+	if err != nil {
+		return "", err
+	}
+	return processURL(parsed, in.Operation)
+}
+
+// grpcError maps store errors onto status codes the same way the REST
+// handlers map them onto HTTP statuses. Unexpected errors are logged.
+func grpcError(ctx context.Context, op string, err error) error {
+	var (
+		verr *ValidationError
+		dup  *DuplicateISBNError
+	)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return status.Error(codes.NotFound, "book not found")
+	case errors.As(err, &dup):
+		return status.Errorf(codes.AlreadyExists, "%s: book %d", dup.Error(), dup.Existing.ID)
+	case errors.Is(err, ErrBookQuota):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.As(err, &verr):
+		st := status.New(codes.InvalidArgument, verr.Message)
+		detailed, derr := st.WithDetails(&errdetails.BadRequest{
+			FieldViolations: []*errdetails.BadRequest_FieldViolation{{Field: verr.Field, Description: verr.Message}},
+		})
+		if derr != nil {
+			return st.Err()
+		}
+		return detailed.Err()
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return status.FromContextError(err).Err()
+	default:
+		loggerFrom(ctx).Error(op, "err", err)
+		return status.Error(codes.Internal, "internal error")
+	}
+}
+
+func toPBBook(b Book) *pb.Book {
+	return &pb.Book{
+		Id:              b.ID,
+		Title:           b.Title,
+		Author:          b.Author,
+		Year:            int32(b.Year),
+		CreatedAt:       timestamppb.New(b.CreatedAt),
+		UpdatedAt:       timestamppb.New(b.UpdatedAt),
+		RatingAvg:       b.RatingAvg,
+		RatingCount:     int32(b.RatingCount),
+		Status:          b.Status,
+		CopiesTotal:     int32(b.CopiesTotal),
+		CopiesAvailable: int32(b.CopiesAvailable),
+		Isbn:            b.ISBN,
+	}
+}
+
+func fromPBInput(in *pb.BookInput) Book {
+	return Book{Title: in.GetTitle(), Author: in.GetAuthor(), Year: int(in.GetYear()), ISBN: in.GetIsbn()}
+}
+
+// grpcContext attaches a request-scoped logger carrying the method and the
+// caller's x-user metadata, mirroring RequestLogger and Identify for HTTP.
+func grpcContext(ctx context.Context, base *slog.Logger, method string) (context.Context, *slog.Logger) {
+	l := base.With("rpc", method)
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if u := md.Get(userHeader); len(u) > 0 && u[0] != "" {
+			ctx = withUser(ctx, u[0])
+			l = l.With("user", u[0])
+		}
+	}
+	return withLogger(ctx, l), l
+}
+
+func unaryLogger(base *slog.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		start := time.Now()
+		ctx, l := grpcContext(ctx, base, info.FullMethod)
+		resp, err := handler(ctx, req)
+		l.Info("rpc", "code", status.Code(err).String(), "latency", time.Since(start))
+		return resp, err
+	}
+}
+
+type loggedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *loggedStream) Context() context.Context { return s.ctx }
+
+func streamLogger(base *slog.Logger) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		ctx, l := grpcContext(ss.Context(), base, info.FullMethod)
+		err := handler(srv, &loggedStream{ServerStream: ss, ctx: ctx})
+		l.Info("rpc", "code", status.Code(err).String(), "latency", time.Since(start))
+		return err
+	}
+}
+
+// grpcWorkspace resolves the workspace of a BookService call from the
+// x-workspace metadata, following the same rules as ResolveWorkspace.
+func grpcWorkspace(ctx context.Context, store *WorkspaceStore, method string) (context.Context, error) {
+	if !strings.HasPrefix(method, "/"+pb.BookService_ServiceDesc.ServiceName+"/") {
+		return ctx, nil
+	}
+	var slug string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get(workspaceHeader); len(v) > 0 {
+			slug = strings.TrimSpace(v[0])
+		}
+	}
+	user, _ := userFromContext(ctx)
+	id, err := store.Resolve(ctx, user, slug)
+	switch {
+	case err == nil:
+		return withWorkspace(ctx, id), nil
+	case err == ErrWorkspaceNotFound:
+		return nil, status.Error(codes.NotFound, err.Error())
+	case err == ErrNotMember && user == "":
+		return nil, status.Error(codes.Unauthenticated, "authentication required")
+	case err == ErrNotMember:
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	case err == ErrWorkspaceRequired:
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	case err == ErrWorkspaceArchived:
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	default:
+		loggerFrom(ctx).Error("resolve workspace", "err", err)
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+}
+
+func unaryWorkspace(store *WorkspaceStore) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, err := grpcWorkspace(ctx, store, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func streamWorkspace(store *WorkspaceStore) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := grpcWorkspace(ss.Context(), store, info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &loggedStream{ServerStream: ss, ctx: ctx})
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"byfood/backend/pb"
+)
+
+func setupGRPC(t *testing.T) (*grpc.ClientConn, func()) {
+	t.Helper()
+
+	_, db := setupTestRouter(t)
+	srv := NewGRPCServer(NewBookStore(db), NewWorkspaceStore(db), slog.New(slog.DiscardHandler))
+	lis := bufconn.Listen(1 << 20)
+	go func() { _ = srv.Serve(lis) }()
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn, func() {
+		conn.Close()
+		srv.Stop()
+		db.Close()
+	}
+}
+
+func TestGRPC_BookCRUD(t *testing.T) {
+	conn, cleanup := setupGRPC(t)
+	defer cleanup()
+	ctx := t.Context()
+	books := pb.NewBookServiceClient(conn)
+
+	created, err := books.Create(ctx, &pb.CreateBookRequest{Book: &pb.BookInput{Title: "Dune", Author: "Frank Herbert", Year: 1965}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.GetId() == 0 || created.GetCreatedAt().AsTime().IsZero() {
+		t.Fatalf("unexpected created book: %v", created)
+	}
+
+	updated, err := books.Update(ctx, &pb.UpdateBookRequest{Id: created.GetId(), Book: &pb.BookInput{Title: "Dune Messiah", Author: "Frank Herbert", Year: 1969}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := books.Get(ctx, &pb.GetBookRequest{Id: created.GetId()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.GetTitle() != "Dune Messiah" || got.GetYear() != updated.GetYear() {
+		t.Fatalf("unexpected book: %v", got)
+	}
+
+	if _, err := books.Delete(ctx, &pb.DeleteBookRequest{Id: created.GetId()}); err != nil {
+		t.Fatal(err)
+	}
+	_, err = books.Get(ctx, &pb.GetBookRequest{Id: created.GetId()})
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("expected NotFound, got %v", err)
+	}
+	_, err = books.Delete(ctx, &pb.DeleteBookRequest{Id: created.GetId()})
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("expected NotFound on second delete, got %v", err)
+	}
+}
+
+func TestGRPC_ValidationDetails(t *testing.T) {
+	conn, cleanup := setupGRPC(t)
+	defer cleanup()
+
+	_, err := pb.NewBookServiceClient(conn).Create(t.Context(), &pb.CreateBookRequest{Book: &pb.BookInput{Title: "Dune", Year: 1965}})
+	st := status.Convert(err)
+	if st.Code() != codes.InvalidArgument || st.Message() != "author is required" {
+		t.Fatalf("unexpected status: %v", st)
+	}
+	var field string
+	for _, d := range st.Details() {
+		if br, ok := d.(*errdetails.BadRequest); ok && len(br.GetFieldViolations()) == 1 {
+			field = br.GetFieldViolations()[0].GetField()
+		}
+	}
+	if field != "author" {
+		t.Fatalf("expected a field violation for author, got %v", st.Details())
+	}
+}
+
+func TestGRPC_ListStreamsAcrossPages(t *testing.T) {
+	conn, cleanup := setupGRPC(t)
+	defer cleanup()
+	ctx := t.Context()
+	books := pb.NewBookServiceClient(conn)
+
+	for i := 0; i < grpcListPageSize+5; i++ {
+		author := "Frank Herbert"
+		if i%2 == 1 {
+			author = "Ursula K. Le Guin"
+		}
+		if _, err := books.Create(ctx, &pb.CreateBookRequest{Book: &pb.BookInput{Title: "Book", Author: author, Year: int32(1950 + i)}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	count := func(req *pb.ListBooksRequest) int {
+		t.Helper()
+		stream, err := books.List(ctx, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n := 0
+		var last int64
+		for {
+			b, err := stream.Recv()
+			if errors.Is(err, io.EOF) {
+				return n
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if b.GetId() <= last {
+				t.Fatalf("out of order: %d after %d", b.GetId(), last)
+			}
+			last = b.GetId()
+			n++
+		}
+	}
+
+	if n := count(&pb.ListBooksRequest{}); n != grpcListPageSize+5 {
+		t.Fatalf("expected %d books, got %d", grpcListPageSize+5, n)
+	}
+	if n := count(&pb.ListBooksRequest{AuthorContains: "le guin", YearFrom: 1960, YearTo: 1969}); n != 5 {
+		t.Fatalf("expected 5 filtered books, got %d", n)
+	}
+}
+
+func TestGRPC_ProcessURL(t *testing.T) {
+	conn, cleanup := setupGRPC(t)
+	defer cleanup()
+	ctx := t.Context()
+	urls := pb.NewURLServiceClient(conn)
+
+	res, err := urls.Process(ctx, &pb.ProcessURLRequest{Url: "https://BYFOOD.com/food-EXPeriences?query=abc/", Operation: "all"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.GetProcessedUrl() != "https://www.byfood.com/food-experiences" {
+		t.Fatalf("unexpected result: %q", res.GetProcessedUrl())
+	}
+	_, err = urls.Process(ctx, &pb.ProcessURLRequest{Url: "https://byfood.com", Operation: "shorten"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument, got %v", err)
+	}
+
+	stream, err := urls.ProcessStream(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reqs := []*pb.ProcessURLRequest{
+		{RequestId: "a", Url: "https://byfood.com/x?y=1", Operation: "canonical"},
+		{RequestId: "b", Url: "not a url", Operation: "all"},
+		{RequestId: "c", Url: "https://BYFOOD.com/X", Operation: "redirection"},
+	}
+	for _, req := range reqs {
+		if err := stream.Send(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := stream.CloseSend(); err != nil {
+		t.Fatal(err)
+	}
+
+	var results []*pb.ProcessURLResult
+	for {
+		r, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		results = append(results, r)
+	}
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results, got %d", len(results))
+	}
+	if results[0].GetRequestId() != "a" || results[0].GetProcessedUrl() != "https://byfood.com/x" {
+		t.Fatalf("unexpected first result: %v", results[0])
+	}
+	if results[1].GetError() != "invalid URL (must include scheme and host)" || results[1].GetProcessedUrl() != "" {
+		t.Fatalf("expected per-item error, got %v", results[1])
+	}
+	if results[2].GetProcessedUrl() != "https://www.byfood.com/x" {
+		t.Fatalf("unexpected third result: %v", results[2])
+	}
+}
+
+func TestGRPC_Health(t *testing.T) {
+	conn, cleanup := setupGRPC(t)
+	defer cleanup()
+
+	res, err := healthpb.NewHealthClient(conn).Check(t.Context(), &healthpb.HealthCheckRequest{Service: "byfood.v1.BookService"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		t.Fatalf("unexpected health: %v", res.GetStatus())
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+)
+
+type HoldsAPI struct {
+	store *LoanStore
+}
+
+func NewHoldsAPI(store *LoanStore) *HoldsAPI {
+	return &HoldsAPI{store: store}
+}
+
+// PlaceHoldHandler godoc
+// @Summary Reserve a book
+// @Description Joins the book's FIFO queue. Only possible while no copy is on the shelf. When a copy comes back it is set aside for the first hold, which becomes ready and has to be collected with a checkout within the pickup window (3 days by default) or it expires.
+// @Tags holds
+// @Produce json
+// @Param id path int true "Book ID"
+// @Param X-User header string true "Borrower"
+// @Success 201 {object} Hold
+// @Failure 400 {object} errorResponse
+// @Failure 401 {object} errorResponse
+// @Failure 404 {object} errorResponse
+// @Failure 409 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /books/{id}/holds [post]
+func (api *HoldsAPI) PlaceHoldHandler(w http.ResponseWriter, r *http.Request) {
+	bookID, ok := parseIDParam(w, r, "id")
+	if !ok {
+		return
+	}
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	h, err := api.store.PlaceHold(r.Context(), bookID, user)
+	api.writeResult(w, r, http.StatusCreated, h, err)
+}
+
+// ListBookHoldsHandler godoc
+// @Summary List a book's active holds in queue order
+// @Description Ready holds come first, then waiting holds by position.
+// @Tags holds
+// @Produce json
+// @Param id path int true "Book ID"
+// @Success 200 {array} Hold
+// @Failure 400 {object} errorResponse
+// @Failure 404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /books/{id}/holds [get]
+func (api *HoldsAPI) ListBookHoldsHandler(w http.ResponseWriter, r *http.Request) {
+	bookID, ok := parseIDParam(w, r, "id")
+	if !ok {
+		return
+	}
+	holds, err := api.store.Holds(r.Context(), HoldFilter{BookID: bookID, Active: true})
+	api.writeResult(w, r, http.StatusOK, holds, err)
+}
+
+// GetHoldHandler godoc
+// @Summary Get a hold and its queue position
+// @Tags holds
+// @Produce json
+// @Param id path int true "Book ID"
+// @Param holdID path int true "Hold ID"
+// @Success 200 {object} Hold
+// @Failure 400 {object} errorResponse
+// @Failure 404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /books/{id}/holds/{holdID} [get]
+func (api *HoldsAPI) GetHoldHandler(w http.ResponseWriter, r *http.Request) {
+	bookID, ok := parseIDParam(w, r, "id")
+	if !ok {
+		return
+	}
+	id, ok := parseIDParam(w, r, "holdID")
+	if !ok {
+		return
+	}
+	h, err := api.store.GetHold(r.Context(), bookID, id)
+	api.writeResult(w, r, http.StatusOK, h, err)
+}
+
+// CancelHoldHandler godoc
+// @Summary Cancel your hold
+// @Description A copy set aside for the hold goes to the next one in the queue.
+// @Tags holds
+// @Param id path int true "Book ID"
+// @Param holdID path int true "Hold ID"
+// @Param X-User header string true "Borrower"
+// @Success 204 "No Content"
+// @Failure 400 {object} errorResponse
+// @Failure 401 {object} errorResponse
+// @Failure 403 {object} errorResponse
+// @Failure 404 {object} errorResponse
+// @Failure 409 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /books/{id}/holds/{holdID} [delete]
+func (api *HoldsAPI) CancelHoldHandler(w http.ResponseWriter, r *http.Request) {
+	bookID, ok := parseIDParam(w, r, "id")
+	if !ok {
+		return
+	}
+	id, ok := parseIDParam(w, r, "holdID")
+	if !ok {
+		return
+	}
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	_, err := api.store.CancelHold(r.Context(), bookID, id, user)
+	if err == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	api.writeResult(w, r, 0, nil, err)
+}
+
+// ListHoldsHandler godoc
+// @Summary List holds across books
+// @Description Filter by `user` to see someone's reservations and their queue positions.
+// @Tags holds
+// @Produce json
+// @Param user query string false "Borrower"
+// @Param active query bool false "Only waiting and ready holds"
+// @Success 200 {array} Hold
+// @Failure 400 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /holds [get]
+func (api *HoldsAPI) ListHoldsHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f := HoldFilter{User: q.Get("user")}
+	if raw := q.Get("active"); raw != "" {
+		v, err := strconv.ParseBool(raw)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "active_invalid")
+			return
+		}
+		f.Active = v
+	}
+
+	holds, err := api.store.Holds(r.Context(), f)
+	api.writeResult(w, r, http.StatusOK, holds, err)
+}
+
+// writeResult maps hold errors onto responses, or writes v with status on
+// success.
+func (api *HoldsAPI) writeResult(w http.ResponseWriter, r *http.Request, status int, v any, err error) {
+	switch {
+	case err == nil:
+		writeJSON(w, status, v)
+	case err == ErrNotFound:
+		writeError(w, r, http.StatusNotFound, "book_not_found")
+	case err == ErrHoldNotFound:
+		writeError(w, r, http.StatusNotFound, errorCode(err))
+	case err == ErrCopyAvailableNow, err == ErrAlreadyBorrowed, err == ErrHoldExists, err == ErrHoldClosed:
+		writeError(w, r, http.StatusConflict, errorCode(err))
+	case err == ErrForbidden:
+		writeError(w, r, http.StatusForbidden, "hold_not_yours")
+	default:
+		loggerFrom(r.Context()).Error("write hold", "err", err)
+		writeError(w, r, http.StatusInternalServerError, "internal_error")
+	}
+}
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultHoldPickup is how long a ready hold waits to be collected.
+const defaultHoldPickup = 3 * 24 * time.Hour
+
+// Hold statuses. A hold waits in its book's queue until a copy is set aside
+// for it, then stays ready until it is collected by a checkout, cancelled or
+// expires.
+const (
+	HoldWaiting   = "waiting"
+	HoldReady     = "ready"
+	HoldCollected = "collected"
+	HoldCancelled = "cancelled"
+	HoldExpired   = "expired"
+)
+
+var (
+	// ErrCopyAvailableNow means a hold is pointless because a copy is on the
+	// shelf.
+	ErrCopyAvailableNow = newCodedError("copy_available_now")
+	// ErrAlreadyBorrowed means the user already has the book on loan.
+	ErrAlreadyBorrowed = newCodedError("already_borrowed")
+	// ErrHoldExists means the user is already in the book's queue.
+	ErrHoldExists = newCodedError("hold_exists")
+	// ErrHoldClosed means the hold was collected, cancelled or expired.
+	ErrHoldClosed = newCodedError("hold_closed")
+	// ErrHoldNotFound means the book has no hold with that id.
+	ErrHoldNotFound = newCodedError("hold_not_found")
+)
+
+// Hold is a user's place in a book's reservation queue. Position is the
+// 1-based place among waiting holds and 0 otherwise.
+type Hold struct {
+	ID        int64      `json:"id"`
+	BookID    int64      `json:"book_id"`
+	User      string     `json:"user"`
+	Status    string     `json:"status" example:"waiting"`
+	Position  int        `json:"position,omitempty" example:"2"`
+	CopyID    *int64     `json:"copy_id,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	ReadyAt   *time.Time `json:"ready_at,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	ClosedAt  *time.Time `json:"closed_at,omitempty"`
+}
+
+// HoldFilter narrows hold listings. Zero values match everything.
+type HoldFilter struct {
+	BookID int64
+	User   string
+	Active bool // waiting or ready
+}
+
+// holdColumns selects from holds aliased as h and computes the position.
+const holdColumns = `h.id, h.book_id, h.user, h.status, h.copy_id, h.created_at, h.ready_at, h.expires_at, h.closed_at,
+	CASE WHEN h.status = 'waiting' THEN
+		(SELECT COUNT(*) FROM holds q WHERE q.book_id = h.book_id AND q.status = 'waiting' AND q.id <= h.id)
+	ELSE 0 END`
+
+func scanHold(row rowScanner) (Hold, error) {
+	var (
+		h                        Hold
+		copyID                   sql.NullInt64
+		ready, expires, closedAt sql.NullTime
+	)
+	err := row.Scan(&h.ID, &h.BookID, &h.User, &h.Status, &copyID, &h.CreatedAt, &ready, &expires, &closedAt, &h.Position)
+	if copyID.Valid {
+		h.CopyID = &copyID.Int64
+	}
+	if ready.Valid {
+		h.ReadyAt = &ready.Time
+	}
+	if expires.Valid {
+		h.ExpiresAt = &expires.Time
+	}
+	if closedAt.Valid {
+		h.ClosedAt = &closedAt.Time
+	}
+	return h, err
+}
+
+func getHold(ctx context.Context, q queryRower, bookID, id int64) (Hold, error) {
+	h, err := scanHold(q.QueryRowContext(ctx,
+		`SELECT `+holdColumns+` FROM holds h WHERE h.id = ? AND h.book_id = ?`, id, bookID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Hold{}, ErrHoldNotFound
+	}
+	return h, err
+}
+
+// PlaceHold puts user at the end of the book's queue. Holds are only taken
+// while no copy is on the shelf.
+func (s *LoanStore) PlaceHold(ctx context.Context, bookID int64, user string) (Hold, error) {
+	unlock := s.locks.Lock(strconv.FormatInt(bookID, 10))
+	defer unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Hold{}, err
+	}
+	defer tx.Rollback()
+
+	var available, borrowed int
+	err = tx.QueryRowContext(ctx, `
+		SELECT
+			(SELECT COUNT(*) FROM copies WHERE book_id = ?1 AND status = 'available' AND retired_at IS NULL),
+			(SELECT COUNT(*) FROM loans WHERE book_id = ?1 AND user = ?2 AND returned_at IS NULL)
+		FROM books WHERE id = ?1`, bookID, user,
+	).Scan(&available, &borrowed)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Hold{}, ErrNotFound
+	}
+	if err != nil {
+		return Hold{}, err
+	}
+	if available > 0 {
+		return Hold{}, ErrCopyAvailableNow
+	}
+	if borrowed > 0 {
+		return Hold{}, ErrAlreadyBorrowed
+	}
+
+	var id int64
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO holds(book_id, user, status, created_at) VALUES(?, ?, 'waiting', ?) RETURNING id`,
+		bookID, user, s.now().UTC(),
+	).Scan(&id)
+	if isUniqueViolation(err) {
+		return Hold{}, ErrHoldExists
+	}
+	if err != nil {
+		return Hold{}, err
+	}
+
+	h, err := getHold(ctx, tx, bookID, id)
+	if err != nil {
+		return Hold{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Hold{}, err
+	}
+	loggerFrom(ctx).Debug("hold placed", "book_id", bookID, "hold_id", id, "position", h.Position)
+	return h, nil
+}
+
+// CancelHold withdraws user's hold. A copy set aside for it goes to the next
+// hold in the queue.
+func (s *LoanStore) CancelHold(ctx context.Context, bookID, id int64, user string) (Hold, error) {
+	unlock := s.locks.Lock(strconv.FormatInt(bookID, 10))
+	defer unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Hold{}, err
+	}
+	defer tx.Rollback()
+
+	h, err := getHold(ctx, tx, bookID, id)
+	if err != nil {
+		return Hold{}, err
+	}
+	if h.User != user {
+		return Hold{}, ErrForbidden
+	}
+	if h.Status != HoldWaiting && h.Status != HoldReady {
+		return Hold{}, ErrHoldClosed
+	}
+
+	now := s.now().UTC()
+	if err := s.closeHold(ctx, tx, h, HoldCancelled, now); err != nil {
+		return Hold{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Hold{}, err
+	}
+	h.Status, h.Position, h.ClosedAt = HoldCancelled, 0, &now
+	loggerFrom(ctx).Debug("hold cancelled", "book_id", bookID, "hold_id", id)
+	return h, nil
+}
+
+// GetHold returns one hold with its current queue position.
+func (s *LoanStore) GetHold(ctx context.Context, bookID, id int64) (Hold, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	return getHold(ctx, s.db, bookID, id)
+}
+
+// Holds lists matching holds of the caller's workspace: ready ones first,
+// then the queue in order, then closed ones newest first.
+func (s *LoanStore) Holds(ctx context.Context, f HoldFilter) ([]Hold, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	if f.BookID > 0 {
+		if err := bookExists(ctx, s.db, f.BookID); err != nil {
+			return nil, err
+		}
+	}
+
+	var (
+		conds = []string{`h.book_id IN (SELECT id FROM books WHERE workspace_id = ?)`}
+		args  = []any{workspaceFromContext(ctx)}
+	)
+	if f.BookID > 0 {
+		conds = append(conds, `h.book_id = ?`)
+		args = append(args, f.BookID)
+	}
+	if f.User != "" {
+		conds = append(conds, `h.user = ?`)
+		args = append(args, f.User)
+	}
+	if f.Active {
+		conds = append(conds, `h.status IN ('waiting', 'ready')`)
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+holdColumns+` FROM holds h WHERE `+strings.Join(conds, " AND ")+`
+		ORDER BY CASE h.status WHEN 'ready' THEN 0 WHEN 'waiting' THEN 1 ELSE 2 END,
+			CASE WHEN h.status IN ('waiting', 'ready') THEN h.id ELSE -h.id END`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []Hold{}
+	for rows.Next() {
+		h, err := scanHold(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, h)
+	}
+	return out, rows.Err()
+}
+
+// ExpireHolds expires ready holds that were not collected in time and hands
+// their copies to the next holds in line. It returns how many expired.
+func (s *LoanStore) ExpireHolds(ctx context.Context) (int, error) {
+	now := s.now().UTC()
+
+	qctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	rows, err := s.db.QueryContext(qctx,
+		`SELECT id, book_id FROM holds WHERE status = 'ready' AND expires_at < ? ORDER BY expires_at LIMIT 100`, now)
+	if err != nil {
+		cancel()
+		return 0, err
+	}
+	type due struct{ id, bookID int64 }
+	var expired []due
+	for rows.Next() {
+		var d due
+		if err := rows.Scan(&d.id, &d.bookID); err != nil {
+			rows.Close()
+			cancel()
+			return 0, err
+		}
+		expired = append(expired, d)
+	}
+	err = rows.Err()
+	rows.Close()
+	cancel()
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, d := range expired {
+		ok, err := s.expireHold(ctx, d.bookID, d.id, now)
+		if err != nil {
+			return n, err
+		}
+		if ok {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (s *LoanStore) expireHold(ctx context.Context, bookID, id int64, now time.Time) (bool, error) {
+	unlock := s.locks.Lock(strconv.FormatInt(bookID, 10))
+	defer unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	// Re-check under the lock: the hold may have been collected meanwhile.
+	h, err := getHold(ctx, tx, bookID, id)
+	if err != nil {
+		return false, err
+	}
+	if h.Status != HoldReady || h.ExpiresAt == nil || !h.ExpiresAt.Before(now) {
+		return false, nil
+	}
+	if err := s.closeHold(ctx, tx, h, HoldExpired, now); err != nil {
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+	loggerFrom(ctx).Debug("hold expired", "book_id", bookID, "hold_id", id)
+	return true, nil
+}
+
+// RunHoldExpiry calls ExpireHolds every interval until ctx is done.
+func (s *LoanStore) RunHoldExpiry(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.ExpireHolds(ctx); err != nil && ctx.Err() == nil {
+			slog.Error("hold expiry", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// closeHold ends an active hold with status. A copy set aside for it is
+// released to the next hold in the queue or back to the shelf.
+func (s *LoanStore) closeHold(ctx context.Context, tx *sql.Tx, h Hold, status string, now time.Time) error {
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE holds SET status = ?, closed_at = ? WHERE id = ?`, status, now, h.ID,
+	); err != nil {
+		return err
+	}
+	if h.Status != HoldReady || h.CopyID == nil {
+		return nil
+	}
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE copies SET status = 'available', updated_at = ? WHERE id = ? AND status = 'on_hold'`, now, *h.CopyID,
+	); err != nil {
+		return err
+	}
+	if _, err := promoteHolds(ctx, tx, h.BookID, now, s.HoldPickup); err != nil {
+		return err
+	}
+	return refreshBookCopies(ctx, tx, h.BookID)
+}
+
+// promoteHolds sets available copies of the book aside for the oldest
+// waiting holds, which become ready until now+pickup. It runs in the same
+// transaction as whatever made the copies available, so a waiting hold never
+// coexists with a copy on the shelf.
+func promoteHolds(ctx context.Context, tx *sql.Tx, bookID int64, now time.Time, pickup time.Duration) ([]Hold, error) {
+	var promoted []Hold
+	for {
+		var holdID, copyID sql.NullInt64
+		err := tx.QueryRowContext(ctx, `
+			SELECT
+				(SELECT id FROM holds WHERE book_id = ?1 AND status = 'waiting' ORDER BY id LIMIT 1),
+				(SELECT id FROM copies WHERE book_id = ?1 AND status = 'available' AND retired_at IS NULL ORDER BY id LIMIT 1)`,
+			bookID,
+		).Scan(&holdID, &copyID)
+		if err != nil {
+			return nil, err
+		}
+		if !holdID.Valid || !copyID.Valid {
+			return promoted, nil
+		}
+
+		expires := now.Add(pickup)
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE copies SET status = 'on_hold', updated_at = ? WHERE id = ?`, now, copyID.Int64,
+		); err != nil {
+			return nil, err
+		}
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE holds SET status = 'ready', copy_id = ?, ready_at = ?, expires_at = ? WHERE id = ?`,
+			copyID.Int64, now, expires, holdID.Int64,
+		); err != nil {
+			return nil, err
+		}
+		h, err := getHold(ctx, tx, bookID, holdID.Int64)
+		if err != nil {
+			return nil, err
+		}
+		promoted = append(promoted, h)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHolds_QueueAndPromotionOnReturn(t *testing.T) {
+	r, _, cleanup := setupLoans(t)
+	defer cleanup()
+
+	book := decodeJSON[Book](t, doJSON(t, r, http.MethodPost, "/books", `{"title":"Dune","author":"Frank Herbert","year":1965}`))
+	bookPath := fmt.Sprintf("/books/%d", book.ID)
+	cp := addCopy(t, r, book.ID, "LIB-1", "Tokyo")
+
+	if rr := doAs(t, r, "bob", http.MethodPost, bookPath+"/holds", ``); rr.Code != http.StatusConflict {
+		t.Fatalf("hold while a copy is on the shelf status %d", rr.Code)
+	}
+	doAs(t, r, "alice", http.MethodPost, bookPath+"/checkout", ``)
+
+	rr := doAs(t, r, "bob", http.MethodPost, bookPath+"/holds", ``)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("hold status %d body=%s", rr.Code, rr.Body.String())
+	}
+	bob := decodeJSON[Hold](t, rr)
+	carol := decodeJSON[Hold](t, doAs(t, r, "carol", http.MethodPost, bookPath+"/holds", ``))
+	if bob.Status != HoldWaiting || bob.Position != 1 || carol.Position != 2 {
+		t.Fatalf("queue positions: bob=%+v carol=%+v", bob, carol)
+	}
+
+	if rr := doAs(t, r, "bob", http.MethodPost, bookPath+"/holds", ``); rr.Code != http.StatusConflict {
+		t.Fatalf("second hold status %d", rr.Code)
+	}
+	if rr := doAs(t, r, "alice", http.MethodPost, bookPath+"/holds", ``); rr.Code != http.StatusConflict {
+		t.Fatalf("hold by the borrower status %d", rr.Code)
+	}
+
+	// The returned copy is set aside for bob, not put back on the shelf.
+	doAs(t, r, "alice", http.MethodPost, bookPath+"/return", ``)
+	bob = decodeJSON[Hold](t, doJSON(t, r, http.MethodGet, fmt.Sprintf("%s/holds/%d", bookPath, bob.ID), ``))
+	if bob.Status != HoldReady || bob.CopyID == nil || *bob.CopyID != cp.ID || bob.ExpiresAt == nil {
+		t.Fatalf("bob's hold after return: %+v", bob)
+	}
+	carol = decodeJSON[Hold](t, doJSON(t, r, http.MethodGet, fmt.Sprintf("%s/holds/%d", bookPath, carol.ID), ``))
+	if carol.Position != 1 {
+		t.Fatalf("carol should be first in line now: %+v", carol)
+	}
+	if got := decodeJSON[Book](t, doJSON(t, r, http.MethodGet, bookPath, ``)); got.CopiesAvailable != 0 || got.Status != BookOnLoan {
+		t.Fatalf("book with a held copy: status=%s available=%d", got.Status, got.CopiesAvailable)
+	}
+	if rr := doAs(t, r, "carol", http.MethodPost, bookPath+"/checkout", ``); rr.Code != http.StatusConflict {
+		t.Fatalf("checkout of a copy held for someone else status %d", rr.Code)
+	}
+
+	rr = doAs(t, r, "bob", http.MethodPost, bookPath+"/checkout", ``)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("collect status %d body=%s", rr.Code, rr.Body.String())
+	}
+	if loan := decodeJSON[Loan](t, rr); loan.CopyID != cp.ID {
+		t.Fatalf("bob should get the held copy: %+v", loan)
+	}
+
+	queue := decodeJSON[[]Hold](t, doJSON(t, r, http.MethodGet, bookPath+"/holds", ``))
+	if len(queue) != 1 || queue[0].User != "carol" {
+		t.Fatalf("queue after collection: %+v", queue)
+	}
+	history := decodeJSON[[]Hold](t, doJSON(t, r, http.MethodGet, "/holds?user=bob", ``))
+	if len(history) != 1 || history[0].Status != HoldCollected {
+		t.Fatalf("bob's holds: %+v", history)
+	}
+}
+
+func TestHolds_ExpiryAndCancellationPromoteTheNext(t *testing.T) {
+	r, store, cleanup := setupLoans(t)
+	defer cleanup()
+
+	now := time.Now().UTC()
+	store.now = func() time.Time { return now }
+
+	book := decodeJSON[Book](t, doJSON(t, r, http.MethodPost, "/books", `{"title":"Dune","author":"Frank Herbert","year":1965}`))
+	bookPath := fmt.Sprintf("/books/%d", book.ID)
+	addCopy(t, r, book.ID, "LIB-1", "Tokyo")
+	doAs(t, r, "alice", http.MethodPost, bookPath+"/checkout", ``)
+	bob := decodeJSON[Hold](t, doAs(t, r, "bob", http.MethodPost, bookPath+"/holds", ``))
+	carol := decodeJSON[Hold](t, doAs(t, r, "carol", http.MethodPost, bookPath+"/holds", ``))
+	doAs(t, r, "alice", http.MethodPost, bookPath+"/return", ``)
+
+	if n, err := store.ExpireHolds(t.Context()); err != nil || n != 0 {
+		t.Fatalf("nothing should expire yet: n=%d err=%v", n, err)
+	}
+
+	now = now.Add(store.HoldPickup + time.Minute)
+	if n, err := store.ExpireHolds(t.Context()); err != nil || n != 1 {
+		t.Fatalf("expire: n=%d err=%v", n, err)
+	}
+	if got := decodeJSON[Hold](t, doJSON(t, r, http.MethodGet, fmt.Sprintf("%s/holds/%d", bookPath, bob.ID), ``)); got.Status != HoldExpired {
+		t.Fatalf("bob's hold: %+v", got)
+	}
+	carol = decodeJSON[Hold](t, doJSON(t, r, http.MethodGet, fmt.Sprintf("%s/holds/%d", bookPath, carol.ID), ``))
+	if carol.Status != HoldReady || !carol.ExpiresAt.Equal(now.Add(store.HoldPickup)) {
+		t.Fatalf("carol's hold: %+v", carol)
+	}
+
+	holdPath := fmt.Sprintf("%s/holds/%d", bookPath, carol.ID)
+	if rr := doAs(t, r, "bob", http.MethodDelete, holdPath, ``); rr.Code != http.StatusForbidden {
+		t.Fatalf("cancel someone else's hold status %d", rr.Code)
+	}
+	if rr := doAs(t, r, "carol", http.MethodDelete, holdPath, ``); rr.Code != http.StatusNoContent {
+		t.Fatalf("cancel status %d", rr.Code)
+	}
+	if rr := doAs(t, r, "carol", http.MethodDelete, holdPath, ``); rr.Code != http.StatusConflict {
+		t.Fatalf("cancel twice status %d", rr.Code)
+	}
+
+	// Nobody is left waiting, so the copy goes back on the shelf.
+	if got := decodeJSON[Book](t, doJSON(t, r, http.MethodGet, bookPath, ``)); got.Status != BookAvailable || got.CopiesAvailable != 1 {
+		t.Fatalf("book after the queue emptied: status=%s available=%d", got.Status, got.CopiesAvailable)
+	}
+}
+
+func TestHolds_NewCopyGoesToTheQueue(t *testing.T) {
+	r, _, cleanup := setupLoans(t)
+	defer cleanup()
+
+	book := decodeJSON[Book](t, doJSON(t, r, http.MethodPost, "/books", `{"title":"Dune","author":"Frank Herbert","year":1965}`))
+	bookPath := fmt.Sprintf("/books/%d", book.ID)
+
+	// A title without copies can be reserved ahead of its first copy.
+	hold := decodeJSON[Hold](t, doAs(t, r, "bob", http.MethodPost, bookPath+"/holds", ``))
+	cp := addCopy(t, r, book.ID, "LIB-1", "Tokyo")
+	if cp.Status != CopyOnHold {
+		t.Fatalf("new copy status %q", cp.Status)
+	}
+	hold = decodeJSON[Hold](t, doJSON(t, r, http.MethodGet, fmt.Sprintf("%s/holds/%d", bookPath, hold.ID), ``))
+	if hold.Status != HoldReady || *hold.CopyID != cp.ID {
+		t.Fatalf("hold after the copy arrived: %+v", hold)
+	}
+	if rr := doJSON(t, r, http.MethodPost, fmt.Sprintf("%s/copies/%d/retire", bookPath, cp.ID), ``); rr.Code != http.StatusConflict {
+		t.Fatalf("retiring a held copy status %d", rr.Code)
+	}
+}
+
+func TestHolds_ConcurrentHoldsGetDistinctPositions(t *testing.T) {
+	r, _, cleanup := setupLoans(t)
+	defer cleanup()
+
+	book := decodeJSON[Book](t, doJSON(t, r, http.MethodPost, "/books", `{"title":"Dune","author":"Frank Herbert","year":1965}`))
+	bookPath := fmt.Sprintf("/books/%d", book.ID)
+
+	var wg sync.WaitGroup
+	codes := make([]int, 8)
+	for i := range codes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes[i] = doAs(t, r, fmt.Sprintf("user%d", i), http.MethodPost, bookPath+"/holds", ``).Code
+		}()
+	}
+	wg.Wait()
+	for _, c := range codes {
+		if c != http.StatusCreated {
+			t.Fatalf("unexpected status codes: %v", codes)
+		}
+	}
+
+	queue := decodeJSON[[]Hold](t, doJSON(t, r, http.MethodGet, bookPath+"/holds", ``))
+	if len(queue) != len(codes) {
+		t.Fatalf("queue length %d", len(queue))
+	}
+	for i, h := range queue {
+		if h.Position != i+1 {
+			t.Fatalf("position %d at index %d: %+v", h.Position, i, queue)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// defaultCacheControl makes clients revalidate every read, which is cheap
+// thanks to ETags and 304 responses.
+const defaultCacheControl = "no-cache"
+
+// strongETag derives a strong validator from the exact response bytes.
+func strongETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// collectionETag identifies a collection response by the table change counter
+// and the query string, so it can be checked before running the query.
+func collectionETag(name string, version int64, rawQuery string) string {
+	sum := sha256.Sum256([]byte(rawQuery))
+	return fmt.Sprintf(`"%s-%d-%s"`, name, version, hex.EncodeToString(sum[:8]))
+}
+
+// notModified evaluates If-None-Match and If-Modified-Since (RFC 9110 §13.2.2).
+// If-Modified-Since is ignored whenever If-None-Match is present.
+func notModified(r *http.Request, etag string, modified time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etagMatches(inm, etag)
+	}
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" && !modified.IsZero() {
+		t, err := http.ParseTime(ims)
+		if err != nil {
+			return false
+		}
+		return !modified.Truncate(time.Second).After(t)
+	}
+	return false
+}
+
+// etagMatches uses the weak comparison If-None-Match calls for.
+func etagMatches(header, etag string) bool {
+	want := strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == want {
+			return true
+		}
+	}
+	return false
+}
+
+// setValidators writes the caching headers shared by 200 and 304 responses.
+func setValidators(w http.ResponseWriter, etag string, modified time.Time, cacheControl string) {
+	h := w.Header()
+	h.Set("ETag", etag)
+	if !modified.IsZero() {
+		h.Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+	if cacheControl != "" {
+		h.Set("Cache-Control", cacheControl)
+	}
+}
+
+// writeCachedJSON encodes v, derives a strong ETag from the body and answers
+// 304 when the client's copy is still current.
+func writeCachedJSON(w http.ResponseWriter, r *http.Request, modified time.Time, cacheControl string, v any) {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(v); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error")
+		return
+	}
+
+	etag := strongETag(buf.Bytes())
+	setValidators(w, etag, modified, cacheControl)
+	if notModified(r, etag, modified) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(buf.Bytes())
+}
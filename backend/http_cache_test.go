@@ -0,0 +1,162 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func doConditional(t *testing.T, r http.Handler, path, header, value string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if header != "" {
+		req.Header.Set(header, value)
+	}
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestBooks_GetETagAndLastModified(t *testing.T) {
+	r, db := setupTestRouter(t)
+	defer db.Close()
+
+	rr := doJSON(t, r, http.MethodPost, "/books", `{"title":"Dune","author":"Frank Herbert","year":1965}`)
+	created := decodeJSON[Book](t, rr)
+	if created.CreatedAt.IsZero() || !created.UpdatedAt.Equal(created.CreatedAt) {
+		t.Fatalf("unexpected timestamps: %+v", created)
+	}
+	path := fmt.Sprintf("/books/%d", created.ID)
+
+	rr = doConditional(t, r, path, "", "")
+	etag := rr.Header().Get("ETag")
+	lastModified := rr.Header().Get("Last-Modified")
+	if rr.Code != http.StatusOK || etag == "" || lastModified == "" {
+		t.Fatalf("status %d etag %q last-modified %q", rr.Code, etag, lastModified)
+	}
+	if rr.Header().Get("Cache-Control") != defaultCacheControl {
+		t.Fatalf("cache-control got %q", rr.Header().Get("Cache-Control"))
+	}
+
+	rr = doConditional(t, r, path, "If-None-Match", etag)
+	if rr.Code != http.StatusNotModified || rr.Body.Len() != 0 {
+		t.Fatalf("if-none-match status %d body=%s", rr.Code, rr.Body.String())
+	}
+
+	rr = doConditional(t, r, path, "If-Modified-Since", lastModified)
+	if rr.Code != http.StatusNotModified {
+		t.Fatalf("if-modified-since status %d", rr.Code)
+	}
+
+	rr = doConditional(t, r, path, "If-Modified-Since", created.CreatedAt.Add(-time.Hour).Format(http.TimeFormat))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("stale if-modified-since status %d", rr.Code)
+	}
+
+	rr = doJSON(t, r, http.MethodPut, path, `{"title":"Dune Messiah","author":"Frank Herbert","year":1969}`)
+	updated := decodeJSON[Book](t, rr)
+	if !updated.CreatedAt.Equal(created.CreatedAt) || updated.UpdatedAt.Before(created.UpdatedAt) {
+		t.Fatalf("unexpected timestamps after update: %+v", updated)
+	}
+
+	rr = doConditional(t, r, path, "If-None-Match", etag)
+	if rr.Code != http.StatusOK || rr.Header().Get("ETag") == etag {
+		t.Fatalf("after update status %d etag %q", rr.Code, rr.Header().Get("ETag"))
+	}
+}
+
+// backdate moves the book's updated_at an hour back, so a change made now
+// shows in the second-precision Last-Modified.
+func backdate(t *testing.T, db *sql.DB, id int64) {
+	t.Helper()
+	if _, err := db.Exec(`UPDATE books SET updated_at = ? WHERE id = ?`, time.Now().UTC().Add(-time.Hour), id); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBooks_ReviewChangesLastModified(t *testing.T) {
+	r, db := setupTestRouter(t)
+	defer db.Close()
+
+	created := decodeJSON[Book](t, doJSON(t, r, http.MethodPost, "/books", `{"title":"Dune","author":"Frank Herbert","year":1965,"isbn":"9780441172719"}`))
+	backdate(t, db, created.ID)
+
+	for _, path := range []string{fmt.Sprintf("/books/%d", created.ID), "/books/by-isbn/9780441172719"} {
+		lastModified := doConditional(t, r, path, "", "").Header().Get("Last-Modified")
+
+		if _, err := NewReviewStore(db).Create(t.Context(), created.ID, "alice"+path, Review{Rating: 4}); err != nil {
+			t.Fatal(err)
+		}
+		rr := doConditional(t, r, path, "If-Modified-Since", lastModified)
+		if rr.Code != http.StatusOK || rr.Header().Get("Last-Modified") == lastModified {
+			t.Fatalf("%s after review: status %d last-modified %q", path, rr.Code, rr.Header().Get("Last-Modified"))
+		}
+		backdate(t, db, created.ID)
+	}
+}
+
+func TestBooks_CopyChangesLastModified(t *testing.T) {
+	r, db := setupTestRouter(t)
+	defer db.Close()
+
+	created := decodeJSON[Book](t, doJSON(t, r, http.MethodPost, "/books", `{"title":"Dune","author":"Frank Herbert","year":1965,"isbn":"9780441172719"}`))
+	copies, loans := NewCopyStore(db), NewLoanStore(db)
+	var c Copy
+
+	for _, tc := range []struct {
+		name   string
+		change func() error
+	}{
+		{"create", func() (err error) {
+			c, err = copies.Create(t.Context(), created.ID, Copy{Barcode: "LIB-1", Location: "Tokyo"})
+			return err
+		}},
+		{"move", func() error { _, err := copies.Move(t.Context(), created.ID, c.ID, "Osaka"); return err }},
+		{"checkout", func() error { _, err := loans.Checkout(t.Context(), created.ID, "alice", 0, c.ID); return err }},
+		{"return", func() error { _, err := loans.Return(t.Context(), created.ID, "alice", c.ID); return err }},
+		{"retire", func() error { _, err := copies.Retire(t.Context(), created.ID, c.ID); return err }},
+	} {
+		backdate(t, db, created.ID)
+		lastModified := doConditional(t, r, fmt.Sprintf("/books/%d", created.ID), "", "").Header().Get("Last-Modified")
+		if err := tc.change(); err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		for _, path := range []string{fmt.Sprintf("/books/%d", created.ID), "/books/by-isbn/9780441172719"} {
+			rr := doConditional(t, r, path, "If-Modified-Since", lastModified)
+			if rr.Code != http.StatusOK || rr.Header().Get("Last-Modified") == lastModified {
+				t.Fatalf("%s %s: status %d last-modified %q", tc.name, path, rr.Code, rr.Header().Get("Last-Modified"))
+			}
+		}
+	}
+}
+
+func TestBooks_ListETagFollowsChangeCounter(t *testing.T) {
+	r, db := setupTestRouter(t)
+	defer db.Close()
+
+	rr := doConditional(t, r, "/books", "", "")
+	etag := rr.Header().Get("ETag")
+	if rr.Code != http.StatusOK || etag == "" {
+		t.Fatalf("status %d etag %q", rr.Code, etag)
+	}
+
+	rr = doConditional(t, r, "/books", "If-None-Match", `"other", `+etag)
+	if rr.Code != http.StatusNotModified {
+		t.Fatalf("if-none-match status %d", rr.Code)
+	}
+
+	rr = doConditional(t, r, "/books?sort=rating", "If-None-Match", etag)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("different query should not match, status %d", rr.Code)
+	}
+
+	doJSON(t, r, http.MethodPost, "/books", `{"title":"Dune","author":"Frank Herbert","year":1965}`)
+
+	rr = doConditional(t, r, "/books", "If-None-Match", etag)
+	if rr.Code != http.StatusOK || rr.Header().Get("ETag") == etag {
+		t.Fatalf("after create status %d etag %q", rr.Code, rr.Header().Get("ETag"))
+	}
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// defaultLanguage ends every fallback chain; its catalog is the one the code
+// is written in.
+const defaultLanguage = "en"
+
+// catalogs holds the API's error messages per language, keyed by a stable
+// code. Codes are part of the API: clients branch on them, so they never
+// change once published, whatever the wording. Every code needs an English
+// entry; other languages may lag behind and fall back.
+var catalogs = map[string]map[string]string{
+	"en": {
+		// general
+		"internal_error":          "internal error",
+		"invalid_json":            "invalid JSON body",
+		"invalid_body":            "invalid request body",
+		"invalid_id":              "invalid id",
+		"authentication_required": "authentication required",
+		"admin_required":          "admin access required",
+		"not_found":               "not found",
+
+		// request bodies; {placeholders} are filled in per request
+		"unsupported_media_type": "Content-Type must be application/json",
+		"body_too_large":         "request body must be at most {limit} bytes",
+		"body_empty":             "request body is empty",
+		"json_syntax":            "malformed JSON at offset {offset}",
+		"json_truncated":         "JSON body ends unexpectedly at offset {offset}",
+		"json_type":              "{field} must be a JSON {type}, not {value} (offset {offset})",
+		"json_unknown_field":     "unknown field {field}",
+		"json_trailing_data":     "request body must hold a single JSON value; found more at offset {offset}",
+		"version_not_available":  "requested API version is not available here",
+
+		// books
+		"book_not_found":      "book not found",
+		"title_required":      "title is required",
+		"author_required":     "author is required",
+		"year_invalid":        "year must be > 0",
+		"isbn_length":         "isbn must have 10 or 13 digits (the last of 10 may be X)",
+		"isbn_prefix":         "isbn-13 must start with 978 or 979",
+		"isbn_check_digit":    "isbn check digit does not match",
+		"isbn_exists":         "a book with this ISBN already exists",
+		"min_rating_invalid":  "min_rating must be a number between 1 and 5",
+		"min_score_invalid":   "min_score must be a number between 0 and 1",
+		"min_score_range":     "min_score must be between 0 and 1",
+		"limit_range":         "limit must be between 1 and 100",
+		"book_ids_count":      "book_ids must name between 2 and 20 books",
+		"book_ids_invalid":    "book_ids must be positive",
+		"survivor_not_listed": "survivor_id must be one of book_ids",
+		"top_range":           "top must be between 1 and 100",
+		"by_invalid":          "by must be one of: decade, year",
+		"interval_invalid":    "interval must be one of: day, week, month",
+		"fields_invalid":      "fields must be a comma-separated list of: " + strings.Join(bookFieldNames(), ", "),
+		"expand_invalid":      "expand must be a comma-separated list of: " + strings.Join(bookExpansions, ", "),
+		"since_invalid":       "since must be a sync token returned by this endpoint",
+		"changes_limit_range": "limit must be between 1 and 1000",
+		"resync_required":     "sync token has expired; sync again without since",
+		"after_invalid":       "after must be a positive book id",
+
+		// url processor
+		"url_required":          "`url` is required",
+		"url_invalid":           "invalid URL (must include scheme and host)",
+		"operation_required":    "`operation` is required",
+		"operation_invalid":     "`operation` must be one of: canonical, redirection, all",
+		"operation_unsupported": "unsupported operation",
+
+		// reviews
+		"review_not_found":       "review not found",
+		"review_exists":          "you have already reviewed this book",
+		"review_not_yours":       "you can only change your own review",
+		"rating_range":           "rating must be between 1 and 5",
+		"text_too_long":          "text must be at most 4000 characters",
+		"min_rating_not_integer": "min_rating must be an integer between 1 and 5",
+		"sort_invalid":           "sort must be one of: id, rating",
+
+		// copies
+		"copy_not_found":          "copy not found",
+		"copy_on_loan":            "copy is on loan or on hold",
+		"copy_retired":            "copy is retired",
+		"barcode_taken":           "barcode is already in use",
+		"barcode_required":        "barcode is required",
+		"barcode_invalid":         "barcode must be at most 64 characters without spaces",
+		"condition_invalid":       "condition must be one of new, good, fair, poor",
+		"status_invalid":          "status must be available or lost",
+		"location_too_long":       "location must be at most 100 characters",
+		"acquired_on_invalid":     "acquired_on must be a date like 2024-04-01",
+		"acquired_on_future":      "acquired_on cannot be in the future",
+		"include_retired_invalid": "include_retired must be true or false",
+
+		// loans and holds
+		"days_range":         "days must be between 1 and " + strconv.Itoa(maxLoanDays),
+		"no_copy_available":  "no copy of this book is available",
+		"copy_unavailable":   "this copy is not available",
+		"not_checked_out":    "book is not checked out",
+		"on_loan_to_other":   "the book is on loan to someone else",
+		"book_id_invalid":    "book_id must be a positive integer",
+		"open_invalid":       "open must be true or false",
+		"overdue_invalid":    "overdue must be true or false",
+		"hold_not_found":     "hold not found",
+		"hold_not_yours":     "you can only cancel your own hold",
+		"hold_exists":        "you already have a hold on this book",
+		"hold_closed":        "hold is no longer active",
+		"already_borrowed":   "you already have this book on loan",
+		"copy_available_now": "a copy is available; check it out instead",
+
+		// metadata
+		"no_isbn":                  "book has no ISBN",
+		"metadata_not_found":       "no metadata found for this ISBN",
+		"metadata_not_loaded":      "book has not been enriched",
+		"metadata_unavailable":     "metadata provider is temporarily unavailable",
+		"metadata_provider_failed": "metadata provider failed",
+
+		// notifications
+		"email_invalid":    "email must be a plain address like name@example.com",
+		"authors_invalid":  "authors must be non-empty names of at most 200 characters",
+		"authors_too_many": "at most 100 authors can be followed",
+		"limit_invalid":    "limit must be an integer between 1 and 500",
+
+		// webhooks
+		"webhook_not_found":     "webhook not found",
+		"webhook_url_invalid":   "url must be an absolute http(s) URL",
+		"webhook_url_private":   "url must not point to a loopback, link-local or private address",
+		"event_types_invalid":   "event_types must only contain: " + strings.Join(bookEventTypes, ", "),
+		"dead_letter_not_found": "dead letter not found",
+		"active_invalid":        "active must be true or false",
+
+		// idempotency
+		"idempotency_key_too_long":    "Idempotency-Key must be at most 255 characters",
+		"idempotency_key_in_progress": "a request with this Idempotency-Key is still in progress",
+		"idempotency_key_reused":      "Idempotency-Key was already used with a different request body",
+
+		// workspaces
+		"workspace_not_found": "workspace not found",
+		"workspace_archived":  "workspace is archived",
+		"workspace_exists":    "a workspace with this slug already exists",
+		"workspace_required":  "you belong to several workspaces; choose one with X-Workspace",
+		"not_member":          "you are not a member of this workspace",
+		"default_workspace":   "the default workspace cannot be archived",
+		"book_quota_reached":  "workspace book quota reached",
+		"slug_invalid":        "slug must be 2-63 lowercase letters, digits or hyphens",
+		"name_required":       "name is required",
+		"max_books_invalid":   "max_books must be >= 0",
+		"members_required":    "members must not be empty",
+		"user_required":       "user is required",
+	},
+	"ja": {
+		"internal_error":          "内部エラーが発生しました",
+		"invalid_json":            "JSON の形式が正しくありません",
+		"invalid_body":            "リクエスト本文を読み取れませんでした",
+		"invalid_id":              "ID が正しくありません",
+		"authentication_required": "認証が必要です",
+		"admin_required":          "管理者権限が必要です",
+		"not_found":               "見つかりません",
+
+		"unsupported_media_type": "Content-Type には application/json を指定してください",
+		"body_too_large":         "リクエスト本文は {limit} バイト以内にしてください",
+		"body_empty":             "リクエスト本文が空です",
+		"json_syntax":            "JSON の形式が正しくありません（オフセット {offset}）",
+		"json_truncated":         "JSON がオフセット {offset} で途切れています",
+		"json_type":              "{field} には JSON の {type} を指定してください（{value} は指定できません、オフセット {offset}）",
+		"json_unknown_field":     "不明なフィールド {field} があります",
+		"json_trailing_data":     "リクエスト本文には JSON 値を 1 つだけ含めてください（オフセット {offset} 以降に余分なデータがあります）",
+		"version_not_available":  "指定された API バージョンはここでは利用できません",
+
+		"book_not_found":      "本が見つかりません",
+		"title_required":      "タイトルは必須です",
+		"author_required":     "著者は必須です",
+		"year_invalid":        "出版年は 0 より大きい値にしてください",
+		"isbn_length":         "ISBN は 10 桁または 13 桁の数字で入力してください（10 桁の場合、最後の桁は X も可）",
+		"isbn_prefix":         "ISBN-13 は 978 または 979 で始まる必要があります",
+		"isbn_check_digit":    "ISBN のチェックディジットが一致しません",
+		"isbn_exists":         "この ISBN の本はすでに登録されています",
+		"min_rating_invalid":  "min_rating には 1 から 5 の数値を指定してください",
+		"min_score_invalid":   "min_score には 0 から 1 の数値を指定してください",
+		"min_score_range":     "min_score は 0 から 1 の範囲で指定してください",
+		"limit_range":         "limit は 1 から 100 の範囲で指定してください",
+		"book_ids_count":      "book_ids には 2 冊から 20 冊の本を指定してください",
+		"book_ids_invalid":    "book_ids には正の値を指定してください",
+		"survivor_not_listed": "survivor_id は book_ids に含まれている必要があります",
+		"top_range":           "top は 1 から 100 の範囲で指定してください",
+		"by_invalid":          "by には decade か year を指定してください",
+		"interval_invalid":    "interval には day、week、month のいずれかを指定してください",
+		"fields_invalid":      "fields には " + strings.Join(bookFieldNames(), "、") + " をカンマ区切りで指定してください",
+		"expand_invalid":      "expand には " + strings.Join(bookExpansions, "、") + " をカンマ区切りで指定してください",
+		"since_invalid":       "since にはこのエンドポイントが返した同期トークンを指定してください",
+		"changes_limit_range": "limit は 1 から 1000 の範囲で指定してください",
+		"resync_required":     "同期トークンの有効期限が切れました。since を付けずに同期し直してください",
+		"after_invalid":       "after には正の書籍 ID を指定してください",
+
+		"url_required":          "`url` は必須です",
+		"url_invalid":           "URL が正しくありません（スキームとホストが必要です）",
+		"operation_required":    "`operation` は必須です",
+		"operation_invalid":     "`operation` には canonical、redirection、all のいずれかを指定してください",
+		"operation_unsupported": "サポートされていない操作です",
+
+		"review_not_found":       "レビューが見つかりません",
+		"review_exists":          "この本はすでにレビュー済みです",
+		"review_not_yours":       "変更できるのは自分のレビューだけです",
+		"rating_range":           "評価は 1 から 5 の範囲で指定してください",
+		"text_too_long":          "本文は 4000 文字以内にしてください",
+		"min_rating_not_integer": "min_rating には 1 から 5 の整数を指定してください",
+		"sort_invalid":           "sort には id か rating を指定してください",
+
+		"copy_not_found":          "蔵書が見つかりません",
+		"copy_on_loan":            "この蔵書は貸出中または予約中です",
+		"copy_retired":            "この蔵書は除籍されています",
+		"barcode_taken":           "このバーコードはすでに使われています",
+		"barcode_required":        "バーコードは必須です",
+		"barcode_invalid":         "バーコードは空白を含まない 64 文字以内にしてください",
+		"condition_invalid":       "condition には new、good、fair、poor のいずれかを指定してください",
+		"status_invalid":          "status には available か lost を指定してください",
+		"location_too_long":       "配架場所は 100 文字以内にしてください",
+		"acquired_on_invalid":     "acquired_on は 2024-04-01 のような日付で指定してください",
+		"acquired_on_future":      "acquired_on に未来の日付は指定できません",
+		"include_retired_invalid": "include_retired には true か false を指定してください",
+
+		"days_range":         "貸出日数は 1 から " + strconv.Itoa(maxLoanDays) + " の範囲で指定してください",
+		"no_copy_available":  "貸し出せる蔵書がありません",
+		"copy_unavailable":   "この蔵書は貸し出せません",
+		"not_checked_out":    "この本は貸し出されていません",
+		"on_loan_to_other":   "この本は他の人に貸出中です",
+		"book_id_invalid":    "book_id には正の整数を指定してください",
+		"open_invalid":       "open には true か false を指定してください",
+		"overdue_invalid":    "overdue には true か false を指定してください",
+		"hold_not_found":     "予約が見つかりません",
+		"hold_not_yours":     "取り消せるのは自分の予約だけです",
+		"hold_exists":        "この本はすでに予約済みです",
+		"hold_closed":        "この予約はすでに有効ではありません",
+		"already_borrowed":   "この本はすでに借りています",
+		"copy_available_now": "貸し出せる蔵書があります。予約ではなく貸出をしてください",
+
+		"no_isbn":                  "この本には ISBN がありません",
+		"metadata_not_found":       "この ISBN の書誌情報は見つかりませんでした",
+		"metadata_not_loaded":      "この本の書誌情報はまだ取得されていません",
+		"metadata_unavailable":     "書誌情報サービスは一時的に利用できません",
+		"metadata_provider_failed": "書誌情報サービスでエラーが発生しました",
+
+		"email_invalid":    "メールアドレスは name@example.com のような形式で指定してください",
+		"authors_invalid":  "著者名は空でない 200 文字以内の名前にしてください",
+		"authors_too_many": "フォローできる著者は 100 人までです",
+		"limit_invalid":    "limit には 1 から 500 の整数を指定してください",
+
+		"webhook_not_found":     "Webhook が見つかりません",
+		"webhook_url_invalid":   "url には http(s) の絶対 URL を指定してください",
+		"webhook_url_private":   "url にはループバック、リンクローカル、プライベートのアドレスを指定できません",
+		"event_types_invalid":   "event_types に指定できるのは " + strings.Join(bookEventTypes, "、") + " だけです",
+		"dead_letter_not_found": "配信失敗イベントが見つかりません",
+		"active_invalid":        "active には true か false を指定してください",
+
+		"idempotency_key_too_long":    "Idempotency-Key は 255 文字以内にしてください",
+		"idempotency_key_in_progress": "この Idempotency-Key のリクエストはまだ処理中です",
+		"idempotency_key_reused":      "この Idempotency-Key は別の内容のリクエストですでに使われています",
+
+		"workspace_not_found": "ワークスペースが見つかりません",
+		"workspace_archived":  "このワークスペースはアーカイブされています",
+		"workspace_exists":    "このスラッグのワークスペースはすでに存在します",
+		"workspace_required":  "複数のワークスペースに所属しています。X-Workspace で指定してください",
+		"not_member":          "このワークスペースのメンバーではありません",
+		"default_workspace":   "既定のワークスペースはアーカイブできません",
+		"book_quota_reached":  "ワークスペースの登録冊数が上限に達しました",
+		"slug_invalid":        "スラッグは英小文字・数字・ハイフンの 2〜63 文字にしてください",
+		"name_required":       "名前は必須です",
+		"max_books_invalid":   "max_books は 0 以上にしてください",
+		"members_required":    "members を空にすることはできません",
+		"user_required":       "ユーザーは必須です",
+	},
+}
+
+// codedError is an error with a stable code. Its message is the code's
+// English text, so stores can return it to gRPC and GraphQL callers as is.
+type codedError struct{ code string }
+
+func newCodedError(code string) error {
+	return &codedError{code: code}
+}
+
+func (e *codedError) Error() string {
+	return catalogs[defaultLanguage][e.code]
+}
+
+func (e *codedError) ErrorCode() string {
+	return e.code
+}
+
+// errorCode returns the stable code carried by err, a codedError or a
+// ValidationError. Errors without one are internal.
+func errorCode(err error) string {
+	var coded interface{ ErrorCode() string }
+	if errors.As(err, &coded) {
+		return coded.ErrorCode()
+	}
+	return "internal_error"
+}
+
+// acceptedLanguages returns the languages to try for an Accept-Language
+// header, best first: each tag is followed by its base language ("ja-JP",
+// then "ja"), and the default language comes last.
+func acceptedLanguages(header string) []string {
+	type pref struct {
+		tag string
+		q   float64
+	}
+	var prefs []pref
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = f
+		}
+		if q <= 0 {
+			continue
+		}
+		prefs = append(prefs, pref{tag: tag, q: q})
+	}
+	sort.SliceStable(prefs, func(i, j int) bool { return prefs[i].q > prefs[j].q })
+
+	langs := make([]string, 0, 2*len(prefs)+1)
+	for _, p := range prefs {
+		langs = append(langs, p.tag)
+		if base, _, ok := strings.Cut(p.tag, "-"); ok {
+			langs = append(langs, base)
+		}
+	}
+	return append(langs, defaultLanguage)
+}
+
+// translate returns code's message in the first of langs that has it, and
+// that language. A code no catalog has is returned as its own message.
+func translate(langs []string, code string) (string, string) {
+	for _, lang := range langs {
+		if text, ok := catalogs[lang][code]; ok {
+			return text, lang
+		}
+	}
+	return code, defaultLanguage
+}
+
+// localizeCode builds the error body for code in the caller's language and
+// sets the headers that go with it. {name} placeholders in the message are
+// filled from params.
+func localizeCode(w http.ResponseWriter, r *http.Request, code string, params map[string]string) errorResponse {
+	text, lang := translate(acceptedLanguages(r.Header.Get("Accept-Language")), code)
+	if len(params) > 0 {
+		pairs := make([]string, 0, 2*len(params))
+		for k, v := range params {
+			pairs = append(pairs, "{"+k+"}", v)
+		}
+		text = strings.NewReplacer(pairs...).Replace(text)
+	}
+	w.Header().Add("Vary", "Accept-Language")
+	w.Header().Set("Content-Language", lang)
+	return errorResponse{Error: text, Code: code}
+}
+
+// writeError answers with the message of code, translated according to
+// Accept-Language.
+func writeError(w http.ResponseWriter, r *http.Request, status int, code string) {
+	writeJSON(w, status, localizeCode(w, r, code, nil))
+}
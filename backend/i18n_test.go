@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func doLocalized(t *testing.T, r http.Handler, lang, method, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	if lang != "" {
+		req.Header.Set("Accept-Language", lang)
+	}
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestAcceptedLanguages(t *testing.T) {
+	cases := []struct {
+		header string
+		want   []string
+	}{
+		{"", []string{"en"}},
+		{"ja", []string{"ja", "en"}},
+		{"ja-JP,ja;q=0.9,en;q=0.8", []string{"ja-jp", "ja", "ja", "en", "en"}},
+		{"en;q=0.5, ja", []string{"ja", "en", "en"}},
+		{"fr-CA, *;q=0.1", []string{"fr-ca", "fr", "en"}},
+		{"ja;q=0, de", []string{"de", "en"}},
+		{"ja;q=abc", []string{"en"}},
+	}
+	for _, tc := range cases {
+		if got := acceptedLanguages(tc.header); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("acceptedLanguages(%q) = %v, want %v", tc.header, got, tc.want)
+		}
+	}
+}
+
+func TestCatalogs_CoverEveryCode(t *testing.T) {
+	en := catalogs[defaultLanguage]
+	for lang, catalog := range catalogs {
+		for code := range catalog {
+			if _, ok := en[code]; !ok {
+				t.Errorf("%s: code %q has no English message", lang, code)
+			}
+		}
+	}
+	for code := range en {
+		if _, ok := catalogs["ja"][code]; !ok {
+			t.Errorf("ja: missing %q", code)
+		}
+	}
+}
+
+func TestErrors_FollowAcceptLanguage(t *testing.T) {
+	r, db := setupTestRouter(t)
+	defer db.Close()
+
+	cases := []struct {
+		name, lang, method, path, body string
+		wantStatus                     int
+		wantCode, wantError, wantLang  string
+	}{
+		{
+			name: "english by default", method: http.MethodPost, path: "/books",
+			body:       `{"title":"","author":"A","year":2000}`,
+			wantStatus: http.StatusBadRequest, wantCode: "title_required", wantError: "title is required", wantLang: "en",
+		},
+		{
+			name: "japanese validation", lang: "ja-JP,ja;q=0.9", method: http.MethodPost, path: "/books",
+			body:       `{"title":"","author":"A","year":2000}`,
+			wantStatus: http.StatusBadRequest, wantCode: "title_required", wantError: "タイトルは必須です", wantLang: "ja",
+		},
+		{
+			name: "japanese url processor", lang: "ja", method: http.MethodPost, path: "/process-url",
+			body:       `{"url":"not a url","operation":"all"}`,
+			wantStatus: http.StatusBadRequest, wantCode: "url_invalid", wantError: "URL が正しくありません（スキームとホストが必要です）", wantLang: "ja",
+		},
+		{
+			name: "unsupported language falls back", lang: "fr-FR, de;q=0.8", method: http.MethodGet, path: "/books/999",
+			wantStatus: http.StatusNotFound, wantCode: "book_not_found", wantError: "book not found", wantLang: "en",
+		},
+		{
+			name: "later preference is used", lang: "fr, ja;q=0.5", method: http.MethodGet, path: "/books/abc",
+			wantStatus: http.StatusBadRequest, wantCode: "invalid_id", wantError: "ID が正しくありません", wantLang: "ja",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := doLocalized(t, r, tc.lang, tc.method, tc.path, tc.body)
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("status = %d, want %d body=%s", rr.Code, tc.wantStatus, rr.Body.String())
+			}
+			got := decodeJSON[errorResponse](t, rr)
+			if got.Code != tc.wantCode || got.Error != tc.wantError {
+				t.Fatalf("got %+v, want code %q error %q", got, tc.wantCode, tc.wantError)
+			}
+			if cl := rr.Header().Get("Content-Language"); cl != tc.wantLang {
+				t.Fatalf("Content-Language = %q, want %q", cl, tc.wantLang)
+			}
+			if v := rr.Header().Get("Vary"); v != "Accept-Language" {
+				t.Fatalf("Vary = %q", v)
+			}
+		})
+	}
+}
+
+func TestErrors_DuplicateKeepsCodeAcrossLanguages(t *testing.T) {
+	r, db := setupTestRouter(t)
+	defer db.Close()
+
+	body := `{"title":"Dune","author":"Frank Herbert","year":1965,"isbn":"9780441013593"}`
+	if rr := doJSON(t, r, http.MethodPost, "/books", body); rr.Code != http.StatusCreated {
+		t.Fatalf("create: %d %s", rr.Code, rr.Body.String())
+	}
+
+	en := decodeJSON[bookExistsResponse](t, doLocalized(t, r, "en", http.MethodPost, "/books", body))
+	ja := decodeJSON[bookExistsResponse](t, doLocalized(t, r, "ja", http.MethodPost, "/books", body))
+	if en.Code != "isbn_exists" || ja.Code != en.Code {
+		t.Fatalf("codes differ: en=%q ja=%q", en.Code, ja.Code)
+	}
+	if ja.Error == en.Error || ja.Existing.ID != en.Existing.ID {
+		t.Fatalf("en=%+v ja=%+v", en, ja)
+	}
+}
+
+func TestErrorCode_ComesFromTheError(t *testing.T) {
+	cases := []struct {
+		err  error
+		want string
+	}{
+		{ErrCopyNotFound, "copy_not_found"},
+		{fmt.Errorf("checkout: %w", ErrNoCopyAvailable), "no_copy_available"},
+		{&ValidationError{Field: "title", Code: "title_required", Message: "title is required"}, "title_required"},
+		{errors.New("database is locked"), "internal_error"},
+	}
+	for _, tc := range cases {
+		if got := errorCode(tc.err); got != tc.want {
+			t.Errorf("errorCode(%v) = %q, want %q", tc.err, got, tc.want)
+		}
+	}
+	// the message of a coded error is its English text
+	if ErrCopyNotFound.Error() != "copy not found" {
+		t.Fatalf("message = %q", ErrCopyNotFound.Error())
+	}
+}
+
+// Every code written by the handlers or carried by an error needs a catalog
+// entry; a typo would otherwise reach clients as an untranslated code.
+func TestCatalogs_HaveEveryCodeInUse(t *testing.T) {
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	used := regexp.MustCompile(`(?:writeError\(w, r, http\.\w+, |localizeCode\(w, r, |newCodedError\(|Code: |\bcode: )"([a-z_]+)"`)
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		src, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range used.FindAllSubmatch(src, -1) {
+			if _, ok := catalogs[defaultLanguage][string(m[1])]; !ok {
+				t.Errorf("%s: code %q is not in the catalog", file, m[1])
+			}
+		}
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	idempotencyHeader = "Idempotency-Key"
+	replayedHeader    = "Idempotent-Replayed"
+
+	defaultIdempotencyTTL = 24 * time.Hour
+	maxIdempotencyKeyLen  = 255
+
+	// abandonedClaimAge is when an unfinished claim is assumed to belong to
+	// a request that died with the process, and may be taken over.
+	abandonedClaimAge = time.Minute
+)
+
+// replayedHeaders are the response headers stored with a result and sent
+// again on replay.
+var replayedHeaders = []string{"Content-Type", "Location", "ETag", "Last-Modified"}
+
+var (
+	errKeyInProgress = errors.New("idempotency key in progress")
+	errKeyMismatch   = errors.New("idempotency key reused with a different request")
+)
+
+// idempotencyRoute names the request's route for scoping keys: the matched
+// pattern without its /vN prefix, so a retry through /books after /v1/books
+// still replays, followed by the path parameters. Outside a chi router it is
+// the path.
+func idempotencyRoute(r *http.Request) string {
+	rctx := chi.RouteContext(r.Context())
+	if rctx == nil || rctx.RoutePattern() == "" {
+		return r.URL.Path
+	}
+	route := rctx.RoutePattern()
+	if rest, ok := strings.CutPrefix(route, "/v"); ok {
+		if i := strings.IndexByte(rest, '/'); i > 0 && strings.Trim(rest[:i], "0123456789") == "" {
+			route = rest[i:]
+		}
+	}
+	for i, k := range rctx.URLParams.Keys {
+		if k != "*" {
+			route += " " + rctx.URLParams.Values[i]
+		}
+	}
+	return route
+}
+
+// idempotentResponse is a stored result. Status 0 means the first request
+// is still running.
+type idempotentResponse struct {
+	Fingerprint string
+	Status      int
+	Headers     map[string]string
+	Body        []byte
+}
+
+// IdempotencyStore keeps request fingerprints and responses for TTL so a
+// retried POST replays the original answer instead of running again.
+type IdempotencyStore struct {
+	db  *sql.DB
+	TTL time.Duration
+
+	locks keyedMutex
+	now   func() time.Time
+}
+
+func NewIdempotencyStore(db *sql.DB) *IdempotencyStore {
+	return &IdempotencyStore{db: db, TTL: defaultIdempotencyTTL, now: time.Now}
+}
+
+// begin returns the stored response for (scope, key), or claims the key for a
+// new request when there is none. Expired entries are dropped first.
+func (s *IdempotencyStore) begin(ctx context.Context, scope, key, fingerprint string) (*idempotentResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	now := s.now().UTC()
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE expires_at <= ?`, now); err != nil {
+		return nil, err
+	}
+
+	var (
+		res       idempotentResponse
+		headers   string
+		createdAt time.Time
+	)
+	err := s.db.QueryRowContext(ctx, `
+		SELECT fingerprint, status, headers, body, created_at FROM idempotency_keys WHERE scope = ? AND key = ?`,
+		scope, key,
+	).Scan(&res.Fingerprint, &res.Status, &headers, &res.Body, &createdAt)
+	switch {
+	case err == sql.ErrNoRows, err == nil && res.Status == 0 && now.Sub(createdAt) > abandonedClaimAge:
+		_, err = s.db.ExecContext(ctx, `
+			INSERT OR REPLACE INTO idempotency_keys(scope, key, fingerprint, created_at, expires_at) VALUES(?, ?, ?, ?, ?)`,
+			scope, key, fingerprint, now, now.Add(s.TTL),
+		)
+		return nil, err
+	case err != nil:
+		return nil, err
+	}
+
+	if res.Fingerprint != fingerprint {
+		return nil, errKeyMismatch
+	}
+	if res.Status == 0 {
+		return nil, errKeyInProgress
+	}
+	if err := json.Unmarshal([]byte(headers), &res.Headers); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+func (s *IdempotencyStore) complete(ctx context.Context, scope, key string, res idempotentResponse) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	headers, err := json.Marshal(res.Headers)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `
+		UPDATE idempotency_keys SET status = ?, headers = ?, body = ? WHERE scope = ? AND key = ?`,
+		res.Status, string(headers), res.Body, scope, key,
+	)
+	return err
+}
+
+// release forgets a claimed key so the request can be retried.
+func (s *IdempotencyStore) release(ctx context.Context, scope, key string) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE scope = ? AND key = ?`, scope, key)
+	return err
+}
+
+// Idempotency makes POST handlers safe to retry when the client sends an
+// Idempotency-Key header. Keys are scoped to the caller and route. A replay
+// with the same body gets the stored response; a different body gets 422.
+// Requests sharing a key run one at a time, so a concurrent duplicate waits
+// and then replays. 5xx responses are not stored.
+func Idempotency(store *IdempotencyStore) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(idempotencyHeader)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > maxIdempotencyKeyLen {
+				writeError(w, r, http.StatusBadRequest, "idempotency_key_too_long")
+				return
+			}
+
+			// Read under the same cap as readJSON, which then sees the copy.
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxJSONBody))
+			if isTooLarge(err) {
+				writeBodyError(w, r, tooLarge())
+				return
+			}
+			if err != nil {
+				writeError(w, r, http.StatusBadRequest, "invalid_body")
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			user, _ := userFromContext(r.Context())
+			scope := strconv.FormatInt(workspaceFromContext(r.Context()), 10) + " " + user + " " + r.Method + " " + idempotencyRoute(r)
+			sum := sha256.Sum256(body)
+			fingerprint := hex.EncodeToString(sum[:])
+
+			unlock := store.locks.Lock(scope + "\x00" + key)
+			defer unlock()
+
+			stored, err := store.begin(r.Context(), scope, key, fingerprint)
+			switch {
+			case errors.Is(err, errKeyMismatch):
+				writeError(w, r, http.StatusUnprocessableEntity, "idempotency_key_reused")
+				return
+			case errors.Is(err, errKeyInProgress):
+				writeError(w, r, http.StatusConflict, "idempotency_key_in_progress")
+				return
+			case err != nil:
+				loggerFrom(r.Context()).Error("idempotency lookup", "err", err)
+				writeError(w, r, http.StatusInternalServerError, "internal_error")
+				return
+			case stored != nil:
+				for k, v := range stored.Headers {
+					w.Header().Set(k, v)
+				}
+				w.Header().Set(replayedHeader, "true")
+				w.WriteHeader(stored.Status)
+				_, _ = w.Write(stored.Body)
+				return
+			}
+
+			rec := &responseCapture{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(rec, r)
+
+			// The handler has answered; don't let a client disconnect skip
+			// recording the result.
+			ctx := context.WithoutCancel(r.Context())
+			if rec.status >= 500 {
+				err = store.release(ctx, scope, key)
+			} else {
+				res := idempotentResponse{Status: rec.status, Headers: map[string]string{}, Body: rec.body.Bytes()}
+				for _, h := range replayedHeaders {
+					if v := rec.Header().Get(h); v != "" {
+						res.Headers[h] = v
+					}
+				}
+				err = store.complete(ctx, scope, key, res)
+			}
+			if err != nil {
+				loggerFrom(ctx).Error("idempotency record", "err", err)
+			}
+		})
+	}
+}
+
+// responseCapture passes a response through while keeping a copy.
+type responseCapture struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (c *responseCapture) WriteHeader(status int) {
+	if !c.wroteHeader {
+		c.status = status
+		c.wroteHeader = true
+	}
+	c.ResponseWriter.WriteHeader(status)
+}
+
+func (c *responseCapture) Write(b []byte) (int, error) {
+	c.wroteHeader = true
+	c.body.Write(b)
+	return c.ResponseWriter.Write(b)
+}
+
+// keyedMutex is a set of mutexes created on demand and dropped when unused.
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*refMutex
+}
+
+type refMutex struct {
+	sync.Mutex
+	refs int
+}
+
+// Lock locks the mutex for key and returns its unlock function.
+func (k *keyedMutex) Lock(key string) func() {
+	k.mu.Lock()
+	if k.locks == nil {
+		k.locks = map[string]*refMutex{}
+	}
+	m, ok := k.locks[key]
+	if !ok {
+		m = &refMutex{}
+		k.locks[key] = m
+	}
+	m.refs++
+	k.mu.Unlock()
+
+	m.Lock()
+	return func() {
+		m.Unlock()
+		k.mu.Lock()
+		if m.refs--; m.refs == 0 {
+			delete(k.locks, key)
+		}
+		k.mu.Unlock()
+	}
+}
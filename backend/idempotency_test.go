@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func setupIdempotency(t *testing.T) (*chi.Mux, *IdempotencyStore, func()) {
+	t.Helper()
+
+	_, db := setupTestRouter(t)
+	store := NewIdempotencyStore(db)
+	api := NewBooksAPI(NewBookStore(db))
+
+	r := chi.NewRouter()
+	r.Use(Identify)
+	r.With(Idempotency(store)).Post("/books", api.CreateBookHandler)
+	r.Get("/books", api.GetBooksHandler)
+	r.With(Idempotency(store)).Post("/process-url", ProcessURLHandler)
+	return r, store, func() { db.Close() }
+}
+
+func postIdempotent(t *testing.T, r http.Handler, path, key, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(idempotencyHeader, key)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestIdempotency_ReplayAndMismatch(t *testing.T) {
+	r, _, cleanup := setupIdempotency(t)
+	defer cleanup()
+
+	body := `{"title":"Dune","author":"Frank Herbert","year":1965}`
+	first := postIdempotent(t, r, "/books", "k1", body)
+	if first.Code != http.StatusCreated {
+		t.Fatalf("create status %d body=%s", first.Code, first.Body.String())
+	}
+
+	again := postIdempotent(t, r, "/books", "k1", body)
+	if again.Code != http.StatusCreated || again.Body.String() != first.Body.String() {
+		t.Fatalf("replay got %d %s, want %d %s", again.Code, again.Body.String(), first.Code, first.Body.String())
+	}
+	if again.Header().Get(replayedHeader) != "true" || again.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("unexpected replay headers: %v", again.Header())
+	}
+
+	if books := decodeJSON[[]Book](t, doJSON(t, r, http.MethodGet, "/books", ``)); len(books) != 1 {
+		t.Fatalf("expected a single book, got %d", len(books))
+	}
+
+	rr := postIdempotent(t, r, "/books", "k1", `{"title":"Emma","author":"Jane Austen","year":1815}`)
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("mismatch status %d body=%s", rr.Code, rr.Body.String())
+	}
+
+	// Keys are scoped per route: the same key on another endpoint is new.
+	rr = postIdempotent(t, r, "/process-url", "k1", `{"url":"https://byfood.com/x?y=1","operation":"canonical"}`)
+	if rr.Code != http.StatusOK || rr.Header().Get(replayedHeader) != "" {
+		t.Fatalf("process-url status %d replayed=%q", rr.Code, rr.Header().Get(replayedHeader))
+	}
+}
+
+func TestIdempotency_ValidationErrorIsReplayedAndExpires(t *testing.T) {
+	r, store, cleanup := setupIdempotency(t)
+	defer cleanup()
+
+	now := time.Now().UTC()
+	store.now = func() time.Time { return now }
+
+	rr := postIdempotent(t, r, "/process-url", "k2", `{"url":"nope","operation":"all"}`)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("status %d", rr.Code)
+	}
+	rr = postIdempotent(t, r, "/process-url", "k2", `{"url":"nope","operation":"all"}`)
+	if rr.Code != http.StatusBadRequest || rr.Header().Get(replayedHeader) != "true" {
+		t.Fatalf("expected replayed 400, got %d replayed=%q", rr.Code, rr.Header().Get(replayedHeader))
+	}
+
+	// After the TTL the key is free again, even for a different body.
+	now = now.Add(store.TTL + time.Second)
+	rr = postIdempotent(t, r, "/process-url", "k2", `{"url":"https://byfood.com","operation":"all"}`)
+	if rr.Code != http.StatusOK || rr.Header().Get(replayedHeader) != "" {
+		t.Fatalf("expected a fresh run, got %d replayed=%q", rr.Code, rr.Header().Get(replayedHeader))
+	}
+}
+
+func TestIdempotency_ConcurrentRequestsRunOnce(t *testing.T) {
+	_, db := setupTestRouter(t)
+	defer db.Close()
+
+	var calls atomic.Int32
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		time.Sleep(20 * time.Millisecond)
+		writeJSON(w, http.StatusCreated, map[string]int32{"call": calls.Load()})
+	})
+	h := Idempotency(NewIdempotencyStore(db))(slow)
+
+	var wg sync.WaitGroup
+	codes := make([]int, 8)
+	for i := range codes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes[i] = postIdempotent(t, h, "/slow", "same", `{}`).Code
+		}()
+	}
+	wg.Wait()
+
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("handler ran %d times", n)
+	}
+	for _, c := range codes {
+		if c != http.StatusCreated {
+			t.Fatalf("unexpected status codes: %v", codes)
+		}
+	}
+}
+
+func TestIdempotency_ServerErrorsAreNotStored(t *testing.T) {
+	_, db := setupTestRouter(t)
+	defer db.Close()
+
+	fail := true
+	h := Idempotency(NewIdempotencyStore(db))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			writeJSON(w, http.StatusInternalServerError, errorResponse{Error: "internal error"})
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	if rr := postIdempotent(t, h, "/x", "k3", `{}`); rr.Code != http.StatusInternalServerError {
+		t.Fatalf("status %d", rr.Code)
+	}
+	fail = false
+	if rr := postIdempotent(t, h, "/x", "k3", `{}`); rr.Code != http.StatusNoContent || rr.Header().Get(replayedHeader) != "" {
+		t.Fatalf("retry after 5xx should run again, got %d", rr.Code)
+	}
+}
+
+func TestIdempotency_ScopedToRouteAcrossVersions(t *testing.T) {
+	_, db := setupTestRouter(t)
+	defer db.Close()
+
+	store := NewIdempotencyStore(db)
+	api := NewBooksAPI(NewBookStore(db))
+	var touched atomic.Int32
+	r := chi.NewRouter()
+	r.Use(Identify)
+	mountVersions(r, func(r chi.Router) {
+		r.With(Idempotency(store)).Post("/books", api.CreateBookHandler)
+		r.With(Idempotency(store)).Post("/books/{id}/touch", func(w http.ResponseWriter, r *http.Request) {
+			touched.Add(1)
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}, []apiVersion{{Number: 1}})
+
+	body := `{"title":"Dune","author":"Frank Herbert","year":1965}`
+	first := postIdempotent(t, r, "/v1/books", "k1", body)
+	retry := postIdempotent(t, r, "/books", "k1", body)
+	if first.Code != http.StatusCreated || retry.Code != http.StatusCreated || retry.Header().Get(replayedHeader) != "true" {
+		t.Fatalf("first %d, retry %d replayed=%q", first.Code, retry.Code, retry.Header().Get(replayedHeader))
+	}
+	if decodeJSON[Book](t, first).ID != decodeJSON[Book](t, retry).ID {
+		t.Fatal("retry through the alias created a second book")
+	}
+	if books, err := NewBookStore(db).List(t.Context(), BookFilter{}, SortByID); err != nil || len(books) != 1 {
+		t.Fatalf("books: %d %v", len(books), err)
+	}
+
+	// The same key on another resource is another request.
+	postIdempotent(t, r, "/v1/books/1/touch", "k2", `{}`)
+	postIdempotent(t, r, "/books/1/touch", "k2", `{}`)
+	postIdempotent(t, r, "/books/2/touch", "k2", `{}`)
+	if n := touched.Load(); n != 2 {
+		t.Fatalf("touched %d times, want 2", n)
+	}
+}
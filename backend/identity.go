@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// userHeader carries the caller's identity. The service is expected to sit
+// behind an authenticating reverse proxy that sets it.
+const userHeader = "X-User"
+
+type userCtxKey struct{}
+
+// Identify stores the caller's identity (if any) in the request context.
+func Identify(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user := strings.TrimSpace(r.Header.Get(userHeader)); user != "" {
+			r = r.WithContext(withUser(r.Context(), user))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func withUser(ctx context.Context, user string) context.Context {
+	return context.WithValue(ctx, userCtxKey{}, user)
+}
+
+func userFromContext(ctx context.Context) (string, bool) {
+	user, ok := ctx.Value(userCtxKey{}).(string)
+	return user, ok && user != ""
+}
+
+// requireUser returns the caller's identity, answering 401 when there is none.
+func requireUser(w http.ResponseWriter, r *http.Request) (string, bool) {
+	user, ok := userFromContext(r.Context())
+	if !ok {
+		writeError(w, r, http.StatusUnauthorized, "authentication_required")
+	}
+	return user, ok
+}
+
+// RequireAdmin lets only the users in admins through: 401 without an
+// identity, 403 for anyone else. With no admins nobody gets in.
+func RequireAdmin(admins []string) func(http.Handler) http.Handler {
+	allowed := make(map[string]bool, len(admins))
+	for _, a := range admins {
+		allowed[a] = true
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, ok := requireUser(w, r)
+			if !ok {
+				return
+			}
+			if !allowed[user] {
+				writeError(w, r, http.StatusForbidden, "admin_required")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+type LoansAPI struct {
+	store *LoanStore
+}
+
+func NewLoansAPI(store *LoanStore) *LoansAPI {
+	return &LoansAPI{store: store}
+}
+
+// checkoutInput is the optional body of a checkout.
+type checkoutInput struct {
+	Days   int   `json:"days" example:"14"`
+	CopyID int64 `json:"copy_id" example:"0"`
+}
+
+// returnInput is the optional body of a return.
+type returnInput struct {
+	CopyID int64 `json:"copy_id" example:"0"`
+}
+
+// CheckoutHandler godoc
+// @Summary Check out a book
+// @Description Lends a copy of the book to the caller: `copy_id` if given, otherwise any available copy. The loan is due after `days` days (default 14, at most 90). When no copy is available the answer is 409.
+// @Tags loans
+// @Accept json
+// @Produce json
+// @Param id path int true "Book ID"
+// @Param X-User header string true "Borrower"
+// @Param loan body checkoutInput false "Loan length"
+// @Success 201 {object} Loan
+// @Failure 400 {object} errorResponse
+// @Failure 401 {object} errorResponse
+// @Failure 404 {object} errorResponse
+// @Failure 409 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /books/{id}/checkout [post]
+func (api *LoansAPI) CheckoutHandler(w http.ResponseWriter, r *http.Request) {
+	bookID, ok := parseIDParam(w, r, "id")
+	if !ok {
+		return
+	}
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	var in checkoutInput
+	if !readOptionalJSON(w, r, &in) {
+		return
+	}
+
+	l, err := api.store.Checkout(r.Context(), bookID, user, in.Days, in.CopyID)
+	api.writeResult(w, r, http.StatusCreated, l, err)
+}
+
+// ReturnHandler godoc
+// @Summary Return a book
+// @Description Closes the caller's open loan of the book. Send `copy_id` to pick one when holding several copies.
+// @Tags loans
+// @Accept json
+// @Produce json
+// @Param id path int true "Book ID"
+// @Param X-User header string true "Borrower"
+// @Param loan body returnInput false "Copy to return"
+// @Success 200 {object} Loan
+// @Failure 400 {object} errorResponse
+// @Failure 401 {object} errorResponse
+// @Failure 403 {object} errorResponse
+// @Failure 404 {object} errorResponse
+// @Failure 409 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /books/{id}/return [post]
+func (api *LoansAPI) ReturnHandler(w http.ResponseWriter, r *http.Request) {
+	bookID, ok := parseIDParam(w, r, "id")
+	if !ok {
+		return
+	}
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	var in returnInput
+	if !readOptionalJSON(w, r, &in) {
+		return
+	}
+
+	l, err := api.store.Return(r.Context(), bookID, user, in.CopyID)
+	api.writeResult(w, r, http.StatusOK, l, err)
+}
+
+// ListLoansHandler godoc
+// @Summary List loans, newest first
+// @Description Filter by `user` for a borrowing history, or by `overdue=true` for loans past their due date.
+// @Tags loans
+// @Produce json
+// @Param user query string false "Borrower"
+// @Param book_id query int false "Book ID"
+// @Param open query bool false "Only loans not returned yet"
+// @Param overdue query bool false "Only open loans past their due date"
+// @Success 200 {array} Loan
+// @Failure 400 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /loans [get]
+func (api *LoansAPI) ListLoansHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f := LoanFilter{User: q.Get("user")}
+
+	if raw := q.Get("book_id"); raw != "" {
+		id, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || id <= 0 {
+			writeError(w, r, http.StatusBadRequest, "book_id_invalid")
+			return
+		}
+		f.BookID = id
+	}
+	for _, flag := range []struct {
+		name string
+		dst  *bool
+	}{{"open", &f.Open}, {"overdue", &f.Overdue}} {
+		name, raw := flag.name, q.Get(flag.name)
+		if raw == "" {
+			continue
+		}
+		v, err := strconv.ParseBool(raw)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, name+"_invalid")
+			return
+		}
+		*flag.dst = v
+	}
+
+	loans, err := api.store.List(r.Context(), f)
+	if err != nil {
+		loggerFrom(r.Context()).Error("list loans", "err", err)
+		writeError(w, r, http.StatusInternalServerError, "internal_error")
+		return
+	}
+	writeJSON(w, http.StatusOK, loans)
+}
+
+// writeResult maps loan store errors onto responses, or writes v with status
+// on success.
+func (api *LoansAPI) writeResult(w http.ResponseWriter, r *http.Request, status int, v any, err error) {
+	var verr *ValidationError
+	switch {
+	case err == nil:
+		writeJSON(w, status, v)
+	case errors.As(err, &verr):
+		writeError(w, r, http.StatusBadRequest, verr.Code)
+	case err == ErrNotFound:
+		writeError(w, r, http.StatusNotFound, "book_not_found")
+	case err == ErrCopyNotFound:
+		writeError(w, r, http.StatusNotFound, errorCode(err))
+	case err == ErrNoCopyAvailable, err == ErrCopyUnavailable, err == ErrNotCheckedOut:
+		writeError(w, r, http.StatusConflict, errorCode(err))
+	case err == ErrForbidden:
+		writeError(w, r, http.StatusForbidden, "on_loan_to_other")
+	default:
+		loggerFrom(r.Context()).Error("write loan", "err", err)
+		writeError(w, r, http.StatusInternalServerError, "internal_error")
+	}
+}
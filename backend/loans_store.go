@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultLoanDays = 14
+	maxLoanDays     = 90
+)
+
+var (
+	// ErrNoCopyAvailable means every copy of the book is out, lost or retired.
+	ErrNoCopyAvailable = newCodedError("no_copy_available")
+	// ErrCopyUnavailable means the requested copy cannot be checked out.
+	ErrCopyUnavailable = newCodedError("copy_unavailable")
+	// ErrNotCheckedOut means there is no open loan to return.
+	ErrNotCheckedOut = newCodedError("not_checked_out")
+)
+
+// Loan is one checkout of a copy of a book. ReturnedAt is nil while the copy
+// is out.
+type Loan struct {
+	ID           int64      `json:"id"`
+	BookID       int64      `json:"book_id"`
+	CopyID       int64      `json:"copy_id"`
+	User         string     `json:"user"`
+	CheckedOutAt time.Time  `json:"checked_out_at"`
+	DueAt        time.Time  `json:"due_at"`
+	ReturnedAt   *time.Time `json:"returned_at,omitempty"`
+	Overdue      bool       `json:"overdue"`
+}
+
+// LoanFilter narrows loan listings. Zero values match everything.
+type LoanFilter struct {
+	User    string
+	BookID  int64
+	Open    bool // not returned yet
+	Overdue bool // not returned and past the due date
+}
+
+const loanColumns = `id, book_id, copy_id, user, checked_out_at, due_at, returned_at`
+
+func scanLoan(row rowScanner) (Loan, error) {
+	var (
+		l        Loan
+		returned sql.NullTime
+	)
+	err := row.Scan(&l.ID, &l.BookID, &l.CopyID, &l.User, &l.CheckedOutAt, &l.DueAt, &returned)
+	if returned.Valid {
+		l.ReturnedAt = &returned.Time
+	}
+	return l, err
+}
+
+type LoanStore struct {
+	db *sql.DB
+
+	// locks serializes checkouts and returns of the same book in this
+	// process, so concurrent requests queue instead of failing on SQLite's
+	// write lock. The schema enforces a single open loan per book on its own.
+	locks keyedMutex
+	now   func() time.Time
+
+	// HoldPickup is how long a copy set aside for a hold waits to be
+	// collected before the hold expires.
+	HoldPickup time.Duration
+}
+
+func NewLoanStore(db *sql.DB) *LoanStore {
+	return &LoanStore{db: db, now: time.Now, HoldPickup: defaultHoldPickup}
+}
+
+// Checkout lends a copy of the book to user for days days (defaultLoanDays
+// when 0). A copy set aside for user's ready hold is lent first; otherwise
+// copyID picks a specific copy and 0 takes any available one. It fails with
+// ErrNoCopyAvailable when every copy is out or held for someone else.
+func (s *LoanStore) Checkout(ctx context.Context, bookID int64, user string, days int, copyID int64) (Loan, error) {
+	if days == 0 {
+		days = defaultLoanDays
+	}
+	if days < 1 || days > maxLoanDays {
+		return Loan{}, &ValidationError{Field: "days", Code: "days_range", Message: "days must be between 1 and " + strconv.Itoa(maxLoanDays)}
+	}
+
+	unlock := s.locks.Lock(strconv.FormatInt(bookID, 10))
+	defer unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Loan{}, err
+	}
+	defer tx.Rollback()
+
+	now := s.now().UTC()
+	l := Loan{BookID: bookID, User: user, CheckedOutAt: now, DueAt: now.AddDate(0, 0, days)}
+
+	// Claiming the copy first takes SQLite's write lock, so the check and the
+	// insert below cannot interleave with another writer.
+	var held int64
+	err = tx.QueryRowContext(ctx,
+		`UPDATE copies SET status = 'on_loan', updated_at = ?1 WHERE id = (
+			SELECT copy_id FROM holds WHERE book_id = ?2 AND user = ?3 AND status = 'ready')
+		RETURNING id`,
+		now, bookID, user,
+	).Scan(&held)
+	switch {
+	case err == nil:
+		if copyID != 0 && copyID != held {
+			return Loan{}, ErrCopyUnavailable
+		}
+		l.CopyID = held
+	case errors.Is(err, sql.ErrNoRows):
+		pick := `SELECT id FROM copies WHERE book_id = ? AND status = 'available' AND retired_at IS NULL ORDER BY id LIMIT 1`
+		args := []any{now, bookID}
+		if copyID != 0 {
+			pick = `SELECT id FROM copies WHERE book_id = ? AND status = 'available' AND retired_at IS NULL AND id = ?`
+			args = append(args, copyID)
+		}
+		err = tx.QueryRowContext(ctx,
+			`UPDATE copies SET status = 'on_loan', updated_at = ? WHERE id = (`+pick+`) RETURNING id`, args...,
+		).Scan(&l.CopyID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return Loan{}, unavailableError(ctx, tx, bookID, copyID)
+		}
+		if err != nil {
+			return Loan{}, err
+		}
+	default:
+		return Loan{}, err
+	}
+
+	// Borrowing the book fulfils the user's hold on it, ready or not.
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE holds SET status = 'collected', closed_at = ? WHERE book_id = ? AND user = ? AND status IN ('waiting', 'ready')`,
+		now, bookID, user,
+	); err != nil {
+		return Loan{}, err
+	}
+
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO loans(book_id, copy_id, user, checked_out_at, due_at) VALUES(?, ?, ?, ?, ?) RETURNING id`,
+		l.BookID, l.CopyID, l.User, l.CheckedOutAt, l.DueAt,
+	).Scan(&l.ID)
+	if isUniqueViolation(err) {
+		return Loan{}, ErrCopyUnavailable
+	}
+	if err != nil {
+		return Loan{}, err
+	}
+	if err := refreshBookCopies(ctx, tx, bookID); err != nil {
+		return Loan{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return Loan{}, err
+	}
+	loggerFrom(ctx).Debug("book checked out", "book_id", bookID, "loan_id", l.ID)
+	return l, nil
+}
+
+// Return closes user's open loan of the book, the oldest one when they hold
+// several copies and copyID is 0. Returning a book only others borrowed is
+// ErrForbidden.
+func (s *LoanStore) Return(ctx context.Context, bookID int64, user string, copyID int64) (Loan, error) {
+	unlock := s.locks.Lock(strconv.FormatInt(bookID, 10))
+	defer unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Loan{}, err
+	}
+	defer tx.Rollback()
+
+	l, err := scanLoan(tx.QueryRowContext(ctx, `
+		SELECT `+loanColumns+` FROM loans
+		WHERE book_id = ?1 AND returned_at IS NULL AND (?2 = 0 OR copy_id = ?2)
+		ORDER BY user = ?3 DESC, checked_out_at, id LIMIT 1`, bookID, copyID, user))
+	if errors.Is(err, sql.ErrNoRows) {
+		if err := bookExists(ctx, tx, bookID); err != nil {
+			return Loan{}, err
+		}
+		return Loan{}, ErrNotCheckedOut
+	}
+	if err != nil {
+		return Loan{}, err
+	}
+	if l.User != user {
+		return Loan{}, ErrForbidden
+	}
+
+	now := s.now().UTC()
+	l.ReturnedAt = &now
+	if _, err := tx.ExecContext(ctx, `UPDATE loans SET returned_at = ? WHERE id = ?`, now, l.ID); err != nil {
+		return Loan{}, err
+	}
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE copies SET status = 'available', updated_at = ? WHERE id = ?`, now, l.CopyID,
+	); err != nil {
+		return Loan{}, err
+	}
+	// The returned copy goes to the first hold in the queue, if any.
+	if _, err := promoteHolds(ctx, tx, bookID, now, s.HoldPickup); err != nil {
+		return Loan{}, err
+	}
+	if err := refreshBookCopies(ctx, tx, bookID); err != nil {
+		return Loan{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return Loan{}, err
+	}
+	loggerFrom(ctx).Debug("book returned", "book_id", bookID, "loan_id", l.ID)
+	return l, nil
+}
+
+// List returns matching loans of the caller's workspace, newest first.
+func (s *LoanStore) List(ctx context.Context, f LoanFilter) ([]Loan, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	now := s.now().UTC()
+	var (
+		conds = []string{`book_id IN (SELECT id FROM books WHERE workspace_id = ?)`}
+		args  = []any{workspaceFromContext(ctx)}
+	)
+	if f.User != "" {
+		conds = append(conds, `user = ?`)
+		args = append(args, f.User)
+	}
+	if f.BookID > 0 {
+		conds = append(conds, `book_id = ?`)
+		args = append(args, f.BookID)
+	}
+	if f.Open || f.Overdue {
+		conds = append(conds, `returned_at IS NULL`)
+	}
+	if f.Overdue {
+		conds = append(conds, `due_at < ?`)
+		args = append(args, now)
+	}
+
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+loanColumns+` FROM loans WHERE `+strings.Join(conds, " AND ")+` ORDER BY checked_out_at DESC, id DESC`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []Loan{}
+	for rows.Next() {
+		l, err := scanLoan(rows)
+		if err != nil {
+			return nil, err
+		}
+		l.Overdue = l.ReturnedAt == nil && now.After(l.DueAt)
+		out = append(out, l)
+	}
+	return out, rows.Err()
+}
+
+// unavailableError explains why no copy could be claimed for a checkout.
+func unavailableError(ctx context.Context, tx *sql.Tx, bookID, copyID int64) error {
+	if err := bookExists(ctx, tx, bookID); err != nil {
+		return err
+	}
+	if copyID == 0 {
+		return ErrNoCopyAvailable
+	}
+	var one int
+	err := tx.QueryRowContext(ctx, `SELECT 1 FROM copies WHERE id = ? AND book_id = ?`, copyID, bookID).Scan(&one)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrCopyNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return ErrCopyUnavailable
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func setupLoans(t *testing.T) (*chi.Mux, *LoanStore, func()) {
+	t.Helper()
+
+	_, db := setupTestRouter(t)
+	books := NewBooksAPI(NewBookStore(db))
+	store := NewLoanStore(db)
+	loans := NewLoansAPI(store)
+	holds := NewHoldsAPI(store)
+	copies := NewCopiesAPI(NewCopyStore(db))
+
+	r := chi.NewRouter()
+	r.Use(Identify)
+	r.Route("/books", func(r chi.Router) {
+		r.Get("/", books.GetBooksHandler)
+		r.Post("/", books.CreateBookHandler)
+		r.Get("/{id}", books.GetBookHandler)
+		r.Route("/{id}/copies", func(r chi.Router) {
+			r.Get("/", copies.ListCopiesHandler)
+			r.Post("/", copies.CreateCopyHandler)
+			r.Get("/{copyID}", copies.GetCopyHandler)
+			r.Patch("/{copyID}", copies.UpdateCopyHandler)
+			r.Post("/{copyID}/move", copies.MoveCopyHandler)
+			r.Post("/{copyID}/retire", copies.RetireCopyHandler)
+		})
+		r.Route("/{id}/holds", func(r chi.Router) {
+			r.Get("/", holds.ListBookHoldsHandler)
+			r.Post("/", holds.PlaceHoldHandler)
+			r.Get("/{holdID}", holds.GetHoldHandler)
+			r.Delete("/{holdID}", holds.CancelHoldHandler)
+		})
+		r.Post("/{id}/checkout", loans.CheckoutHandler)
+		r.Post("/{id}/return", loans.ReturnHandler)
+	})
+	r.Get("/loans", loans.ListLoansHandler)
+	r.Get("/holds", holds.ListHoldsHandler)
+	return r, store, func() { db.Close() }
+}
+
+func addCopy(t *testing.T, r http.Handler, bookID int64, barcode, location string) Copy {
+	t.Helper()
+	rr := doJSON(t, r, http.MethodPost, fmt.Sprintf("/books/%d/copies", bookID),
+		fmt.Sprintf(`{"barcode":%q,"location":%q}`, barcode, location))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("add copy status %d body=%s", rr.Code, rr.Body.String())
+	}
+	return decodeJSON[Copy](t, rr)
+}
+
+func TestLoans_CheckoutAndReturn(t *testing.T) {
+	r, _, cleanup := setupLoans(t)
+	defer cleanup()
+
+	book := decodeJSON[Book](t, doJSON(t, r, http.MethodPost, "/books", `{"title":"Dune","author":"Frank Herbert","year":1965}`))
+	if book.Status != BookUnavailable {
+		t.Fatalf("new book status %q", book.Status)
+	}
+	bookPath := fmt.Sprintf("/books/%d", book.ID)
+	if rr := doAs(t, r, "alice", http.MethodPost, bookPath+"/checkout", ``); rr.Code != http.StatusConflict {
+		t.Fatalf("checkout without copies status %d", rr.Code)
+	}
+	cp := addCopy(t, r, book.ID, "LIB-1", "Tokyo")
+
+	rr := doAs(t, r, "alice", http.MethodPost, bookPath+"/checkout", `{"days":7}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("checkout status %d body=%s", rr.Code, rr.Body.String())
+	}
+	loan := decodeJSON[Loan](t, rr)
+	if loan.User != "alice" || loan.CopyID != cp.ID || loan.ReturnedAt != nil || !loan.DueAt.Equal(loan.CheckedOutAt.AddDate(0, 0, 7)) {
+		t.Fatalf("unexpected loan: %+v", loan)
+	}
+	if got := decodeJSON[Book](t, doJSON(t, r, http.MethodGet, bookPath, ``)); got.Status != BookOnLoan {
+		t.Fatalf("status after checkout %q", got.Status)
+	}
+
+	if rr := doAs(t, r, "bob", http.MethodPost, bookPath+"/checkout", ``); rr.Code != http.StatusConflict {
+		t.Fatalf("second checkout status %d", rr.Code)
+	}
+	if rr := doAs(t, r, "bob", http.MethodPost, bookPath+"/return", ``); rr.Code != http.StatusForbidden {
+		t.Fatalf("return by someone else status %d", rr.Code)
+	}
+
+	rr = doAs(t, r, "alice", http.MethodPost, bookPath+"/return", ``)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("return status %d body=%s", rr.Code, rr.Body.String())
+	}
+	if returned := decodeJSON[Loan](t, rr); returned.ID != loan.ID || returned.ReturnedAt == nil {
+		t.Fatalf("unexpected returned loan: %+v", returned)
+	}
+	if got := decodeJSON[Book](t, doJSON(t, r, http.MethodGet, bookPath, ``)); got.Status != BookAvailable {
+		t.Fatalf("status after return %q", got.Status)
+	}
+	if rr := doAs(t, r, "alice", http.MethodPost, bookPath+"/return", ``); rr.Code != http.StatusConflict {
+		t.Fatalf("returning twice status %d", rr.Code)
+	}
+
+	// Bob can borrow it now; alice's history keeps the returned loan.
+	if rr := doAs(t, r, "bob", http.MethodPost, bookPath+"/checkout", ``); rr.Code != http.StatusCreated {
+		t.Fatalf("checkout after return status %d", rr.Code)
+	}
+	history := decodeJSON[[]Loan](t, doJSON(t, r, http.MethodGet, "/loans?user=alice", ``))
+	if len(history) != 1 || history[0].ID != loan.ID || history[0].ReturnedAt == nil {
+		t.Fatalf("alice's history: %+v", history)
+	}
+	if open := decodeJSON[[]Loan](t, doJSON(t, r, http.MethodGet, "/loans?open=true", ``)); len(open) != 1 || open[0].User != "bob" {
+		t.Fatalf("open loans: %+v", open)
+	}
+}
+
+func TestLoans_Overdue(t *testing.T) {
+	r, store, cleanup := setupLoans(t)
+	defer cleanup()
+
+	now := time.Now().UTC()
+	store.now = func() time.Time { return now }
+
+	for i, days := range []int{3, 30} {
+		b := decodeJSON[Book](t, doJSON(t, r, http.MethodPost, "/books", fmt.Sprintf(`{"title":"Book %d","author":"A","year":2000}`, i)))
+		addCopy(t, r, b.ID, fmt.Sprintf("LIB-%d", i), "Tokyo")
+		doAs(t, r, "alice", http.MethodPost, fmt.Sprintf("/books/%d/checkout", b.ID), fmt.Sprintf(`{"days":%d}`, days))
+	}
+
+	if overdue := decodeJSON[[]Loan](t, doJSON(t, r, http.MethodGet, "/loans?overdue=true", ``)); len(overdue) != 0 {
+		t.Fatalf("nothing should be overdue yet: %+v", overdue)
+	}
+
+	now = now.AddDate(0, 0, 4)
+	overdue := decodeJSON[[]Loan](t, doJSON(t, r, http.MethodGet, "/loans?overdue=true", ``))
+	if len(overdue) != 1 || !overdue[0].Overdue || overdue[0].DueAt.After(now) {
+		t.Fatalf("overdue loans: %+v", overdue)
+	}
+
+	if rr := doJSON(t, r, http.MethodGet, "/loans?overdue=soon", ``); rr.Code != http.StatusBadRequest {
+		t.Fatalf("bad overdue flag status %d", rr.Code)
+	}
+}
+
+func TestLoans_Validation(t *testing.T) {
+	r, _, cleanup := setupLoans(t)
+	defer cleanup()
+
+	book := decodeJSON[Book](t, doJSON(t, r, http.MethodPost, "/books", `{"title":"Dune","author":"Frank Herbert","year":1965}`))
+	checkout := fmt.Sprintf("/books/%d/checkout", book.ID)
+
+	cases := []struct {
+		name   string
+		user   string
+		path   string
+		body   string
+		status int
+	}{
+		{"anonymous", "", checkout, ``, http.StatusUnauthorized},
+		{"too long", "alice", checkout, `{"days":365}`, http.StatusBadRequest},
+		{"bad json", "alice", checkout, `{"days":`, http.StatusBadRequest},
+		{"unknown book", "alice", "/books/9999/checkout", ``, http.StatusNotFound},
+		{"return unknown book", "alice", "/books/9999/return", ``, http.StatusNotFound},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := doAs(t, r, tc.user, http.MethodPost, tc.path, tc.body)
+			if rr.Code != tc.status {
+				t.Fatalf("status got %d want %d body=%s", rr.Code, tc.status, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestLoans_ConcurrentCheckoutsLendEachCopyOnce(t *testing.T) {
+	r, _, cleanup := setupLoans(t)
+	defer cleanup()
+
+	book := decodeJSON[Book](t, doJSON(t, r, http.MethodPost, "/books", `{"title":"Dune","author":"Frank Herbert","year":1965}`))
+	addCopy(t, r, book.ID, "LIB-1", "Tokyo")
+	addCopy(t, r, book.ID, "LIB-2", "Osaka")
+	checkout := fmt.Sprintf("/books/%d/checkout", book.ID)
+
+	var wg sync.WaitGroup
+	codes := make([]int, 8)
+	for i := range codes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes[i] = doAs(t, r, fmt.Sprintf("user%d", i), http.MethodPost, checkout, ``).Code
+		}()
+	}
+	wg.Wait()
+
+	created := 0
+	for _, c := range codes {
+		switch c {
+		case http.StatusCreated:
+			created++
+		case http.StatusConflict:
+		default:
+			t.Fatalf("unexpected status codes: %v", codes)
+		}
+	}
+	if created != 2 {
+		t.Fatalf("expected one checkout per copy, got %v", codes)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	chimw "github.com/go-chi/chi/v5/middleware"
+)
+
+// sensitiveHeaders are never written to the logs verbatim.
+var sensitiveHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+	"X-Api-Key":           true,
+}
+
+const redacted = "[REDACTED]"
+
+// NewLogger builds a slog logger writing to w. format is "json" or "text",
+// level is one of debug, info, warn, error.
+func NewLogger(w io.Writer, format, level string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(strings.TrimSpace(level))); err != nil {
+		return nil, fmt.Errorf("log level %q: %w", level, err)
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case "", "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q (want json or text)", format)
+	}
+}
+
+type loggerCtxKey struct{}
+
+func withLogger(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerCtxKey{}, l)
+}
+
+// loggerFrom returns the request-scoped logger, or the default logger when
+// ctx does not carry one (tests, background jobs).
+func loggerFrom(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(loggerCtxKey{}).(*slog.Logger); ok {
+		return l
+	}
+	return slog.Default()
+}
+
+// routeHandler adds the matched chi route pattern to every record. The
+// pattern is read at log time because the request logger is created before
+// routing has finished.
+type routeHandler struct {
+	slog.Handler
+	rctx *chi.Context
+}
+
+func (h routeHandler) Handle(ctx context.Context, rec slog.Record) error {
+	if h.rctx != nil {
+		rec.AddAttrs(slog.String("route", h.rctx.RoutePattern()))
+	}
+	return h.Handler.Handle(ctx, rec)
+}
+
+func (h routeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return routeHandler{Handler: h.Handler.WithAttrs(attrs), rctx: h.rctx}
+}
+
+func (h routeHandler) WithGroup(name string) slog.Handler {
+	return routeHandler{Handler: h.Handler.WithGroup(name), rctx: h.rctx}
+}
+
+// RequestLogger replaces chimw.Logger. It attaches a logger carrying the
+// request correlation fields to the context and writes one access line per
+// request. It must run after chimw.RequestID and Identify.
+func RequestLogger(base *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+
+			attrs := []any{slog.String("request_id", chimw.GetReqID(r.Context()))}
+			if user, ok := userFromContext(r.Context()); ok {
+				attrs = append(attrs, slog.String("user", user))
+			}
+			h := routeHandler{Handler: base.Handler(), rctx: chi.RouteContext(r.Context())}
+			l := slog.New(h).With(attrs...)
+
+			ww := chimw.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r.WithContext(withLogger(r.Context(), l)))
+
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			level := slog.LevelInfo
+			if status >= 500 {
+				level = slog.LevelError
+			}
+			l.LogAttrs(r.Context(), level, "request",
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.String("remote_addr", r.RemoteAddr),
+				slog.Int("status", status),
+				slog.Int("bytes", ww.BytesWritten()),
+				slog.Duration("latency", time.Since(start)),
+			)
+			if l.Enabled(r.Context(), slog.LevelDebug) {
+				l.LogAttrs(r.Context(), slog.LevelDebug, "request headers",
+					slog.Any("headers", redactHeaders(r.Header)),
+				)
+			}
+		})
+	}
+}
+
+// redactHeaders flattens h for logging with sensitive values masked.
+func redactHeaders(h http.Header) map[string]string {
+	out := make(map[string]string, len(h))
+	for k, v := range h {
+		if sensitiveHeaders[http.CanonicalHeaderKey(k)] {
+			out[k] = redacted
+			continue
+		}
+		out[k] = strings.Join(v, ", ")
+	}
+	return out
+}
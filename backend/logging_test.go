@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	chimw "github.com/go-chi/chi/v5/middleware"
+)
+
+func logLines(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var out []map[string]any
+	sc := bufio.NewScanner(buf)
+	for sc.Scan() {
+		var m map[string]any
+		if err := json.Unmarshal(sc.Bytes(), &m); err != nil {
+			t.Fatalf("invalid log line: %v %s", err, sc.Text())
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+func TestRequestLogger_CorrelationFields(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := NewLogger(&buf, "json", "debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := OpenDB("file::memory:?cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	api := NewBooksAPI(NewBookStore(db))
+
+	r := chi.NewRouter()
+	r.Use(chimw.RequestID)
+	r.Use(Identify)
+	r.Use(RequestLogger(logger))
+	r.Post("/books", api.CreateBookHandler)
+
+	req := httptest.NewRequest(http.MethodPost, "/books", bytes.NewBufferString(`{"title":"Dune","author":"Frank Herbert","year":1965}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer secret-token")
+	req.Header.Set(userHeader, "alice")
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("status got %d body=%s", rr.Code, rr.Body.String())
+	}
+
+	lines := logLines(t, &buf)
+	byMsg := map[string]map[string]any{}
+	for _, l := range lines {
+		byMsg[l["msg"].(string)] = l
+	}
+
+	access, ok := byMsg["request"]
+	if !ok {
+		t.Fatalf("missing access line: %v", lines)
+	}
+	if access["route"] != "/books" || access["user"] != "alice" || access["status"] != float64(http.StatusCreated) {
+		t.Fatalf("unexpected access line: %v", access)
+	}
+	if access["request_id"] == "" || access["latency"] == nil {
+		t.Fatalf("missing correlation fields: %v", access)
+	}
+
+	created, ok := byMsg["book created"]
+	if !ok {
+		t.Fatalf("missing store line: %v", lines)
+	}
+	if created["request_id"] != access["request_id"] || created["user"] != "alice" {
+		t.Fatalf("store line not correlated: %v", created)
+	}
+
+	headers := byMsg["request headers"]["headers"].(map[string]any)
+	if headers["Authorization"] != redacted {
+		t.Fatalf("authorization not redacted: %v", headers)
+	}
+}
+
+func TestNewLogger_InvalidConfig(t *testing.T) {
+	if _, err := NewLogger(&bytes.Buffer{}, "xml", "info"); err == nil {
+		t.Fatal("expected error for unknown format")
+	}
+	if _, err := NewLogger(&bytes.Buffer{}, "json", "loud"); err == nil {
+		t.Fatal("expected error for unknown level")
+	}
+}
@@ -1,8 +1,13 @@
 package main
 
 import (
-	"log"
+	"context"
+	"database/sql"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	chimw "github.com/go-chi/chi/v5/middleware"
@@ -17,21 +22,88 @@ import (
 // @title byFood Assignment API
 // @version 1.0
 // @description Books CRUD + URL Processor service
-// @BasePath /
+// @BasePath /v1
 func main() {
-	// database part
-	db, err := OpenDB("file:books.db?_pragma=busy_timeout(5000)")
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer db.Close()
+	os.Exit(runCLI(context.Background(), os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
 
+// serve runs the HTTP and gRPC servers until ctx is cancelled.
+func serve(ctx context.Context, db *sql.DB, logger *slog.Logger, addr, grpcAddr string, backups *BackupManager) error {
 	if err := Migrate(db); err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	store := NewBookStore(db)
 	booksAPI := NewBooksAPI(store)
+	booksAPI.CacheControl = getenv("BOOKS_CACHE_CONTROL", defaultCacheControl)
+
+	// live change feed, seeded so clients can resume across restarts
+	hub := NewEventHub(1000)
+	recent, err := store.RecentEvents(ctx, 1000)
+	if err != nil {
+		return err
+	}
+	hub.Seed(recent)
+	store.OnCommit(hub.Publish)
+	eventsAPI := NewBookEventsAPI(hub)
+
+	// catalog metadata for books with an ISBN, fetched on create and on demand
+	enricher := NewEnricher(db, NewOpenLibraryProvider(
+		getenv("OPENLIBRARY_URL", defaultOpenLibraryURL),
+		getenv("OPENLIBRARY_COVERS_URL", defaultCoversURL),
+	))
+	store.OnCommit(enricher.OnBookEvent)
+	metadataAPI := NewMetadataAPI(enricher)
+
+	graphqlAPI, err := NewGraphQLAPI(store)
+	if err != nil {
+		return err
+	}
+
+	webhookStore := NewWebhookStore(db)
+	webhookStore.AllowPrivate = getenv("WEBHOOK_ALLOW_PRIVATE", "") == "true"
+	webhooksAPI := NewWebhooksAPI(webhookStore)
+
+	go NewWebhookDispatcher(webhookStore).Run(ctx)
+
+	reviewsAPI := NewReviewsAPI(NewReviewStore(db))
+	loanStore := NewLoanStore(db)
+	copyStore := NewCopyStore(db)
+	loanStore.HoldPickup = holdPickup()
+	copyStore.HoldPickup = loanStore.HoldPickup
+	store.HoldPickup = loanStore.HoldPickup
+	loansAPI := NewLoansAPI(loanStore)
+	holdsAPI := NewHoldsAPI(loanStore)
+	copiesAPI := NewCopiesAPI(copyStore)
+
+	go loanStore.RunHoldExpiry(ctx, time.Minute)
+	go store.RunChangePruning(ctx, time.Hour)
+
+	transport, err := newTransport(logger)
+	if err != nil {
+		return err
+	}
+	notificationStore := NewNotificationStore(db)
+	notificationsAPI := NewNotificationsAPI(notificationStore)
+	go NewNotifier(notificationStore, transport).Run(ctx)
+
+	adminAPI := NewAdminAPI(backups)
+	idempotent := Idempotency(NewIdempotencyStore(db))
+
+	workspaceStore := NewWorkspaceStore(db)
+	workspacesAPI := NewWorkspacesAPI(workspaceStore)
+
+	// gRPC on its own port, sharing the store and URL processor
+	lis, err := net.Listen("tcp", grpcAddr)
+	if err != nil {
+		return err
+	}
+	grpcServer := NewGRPCServer(store, workspaceStore, logger)
+	grpcErr := make(chan error, 1)
+	go func() {
+		logger.Info("grpc listening", "addr", grpcAddr)
+		grpcErr <- grpcServer.Serve(lis)
+	}()
 
 	// router part
 	r := chi.NewRouter()
@@ -39,14 +111,16 @@ func main() {
 	// Core middleware
 	r.Use(chimw.RequestID)
 	r.Use(chimw.RealIP)
-	r.Use(chimw.Logger)
+	r.Use(Identify)
+	r.Use(RequestLogger(logger))
 	r.Use(chimw.Recoverer)
 
 	// CORS (in Next.js)
 	r.Use(cors.Handler(cors.Options{
 		AllowedOrigins: []string{"http://localhost:3000"},
-		AllowedMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders: []string{"Accept", "Authorization", "Content-Type"},
+		AllowedMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders: []string{"Accept", "Authorization", "Content-Type", "Last-Event-ID", idempotencyHeader, userHeader, workspaceHeader},
+		ExposedHeaders: []string{"ETag", "Last-Modified", replayedHeader, "API-Version", "Deprecation", "Sunset", "Link"},
 		MaxAge:         300, // cache preflight for 5 minutes
 	}))
 
@@ -56,20 +130,143 @@ func main() {
 		httpSwagger.URL("/swagger/doc.json"),
 	))
 
-	// Part 2: URL Processor
-	r.Post("/process-url", ProcessURLHandler)
+	// API routes, served under /v1 (and later versions) and, deprecated, at the root
+	routes := func(r chi.Router) {
+		// Part 2: URL Processor
+		r.With(idempotent).Post("/process-url", ProcessURLHandler)
+
+		// Notifications
+		r.Route("/notifications", func(r chi.Router) {
+			r.Get("/", notificationsAPI.ListNotificationsHandler)
+			r.Get("/preferences", notificationsAPI.GetPreferencesHandler)
+			r.Put("/preferences", notificationsAPI.UpdatePreferencesHandler)
+		})
+
+		// Everything below works on the caller's workspace
+		r.Group(func(r chi.Router) {
+			r.Use(ResolveWorkspace(workspaceStore))
+
+			// Part 1: Books CRUD
+			r.Route("/books", func(r chi.Router) {
+				r.Get("/", booksAPI.GetBooksHandler)
+				r.With(idempotent).Post("/", booksAPI.CreateBookHandler)
+				r.Get("/events", eventsAPI.StreamBookEventsHandler)
+				r.Get("/changes", booksAPI.ChangesHandler)
+				r.Get("/by-isbn/{isbn}", booksAPI.GetBookByISBNHandler)
+				r.Get("/duplicates", booksAPI.DuplicatesHandler)
+				r.Post("/merge", booksAPI.MergeBooksHandler)
+				r.Get("/{id}", booksAPI.GetBookHandler)
+				r.Put("/{id}", booksAPI.UpdateBookHandler)
+				r.Delete("/{id}", booksAPI.DeleteBookHandler)
+
+				r.Route("/{id}/reviews", func(r chi.Router) {
+					r.Use(booksAPI.RequireBook)
+					r.Get("/", reviewsAPI.ListReviewsHandler)
+					r.Post("/", reviewsAPI.CreateReviewHandler)
+					r.Get("/{reviewID}", reviewsAPI.GetReviewHandler)
+					r.Put("/{reviewID}", reviewsAPI.UpdateReviewHandler)
+					r.Delete("/{reviewID}", reviewsAPI.DeleteReviewHandler)
+				})
+
+				r.Route("/{id}/copies", func(r chi.Router) {
+					r.Use(booksAPI.RequireBook)
+					r.Get("/", copiesAPI.ListCopiesHandler)
+					r.Post("/", copiesAPI.CreateCopyHandler)
+					r.Get("/{copyID}", copiesAPI.GetCopyHandler)
+					r.Patch("/{copyID}", copiesAPI.UpdateCopyHandler)
+					r.Post("/{copyID}/move", copiesAPI.MoveCopyHandler)
+					r.Post("/{copyID}/retire", copiesAPI.RetireCopyHandler)
+				})
+
+				r.Route("/{id}/holds", func(r chi.Router) {
+					r.Use(booksAPI.RequireBook)
+					r.Get("/", holdsAPI.ListBookHoldsHandler)
+					r.Post("/", holdsAPI.PlaceHoldHandler)
+					r.Get("/{holdID}", holdsAPI.GetHoldHandler)
+					r.Delete("/{holdID}", holdsAPI.CancelHoldHandler)
+				})
+
+				r.With(booksAPI.RequireBook).Post("/{id}/enrich", metadataAPI.EnrichBookHandler)
+				r.With(booksAPI.RequireBook).Get("/{id}/metadata", metadataAPI.GetMetadataHandler)
+
+				r.With(booksAPI.RequireBook).Post("/{id}/checkout", loansAPI.CheckoutHandler)
+				r.With(booksAPI.RequireBook).Post("/{id}/return", loansAPI.ReturnHandler)
+			})
+
+			r.Get("/stats", booksAPI.StatsHandler)
+			r.Get("/loans", loansAPI.ListLoansHandler)
+			r.Get("/holds", holdsAPI.ListHoldsHandler)
+
+			// GraphQL (playground at GET /graphql in a browser)
+			r.Get("/graphql", graphqlAPI.GraphQLHandler)
+			r.Post("/graphql", graphqlAPI.GraphQLHandler)
 
-	// Part 1: Books CRUD
-	r.Route("/books", func(r chi.Router) {
-		r.Get("/", booksAPI.GetBooksHandler)
-		r.Post("/", booksAPI.CreateBookHandler)
-		r.Get("/{id}", booksAPI.GetBookHandler)
-		r.Put("/{id}", booksAPI.UpdateBookHandler)
-		r.Delete("/{id}", booksAPI.DeleteBookHandler)
+			// Webhooks
+			r.Route("/webhooks", func(r chi.Router) {
+				r.Get("/", webhooksAPI.ListWebhooksHandler)
+				r.Post("/", webhooksAPI.CreateWebhookHandler)
+				r.Get("/dead-letters", webhooksAPI.ListDeadLettersHandler)
+				r.Post("/dead-letters/{id}/replay", webhooksAPI.ReplayDeadLetterHandler)
+				r.Get("/{id}", webhooksAPI.GetWebhookHandler)
+				r.Delete("/{id}", webhooksAPI.DeleteWebhookHandler)
+			})
+		})
+
+		// Admin
+		r.Route("/admin", func(r chi.Router) {
+			r.Use(RequireAdmin(adminUsers()))
+			r.Post("/backup", adminAPI.CreateBackupHandler)
+			r.Get("/backups", adminAPI.ListBackupsHandler)
+
+			r.Route("/workspaces", func(r chi.Router) {
+				r.Get("/", workspacesAPI.ListWorkspacesHandler)
+				r.Post("/", workspacesAPI.CreateWorkspaceHandler)
+				r.Get("/{id}", workspacesAPI.GetWorkspaceHandler)
+				r.Patch("/{id}", workspacesAPI.UpdateWorkspaceHandler)
+				r.Post("/{id}/archive", workspacesAPI.ArchiveWorkspaceHandler)
+				r.Put("/{id}/members/{user}", workspacesAPI.AddMemberHandler)
+				r.Delete("/{id}/members/{user}", workspacesAPI.RemoveMemberHandler)
+			})
+		})
+	}
+	mountVersions(r, routes, apiVersions)
+
+	// OPDS catalog for e-reader apps; Atom, so outside the versioned JSON API
+	opdsAPI := NewOPDSAPI(store)
+	r.Route("/opds", func(r chi.Router) {
+		r.Use(ResolveWorkspace(workspaceStore))
+		r.Get("/", opdsAPI.RootHandler)
+		r.Get("/books", opdsAPI.BooksHandler)
+		r.Get("/authors", opdsAPI.FacetHandler(FacetAuthor, "By author"))
+		r.Get("/tags", opdsAPI.FacetHandler(FacetTag, "By tag"))
+		r.Get("/years", opdsAPI.FacetHandler(FacetYear, "By year"))
+		r.Get("/opensearch.xml", opdsAPI.OpenSearchHandler)
 	})
 
-	// Start server
-	addr := ":8080"
-	log.Printf("listening on %s", addr)
-	log.Fatal(http.ListenAndServe(addr, r))
+	// Start server; both stop gracefully on SIGINT/SIGTERM
+	srv := &http.Server{Addr: addr, Handler: r}
+	httpErr := make(chan error, 1)
+	go func() {
+		logger.Info("listening", "addr", addr)
+		httpErr <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err = <-httpErr:
+	case err = <-grpcErr:
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_ = srv.Shutdown(shutdownCtx)
+	grpcServer.GracefulStop()
+	return err
 }
+
+func getenv(key, fallback string) string {
+	if v, ok := os.LookupEnv(key); ok && v != "" {
+		return v
+	}
+	return fallback
+}
+
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNoISBN            = newCodedError("no_isbn")
+	ErrMetadataNotFound  = newCodedError("metadata_not_found")
+	ErrProviderOpen      = newCodedError("metadata_unavailable")
+	ErrMetadataNotLoaded = newCodedError("metadata_not_loaded")
+)
+
+// Metadata is what a provider knows about an edition.
+type Metadata struct {
+	Publisher   string
+	PageCount   int
+	Subjects    []string
+	Description string
+	CoverURL    string
+}
+
+// MetadataProvider looks books up in a bibliographic catalog by ISBN. It
+// returns ErrMetadataNotFound when the catalog has no such edition.
+type MetadataProvider interface {
+	Name() string
+	Lookup(ctx context.Context, isbn string) (Metadata, error)
+}
+
+// BookMetadata is the enriched data stored for a book, separate from the
+// fields users edit. Source names the provider it came from.
+type BookMetadata struct {
+	BookID      int64     `json:"book_id"`
+	Source      string    `json:"source" example:"openlibrary"`
+	ISBN        string    `json:"isbn" example:"9780441172719"`
+	Publisher   string    `json:"publisher" example:"Ace Books"`
+	PageCount   int       `json:"page_count" example:"535"`
+	Subjects    []string  `json:"subjects"`
+	Description string    `json:"description"`
+	CoverURL    string    `json:"cover_url"`
+	FetchedAt   time.Time `json:"fetched_at"`
+}
+
+// metadataCache remembers lookups, including misses, for TTL so repeated
+// enrichment does not hit the provider.
+type metadataCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	max     int
+	entries map[string]metadataCacheEntry
+}
+
+type metadataCacheEntry struct {
+	md      Metadata
+	err     error // nil or ErrMetadataNotFound
+	expires time.Time
+}
+
+func (c *metadataCache) get(isbn string, now time.Time) (Metadata, error, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[isbn]
+	if !ok || now.After(e.expires) {
+		return Metadata{}, nil, false
+	}
+	return e.md, e.err, true
+}
+
+func (c *metadataCache) put(isbn string, md Metadata, err error, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil || len(c.entries) >= c.max {
+		c.entries = map[string]metadataCacheEntry{}
+	}
+	c.entries[isbn] = metadataCacheEntry{md: md, err: err, expires: now.Add(c.ttl)}
+}
+
+// circuitBreaker stops calling a failing provider. After threshold
+// consecutive failures it opens for cooldown; the first call after that is
+// let through, and closes the breaker again if it succeeds.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openUntil time.Time
+}
+
+// allow reports whether a call may go ahead, and if not, when to retry.
+func (b *circuitBreaker) allow(now time.Time) (bool, time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < b.threshold {
+		return true, time.Time{}
+	}
+	if now.Before(b.openUntil) {
+		return false, b.openUntil
+	}
+	// Half open: let this call probe, and hold the others off meanwhile.
+	b.openUntil = now.Add(b.cooldown)
+	return true, time.Time{}
+}
+
+func (b *circuitBreaker) record(ok bool, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ok {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openUntil = now.Add(b.cooldown)
+	}
+}
+
+// BreakerOpenError is returned while the circuit breaker is open.
+type BreakerOpenError struct {
+	RetryAt time.Time
+}
+
+func (e *BreakerOpenError) Error() string { return ErrProviderOpen.Error() }
+func (e *BreakerOpenError) Unwrap() error { return ErrProviderOpen }
+
+// ProviderError is a failed or timed out provider call.
+type ProviderError struct {
+	Provider string
+	Err      error
+}
+
+func (e *ProviderError) Error() string { return e.Provider + " lookup: " + e.Err.Error() }
+func (e *ProviderError) Unwrap() error { return e.Err }
+
+// Enricher fetches metadata for books with an ISBN and stores it. Lookups go
+// through a cache, a per-call timeout and a circuit breaker.
+type Enricher struct {
+	db       *sql.DB
+	provider MetadataProvider
+	now      func() time.Time
+	cache    metadataCache
+	breaker  circuitBreaker
+
+	Timeout time.Duration
+	// Workers enrich books in the background, with up to Queue more books
+	// waiting; books beyond that are skipped and can be enriched on demand.
+	Workers int
+	Queue   int
+
+	startWorkers sync.Once
+	jobs         chan BookEvent
+	background   sync.WaitGroup
+}
+
+func NewEnricher(db *sql.DB, provider MetadataProvider) *Enricher {
+	return &Enricher{
+		db:       db,
+		provider: provider,
+		now:      func() time.Time { return time.Now().UTC() },
+		cache:    metadataCache{ttl: 24 * time.Hour, max: 10000},
+		breaker:  circuitBreaker{threshold: 5, cooldown: 30 * time.Second},
+		Timeout:  5 * time.Second,
+		Workers:  4,
+		Queue:    1000,
+	}
+}
+
+// lookup asks the provider about isbn, unless the answer is cached or the
+// breaker is open.
+func (e *Enricher) lookup(ctx context.Context, isbn string) (Metadata, error) {
+	if md, err, ok := e.cache.get(isbn, e.now()); ok {
+		return md, err
+	}
+	if ok, retryAt := e.breaker.allow(e.now()); !ok {
+		return Metadata{}, &BreakerOpenError{RetryAt: retryAt}
+	}
+
+	callCtx, cancel := context.WithTimeout(ctx, e.Timeout)
+	defer cancel()
+	md, err := e.provider.Lookup(callCtx, isbn)
+
+	// A miss is a valid answer; only errors count against the provider, and
+	// not when the caller went away or gave up before it answered.
+	if err == nil || err == ErrMetadataNotFound {
+		e.breaker.record(true, e.now())
+		e.cache.put(isbn, md, err, e.now())
+		return md, err
+	}
+	if ctx.Err() == nil {
+		e.breaker.record(false, e.now())
+	}
+	return Metadata{}, &ProviderError{Provider: e.provider.Name(), Err: err}
+}
+
+// Enrich fetches metadata for the book's ISBN and stores it, replacing what
+// was there.
+func (e *Enricher) Enrich(ctx context.Context, bookID int64) (BookMetadata, error) {
+	var isbn string
+	err := e.db.QueryRowContext(ctx,
+		`SELECT isbn FROM books WHERE id = ? AND workspace_id = ?`, bookID, workspaceFromContext(ctx),
+	).Scan(&isbn)
+	if err == sql.ErrNoRows {
+		return BookMetadata{}, ErrNotFound
+	}
+	if err != nil {
+		return BookMetadata{}, err
+	}
+	if isbn == "" {
+		return BookMetadata{}, ErrNoISBN
+	}
+
+	md, err := e.lookup(ctx, isbn)
+	if err != nil {
+		return BookMetadata{}, err
+	}
+
+	bm := BookMetadata{
+		BookID:      bookID,
+		Source:      e.provider.Name(),
+		ISBN:        isbn,
+		Publisher:   md.Publisher,
+		PageCount:   md.PageCount,
+		Subjects:    md.Subjects,
+		Description: md.Description,
+		CoverURL:    md.CoverURL,
+		FetchedAt:   e.now(),
+	}
+	if bm.Subjects == nil {
+		bm.Subjects = []string{}
+	}
+	subjects, err := json.Marshal(bm.Subjects)
+	if err != nil {
+		return BookMetadata{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	// Only store it if the book still has that ISBN; it may have been edited
+	// or deleted while the provider was answering.
+	res, err := e.db.ExecContext(ctx, `
+		INSERT INTO book_metadata(book_id, source, isbn, publisher, page_count, subjects, description, cover_url, fetched_at)
+		SELECT id, ?, isbn, ?, ?, ?, ?, ?, ? FROM books WHERE id = ? AND isbn = ?
+		ON CONFLICT(book_id) DO UPDATE SET source = excluded.source, isbn = excluded.isbn,
+			publisher = excluded.publisher, page_count = excluded.page_count, subjects = excluded.subjects,
+			description = excluded.description, cover_url = excluded.cover_url, fetched_at = excluded.fetched_at`,
+		bm.Source, bm.Publisher, bm.PageCount, string(subjects), bm.Description, bm.CoverURL, bm.FetchedAt, bookID, isbn,
+	)
+	if err != nil {
+		return BookMetadata{}, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return BookMetadata{}, ErrNotFound
+	}
+	return bm, nil
+}
+
+// Get returns the stored metadata of a book.
+func (e *Enricher) Get(ctx context.Context, bookID int64) (BookMetadata, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	if err := bookExists(ctx, e.db, bookID); err != nil {
+		return BookMetadata{}, err
+	}
+
+	var (
+		bm       BookMetadata
+		subjects string
+	)
+	err := e.db.QueryRowContext(ctx, `
+		SELECT book_id, source, isbn, publisher, page_count, subjects, description, cover_url, fetched_at
+		FROM book_metadata WHERE book_id = ?`, bookID,
+	).Scan(&bm.BookID, &bm.Source, &bm.ISBN, &bm.Publisher, &bm.PageCount, &subjects, &bm.Description, &bm.CoverURL, &bm.FetchedAt)
+	if err == sql.ErrNoRows {
+		return BookMetadata{}, ErrMetadataNotLoaded
+	}
+	if err != nil {
+		return BookMetadata{}, err
+	}
+	return bm, json.Unmarshal([]byte(subjects), &bm.Subjects)
+}
+
+// OnBookEvent enriches books that were created, or given a new ISBN, in the
+// background. It is registered with BookStore.OnCommit and never blocks the
+// writer.
+func (e *Enricher) OnBookEvent(ev BookEvent) {
+	if ev.Book.ISBN == "" || (ev.Type != EventBookCreated && ev.Type != EventBookUpdated) {
+		return
+	}
+
+	e.startWorkers.Do(func() {
+		e.jobs = make(chan BookEvent, e.Queue)
+		for range e.Workers {
+			go func() {
+				for ev := range e.jobs {
+					e.enrichEvent(ev)
+					e.background.Done()
+				}
+			}()
+		}
+	})
+
+	e.background.Add(1)
+	select {
+	case e.jobs <- ev:
+	default:
+		e.background.Done()
+		slog.Warn("enrichment queue full, skipping book", "book_id", ev.Book.ID, "isbn", ev.Book.ISBN)
+	}
+}
+
+func (e *Enricher) enrichEvent(ev BookEvent) {
+	if ev.Type == EventBookUpdated {
+		var stored string
+		err := e.db.QueryRow(`SELECT isbn FROM book_metadata WHERE book_id = ?`, ev.Book.ID).Scan(&stored)
+		if err == nil && stored == ev.Book.ISBN {
+			return
+		}
+	}
+	ctx := withWorkspace(context.Background(), ev.Book.WorkspaceID)
+	if _, err := e.Enrich(ctx, ev.Book.ID); err != nil && err != ErrMetadataNotFound && err != ErrNotFound {
+		slog.Warn("enrich book", "book_id", ev.Book.ID, "isbn", ev.Book.ISBN, "err", err)
+	}
+}
+
+// Wait blocks until background enrichment started so far has finished.
+func (e *Enricher) Wait() {
+	e.background.Wait()
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type MetadataAPI struct {
+	enricher *Enricher
+}
+
+func NewMetadataAPI(enricher *Enricher) *MetadataAPI {
+	return &MetadataAPI{enricher: enricher}
+}
+
+// EnrichBookHandler godoc
+// @Summary Fetch catalog metadata for a book
+// @Description Looks the book's ISBN up with the metadata provider (Open Library by default) and stores publisher, page count, subjects, description and cover apart from the user-entered fields. Books created with an ISBN are enriched automatically.
+// @Tags metadata
+// @Produce json
+// @Param id path int true "Book ID"
+// @Success 200 {object} BookMetadata
+// @Failure 400 {object} errorResponse
+// @Failure 404 {object} errorResponse
+// @Failure 409 {object} errorResponse
+// @Failure 502 {object} errorResponse
+// @Failure 503 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /books/{id}/enrich [post]
+func (api *MetadataAPI) EnrichBookHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseIDParam(w, r, "id")
+	if !ok {
+		return
+	}
+	bm, err := api.enricher.Enrich(r.Context(), id)
+	api.writeResult(w, r, bm, err)
+}
+
+// GetMetadataHandler godoc
+// @Summary Get a book's stored catalog metadata
+// @Tags metadata
+// @Produce json
+// @Param id path int true "Book ID"
+// @Success 200 {object} BookMetadata
+// @Failure 400 {object} errorResponse
+// @Failure 404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /books/{id}/metadata [get]
+func (api *MetadataAPI) GetMetadataHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseIDParam(w, r, "id")
+	if !ok {
+		return
+	}
+	bm, err := api.enricher.Get(r.Context(), id)
+	api.writeResult(w, r, bm, err)
+}
+
+func (api *MetadataAPI) writeResult(w http.ResponseWriter, r *http.Request, bm BookMetadata, err error) {
+	var (
+		open    *BreakerOpenError
+		provErr *ProviderError
+	)
+	switch {
+	case err == nil:
+		writeJSON(w, http.StatusOK, bm)
+	case err == ErrNotFound:
+		writeError(w, r, http.StatusNotFound, "book_not_found")
+	case err == ErrMetadataNotFound, err == ErrMetadataNotLoaded:
+		writeError(w, r, http.StatusNotFound, errorCode(err))
+	case err == ErrNoISBN:
+		writeError(w, r, http.StatusConflict, errorCode(err))
+	case errors.As(err, &open):
+		secs := int(time.Until(open.RetryAt).Seconds()) + 1
+		w.Header().Set("Retry-After", strconv.Itoa(secs))
+		writeError(w, r, http.StatusServiceUnavailable, errorCode(err))
+	case errors.As(err, &provErr):
+		loggerFrom(r.Context()).Warn("metadata provider", "err", err)
+		writeError(w, r, http.StatusBadGateway, "metadata_provider_failed")
+	default:
+		loggerFrom(r.Context()).Error("book metadata", "err", err)
+		writeError(w, r, http.StatusInternalServerError, "internal_error")
+	}
+}
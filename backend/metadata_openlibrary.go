@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const (
+	defaultOpenLibraryURL = "https://openlibrary.org"
+	defaultCoversURL      = "https://covers.openlibrary.org"
+)
+
+// OpenLibraryProvider looks editions up through the Open Library books API.
+// BaseURL and CoversURL can point at a local fixture server in tests.
+type OpenLibraryProvider struct {
+	BaseURL   string
+	CoversURL string
+	client    *http.Client
+}
+
+func NewOpenLibraryProvider(baseURL, coversURL string) *OpenLibraryProvider {
+	return &OpenLibraryProvider{
+		BaseURL:   strings.TrimRight(baseURL, "/"),
+		CoversURL: strings.TrimRight(coversURL, "/"),
+		client:    &http.Client{},
+	}
+}
+
+func (p *OpenLibraryProvider) Name() string { return "openlibrary" }
+
+// openLibraryEdition is the part of /isbn/{isbn}.json we use. Description is
+// either a string or {"type": ..., "value": ...}.
+type openLibraryEdition struct {
+	Publishers    []string        `json:"publishers"`
+	NumberOfPages int             `json:"number_of_pages"`
+	Subjects      []string        `json:"subjects"`
+	Description   json.RawMessage `json:"description"`
+	Covers        []int64         `json:"covers"`
+}
+
+func (p *OpenLibraryProvider) Lookup(ctx context.Context, isbn string) (Metadata, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.BaseURL+"/isbn/"+isbn+".json", nil)
+	if err != nil {
+		return Metadata{}, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return Metadata{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return Metadata{}, ErrMetadataNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return Metadata{}, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	var ed openLibraryEdition
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&ed); err != nil {
+		return Metadata{}, fmt.Errorf("decode edition: %w", err)
+	}
+
+	md := Metadata{PageCount: ed.NumberOfPages, Subjects: ed.Subjects}
+	if len(ed.Publishers) > 0 {
+		md.Publisher = ed.Publishers[0]
+	}
+	if len(ed.Description) > 0 {
+		var text string
+		if err := json.Unmarshal(ed.Description, &text); err != nil {
+			var typed struct {
+				Value string `json:"value"`
+			}
+			if err := json.Unmarshal(ed.Description, &typed); err == nil {
+				text = typed.Value
+			}
+		}
+		md.Description = text
+	}
+	for _, id := range ed.Covers {
+		// Open Library uses -1 for a removed cover.
+		if id > 0 {
+			md.CoverURL = fmt.Sprintf("%s/b/id/%d-L.jpg", p.CoversURL, id)
+			break
+		}
+	}
+	return md, nil
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// openLibraryFixture serves a few editions the way Open Library does.
+type openLibraryFixture struct {
+	*httptest.Server
+	hits    atomic.Int32
+	failing atomic.Bool
+	delay   atomic.Int64 // nanoseconds
+	// inFlight counts requests being served; peak is its highest value.
+	inFlight, peak atomic.Int32
+}
+
+func startOpenLibraryFixture(t *testing.T) *openLibraryFixture {
+	t.Helper()
+	f := &openLibraryFixture{}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.hits.Add(1)
+		n := f.inFlight.Add(1)
+		defer f.inFlight.Add(-1)
+		for p := f.peak.Load(); n > p && !f.peak.CompareAndSwap(p, n); p = f.peak.Load() {
+		}
+		if d := time.Duration(f.delay.Load()); d > 0 {
+			select {
+			case <-time.After(d):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		if f.failing.Load() {
+			http.Error(w, "upstream down", http.StatusInternalServerError)
+			return
+		}
+		switch r.URL.Path {
+		case "/isbn/9780441172719.json":
+			fmt.Fprint(w, `{
+				"title": "Dune",
+				"publishers": ["Ace Books", "Chilton"],
+				"number_of_pages": 535,
+				"subjects": ["Science fiction", "Arrakis"],
+				"description": {"type": "/type/text", "value": "A desert planet."},
+				"covers": [-1, 8231851]
+			}`)
+		case "/isbn/9780593099322.json":
+			fmt.Fprint(w, `{"publishers": ["Ace"], "description": "Plain string description."}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func setupMetadata(t *testing.T, fixture *openLibraryFixture) (*chi.Mux, *Enricher, func()) {
+	t.Helper()
+
+	_, db := setupTestRouter(t)
+	store := NewBookStore(db)
+	books := NewBooksAPI(store)
+	enricher := NewEnricher(db, NewOpenLibraryProvider(fixture.URL, "https://covers.example"))
+	store.OnCommit(enricher.OnBookEvent)
+	api := NewMetadataAPI(enricher)
+
+	r := chi.NewRouter()
+	r.Route("/books", func(r chi.Router) {
+		r.Post("/", books.CreateBookHandler)
+		r.Get("/{id}", books.GetBookHandler)
+		r.Put("/{id}", books.UpdateBookHandler)
+		r.Post("/{id}/enrich", api.EnrichBookHandler)
+		r.Get("/{id}/metadata", api.GetMetadataHandler)
+	})
+	return r, enricher, func() {
+		enricher.Wait()
+		db.Close()
+	}
+}
+
+func TestMetadata_EnrichedOnCreateAndOnDemand(t *testing.T) {
+	fixture := startOpenLibraryFixture(t)
+	r, enricher, cleanup := setupMetadata(t, fixture)
+	defer cleanup()
+
+	rr := doJSON(t, r, http.MethodPost, "/books", `{"title":"Dune","author":"Frank Herbert","year":1965,"isbn":"978-0-441-17271-9"}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("create status %d body=%s", rr.Code, rr.Body.String())
+	}
+	book := decodeJSON[Book](t, rr)
+	if book.ISBN != "9780441172719" {
+		t.Fatalf("isbn not normalized: %q", book.ISBN)
+	}
+	enricher.Wait()
+
+	path := fmt.Sprintf("/books/%d", book.ID)
+	md := decodeJSON[BookMetadata](t, doJSON(t, r, http.MethodGet, path+"/metadata", ``))
+	want := BookMetadata{
+		BookID: book.ID, Source: "openlibrary", ISBN: "9780441172719", Publisher: "Ace Books", PageCount: 535,
+		Subjects: []string{"Science fiction", "Arrakis"}, Description: "A desert planet.",
+		CoverURL: "https://covers.example/b/id/8231851-L.jpg",
+	}
+	md.FetchedAt = time.Time{}
+	if fmt.Sprint(md) != fmt.Sprint(want) {
+		t.Fatalf("metadata got %+v want %+v", md, want)
+	}
+
+	// The user-entered fields are untouched.
+	if got := decodeJSON[Book](t, doJSON(t, r, http.MethodGet, path, ``)); got.Title != "Dune" || got.Author != "Frank Herbert" {
+		t.Fatalf("book changed: %+v", got)
+	}
+
+	// Enriching again is answered from the cache.
+	if rr := doJSON(t, r, http.MethodPost, path+"/enrich", ``); rr.Code != http.StatusOK {
+		t.Fatalf("enrich status %d", rr.Code)
+	}
+	if n := fixture.hits.Load(); n != 1 {
+		t.Fatalf("provider called %d times", n)
+	}
+
+	// A new ISBN is looked up again in the background.
+	doJSON(t, r, http.MethodPut, path, `{"title":"Dune","author":"Frank Herbert","year":1965,"isbn":"0-593-09932-X"}`)
+	enricher.Wait()
+	md = decodeJSON[BookMetadata](t, doJSON(t, r, http.MethodGet, path+"/metadata", ``))
+	if md.ISBN != "9780593099322" || md.Description != "Plain string description." || md.PageCount != 0 || len(md.Subjects) != 0 {
+		t.Fatalf("metadata after isbn change: %+v", md)
+	}
+}
+
+func TestMetadata_Errors(t *testing.T) {
+	fixture := startOpenLibraryFixture(t)
+	r, _, cleanup := setupMetadata(t, fixture)
+	defer cleanup()
+
+	plain := decodeJSON[Book](t, doJSON(t, r, http.MethodPost, "/books", `{"title":"Emma","author":"Jane Austen","year":1815}`))
+	unknown := decodeJSON[Book](t, doJSON(t, r, http.MethodPost, "/books", `{"title":"Lost","author":"Nobody","year":2000,"isbn":"9780000000002"}`))
+
+	cases := []struct {
+		name   string
+		method string
+		path   string
+		status int
+	}{
+		{"no isbn", http.MethodPost, fmt.Sprintf("/books/%d/enrich", plain.ID), http.StatusConflict},
+		{"not in catalog", http.MethodPost, fmt.Sprintf("/books/%d/enrich", unknown.ID), http.StatusNotFound},
+		{"not enriched", http.MethodGet, fmt.Sprintf("/books/%d/metadata", plain.ID), http.StatusNotFound},
+		{"unknown book", http.MethodPost, "/books/9999/enrich", http.StatusNotFound},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if rr := doJSON(t, r, tc.method, tc.path, ``); rr.Code != tc.status {
+				t.Fatalf("status got %d want %d body=%s", rr.Code, tc.status, rr.Body.String())
+			}
+		})
+	}
+
+	if rr := doJSON(t, r, http.MethodPost, "/books", `{"title":"Dune","author":"Frank Herbert","year":1965,"isbn":"12345"}`); rr.Code != http.StatusBadRequest {
+		t.Fatalf("malformed isbn status %d", rr.Code)
+	}
+}
+
+func TestMetadata_TimeoutAndCircuitBreaker(t *testing.T) {
+	fixture := startOpenLibraryFixture(t)
+	r, enricher, cleanup := setupMetadata(t, fixture)
+	defer cleanup()
+
+	now := time.Now().UTC()
+	enricher.now = func() time.Time { return now }
+	enricher.Timeout = 50 * time.Millisecond
+
+	fixture.failing.Store(true)
+	book := decodeJSON[Book](t, doJSON(t, r, http.MethodPost, "/books", `{"title":"Dune","author":"Frank Herbert","year":1965,"isbn":"9780441172719"}`))
+	enricher.Wait()
+	path := fmt.Sprintf("/books/%d/enrich", book.ID)
+
+	// The background attempt counted as the first failure.
+	for i := 1; i < enricher.breaker.threshold; i++ {
+		if rr := doJSON(t, r, http.MethodPost, path, ``); rr.Code != http.StatusBadGateway {
+			t.Fatalf("attempt %d status %d", i, rr.Code)
+		}
+	}
+	hits := fixture.hits.Load()
+	rr := doJSON(t, r, http.MethodPost, path, ``)
+	if rr.Code != http.StatusServiceUnavailable || rr.Header().Get("Retry-After") == "" {
+		t.Fatalf("open breaker status %d headers %v", rr.Code, rr.Header())
+	}
+	if fixture.hits.Load() != hits {
+		t.Fatal("open breaker still called the provider")
+	}
+
+	// After the cooldown one probe goes through; a slow answer is a timeout.
+	fixture.failing.Store(false)
+	fixture.delay.Store(int64(500 * time.Millisecond))
+	now = now.Add(enricher.breaker.cooldown + time.Second)
+	start := time.Now()
+	if rr := doJSON(t, r, http.MethodPost, path, ``); rr.Code != http.StatusBadGateway || !strings.Contains(rr.Body.String(), "provider") {
+		t.Fatalf("slow probe status %d", rr.Code)
+	}
+	if time.Since(start) > 400*time.Millisecond {
+		t.Fatal("timeout was not applied")
+	}
+
+	fixture.delay.Store(0)
+	now = now.Add(enricher.breaker.cooldown + time.Second)
+	if rr := doJSON(t, r, http.MethodPost, path, ``); rr.Code != http.StatusOK {
+		t.Fatalf("recovered status %d body=%s", rr.Code, rr.Body.String())
+	}
+	if rr := doJSON(t, r, http.MethodPost, fmt.Sprintf("/books/%d/enrich", book.ID), ``); rr.Code != http.StatusOK {
+		t.Fatalf("closed breaker status %d", rr.Code)
+	}
+}
+
+func TestMetadata_CanceledCallsDoNotTripBreaker(t *testing.T) {
+	fixture := startOpenLibraryFixture(t)
+	_, enricher, cleanup := setupMetadata(t, fixture)
+	defer cleanup()
+
+	fixture.delay.Store(int64(time.Second))
+	for i := 0; i < enricher.breaker.threshold; i++ {
+		ctx, cancel := context.WithTimeout(t.Context(), 10*time.Millisecond)
+		_, err := enricher.lookup(ctx, "9780441172719")
+		cancel()
+		if err == nil {
+			t.Fatalf("call %d: expected an error", i)
+		}
+	}
+
+	fixture.delay.Store(0)
+	if _, err := enricher.lookup(t.Context(), "9780441172719"); err != nil {
+		t.Fatalf("breaker opened by abandoned calls: %v", err)
+	}
+}
+
+func TestMetadata_BackgroundEnrichmentIsBounded(t *testing.T) {
+	fixture := startOpenLibraryFixture(t)
+	r, enricher, cleanup := setupMetadata(t, fixture)
+	defer cleanup()
+	enricher.Workers, enricher.Queue = 2, 3
+
+	fixture.delay.Store(int64(50 * time.Millisecond))
+	enricher.cache.ttl = 0 // every event reaches the provider
+	book := decodeJSON[Book](t, doJSON(t, r, http.MethodPost, "/books", `{"title":"Dune","author":"Frank Herbert","year":1965,"isbn":"9780441172719"}`))
+	for i := 0; i < 20; i++ {
+		enricher.OnBookEvent(BookEvent{Type: EventBookCreated, Book: book})
+	}
+	enricher.Wait()
+
+	if peak := fixture.peak.Load(); peak > 2 {
+		t.Fatalf("%d lookups ran at once, want at most 2", peak)
+	}
+	// two running and three queued; the rest were skipped
+	if hits := fixture.hits.Load(); hits > 5 {
+		t.Fatalf("%d lookups, want at most 5", hits)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+type NotificationsAPI struct {
+	store *NotificationStore
+}
+
+func NewNotificationsAPI(store *NotificationStore) *NotificationsAPI {
+	return &NotificationsAPI{store: store}
+}
+
+// notificationPrefsInput changes notification settings. Omitted fields keep
+// their current value.
+type notificationPrefsInput struct {
+	Email     *string   `json:"email" example:"alice@example.com"`
+	DueSoon   *bool     `json:"due_soon"`
+	Overdue   *bool     `json:"overdue"`
+	HoldReady *bool     `json:"hold_ready"`
+	NewBooks  *bool     `json:"new_books"`
+	Authors   *[]string `json:"authors" example:"Frank Herbert,Ursula K. Le Guin"`
+}
+
+// GetPreferencesHandler godoc
+// @Summary Get your notification settings
+// @Tags notifications
+// @Produce json
+// @Param X-User header string true "User"
+// @Success 200 {object} NotificationPrefs
+// @Failure 401 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /notifications/preferences [get]
+func (api *NotificationsAPI) GetPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+	p, err := api.store.Prefs(r.Context(), user)
+	api.writeResult(w, r, http.StatusOK, p, err)
+}
+
+// UpdatePreferencesHandler godoc
+// @Summary Change your notification settings
+// @Description Mail goes to `email`; nothing is sent without one. `due_soon`, `overdue`, `hold_ready` and `new_books` turn each kind on or off, and `authors` replaces the list of followed authors whose new books are announced.
+// @Tags notifications
+// @Accept json
+// @Produce json
+// @Param X-User header string true "User"
+// @Param preferences body notificationPrefsInput true "Settings to change"
+// @Success 200 {object} NotificationPrefs
+// @Failure 400 {object} errorResponse
+// @Failure 401 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /notifications/preferences [put]
+func (api *NotificationsAPI) UpdatePreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	var in notificationPrefsInput
+	if !readJSON(w, r, &in) {
+		return
+	}
+
+	p, err := api.store.Prefs(r.Context(), user)
+	if err != nil {
+		api.writeResult(w, r, 0, nil, err)
+		return
+	}
+	if in.Email != nil {
+		p.Email = *in.Email
+	}
+	if in.DueSoon != nil {
+		p.DueSoon = *in.DueSoon
+	}
+	if in.Overdue != nil {
+		p.Overdue = *in.Overdue
+	}
+	if in.HoldReady != nil {
+		p.HoldReady = *in.HoldReady
+	}
+	if in.NewBooks != nil {
+		p.NewBooks = *in.NewBooks
+	}
+	if in.Authors != nil {
+		p.Authors = *in.Authors
+	}
+
+	p, err = api.store.SavePrefs(r.Context(), p)
+	api.writeResult(w, r, http.StatusOK, p, err)
+}
+
+// ListNotificationsHandler godoc
+// @Summary List your notifications, newest first
+// @Tags notifications
+// @Produce json
+// @Param X-User header string true "User"
+// @Param limit query int false "At most this many (default 50, max 500)"
+// @Success 200 {array} Notification
+// @Failure 400 {object} errorResponse
+// @Failure 401 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /notifications [get]
+func (api *NotificationsAPI) ListNotificationsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+	limit := 50
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		v, err := strconv.Atoi(raw)
+		if err != nil || v < 1 || v > 500 {
+			writeError(w, r, http.StatusBadRequest, "limit_invalid")
+			return
+		}
+		limit = v
+	}
+
+	list, err := api.store.List(r.Context(), user, limit)
+	api.writeResult(w, r, http.StatusOK, list, err)
+}
+
+func (api *NotificationsAPI) writeResult(w http.ResponseWriter, r *http.Request, status int, v any, err error) {
+	var verr *ValidationError
+	switch {
+	case err == nil:
+		writeJSON(w, status, v)
+	case errors.As(err, &verr):
+		writeError(w, r, http.StatusBadRequest, verr.Code)
+	default:
+		loggerFrom(r.Context()).Error("notifications", "err", err)
+		writeError(w, r, http.StatusInternalServerError, "internal_error")
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Notifier queues notifications for due and overdue loans, ready holds and
+// new books by followed authors, and sends them through Transport. A send
+// that fails is retried on later runs until MaxAttempts is reached.
+type Notifier struct {
+	store     *NotificationStore
+	transport Transport
+	now       func() time.Time
+
+	DueSoon      time.Duration
+	MaxAttempts  int
+	PollInterval time.Duration
+	BatchSize    int
+}
+
+func NewNotifier(store *NotificationStore, transport Transport) *Notifier {
+	return &Notifier{
+		store:        store,
+		transport:    transport,
+		now:          func() time.Time { return time.Now().UTC() },
+		DueSoon:      2 * 24 * time.Hour,
+		MaxAttempts:  5,
+		PollInterval: time.Minute,
+		BatchSize:    50,
+	}
+}
+
+// Run processes notifications every PollInterval until ctx is cancelled.
+func (n *Notifier) Run(ctx context.Context) {
+	ticker := time.NewTicker(n.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := n.ProcessOnce(ctx); err != nil && ctx.Err() == nil {
+			slog.Error("notifications", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ProcessOnce queues whatever became due and sends one batch.
+func (n *Notifier) ProcessOnce(ctx context.Context) error {
+	if _, err := n.store.enqueue(ctx, n.now(), n.DueSoon); err != nil {
+		return fmt.Errorf("enqueue: %w", err)
+	}
+
+	pending, err := n.store.pending(ctx, n.BatchSize)
+	if err != nil {
+		return fmt.Errorf("pending notifications: %w", err)
+	}
+
+	for _, pn := range pending {
+		attempts := pn.Attempts + 1
+		sendErr := n.transport.Send(ctx, Message{To: pn.Email, Subject: pn.Subject, Text: pn.Text, HTML: pn.HTML})
+		if sendErr == nil {
+			if err := n.store.markSent(ctx, pn.ID, attempts, n.now()); err != nil {
+				return err
+			}
+			continue
+		}
+
+		dead := attempts >= n.MaxAttempts
+		if err := n.store.markFailed(ctx, pn.ID, attempts, dead, sendErr.Error()); err != nil {
+			return err
+		}
+		slog.Warn("notification failed",
+			"notification_id", pn.ID, "kind", pn.Kind, "attempts", attempts, "dead", dead, "err", sendErr)
+	}
+	return nil
+}
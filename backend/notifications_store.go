@@ -0,0 +1,379 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/mail"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	notificationPending = "pending"
+	notificationSent    = "sent"
+	notificationFailed  = "failed"
+
+	maxFollowedAuthors = 100
+)
+
+// NotificationPrefs are a user's notification settings. Nothing is sent
+// until Email is set; every kind is on by default.
+type NotificationPrefs struct {
+	User      string     `json:"user"`
+	Email     string     `json:"email"`
+	DueSoon   bool       `json:"due_soon"`
+	Overdue   bool       `json:"overdue"`
+	HoldReady bool       `json:"hold_ready"`
+	NewBooks  bool       `json:"new_books"`
+	Authors   []string   `json:"authors"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+}
+
+// Notification is a queued or sent message.
+type Notification struct {
+	ID        int64      `json:"id"`
+	User      string     `json:"user"`
+	Kind      string     `json:"kind"`
+	Email     string     `json:"email"`
+	Subject   string     `json:"subject"`
+	Text      string     `json:"text"`
+	HTML      string     `json:"html"`
+	Status    string     `json:"status"`
+	Attempts  int        `json:"attempts"`
+	LastError string     `json:"last_error,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	SentAt    *time.Time `json:"sent_at,omitempty"`
+}
+
+func defaultNotificationPrefs(user string) NotificationPrefs {
+	return NotificationPrefs{User: user, DueSoon: true, Overdue: true, HoldReady: true, NewBooks: true, Authors: []string{}}
+}
+
+// validatePrefs checks the email address and tidies the author list.
+func validatePrefs(p *NotificationPrefs) error {
+	p.Email = strings.TrimSpace(p.Email)
+	if p.Email != "" {
+		addr, err := mail.ParseAddress(p.Email)
+		if err != nil || addr.Address != p.Email {
+			return &ValidationError{Field: "email", Code: "email_invalid", Message: "email must be a plain address like name@example.com"}
+		}
+	}
+
+	seen := map[string]bool{}
+	authors := []string{}
+	for _, a := range p.Authors {
+		a = strings.TrimSpace(a)
+		if a == "" || utf8.RuneCountInString(a) > 200 {
+			return &ValidationError{Field: "authors", Code: "authors_invalid", Message: "authors must be non-empty names of at most 200 characters"}
+		}
+		if key := strings.ToLower(a); !seen[key] {
+			seen[key] = true
+			authors = append(authors, a)
+		}
+	}
+	if len(authors) > maxFollowedAuthors {
+		return &ValidationError{Field: "authors", Code: "authors_too_many", Message: "at most 100 authors can be followed"}
+	}
+	sort.Strings(authors)
+	p.Authors = authors
+	return nil
+}
+
+const notificationColumns = `id, user, kind, email, subject, body_text, body_html, status, attempts, last_error, created_at, sent_at`
+
+func scanNotification(row rowScanner) (Notification, error) {
+	var n Notification
+	err := row.Scan(&n.ID, &n.User, &n.Kind, &n.Email, &n.Subject, &n.Text, &n.HTML,
+		&n.Status, &n.Attempts, &n.LastError, &n.CreatedAt, &n.SentAt)
+	return n, err
+}
+
+type NotificationStore struct {
+	db *sql.DB
+}
+
+func NewNotificationStore(db *sql.DB) *NotificationStore {
+	return &NotificationStore{db: db}
+}
+
+// Prefs returns the user's settings, or the defaults if they never saved any.
+func (s *NotificationStore) Prefs(ctx context.Context, user string) (NotificationPrefs, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	return getPrefs(ctx, s.db, user)
+}
+
+// querier is satisfied by both *sql.DB and *sql.Tx.
+type querier interface {
+	queryRower
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+func getPrefs(ctx context.Context, q querier, user string) (NotificationPrefs, error) {
+	p := defaultNotificationPrefs(user)
+	var updatedAt time.Time
+	err := q.QueryRowContext(ctx,
+		`SELECT email, due_soon, overdue, hold_ready, new_books, updated_at FROM notification_prefs WHERE user = ?`, user,
+	).Scan(&p.Email, &p.DueSoon, &p.Overdue, &p.HoldReady, &p.NewBooks, &updatedAt)
+	if err == sql.ErrNoRows {
+		return p, nil
+	}
+	if err != nil {
+		return NotificationPrefs{}, err
+	}
+	p.UpdatedAt = &updatedAt
+
+	rows, err := q.QueryContext(ctx, `SELECT author FROM author_follows WHERE user = ? ORDER BY author`, user)
+	if err != nil {
+		return NotificationPrefs{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var a string
+		if err := rows.Scan(&a); err != nil {
+			return NotificationPrefs{}, err
+		}
+		p.Authors = append(p.Authors, a)
+	}
+	return p, rows.Err()
+}
+
+// SavePrefs replaces the user's settings and followed authors.
+func (s *NotificationStore) SavePrefs(ctx context.Context, p NotificationPrefs) (NotificationPrefs, error) {
+	if err := validatePrefs(&p); err != nil {
+		return NotificationPrefs{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return NotificationPrefs{}, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO notification_prefs(user, email, due_soon, overdue, hold_ready, new_books, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(user) DO UPDATE SET email = excluded.email, due_soon = excluded.due_soon,
+			overdue = excluded.overdue, hold_ready = excluded.hold_ready,
+			new_books = excluded.new_books, updated_at = excluded.updated_at`,
+		p.User, p.Email, p.DueSoon, p.Overdue, p.HoldReady, p.NewBooks, time.Now().UTC(),
+	); err != nil {
+		return NotificationPrefs{}, err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM author_follows WHERE user = ?`, p.User); err != nil {
+		return NotificationPrefs{}, err
+	}
+	for _, a := range p.Authors {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO author_follows(user, author) VALUES (?, ?)`, p.User, a); err != nil {
+			return NotificationPrefs{}, err
+		}
+	}
+
+	saved, err := getPrefs(ctx, tx, p.User)
+	if err != nil {
+		return NotificationPrefs{}, err
+	}
+	return saved, tx.Commit()
+}
+
+// List returns the user's notifications, newest first.
+func (s *NotificationStore) List(ctx context.Context, user string, limit int) ([]Notification, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+notificationColumns+` FROM notifications WHERE user = ? ORDER BY id DESC LIMIT ?`, user, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []Notification{}
+	for rows.Next() {
+		n, err := scanNotification(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, n)
+	}
+	return out, rows.Err()
+}
+
+// candidateQuery selects everything of one kind that should be announced and
+// has not been yet, as (dedupe key, user, email, title, author, year, time).
+// The dedupe key makes each message go out once however often the scheduler
+// runs. New books are only announced to followers who can see their
+// workspace.
+type candidateQuery struct {
+	kind  string
+	query string
+	args  []any
+}
+
+func candidateQueries(now, dueBefore time.Time, afterEvent, lastEvent int64) []candidateQuery {
+	return []candidateQuery{
+		{NotifyOverdue, `
+			SELECT 'overdue:' || l.id, l.user, p.email, b.title, b.author, b.year, l.due_at
+			FROM loans l
+			JOIN notification_prefs p ON p.user = l.user AND p.email <> '' AND p.overdue
+			JOIN books b ON b.id = l.book_id
+			WHERE l.returned_at IS NULL AND l.due_at < ?
+				AND NOT EXISTS (SELECT 1 FROM notifications n WHERE n.dedupe_key = 'overdue:' || l.id)`,
+			[]any{now}},
+		{NotifyDueSoon, `
+			SELECT 'due_soon:' || l.id, l.user, p.email, b.title, b.author, b.year, l.due_at
+			FROM loans l
+			JOIN notification_prefs p ON p.user = l.user AND p.email <> '' AND p.due_soon
+			JOIN books b ON b.id = l.book_id
+			WHERE l.returned_at IS NULL AND l.due_at >= ? AND l.due_at < ?
+				AND NOT EXISTS (SELECT 1 FROM notifications n WHERE n.dedupe_key = 'due_soon:' || l.id)`,
+			[]any{now, dueBefore}},
+		{NotifyHoldReady, `
+			SELECT 'hold_ready:' || h.id, h.user, p.email, b.title, b.author, b.year, h.expires_at
+			FROM holds h
+			JOIN notification_prefs p ON p.user = h.user AND p.email <> '' AND p.hold_ready
+			JOIN books b ON b.id = h.book_id
+			WHERE h.status = 'ready'
+				AND NOT EXISTS (SELECT 1 FROM notifications n WHERE n.dedupe_key = 'hold_ready:' || h.id)`,
+			nil},
+		{NotifyNewBook, `
+			SELECT 'new_book:' || b.id || ':' || f.user, f.user, p.email, b.title, b.author, b.year, b.created_at
+			FROM outbox_events e
+			JOIN books b ON b.id = e.book_id
+			JOIN author_follows f ON f.author = b.author
+			JOIN notification_prefs p ON p.user = f.user AND p.email <> '' AND p.new_books
+			WHERE e.event_type = 'book.created' AND e.id > ? AND e.id <= ?
+				AND (b.workspace_id = 1 OR EXISTS (SELECT 1 FROM workspace_members m WHERE m.workspace_id = b.workspace_id AND m.user = f.user))
+				AND NOT EXISTS (SELECT 1 FROM notifications n WHERE n.dedupe_key = 'new_book:' || b.id || ':' || f.user)`,
+			[]any{afterEvent, lastEvent}},
+	}
+}
+
+// enqueue renders and queues every notification that is due at now. Loans
+// due before now+dueSoon get a reminder. It returns how many were queued.
+func (s *NotificationStore) enqueue(ctx context.Context, now time.Time, dueSoon time.Duration) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// New books are read from the outbox up to a cursor, so each creation is
+	// looked at once even for authors followed later.
+	var cursor, lastEvent int64
+	if err := tx.QueryRowContext(ctx,
+		`SELECT c.event_id, COALESCE((SELECT MAX(id) FROM outbox_events), 0) FROM notification_cursor c WHERE c.id = 1`,
+	).Scan(&cursor, &lastEvent); err != nil {
+		return 0, err
+	}
+
+	queued := 0
+	for _, cq := range candidateQueries(now, now.Add(dueSoon), cursor, lastEvent) {
+		rows, err := tx.QueryContext(ctx, cq.query, cq.args...)
+		if err != nil {
+			return 0, err
+		}
+
+		type candidate struct {
+			key, email string
+			data       notificationData
+		}
+		var found []candidate
+		for rows.Next() {
+			var (
+				c  candidate
+				at time.Time
+			)
+			if err := rows.Scan(&c.key, &c.data.User, &c.email, &c.data.Title, &c.data.Author, &c.data.Year, &at); err != nil {
+				rows.Close()
+				return 0, err
+			}
+			c.data.DueAt, c.data.ExpiresAt = at, at
+			found = append(found, c)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return 0, err
+		}
+
+		for _, c := range found {
+			msg, err := renderNotification(cq.kind, c.data)
+			if err != nil {
+				return 0, err
+			}
+			res, err := tx.ExecContext(ctx, `
+				INSERT INTO notifications(user, kind, dedupe_key, email, subject, body_text, body_html, created_at)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+				ON CONFLICT(dedupe_key) DO NOTHING`,
+				c.data.User, cq.kind, c.key, c.email, msg.Subject, msg.Text, msg.HTML, now,
+			)
+			if err != nil {
+				return 0, err
+			}
+			n, _ := res.RowsAffected()
+			queued += int(n)
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE notification_cursor SET event_id = ? WHERE id = 1`, lastEvent); err != nil {
+		return 0, err
+	}
+	return queued, tx.Commit()
+}
+
+// pending returns up to limit queued notifications, oldest first.
+func (s *NotificationStore) pending(ctx context.Context, limit int) ([]Notification, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+notificationColumns+` FROM notifications WHERE status = 'pending' ORDER BY id LIMIT ?`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []Notification
+	for rows.Next() {
+		n, err := scanNotification(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, n)
+	}
+	return out, rows.Err()
+}
+
+func (s *NotificationStore) markSent(ctx context.Context, id int64, attempts int, now time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx,
+		`UPDATE notifications SET status = ?, attempts = ?, last_error = '', sent_at = ? WHERE id = ?`,
+		notificationSent, attempts, now, id)
+	return err
+}
+
+// markFailed records a failed attempt. The notification stays pending unless
+// dead is set.
+func (s *NotificationStore) markFailed(ctx context.Context, id int64, attempts int, dead bool, lastErr string) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	status := notificationPending
+	if dead {
+		status = notificationFailed
+	}
+	_, err := s.db.ExecContext(ctx,
+		`UPDATE notifications SET status = ?, attempts = ?, last_error = ? WHERE id = ?`,
+		status, attempts, lastErr, id)
+	return err
+}
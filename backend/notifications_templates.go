@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"text/template"
+	"time"
+)
+
+const (
+	NotifyDueSoon   = "due_soon"
+	NotifyOverdue   = "overdue"
+	NotifyHoldReady = "hold_ready"
+	NotifyNewBook   = "new_book"
+)
+
+// notificationData is what the templates can refer to.
+type notificationData struct {
+	User      string
+	Title     string
+	Author    string
+	Year      int
+	DueAt     time.Time
+	ExpiresAt time.Time
+}
+
+// notificationTemplate holds the subject, plain text and HTML bodies of one
+// kind of notification. The HTML body is escaped by html/template.
+type notificationTemplate struct {
+	subject *template.Template
+	text    *template.Template
+	html    *htmltemplate.Template
+}
+
+var templateFuncs = map[string]any{
+	"date": func(t time.Time) string { return t.Format("Mon, 2 Jan 2006") },
+}
+
+func newNotificationTemplate(kind, subject, text, html string) notificationTemplate {
+	return notificationTemplate{
+		subject: template.Must(template.New(kind + ".subject").Funcs(templateFuncs).Parse(subject)),
+		text:    template.Must(template.New(kind + ".txt").Funcs(templateFuncs).Parse(text)),
+		html:    htmltemplate.Must(htmltemplate.New(kind + ".html").Funcs(templateFuncs).Parse(html)),
+	}
+}
+
+var notificationTemplates = map[string]notificationTemplate{
+	NotifyDueSoon: newNotificationTemplate(NotifyDueSoon,
+		`"{{.Title}}" is due {{date .DueAt}}`,
+		`Hi {{.User}},
+
+"{{.Title}}" by {{.Author}} is due back on {{date .DueAt}}.
+Please return it on time so the next reader can have it.
+`,
+		`<p>Hi {{.User}},</p>
+<p><strong>{{.Title}}</strong> by {{.Author}} is due back on {{date .DueAt}}.</p>
+<p>Please return it on time so the next reader can have it.</p>
+`),
+	NotifyOverdue: newNotificationTemplate(NotifyOverdue,
+		`"{{.Title}}" is overdue`,
+		`Hi {{.User}},
+
+"{{.Title}}" by {{.Author}} was due back on {{date .DueAt}}.
+Please return it as soon as you can.
+`,
+		`<p>Hi {{.User}},</p>
+<p><strong>{{.Title}}</strong> by {{.Author}} was due back on {{date .DueAt}}.</p>
+<p>Please return it as soon as you can.</p>
+`),
+	NotifyHoldReady: newNotificationTemplate(NotifyHoldReady,
+		`"{{.Title}}" is ready for pickup`,
+		`Hi {{.User}},
+
+A copy of "{{.Title}}" by {{.Author}} is waiting for you.
+It is held until {{date .ExpiresAt}}; after that it goes to the next reader.
+`,
+		`<p>Hi {{.User}},</p>
+<p>A copy of <strong>{{.Title}}</strong> by {{.Author}} is waiting for you.</p>
+<p>It is held until {{date .ExpiresAt}}; after that it goes to the next reader.</p>
+`),
+	NotifyNewBook: newNotificationTemplate(NotifyNewBook,
+		`New from {{.Author}}: "{{.Title}}"`,
+		`Hi {{.User}},
+
+"{{.Title}}" by {{.Author}}{{if .Year}} ({{.Year}}){{end}} was just added to the library.
+`,
+		`<p>Hi {{.User}},</p>
+<p><strong>{{.Title}}</strong> by {{.Author}}{{if .Year}} ({{.Year}}){{end}} was just added to the library.</p>
+`),
+}
+
+// renderNotification fills in the templates for kind.
+func renderNotification(kind string, data notificationData) (Message, error) {
+	t, ok := notificationTemplates[kind]
+	if !ok {
+		return Message{}, fmt.Errorf("no template for notification %q", kind)
+	}
+
+	var subject, text, html bytes.Buffer
+	if err := t.subject.Execute(&subject, data); err != nil {
+		return Message{}, err
+	}
+	if err := t.text.Execute(&text, data); err != nil {
+		return Message{}, err
+	}
+	if err := t.html.Execute(&html, data); err != nil {
+		return Message{}, err
+	}
+	return Message{Subject: subject.String(), Text: text.String(), HTML: html.String()}, nil
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// recordingTransport keeps every message, or fails with err when set.
+type recordingTransport struct {
+	mu   sync.Mutex
+	sent []Message
+	err  error
+}
+
+func (t *recordingTransport) Send(ctx context.Context, m Message) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.err != nil {
+		return t.err
+	}
+	t.sent = append(t.sent, m)
+	return nil
+}
+
+func (t *recordingTransport) take() []Message {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := t.sent
+	t.sent = nil
+	return out
+}
+
+func setupNotifications(t *testing.T) (*chi.Mux, *Notifier, *recordingTransport, func()) {
+	t.Helper()
+
+	r, loans, cleanup := setupLoans(t)
+	store := NewNotificationStore(loans.db)
+	api := NewNotificationsAPI(store)
+	r.Route("/notifications", func(r chi.Router) {
+		r.Get("/", api.ListNotificationsHandler)
+		r.Get("/preferences", api.GetPreferencesHandler)
+		r.Put("/preferences", api.UpdatePreferencesHandler)
+	})
+
+	transport := &recordingTransport{}
+	return r, NewNotifier(store, transport), transport, cleanup
+}
+
+func subjects(msgs []Message) []string {
+	var out []string
+	for _, m := range msgs {
+		out = append(out, m.To+": "+m.Subject)
+	}
+	return out
+}
+
+func TestNotifications_SchedulerSendsEachMessageOnce(t *testing.T) {
+	r, notifier, transport, cleanup := setupNotifications(t)
+	defer cleanup()
+
+	now := time.Now().UTC()
+	notifier.now = func() time.Time { return now }
+
+	doAs(t, r, "alice", http.MethodPut, "/notifications/preferences", `{"email":"alice@example.com","authors":["frank herbert"]}`)
+	doAs(t, r, "carol", http.MethodPut, "/notifications/preferences", `{"email":"carol@example.com","new_books":false}`)
+
+	dune := decodeJSON[Book](t, doJSON(t, r, http.MethodPost, "/books", `{"title":"Dune","author":"Frank Herbert","year":1965}`))
+	dunePath := fmt.Sprintf("/books/%d", dune.ID)
+	addCopy(t, r, dune.ID, "LIB-1", "Tokyo")
+	addCopy(t, r, dune.ID, "LIB-2", "Tokyo")
+
+	// Bob never gave an email address, so his loan is not announced.
+	doAs(t, r, "alice", http.MethodPost, dunePath+"/checkout", `{"days":1}`)
+	doAs(t, r, "bob", http.MethodPost, dunePath+"/checkout", `{"days":1}`)
+	if err := notifier.ProcessOnce(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	got := subjects(transport.take())
+	want := []string{
+		`alice@example.com: "Dune" is due ` + now.AddDate(0, 0, 1).Format("Mon, 2 Jan 2006"),
+		`alice@example.com: New from Frank Herbert: "Dune"`,
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("first run got %q want %q", got, want)
+	}
+
+	doAs(t, r, "carol", http.MethodPost, dunePath+"/holds", ``)
+	doAs(t, r, "alice", http.MethodPost, dunePath+"/return", ``)
+	doJSON(t, r, http.MethodPost, "/books", `{"title":"Dune Messiah","author":"Frank Herbert","year":1969}`)
+	doJSON(t, r, http.MethodPost, "/books", `{"title":"Emma","author":"Jane Austen","year":1815}`)
+
+	if err := notifier.ProcessOnce(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	got = subjects(transport.take())
+	want = []string{
+		`carol@example.com: "Dune" is ready for pickup`,
+		`alice@example.com: New from Frank Herbert: "Dune Messiah"`,
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("second run got %q want %q", got, want)
+	}
+
+	// Nothing new happened, so nothing is sent again.
+	if err := notifier.ProcessOnce(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	if got := transport.take(); len(got) != 0 {
+		t.Fatalf("repeated run sent %q", subjects(got))
+	}
+
+	// Carol keeps the book too long.
+	doAs(t, r, "carol", http.MethodPost, dunePath+"/checkout", `{"days":1}`)
+	now = now.Add(48 * time.Hour)
+	if err := notifier.ProcessOnce(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	msgs := transport.take()
+	if got := subjects(msgs); len(got) != 1 || got[0] != `carol@example.com: "Dune" is overdue` {
+		t.Fatalf("overdue run got %q", got)
+	}
+	if !strings.Contains(msgs[0].Text, "Hi carol") || !strings.Contains(msgs[0].HTML, "<strong>Dune</strong>") {
+		t.Fatalf("overdue bodies: %+v", msgs[0])
+	}
+
+	list := decodeJSON[[]Notification](t, doAs(t, r, "alice", http.MethodGet, "/notifications", ``))
+	if len(list) != 3 || list[0].Kind != NotifyNewBook || list[0].Status != notificationSent || list[0].SentAt == nil {
+		t.Fatalf("alice's notifications: %+v", list)
+	}
+}
+
+func TestNotifications_FailedSendsAreRetriedThenGivenUp(t *testing.T) {
+	r, notifier, transport, cleanup := setupNotifications(t)
+	defer cleanup()
+
+	notifier.MaxAttempts = 2
+	transport.err = errors.New("connection refused")
+
+	doAs(t, r, "alice", http.MethodPut, "/notifications/preferences", `{"email":"alice@example.com"}`)
+	book := decodeJSON[Book](t, doJSON(t, r, http.MethodPost, "/books", `{"title":"Dune","author":"Frank Herbert","year":1965}`))
+	addCopy(t, r, book.ID, "LIB-1", "Tokyo")
+	doAs(t, r, "alice", http.MethodPost, fmt.Sprintf("/books/%d/checkout", book.ID), `{"days":1}`)
+
+	for i := 0; i < 3; i++ {
+		if err := notifier.ProcessOnce(t.Context()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	list := decodeJSON[[]Notification](t, doAs(t, r, "alice", http.MethodGet, "/notifications", ``))
+	if len(list) != 1 || list[0].Status != notificationFailed || list[0].Attempts != 2 || list[0].LastError != "connection refused" {
+		t.Fatalf("after retries: %+v", list)
+	}
+}
+
+func TestNotifications_Preferences(t *testing.T) {
+	r, _, _, cleanup := setupNotifications(t)
+	defer cleanup()
+
+	prefs := decodeJSON[NotificationPrefs](t, doAs(t, r, "alice", http.MethodGet, "/notifications/preferences", ``))
+	if prefs.Email != "" || !prefs.DueSoon || !prefs.NewBooks || len(prefs.Authors) != 0 || prefs.UpdatedAt != nil {
+		t.Fatalf("defaults: %+v", prefs)
+	}
+
+	rr := doAs(t, r, "alice", http.MethodPut, "/notifications/preferences",
+		`{"email":"alice@example.com","overdue":false,"authors":["Jane Austen"," Frank Herbert","jane austen"]}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("update status %d body=%s", rr.Code, rr.Body.String())
+	}
+	rr = doAs(t, r, "alice", http.MethodPut, "/notifications/preferences", `{"hold_ready":false}`)
+	prefs = decodeJSON[NotificationPrefs](t, rr)
+	if prefs.Email != "alice@example.com" || prefs.Overdue || prefs.HoldReady || !prefs.DueSoon ||
+		fmt.Sprint(prefs.Authors) != "[Frank Herbert Jane Austen]" {
+		t.Fatalf("partial update lost settings: %+v", prefs)
+	}
+
+	cases := []struct {
+		name   string
+		user   string
+		body   string
+		status int
+	}{
+		{"anonymous", "", `{}`, http.StatusUnauthorized},
+		{"bad email", "alice", `{"email":"not an address"}`, http.StatusBadRequest},
+		{"display name", "alice", `{"email":"Alice <alice@example.com>"}`, http.StatusBadRequest},
+		{"blank author", "alice", `{"authors":[" "]}`, http.StatusBadRequest},
+		{"bad json", "alice", `{`, http.StatusBadRequest},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if rr := doAs(t, r, tc.user, http.MethodPut, "/notifications/preferences", tc.body); rr.Code != tc.status {
+				t.Fatalf("status got %d want %d body=%s", rr.Code, tc.status, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestRenderNotification_EscapesHTML(t *testing.T) {
+	msg, err := renderNotification(NotifyNewBook, notificationData{User: "alice", Title: `<script>x</script>`, Author: "A & B", Year: 2001})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(msg.HTML, "&lt;script&gt;") || !strings.Contains(msg.HTML, "A &amp; B (2001)") {
+		t.Fatalf("html not escaped: %s", msg.HTML)
+	}
+	if !strings.Contains(msg.Text, `"<script>x</script>" by A & B (2001)`) {
+		t.Fatalf("text body: %s", msg.Text)
+	}
+}
+
+// smtpSink is a minimal SMTP server that accepts and keeps every message.
+type smtpSink struct {
+	ln    net.Listener
+	mu    sync.Mutex
+	mails []sinkMail
+}
+
+type sinkMail struct {
+	from string
+	to   []string
+	data string
+}
+
+func startSMTPSink(t *testing.T) *smtpSink {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpSink{ln: ln}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.handle(conn)
+		}
+	}()
+	t.Cleanup(func() { ln.Close() })
+	return s
+}
+
+func (s *smtpSink) handle(conn net.Conn) {
+	defer conn.Close()
+	tp := textproto.NewConn(conn)
+	var m sinkMail
+	tp.PrintfLine("220 sink ready")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			tp.PrintfLine("250 sink")
+		case strings.HasPrefix(cmd, "MAIL FROM:"):
+			m = sinkMail{from: strings.Trim(line[len("MAIL FROM:"):], "<> ")}
+			tp.PrintfLine("250 ok")
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			m.to = append(m.to, strings.Trim(line[len("RCPT TO:"):], "<> "))
+			tp.PrintfLine("250 ok")
+		case cmd == "DATA":
+			tp.PrintfLine("354 go ahead")
+			data, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			m.data = string(data)
+			s.mu.Lock()
+			s.mails = append(s.mails, m)
+			s.mu.Unlock()
+			tp.PrintfLine("250 queued")
+		case cmd == "QUIT":
+			tp.PrintfLine("221 bye")
+			return
+		default:
+			tp.PrintfLine("250 ok")
+		}
+	}
+}
+
+func TestSMTPTransport_DeliversToLocalSink(t *testing.T) {
+	sink := startSMTPSink(t)
+	transport := &SMTPTransport{Addr: sink.ln.Addr().String(), From: "Library <library@example.com>", Timeout: 5 * time.Second}
+
+	msg := Message{
+		To:      "alice@example.com",
+		Subject: `"砂の惑星" is ready for pickup`,
+		Text:    "A copy is waiting for you.\n",
+		HTML:    "<p>A copy is <strong>waiting</strong> for you.</p>\n",
+	}
+	if err := transport.Send(t.Context(), msg); err != nil {
+		t.Fatal(err)
+	}
+
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if len(sink.mails) != 1 {
+		t.Fatalf("sink got %d mails", len(sink.mails))
+	}
+	got := sink.mails[0]
+	if got.from != "library@example.com" || fmt.Sprint(got.to) != "[alice@example.com]" {
+		t.Fatalf("envelope: from=%q to=%q", got.from, got.to)
+	}
+
+	parsed, err := mail.ReadMessage(strings.NewReader(got.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	if err != nil || subject != msg.Subject {
+		t.Fatalf("subject %q err=%v", subject, err)
+	}
+	if parsed.Header.Get("To") != msg.To || parsed.Header.Get("From") != transport.From {
+		t.Fatalf("headers: %v", parsed.Header)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("content type %q err=%v", mediaType, err)
+	}
+	parts := map[string]string{}
+	mr := multipart.NewReader(parsed.Body, params["boundary"])
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(p)
+		ct, _, _ := mime.ParseMediaType(p.Header.Get("Content-Type"))
+		parts[ct] = strings.ReplaceAll(string(body), "\r\n", "\n")
+	}
+	if parts["text/plain"] != msg.Text || parts["text/html"] != msg.HTML {
+		t.Fatalf("parts: %q", parts)
+	}
+}
+
+func TestSMTPTransport_HeaderInjection(t *testing.T) {
+	data, err := buildMIME("library@example.com", Message{To: "alice@example.com", Subject: "Hi\r\nBcc: eve@example.com"}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := mail.ReadMessage(strings.NewReader(string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Header.Get("Bcc") != "" {
+		t.Fatalf("subject smuggled a header: %v", parsed.Header)
+	}
+}
+
+func TestFileTransport_WritesEmlFiles(t *testing.T) {
+	dir := t.TempDir()
+	transport := &FileTransport{Dir: dir, From: "library@example.com"}
+	for _, subject := range []string{"one", "two"} {
+		if err := transport.Send(t.Context(), Message{To: "alice@example.com", Subject: subject, Text: "hi", HTML: "<p>hi</p>"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil || len(files) != 2 {
+		t.Fatalf("files %v err=%v", files, err)
+	}
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed, err := mail.ReadMessage(strings.NewReader(string(data))); err != nil || parsed.Header.Get("To") != "alice@example.com" {
+		t.Fatalf("not a readable email: err=%v", err)
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Message is one rendered notification, ready to send.
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Transport delivers messages. Send must be safe to call from one goroutine
+// at a time; the scheduler never calls it concurrently.
+type Transport interface {
+	Send(ctx context.Context, m Message) error
+}
+
+// buildMIME encodes m as a multipart/alternative email with a plain text and
+// an HTML part.
+func buildMIME(from string, m Message, date time.Time) ([]byte, error) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain; charset=utf-8", m.Text},
+		{"text/html; charset=utf-8", m.HTML},
+	} {
+		pw, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(pw)
+		if _, err := qp.Write([]byte(part.content)); err != nil {
+			return nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	var msg bytes.Buffer
+	for _, h := range [][2]string{
+		{"From", from},
+		{"To", m.To},
+		{"Subject", mime.QEncoding.Encode("utf-8", m.Subject)},
+		{"Date", date.Format(time.RFC1123Z)},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", `multipart/alternative; boundary="` + mw.Boundary() + `"`},
+	} {
+		// Header values come from user data; a line break would start a new header.
+		v := strings.NewReplacer("\r", " ", "\n", " ").Replace(h[1])
+		fmt.Fprintf(&msg, "%s: %s\r\n", h[0], v)
+	}
+	msg.WriteString("\r\n")
+	msg.Write(body.Bytes())
+	return msg.Bytes(), nil
+}
+
+// SMTPTransport sends mail through an SMTP server. STARTTLS is used when the
+// server offers it, and PLAIN auth when Username is set.
+type SMTPTransport struct {
+	Addr     string
+	From     string
+	Username string
+	Password string
+	Timeout  time.Duration
+}
+
+func (t *SMTPTransport) Send(ctx context.Context, m Message) error {
+	host, _, err := net.SplitHostPort(t.Addr)
+	if err != nil {
+		return err
+	}
+	from, err := mail.ParseAddress(t.From)
+	if err != nil {
+		return fmt.Errorf("from address: %w", err)
+	}
+	data, err := buildMIME(t.From, m, time.Now())
+	if err != nil {
+		return err
+	}
+
+	timeout := t.Timeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", t.Addr)
+	if err != nil {
+		return err
+	}
+	deadline, _ := ctx.Deadline()
+	_ = conn.SetDeadline(deadline)
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return fmt.Errorf("starttls: %w", err)
+		}
+	}
+	if t.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", t.Username, t.Password, host)); err != nil {
+			return fmt.Errorf("auth: %w", err)
+		}
+	}
+	if err := c.Mail(from.Address); err != nil {
+		return err
+	}
+	if err := c.Rcpt(m.To); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// FileTransport writes every message to its own .eml file in Dir, for
+// development.
+type FileTransport struct {
+	Dir  string
+	From string
+}
+
+func (t *FileTransport) Send(ctx context.Context, m Message) error {
+	now := time.Now()
+	data, err := buildMIME(t.From, m, now)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(t.Dir, 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(t.Dir, now.UTC().Format("20060102T150405")+"-*.eml")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// LogTransport only logs messages. It is the default, so nothing is mailed
+// until a real transport is configured.
+type LogTransport struct {
+	Logger *slog.Logger
+}
+
+func (t *LogTransport) Send(ctx context.Context, m Message) error {
+	t.Logger.Info("notification", "to", m.To, "subject", m.Subject, "text", m.Text)
+	return nil
+}
+
+// newTransport builds the transport selected by NOTIFY_TRANSPORT: smtp, file
+// or log (the default).
+func newTransport(logger *slog.Logger) (Transport, error) {
+	from := getenv("NOTIFY_FROM", "library@localhost")
+	switch kind := getenv("NOTIFY_TRANSPORT", "log"); kind {
+	case "smtp":
+		return &SMTPTransport{
+			Addr:     getenv("SMTP_ADDR", "localhost:25"),
+			From:     from,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+		}, nil
+	case "file":
+		return &FileTransport{Dir: filepath.Clean(getenv("NOTIFY_DIR", "mail")), From: from}, nil
+	case "log":
+		return &LogTransport{Logger: logger}, nil
+	default:
+		return nil, fmt.Errorf("unknown NOTIFY_TRANSPORT %q (want smtp, file or log)", kind)
+	}
+}
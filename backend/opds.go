@@ -0,0 +1,414 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// OPDS 1.2 catalog (https://specs.opds.io/opds-1.2) for e-reader apps. The
+// feeds are Atom documents outside the versioned JSON API: a root
+// navigation feed, navigation feeds by author, tag and year, and paginated
+// acquisition feeds of books, searchable through OpenSearch. Tags are the
+// subjects of the books' catalog metadata.
+
+// Media types of OPDS documents.
+const (
+	opdsNavigation  = "application/atom+xml;profile=opds-catalog;kind=navigation"
+	opdsAcquisition = "application/atom+xml;profile=opds-catalog;kind=acquisition"
+	openSearchType  = "application/opensearchdescription+xml"
+
+	defaultOPDSPageSize = 50
+)
+
+// Namespaces used in the feeds.
+const (
+	atomNS       = "http://www.w3.org/2005/Atom"
+	dcNS         = "http://purl.org/dc/terms/"
+	opdsNS       = "http://opds-spec.org/2010/catalog"
+	openSearchNS = "http://a9.com/-/spec/opensearch/1.1/"
+)
+
+// Link relations of OPDS.
+const (
+	relBorrow    = "http://opds-spec.org/acquisition/borrow"
+	relImage     = "http://opds-spec.org/image"
+	relThumbnail = "http://opds-spec.org/image/thumbnail"
+)
+
+type atomFeed struct {
+	XMLName      xml.Name `xml:"feed"`
+	Xmlns        string   `xml:"xmlns,attr"`
+	XmlnsDC      string   `xml:"xmlns:dc,attr"`
+	XmlnsOPDS    string   `xml:"xmlns:opds,attr"`
+	XmlnsOS      string   `xml:"xmlns:opensearch,attr"`
+	ID           string   `xml:"id"`
+	Title        string   `xml:"title"`
+	Updated      string   `xml:"updated"`
+	Author       atomPerson
+	TotalResults *int        `xml:"opensearch:totalResults,omitempty"`
+	ItemsPerPage int         `xml:"opensearch:itemsPerPage,omitempty"`
+	Links        []atomLink  `xml:"link"`
+	Entries      []atomEntry `xml:"entry"`
+}
+
+type atomPerson struct {
+	XMLName xml.Name `xml:"author"`
+	Name    string   `xml:"name"`
+}
+
+type atomLink struct {
+	Rel   string `xml:"rel,attr,omitempty"`
+	Href  string `xml:"href,attr"`
+	Type  string `xml:"type,attr,omitempty"`
+	Title string `xml:"title,attr,omitempty"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Text string `xml:",chardata"`
+}
+
+type atomCategory struct {
+	Term  string `xml:"term,attr"`
+	Label string `xml:"label,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Title      string         `xml:"title"`
+	ID         string         `xml:"id"`
+	Updated    string         `xml:"updated"`
+	Authors    []atomPerson   `xml:"author"`
+	Issued     string         `xml:"dc:issued,omitempty"`
+	Identifier string         `xml:"dc:identifier,omitempty"`
+	Publisher  string         `xml:"dc:publisher,omitempty"`
+	Categories []atomCategory `xml:"category"`
+	Summary    *atomText      `xml:"summary"`
+	Content    *atomText      `xml:"content"`
+	Links      []atomLink     `xml:"link"`
+}
+
+type openSearchDescription struct {
+	XMLName        xml.Name      `xml:"OpenSearchDescription"`
+	Xmlns          string        `xml:"xmlns,attr"`
+	ShortName      string        `xml:"ShortName"`
+	Description    string        `xml:"Description"`
+	InputEncoding  string        `xml:"InputEncoding"`
+	OutputEncoding string        `xml:"OutputEncoding"`
+	URL            openSearchURL `xml:"Url"`
+}
+
+type openSearchURL struct {
+	Type     string `xml:"type,attr"`
+	Template string `xml:"template,attr"`
+}
+
+// Facets of the navigation feeds.
+const (
+	FacetAuthor = "author"
+	FacetTag    = "tag"
+	FacetYear   = "year"
+)
+
+// FacetCount is how many books share one value of a facet.
+type FacetCount struct {
+	Value string
+	Books int
+}
+
+var facetQueries = map[string]string{
+	// authors are grouped case-insensitively, as in the catalog statistics
+	FacetAuthor: `
+		SELECT MIN(trim(author)), COUNT(*) FROM books WHERE workspace_id = ?
+		GROUP BY lower(trim(author)) ORDER BY lower(trim(author))`,
+	FacetTag: `
+		SELECT MIN(j.value), COUNT(DISTINCT b.id)
+		FROM books b JOIN book_metadata m ON m.book_id = b.id, json_each(m.subjects) j
+		WHERE b.workspace_id = ?
+		GROUP BY lower(j.value) ORDER BY lower(j.value)`,
+	FacetYear: `
+		SELECT year, COUNT(*) FROM books WHERE workspace_id = ?
+		GROUP BY year ORDER BY year DESC`,
+}
+
+// Facets returns the values of facet in the workspace's books, with how
+// many books have each.
+func (s *BookStore) Facets(ctx context.Context, facet string) ([]FacetCount, error) {
+	query, ok := facetQueries[facet]
+	if !ok {
+		return nil, fmt.Errorf("unknown facet %q", facet)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var out []FacetCount
+	err := eachRow(ctx, s.db, query, []any{workspaceFromContext(ctx)}, func(rows *sql.Rows) error {
+		var fc FacetCount
+		if err := rows.Scan(&fc.Value, &fc.Books); err != nil {
+			return err
+		}
+		out = append(out, fc)
+		return nil
+	})
+	return out, err
+}
+
+type OPDSAPI struct {
+	store *BookStore
+
+	// PageSize is the number of books per acquisition feed page.
+	PageSize int
+}
+
+func NewOPDSAPI(store *BookStore) *OPDSAPI {
+	return &OPDSAPI{store: store, PageSize: defaultOPDSPageSize}
+}
+
+// newFeed starts a feed with the links every feed has.
+func (api *OPDSAPI) newFeed(r *http.Request, id, title, kind string, updated time.Time) atomFeed {
+	return atomFeed{
+		Xmlns:     atomNS,
+		XmlnsDC:   dcNS,
+		XmlnsOPDS: opdsNS,
+		XmlnsOS:   openSearchNS,
+		ID:        "urn:byfood:opds:" + id,
+		Title:     title,
+		Updated:   updated.UTC().Format(time.RFC3339),
+		Author:    atomPerson{Name: "byFood"},
+		Links: []atomLink{
+			{Rel: "self", Href: r.URL.RequestURI(), Type: kind},
+			{Rel: "start", Href: "/opds", Type: opdsNavigation, Title: "Catalog"},
+			{Rel: "search", Href: "/opds/opensearch.xml", Type: openSearchType},
+		},
+	}
+}
+
+// updated is when the books last changed, the time of every feed.
+func (api *OPDSAPI) updated(ctx context.Context) (time.Time, error) {
+	_, modified, err := api.store.ListVersion(ctx)
+	return modified, err
+}
+
+func writeXML(w http.ResponseWriter, status int, contentType string, v any) {
+	w.Header().Set("Content-Type", contentType+";charset=utf-8")
+	w.WriteHeader(status)
+	_, _ = io.WriteString(w, xml.Header)
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	_ = enc.Encode(v)
+}
+
+func navigationEntry(id, title, content, href, kind string, updated time.Time) atomEntry {
+	return atomEntry{
+		Title:   title,
+		ID:      "urn:byfood:opds:" + id,
+		Updated: updated.UTC().Format(time.RFC3339),
+		Content: &atomText{Type: "text", Text: content},
+		Links:   []atomLink{{Rel: "subsection", Href: href, Type: kind}},
+	}
+}
+
+// RootHandler serves the catalog's start feed.
+func (api *OPDSAPI) RootHandler(w http.ResponseWriter, r *http.Request) {
+	updated, err := api.updated(r.Context())
+	if err != nil {
+		loggerFrom(r.Context()).Error("opds root", "err", err)
+		writeError(w, r, http.StatusInternalServerError, "internal_error")
+		return
+	}
+
+	feed := api.newFeed(r, "root", "byFood Books", opdsNavigation, updated)
+	feed.Entries = []atomEntry{
+		navigationEntry("books", "All books", "Every book in the catalog", "/opds/books", opdsAcquisition, updated),
+		navigationEntry("authors", "By author", "Books grouped by author", "/opds/authors", opdsNavigation, updated),
+		navigationEntry("tags", "By tag", "Books grouped by subject", "/opds/tags", opdsNavigation, updated),
+		navigationEntry("years", "By year", "Books grouped by publication year", "/opds/years", opdsNavigation, updated),
+	}
+	writeXML(w, http.StatusOK, opdsNavigation, feed)
+}
+
+// FacetHandler serves the navigation feed of one facet: an entry per author,
+// tag or year, leading to its books.
+func (api *OPDSAPI) FacetHandler(facet, title string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		updated, err := api.updated(r.Context())
+		var counts []FacetCount
+		if err == nil {
+			counts, err = api.store.Facets(r.Context(), facet)
+		}
+		if err != nil {
+			loggerFrom(r.Context()).Error("opds facet", "facet", facet, "err", err)
+			writeError(w, r, http.StatusInternalServerError, "internal_error")
+			return
+		}
+
+		feed := api.newFeed(r, facet+"s", title, opdsNavigation, updated)
+		feed.Links = append(feed.Links, atomLink{Rel: "up", Href: "/opds", Type: opdsNavigation})
+		feed.Entries = []atomEntry{}
+		for _, c := range counts {
+			content := strconv.Itoa(c.Books) + " books"
+			if c.Books == 1 {
+				content = "1 book"
+			}
+			href := "/opds/books?" + url.Values{facet: {c.Value}}.Encode()
+			feed.Entries = append(feed.Entries,
+				navigationEntry(facet+":"+url.QueryEscape(c.Value), c.Value, content, href, opdsAcquisition, updated))
+		}
+		writeXML(w, http.StatusOK, opdsNavigation, feed)
+	}
+}
+
+// BooksHandler serves a page of books as an acquisition feed. author, tag
+// and year narrow it to one entry of a navigation feed, q searches titles
+// and authors; after is the id of the last book of the previous page.
+func (api *OPDSAPI) BooksHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f := BookFilter{AuthorExact: q.Get(FacetAuthor), Tag: q.Get(FacetTag), Text: q.Get("q")}
+	title, id, up := "All books", "books", "/opds"
+	switch {
+	case f.AuthorExact != "":
+		title, id, up = "Books by "+f.AuthorExact, "author:"+url.QueryEscape(f.AuthorExact), "/opds/authors"
+	case f.Tag != "":
+		title, id, up = "Books tagged "+f.Tag, "tag:"+url.QueryEscape(f.Tag), "/opds/tags"
+	case f.Text != "":
+		title, id = "Search results for "+f.Text, "search:"+url.QueryEscape(f.Text)
+	}
+	if raw := q.Get(FacetYear); raw != "" {
+		year, err := strconv.Atoi(raw)
+		if err != nil || year <= 0 {
+			writeError(w, r, http.StatusBadRequest, "year_invalid")
+			return
+		}
+		f.YearFrom, f.YearTo = year, year
+		if id == "books" {
+			title, id, up = "Books from "+raw, "year:"+raw, "/opds/years"
+		}
+	}
+	var after int64
+	if raw := q.Get("after"); raw != "" {
+		v, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || v <= 0 {
+			writeError(w, r, http.StatusBadRequest, "after_invalid")
+			return
+		}
+		after = v
+	}
+
+	page, total, expanded, updated, err := api.page(r.Context(), f, after)
+	if err != nil {
+		loggerFrom(r.Context()).Error("opds books", "err", err)
+		writeError(w, r, http.StatusInternalServerError, "internal_error")
+		return
+	}
+
+	feed := api.newFeed(r, id, title, opdsAcquisition, updated)
+	feed.TotalResults, feed.ItemsPerPage = &total, api.PageSize
+	feed.Links = append(feed.Links, atomLink{Rel: "up", Href: up, Type: opdsNavigation})
+	if len(page) > api.PageSize {
+		page = page[:api.PageSize]
+		next := r.URL.Query()
+		next.Set("after", strconv.FormatInt(page[len(page)-1].ID, 10))
+		feed.Links = append(feed.Links, atomLink{Rel: "next", Href: "/opds/books?" + next.Encode(), Type: opdsAcquisition})
+	}
+	if after > 0 {
+		first := r.URL.Query()
+		first.Del("after")
+		href := "/opds/books"
+		if len(first) > 0 {
+			href += "?" + first.Encode()
+		}
+		feed.Links = append(feed.Links, atomLink{Rel: "first", Href: href, Type: opdsAcquisition})
+	}
+	feed.Entries = []atomEntry{}
+	for i, b := range page {
+		var md *BookMetadata
+		if m, ok := expanded[i][ExpandMetadata].(BookMetadata); ok {
+			md = &m
+		}
+		feed.Entries = append(feed.Entries, acquisitionEntry(b, md))
+	}
+	writeXML(w, http.StatusOK, opdsAcquisition, feed)
+}
+
+// page reads up to PageSize+1 books after the given id, with their count
+// and metadata, and when the catalog last changed.
+func (api *OPDSAPI) page(ctx context.Context, f BookFilter, after int64) ([]Book, int, []map[string]any, time.Time, error) {
+	books, err := api.store.Search(ctx, BookQuery{BookFilter: f, AfterID: after, Limit: api.PageSize + 1})
+	if err != nil {
+		return nil, 0, nil, time.Time{}, err
+	}
+	total, err := api.store.Count(ctx, f)
+	if err != nil {
+		return nil, 0, nil, time.Time{}, err
+	}
+	expanded, err := api.store.Expand(ctx, books, []string{ExpandMetadata})
+	if err != nil {
+		return nil, 0, nil, time.Time{}, err
+	}
+	updated, err := api.updated(ctx)
+	return books, total, expanded, updated, err
+}
+
+// acquisitionEntry describes b, with its catalog metadata when there is some.
+// Books are borrowed, not downloaded: the acquisition link checks out a copy.
+func acquisitionEntry(b Book, md *BookMetadata) atomEntry {
+	path := "/v1/books/" + strconv.FormatInt(b.ID, 10)
+	e := atomEntry{
+		Title:   b.Title,
+		ID:      "urn:byfood:book:" + strconv.FormatInt(b.ID, 10),
+		Updated: b.UpdatedAt.UTC().Format(time.RFC3339),
+		Authors: []atomPerson{{Name: b.Author}},
+		Issued:  strconv.Itoa(b.Year),
+		Links: []atomLink{
+			{Rel: relBorrow, Href: path + "/checkout", Type: "application/json"},
+			{Rel: "alternate", Href: path, Type: "application/json"},
+		},
+	}
+	if b.ISBN != "" {
+		e.Identifier = "urn:isbn:" + b.ISBN
+	}
+	if md == nil {
+		return e
+	}
+	e.Publisher = md.Publisher
+	for _, s := range md.Subjects {
+		e.Categories = append(e.Categories, atomCategory{Term: s, Label: s})
+	}
+	if md.Description != "" {
+		e.Summary = &atomText{Type: "text", Text: md.Description}
+	}
+	if md.CoverURL != "" {
+		e.Links = append(e.Links,
+			atomLink{Rel: relImage, Href: md.CoverURL, Type: "image/jpeg"},
+			atomLink{Rel: relThumbnail, Href: md.CoverURL, Type: "image/jpeg"},
+		)
+	}
+	return e
+}
+
+// OpenSearchHandler serves the OpenSearch description that lets readers
+// search the catalog by title or author.
+func (api *OPDSAPI) OpenSearchHandler(w http.ResponseWriter, r *http.Request) {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	writeXML(w, http.StatusOK, openSearchType, openSearchDescription{
+		Xmlns:          openSearchNS,
+		ShortName:      "byFood Books",
+		Description:    "Search the catalog by title or author",
+		InputEncoding:  "UTF-8",
+		OutputEncoding: "UTF-8",
+		URL: openSearchURL{
+			Type:     opdsAcquisition,
+			Template: scheme + "://" + r.Host + "/opds/books?q={searchTerms}",
+		},
+	})
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Feeds as a reader parses them, namespaces resolved.
+type parsedFeed struct {
+	XMLName      xml.Name      `xml:"http://www.w3.org/2005/Atom feed"`
+	ID           string        `xml:"http://www.w3.org/2005/Atom id"`
+	Title        string        `xml:"http://www.w3.org/2005/Atom title"`
+	Updated      string        `xml:"http://www.w3.org/2005/Atom updated"`
+	TotalResults int           `xml:"http://a9.com/-/spec/opensearch/1.1/ totalResults"`
+	Links        []atomLink    `xml:"http://www.w3.org/2005/Atom link"`
+	Entries      []parsedEntry `xml:"http://www.w3.org/2005/Atom entry"`
+}
+
+type parsedEntry struct {
+	Title      string         `xml:"http://www.w3.org/2005/Atom title"`
+	ID         string         `xml:"http://www.w3.org/2005/Atom id"`
+	Author     string         `xml:"author>name"`
+	Content    string         `xml:"http://www.w3.org/2005/Atom content"`
+	Summary    string         `xml:"http://www.w3.org/2005/Atom summary"`
+	Issued     string         `xml:"http://purl.org/dc/terms/ issued"`
+	Identifier string         `xml:"http://purl.org/dc/terms/ identifier"`
+	Categories []atomCategory `xml:"http://www.w3.org/2005/Atom category"`
+	Links      []atomLink     `xml:"http://www.w3.org/2005/Atom link"`
+}
+
+func (f parsedFeed) link(rel string) (atomLink, bool) {
+	return findLink(f.Links, rel)
+}
+
+func findLink(links []atomLink, rel string) (atomLink, bool) {
+	for _, l := range links {
+		if l.Rel == rel {
+			return l, true
+		}
+	}
+	return atomLink{}, false
+}
+
+func setupOPDS(t *testing.T) (*chi.Mux, *OPDSAPI, func()) {
+	t.Helper()
+
+	books, db := setupTestRouter(t)
+	api := NewOPDSAPI(NewBookStore(db))
+	r := chi.NewRouter()
+	r.Mount("/v1", books)
+	r.Get("/opds", api.RootHandler)
+	r.Get("/opds/books", api.BooksHandler)
+	r.Get("/opds/authors", api.FacetHandler(FacetAuthor, "By author"))
+	r.Get("/opds/tags", api.FacetHandler(FacetTag, "By tag"))
+	r.Get("/opds/years", api.FacetHandler(FacetYear, "By year"))
+	r.Get("/opds/opensearch.xml", api.OpenSearchHandler)
+
+	for _, body := range []string{
+		`{"title":"Dune","author":"Frank Herbert","year":1965,"isbn":"9780441172719"}`,
+		`{"title":"Dune Messiah","author":"Frank Herbert","year":1969}`,
+		`{"title":"Hyperion","author":"Dan Simmons","year":1989}`,
+		`{"title":"Solaris","author":"Stanislaw Lem","year":1961}`,
+		`{"title":"The Left Hand of Darkness","author":"Ursula K. Le Guin","year":1969}`,
+	} {
+		doJSON(t, r, http.MethodPost, "/v1/books", body)
+	}
+	now := time.Now().UTC()
+	if _, err := db.Exec(`
+		INSERT INTO book_metadata(book_id, source, isbn, publisher, subjects, description, cover_url, fetched_at) VALUES
+		(1, 'openlibrary', '9780441172719', 'Ace', '["Science Fiction","Deserts"]', 'Arrakis.', 'https://covers.example/1.jpg', ?),
+		(3, 'openlibrary', '', '', '["science fiction"]', '', '', ?)`, now, now); err != nil {
+		t.Fatal(err)
+	}
+	return r, api, func() { db.Close() }
+}
+
+func getFeed(t *testing.T, r http.Handler, path, wantType string) parsedFeed {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("GET %s: %d %s", path, rr.Code, rr.Body.String())
+	}
+	if got := rr.Header().Get("Content-Type"); !strings.HasPrefix(got, wantType) {
+		t.Fatalf("GET %s: Content-Type %q, want %q", path, got, wantType)
+	}
+	var feed parsedFeed
+	if err := xml.Unmarshal(rr.Body.Bytes(), &feed); err != nil {
+		t.Fatalf("GET %s: %v\n%s", path, err, rr.Body.String())
+	}
+	if _, err := time.Parse(time.RFC3339, feed.Updated); err != nil || feed.ID == "" {
+		t.Fatalf("GET %s: id %q updated %q", path, feed.ID, feed.Updated)
+	}
+	return feed
+}
+
+func TestOPDS_Navigation(t *testing.T) {
+	r, _, cleanup := setupOPDS(t)
+	defer cleanup()
+
+	root := getFeed(t, r, "/opds", opdsNavigation)
+	if len(root.Entries) != 4 {
+		t.Fatalf("root entries = %+v", root.Entries)
+	}
+	if l, ok := root.link("search"); !ok || l.Type != openSearchType {
+		t.Fatalf("root links = %+v", root.Links)
+	}
+	for _, e := range root.Entries {
+		l, ok := findLink(e.Links, "subsection")
+		if !ok {
+			t.Fatalf("%s has no subsection link", e.Title)
+		}
+		// every entry leads to a feed that parses
+		getFeed(t, r, l.Href, l.Type)
+	}
+
+	authors := getFeed(t, r, "/opds/authors", opdsNavigation)
+	if len(authors.Entries) != 4 || authors.Entries[1].Title != "Frank Herbert" || authors.Entries[1].Content != "2 books" {
+		t.Fatalf("authors = %+v", authors.Entries)
+	}
+	herbert := getFeed(t, r, authors.Entries[1].Links[0].Href, opdsAcquisition)
+	if herbert.Title != "Books by Frank Herbert" || len(herbert.Entries) != 2 {
+		t.Fatalf("herbert = %+v", herbert)
+	}
+
+	// subjects are grouped case-insensitively
+	tags := getFeed(t, r, "/opds/tags", opdsNavigation)
+	if len(tags.Entries) != 2 || tags.Entries[0].Title != "Deserts" || tags.Entries[1].Content != "2 books" {
+		t.Fatalf("tags = %+v", tags.Entries)
+	}
+	sf := getFeed(t, r, tags.Entries[1].Links[0].Href, opdsAcquisition)
+	if len(sf.Entries) != 2 || sf.Entries[0].Title != "Dune" || sf.Entries[1].Title != "Hyperion" {
+		t.Fatalf("science fiction = %+v", sf.Entries)
+	}
+
+	years := getFeed(t, r, "/opds/years", opdsNavigation)
+	if len(years.Entries) != 4 || years.Entries[0].Title != "1989" || years.Entries[1].Content != "2 books" {
+		t.Fatalf("years = %+v", years.Entries)
+	}
+	y1969 := getFeed(t, r, years.Entries[1].Links[0].Href, opdsAcquisition)
+	if y1969.Title != "Books from 1969" || len(y1969.Entries) != 2 {
+		t.Fatalf("1969 = %+v", y1969)
+	}
+	if up, _ := y1969.link("up"); up.Href != "/opds/years" {
+		t.Fatalf("up = %+v", up)
+	}
+}
+
+func TestOPDS_AuthorFacetMatchesWholeName(t *testing.T) {
+	r, _, cleanup := setupOPDS(t)
+	defer cleanup()
+
+	for _, body := range []string{
+		`{"title":"First","author":"Ann","year":2001}`,
+		`{"title":"Second","author":" ann ","year":2002}`,
+		`{"title":"Third","author":"Joanne","year":2003}`,
+	} {
+		doJSON(t, r, http.MethodPost, "/v1/books", body)
+	}
+
+	authors := getFeed(t, r, "/opds/authors", opdsNavigation)
+	for name, want := range map[string][]string{"Ann": {"First", "Second"}, "Joanne": {"Third"}} {
+		var href string
+		for _, e := range authors.Entries {
+			if e.Title == name {
+				href = e.Links[0].Href
+			}
+		}
+		if href == "" {
+			t.Fatalf("no %s in %+v", name, authors.Entries)
+		}
+		var got []string
+		for _, e := range getFeed(t, r, href, opdsAcquisition).Entries {
+			got = append(got, e.Title)
+		}
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Fatalf("%s: %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestOPDS_AcquisitionPages(t *testing.T) {
+	r, api, cleanup := setupOPDS(t)
+	defer cleanup()
+	api.PageSize = 2
+
+	var titles []string
+	path, pages := "/opds/books", 0
+	for {
+		feed := getFeed(t, r, path, opdsAcquisition)
+		pages++
+		if feed.TotalResults != 5 {
+			t.Fatalf("totalResults = %d", feed.TotalResults)
+		}
+		for _, e := range feed.Entries {
+			titles = append(titles, e.Title)
+		}
+		next, ok := feed.link("next")
+		if !ok {
+			break
+		}
+		path = next.Href
+	}
+	if pages != 3 || len(titles) != 5 || titles[0] != "Dune" || titles[4] != "The Left Hand of Darkness" {
+		t.Fatalf("%d pages: %v", pages, titles)
+	}
+
+	dune := getFeed(t, r, "/opds/books", opdsAcquisition).Entries[0]
+	if dune.Author != "Frank Herbert" || dune.Issued != "1965" || dune.Identifier != "urn:isbn:9780441172719" || dune.Summary != "Arrakis." {
+		t.Fatalf("dune = %+v", dune)
+	}
+	if len(dune.Categories) != 2 || dune.Categories[0].Term != "Science Fiction" {
+		t.Fatalf("categories = %+v", dune.Categories)
+	}
+	if l, ok := findLink(dune.Links, relBorrow); !ok || l.Href != "/v1/books/1/checkout" {
+		t.Fatalf("borrow link = %+v", dune.Links)
+	}
+	if l, ok := findLink(dune.Links, relImage); !ok || l.Href != "https://covers.example/1.jpg" {
+		t.Fatalf("cover link = %+v", dune.Links)
+	}
+
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/opds/books?after=x", nil))
+	if rr.Code != http.StatusBadRequest || decodeJSON[errorResponse](t, rr).Code != "after_invalid" {
+		t.Fatalf("bad after: %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestOPDS_OpenSearch(t *testing.T) {
+	r, _, cleanup := setupOPDS(t)
+	defer cleanup()
+
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "http://books.example/opds/opensearch.xml", nil))
+	if !strings.HasPrefix(rr.Header().Get("Content-Type"), openSearchType) {
+		t.Fatalf("Content-Type = %q", rr.Header().Get("Content-Type"))
+	}
+	var desc struct {
+		XMLName   xml.Name `xml:"http://a9.com/-/spec/opensearch/1.1/ OpenSearchDescription"`
+		ShortName string   `xml:"ShortName"`
+		URL       struct {
+			Type     string `xml:"type,attr"`
+			Template string `xml:"template,attr"`
+		} `xml:"Url"`
+	}
+	if err := xml.Unmarshal(rr.Body.Bytes(), &desc); err != nil {
+		t.Fatal(err)
+	}
+	if desc.URL.Type != opdsAcquisition || desc.URL.Template != "http://books.example/opds/books?q={searchTerms}" {
+		t.Fatalf("description = %+v", desc)
+	}
+
+	// fill in the template as a reader does; the term matches titles and authors
+	search := strings.TrimPrefix(strings.Replace(desc.URL.Template, "{searchTerms}", "simmons", 1), "http://books.example")
+	feed := getFeed(t, r, search, opdsAcquisition)
+	if len(feed.Entries) != 1 || feed.Entries[0].Title != "Hyperion" || feed.TotalResults != 1 {
+		t.Fatalf("search = %+v", feed.Entries)
+	}
+	if feed := getFeed(t, r, "/opds/books?q=dune", opdsAcquisition); len(feed.Entries) != 2 {
+		t.Fatalf("title search = %+v", feed.Entries)
+	}
+}
@@ -0,0 +1,792 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.12
+// 	protoc        (unknown)
+// source: byfood.proto
+
+package pb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// Book mirrors the REST Book representation.
+type Book struct {
+	state     protoimpl.MessageState `protogen:"open.v1"`
+	Id        int64                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Title     string                 `protobuf:"bytes,2,opt,name=title,proto3" json:"title,omitempty"`
+	Author    string                 `protobuf:"bytes,3,opt,name=author,proto3" json:"author,omitempty"`
+	Year      int32                  `protobuf:"varint,4,opt,name=year,proto3" json:"year,omitempty"`
+	CreatedAt *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
+	UpdatedAt *timestamppb.Timestamp `protobuf:"bytes,6,opt,name=updated_at,json=updatedAt,proto3" json:"updated_at,omitempty"`
+	// Average review rating (0 when unreviewed) and number of reviews.
+	RatingAvg   float64 `protobuf:"fixed64,7,opt,name=rating_avg,json=ratingAvg,proto3" json:"rating_avg,omitempty"`
+	RatingCount int32   `protobuf:"varint,8,opt,name=rating_count,json=ratingCount,proto3" json:"rating_count,omitempty"`
+	// "available", "on_loan" or "unavailable".
+	Status string `protobuf:"bytes,9,opt,name=status,proto3" json:"status,omitempty"`
+	// Copies in service, and how many of them are on the shelf.
+	CopiesTotal     int32 `protobuf:"varint,10,opt,name=copies_total,json=copiesTotal,proto3" json:"copies_total,omitempty"`
+	CopiesAvailable int32 `protobuf:"varint,11,opt,name=copies_available,json=copiesAvailable,proto3" json:"copies_available,omitempty"`
+	// Empty when unknown.
+	Isbn          string `protobuf:"bytes,12,opt,name=isbn,proto3" json:"isbn,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Book) Reset() {
+	*x = Book{}
+	mi := &file_byfood_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Book) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Book) ProtoMessage() {}
+
+func (x *Book) ProtoReflect() protoreflect.Message {
+	mi := &file_byfood_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Book.ProtoReflect.Descriptor instead.
+func (*Book) Descriptor() ([]byte, []int) {
+	return file_byfood_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Book) GetId() int64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *Book) GetTitle() string {
+	if x != nil {
+		return x.Title
+	}
+	return ""
+}
+
+func (x *Book) GetAuthor() string {
+	if x != nil {
+		return x.Author
+	}
+	return ""
+}
+
+func (x *Book) GetYear() int32 {
+	if x != nil {
+		return x.Year
+	}
+	return 0
+}
+
+func (x *Book) GetCreatedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.CreatedAt
+	}
+	return nil
+}
+
+func (x *Book) GetUpdatedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.UpdatedAt
+	}
+	return nil
+}
+
+func (x *Book) GetRatingAvg() float64 {
+	if x != nil {
+		return x.RatingAvg
+	}
+	return 0
+}
+
+func (x *Book) GetRatingCount() int32 {
+	if x != nil {
+		return x.RatingCount
+	}
+	return 0
+}
+
+func (x *Book) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *Book) GetCopiesTotal() int32 {
+	if x != nil {
+		return x.CopiesTotal
+	}
+	return 0
+}
+
+func (x *Book) GetCopiesAvailable() int32 {
+	if x != nil {
+		return x.CopiesAvailable
+	}
+	return 0
+}
+
+func (x *Book) GetIsbn() string {
+	if x != nil {
+		return x.Isbn
+	}
+	return ""
+}
+
+// BookInput holds the user-editable fields of a book.
+type BookInput struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Title         string                 `protobuf:"bytes,1,opt,name=title,proto3" json:"title,omitempty"`
+	Author        string                 `protobuf:"bytes,2,opt,name=author,proto3" json:"author,omitempty"`
+	Year          int32                  `protobuf:"varint,3,opt,name=year,proto3" json:"year,omitempty"`
+	Isbn          string                 `protobuf:"bytes,4,opt,name=isbn,proto3" json:"isbn,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BookInput) Reset() {
+	*x = BookInput{}
+	mi := &file_byfood_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BookInput) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BookInput) ProtoMessage() {}
+
+func (x *BookInput) ProtoReflect() protoreflect.Message {
+	mi := &file_byfood_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BookInput.ProtoReflect.Descriptor instead.
+func (*BookInput) Descriptor() ([]byte, []int) {
+	return file_byfood_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *BookInput) GetTitle() string {
+	if x != nil {
+		return x.Title
+	}
+	return ""
+}
+
+func (x *BookInput) GetAuthor() string {
+	if x != nil {
+		return x.Author
+	}
+	return ""
+}
+
+func (x *BookInput) GetYear() int32 {
+	if x != nil {
+		return x.Year
+	}
+	return 0
+}
+
+func (x *BookInput) GetIsbn() string {
+	if x != nil {
+		return x.Isbn
+	}
+	return ""
+}
+
+type GetBookRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            int64                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetBookRequest) Reset() {
+	*x = GetBookRequest{}
+	mi := &file_byfood_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetBookRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetBookRequest) ProtoMessage() {}
+
+func (x *GetBookRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_byfood_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetBookRequest.ProtoReflect.Descriptor instead.
+func (*GetBookRequest) Descriptor() ([]byte, []int) {
+	return file_byfood_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *GetBookRequest) GetId() int64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+// ListBooksRequest filters the streamed books. Empty fields match everything.
+type ListBooksRequest struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	TitleContains  string                 `protobuf:"bytes,1,opt,name=title_contains,json=titleContains,proto3" json:"title_contains,omitempty"`
+	AuthorContains string                 `protobuf:"bytes,2,opt,name=author_contains,json=authorContains,proto3" json:"author_contains,omitempty"`
+	YearFrom       int32                  `protobuf:"varint,3,opt,name=year_from,json=yearFrom,proto3" json:"year_from,omitempty"`
+	YearTo         int32                  `protobuf:"varint,4,opt,name=year_to,json=yearTo,proto3" json:"year_to,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *ListBooksRequest) Reset() {
+	*x = ListBooksRequest{}
+	mi := &file_byfood_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListBooksRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListBooksRequest) ProtoMessage() {}
+
+func (x *ListBooksRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_byfood_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListBooksRequest.ProtoReflect.Descriptor instead.
+func (*ListBooksRequest) Descriptor() ([]byte, []int) {
+	return file_byfood_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *ListBooksRequest) GetTitleContains() string {
+	if x != nil {
+		return x.TitleContains
+	}
+	return ""
+}
+
+func (x *ListBooksRequest) GetAuthorContains() string {
+	if x != nil {
+		return x.AuthorContains
+	}
+	return ""
+}
+
+func (x *ListBooksRequest) GetYearFrom() int32 {
+	if x != nil {
+		return x.YearFrom
+	}
+	return 0
+}
+
+func (x *ListBooksRequest) GetYearTo() int32 {
+	if x != nil {
+		return x.YearTo
+	}
+	return 0
+}
+
+type CreateBookRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Book          *BookInput             `protobuf:"bytes,1,opt,name=book,proto3" json:"book,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CreateBookRequest) Reset() {
+	*x = CreateBookRequest{}
+	mi := &file_byfood_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CreateBookRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CreateBookRequest) ProtoMessage() {}
+
+func (x *CreateBookRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_byfood_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CreateBookRequest.ProtoReflect.Descriptor instead.
+func (*CreateBookRequest) Descriptor() ([]byte, []int) {
+	return file_byfood_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *CreateBookRequest) GetBook() *BookInput {
+	if x != nil {
+		return x.Book
+	}
+	return nil
+}
+
+type UpdateBookRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            int64                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Book          *BookInput             `protobuf:"bytes,2,opt,name=book,proto3" json:"book,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UpdateBookRequest) Reset() {
+	*x = UpdateBookRequest{}
+	mi := &file_byfood_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UpdateBookRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UpdateBookRequest) ProtoMessage() {}
+
+func (x *UpdateBookRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_byfood_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UpdateBookRequest.ProtoReflect.Descriptor instead.
+func (*UpdateBookRequest) Descriptor() ([]byte, []int) {
+	return file_byfood_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *UpdateBookRequest) GetId() int64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *UpdateBookRequest) GetBook() *BookInput {
+	if x != nil {
+		return x.Book
+	}
+	return nil
+}
+
+type DeleteBookRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            int64                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteBookRequest) Reset() {
+	*x = DeleteBookRequest{}
+	mi := &file_byfood_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteBookRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteBookRequest) ProtoMessage() {}
+
+func (x *DeleteBookRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_byfood_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteBookRequest.ProtoReflect.Descriptor instead.
+func (*DeleteBookRequest) Descriptor() ([]byte, []int) {
+	return file_byfood_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *DeleteBookRequest) GetId() int64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+type ProcessURLRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Url   string                 `protobuf:"bytes,1,opt,name=url,proto3" json:"url,omitempty"`
+	// One of: canonical, redirection, all.
+	Operation string `protobuf:"bytes,2,opt,name=operation,proto3" json:"operation,omitempty"`
+	// Optional client-chosen id echoed in stream results.
+	RequestId     string `protobuf:"bytes,3,opt,name=request_id,json=requestId,proto3" json:"request_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ProcessURLRequest) Reset() {
+	*x = ProcessURLRequest{}
+	mi := &file_byfood_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ProcessURLRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ProcessURLRequest) ProtoMessage() {}
+
+func (x *ProcessURLRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_byfood_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ProcessURLRequest.ProtoReflect.Descriptor instead.
+func (*ProcessURLRequest) Descriptor() ([]byte, []int) {
+	return file_byfood_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *ProcessURLRequest) GetUrl() string {
+	if x != nil {
+		return x.Url
+	}
+	return ""
+}
+
+func (x *ProcessURLRequest) GetOperation() string {
+	if x != nil {
+		return x.Operation
+	}
+	return ""
+}
+
+func (x *ProcessURLRequest) GetRequestId() string {
+	if x != nil {
+		return x.RequestId
+	}
+	return ""
+}
+
+type ProcessURLResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ProcessedUrl  string                 `protobuf:"bytes,1,opt,name=processed_url,json=processedUrl,proto3" json:"processed_url,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ProcessURLResponse) Reset() {
+	*x = ProcessURLResponse{}
+	mi := &file_byfood_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ProcessURLResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ProcessURLResponse) ProtoMessage() {}
+
+func (x *ProcessURLResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_byfood_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ProcessURLResponse.ProtoReflect.Descriptor instead.
+func (*ProcessURLResponse) Descriptor() ([]byte, []int) {
+	return file_byfood_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *ProcessURLResponse) GetProcessedUrl() string {
+	if x != nil {
+		return x.ProcessedUrl
+	}
+	return ""
+}
+
+// ProcessURLResult is one answer on the batch stream. Exactly one of
+// processed_url and error is set.
+type ProcessURLResult struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	RequestId     string                 `protobuf:"bytes,1,opt,name=request_id,json=requestId,proto3" json:"request_id,omitempty"`
+	ProcessedUrl  string                 `protobuf:"bytes,2,opt,name=processed_url,json=processedUrl,proto3" json:"processed_url,omitempty"`
+	Error         string                 `protobuf:"bytes,3,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ProcessURLResult) Reset() {
+	*x = ProcessURLResult{}
+	mi := &file_byfood_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ProcessURLResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ProcessURLResult) ProtoMessage() {}
+
+func (x *ProcessURLResult) ProtoReflect() protoreflect.Message {
+	mi := &file_byfood_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ProcessURLResult.ProtoReflect.Descriptor instead.
+func (*ProcessURLResult) Descriptor() ([]byte, []int) {
+	return file_byfood_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *ProcessURLResult) GetRequestId() string {
+	if x != nil {
+		return x.RequestId
+	}
+	return ""
+}
+
+func (x *ProcessURLResult) GetProcessedUrl() string {
+	if x != nil {
+		return x.ProcessedUrl
+	}
+	return ""
+}
+
+func (x *ProcessURLResult) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+var File_byfood_proto protoreflect.FileDescriptor
+
+const file_byfood_proto_rawDesc = "" +
+	"\n" +
+	"\fbyfood.proto\x12\tbyfood.v1\x1a\x1bgoogle/protobuf/empty.proto\x1a\x1fgoogle/protobuf/timestamp.proto\"\x8a\x03\n" +
+	"\x04Book\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x03R\x02id\x12\x14\n" +
+	"\x05title\x18\x02 \x01(\tR\x05title\x12\x16\n" +
+	"\x06author\x18\x03 \x01(\tR\x06author\x12\x12\n" +
+	"\x04year\x18\x04 \x01(\x05R\x04year\x129\n" +
+	"\n" +
+	"created_at\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\tcreatedAt\x129\n" +
+	"\n" +
+	"updated_at\x18\x06 \x01(\v2\x1a.google.protobuf.TimestampR\tupdatedAt\x12\x1d\n" +
+	"\n" +
+	"rating_avg\x18\a \x01(\x01R\tratingAvg\x12!\n" +
+	"\frating_count\x18\b \x01(\x05R\vratingCount\x12\x16\n" +
+	"\x06status\x18\t \x01(\tR\x06status\x12!\n" +
+	"\fcopies_total\x18\n" +
+	" \x01(\x05R\vcopiesTotal\x12)\n" +
+	"\x10copies_available\x18\v \x01(\x05R\x0fcopiesAvailable\x12\x12\n" +
+	"\x04isbn\x18\f \x01(\tR\x04isbn\"a\n" +
+	"\tBookInput\x12\x14\n" +
+	"\x05title\x18\x01 \x01(\tR\x05title\x12\x16\n" +
+	"\x06author\x18\x02 \x01(\tR\x06author\x12\x12\n" +
+	"\x04year\x18\x03 \x01(\x05R\x04year\x12\x12\n" +
+	"\x04isbn\x18\x04 \x01(\tR\x04isbn\" \n" +
+	"\x0eGetBookRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x03R\x02id\"\x98\x01\n" +
+	"\x10ListBooksRequest\x12%\n" +
+	"\x0etitle_contains\x18\x01 \x01(\tR\rtitleContains\x12'\n" +
+	"\x0fauthor_contains\x18\x02 \x01(\tR\x0eauthorContains\x12\x1b\n" +
+	"\tyear_from\x18\x03 \x01(\x05R\byearFrom\x12\x17\n" +
+	"\ayear_to\x18\x04 \x01(\x05R\x06yearTo\"=\n" +
+	"\x11CreateBookRequest\x12(\n" +
+	"\x04book\x18\x01 \x01(\v2\x14.byfood.v1.BookInputR\x04book\"M\n" +
+	"\x11UpdateBookRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x03R\x02id\x12(\n" +
+	"\x04book\x18\x02 \x01(\v2\x14.byfood.v1.BookInputR\x04book\"#\n" +
+	"\x11DeleteBookRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x03R\x02id\"b\n" +
+	"\x11ProcessURLRequest\x12\x10\n" +
+	"\x03url\x18\x01 \x01(\tR\x03url\x12\x1c\n" +
+	"\toperation\x18\x02 \x01(\tR\toperation\x12\x1d\n" +
+	"\n" +
+	"request_id\x18\x03 \x01(\tR\trequestId\"9\n" +
+	"\x12ProcessURLResponse\x12#\n" +
+	"\rprocessed_url\x18\x01 \x01(\tR\fprocessedUrl\"l\n" +
+	"\x10ProcessURLResult\x12\x1d\n" +
+	"\n" +
+	"request_id\x18\x01 \x01(\tR\trequestId\x12#\n" +
+	"\rprocessed_url\x18\x02 \x01(\tR\fprocessedUrl\x12\x14\n" +
+	"\x05error\x18\x03 \x01(\tR\x05error2\xaa\x02\n" +
+	"\vBookService\x121\n" +
+	"\x03Get\x12\x19.byfood.v1.GetBookRequest\x1a\x0f.byfood.v1.Book\x126\n" +
+	"\x04List\x12\x1b.byfood.v1.ListBooksRequest\x1a\x0f.byfood.v1.Book0\x01\x127\n" +
+	"\x06Create\x12\x1c.byfood.v1.CreateBookRequest\x1a\x0f.byfood.v1.Book\x127\n" +
+	"\x06Update\x12\x1c.byfood.v1.UpdateBookRequest\x1a\x0f.byfood.v1.Book\x12>\n" +
+	"\x06Delete\x12\x1c.byfood.v1.DeleteBookRequest\x1a\x16.google.protobuf.Empty2\xa4\x01\n" +
+	"\n" +
+	"URLService\x12F\n" +
+	"\aProcess\x12\x1c.byfood.v1.ProcessURLRequest\x1a\x1d.byfood.v1.ProcessURLResponse\x12N\n" +
+	"\rProcessStream\x12\x1c.byfood.v1.ProcessURLRequest\x1a\x1b.byfood.v1.ProcessURLResult(\x010\x01B\x16Z\x14byfood/backend/pb;pbb\x06proto3"
+
+var (
+	file_byfood_proto_rawDescOnce sync.Once
+	file_byfood_proto_rawDescData []byte
+)
+
+func file_byfood_proto_rawDescGZIP() []byte {
+	file_byfood_proto_rawDescOnce.Do(func() {
+		file_byfood_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_byfood_proto_rawDesc), len(file_byfood_proto_rawDesc)))
+	})
+	return file_byfood_proto_rawDescData
+}
+
+var file_byfood_proto_msgTypes = make([]protoimpl.MessageInfo, 10)
+var file_byfood_proto_goTypes = []any{
+	(*Book)(nil),                  // 0: byfood.v1.Book
+	(*BookInput)(nil),             // 1: byfood.v1.BookInput
+	(*GetBookRequest)(nil),        // 2: byfood.v1.GetBookRequest
+	(*ListBooksRequest)(nil),      // 3: byfood.v1.ListBooksRequest
+	(*CreateBookRequest)(nil),     // 4: byfood.v1.CreateBookRequest
+	(*UpdateBookRequest)(nil),     // 5: byfood.v1.UpdateBookRequest
+	(*DeleteBookRequest)(nil),     // 6: byfood.v1.DeleteBookRequest
+	(*ProcessURLRequest)(nil),     // 7: byfood.v1.ProcessURLRequest
+	(*ProcessURLResponse)(nil),    // 8: byfood.v1.ProcessURLResponse
+	(*ProcessURLResult)(nil),      // 9: byfood.v1.ProcessURLResult
+	(*timestamppb.Timestamp)(nil), // 10: google.protobuf.Timestamp
+	(*emptypb.Empty)(nil),         // 11: google.protobuf.Empty
+}
+var file_byfood_proto_depIdxs = []int32{
+	10, // 0: byfood.v1.Book.created_at:type_name -> google.protobuf.Timestamp
+	10, // 1: byfood.v1.Book.updated_at:type_name -> google.protobuf.Timestamp
+	1,  // 2: byfood.v1.CreateBookRequest.book:type_name -> byfood.v1.BookInput
+	1,  // 3: byfood.v1.UpdateBookRequest.book:type_name -> byfood.v1.BookInput
+	2,  // 4: byfood.v1.BookService.Get:input_type -> byfood.v1.GetBookRequest
+	3,  // 5: byfood.v1.BookService.List:input_type -> byfood.v1.ListBooksRequest
+	4,  // 6: byfood.v1.BookService.Create:input_type -> byfood.v1.CreateBookRequest
+	5,  // 7: byfood.v1.BookService.Update:input_type -> byfood.v1.UpdateBookRequest
+	6,  // 8: byfood.v1.BookService.Delete:input_type -> byfood.v1.DeleteBookRequest
+	7,  // 9: byfood.v1.URLService.Process:input_type -> byfood.v1.ProcessURLRequest
+	7,  // 10: byfood.v1.URLService.ProcessStream:input_type -> byfood.v1.ProcessURLRequest
+	0,  // 11: byfood.v1.BookService.Get:output_type -> byfood.v1.Book
+	0,  // 12: byfood.v1.BookService.List:output_type -> byfood.v1.Book
+	0,  // 13: byfood.v1.BookService.Create:output_type -> byfood.v1.Book
+	0,  // 14: byfood.v1.BookService.Update:output_type -> byfood.v1.Book
+	11, // 15: byfood.v1.BookService.Delete:output_type -> google.protobuf.Empty
+	8,  // 16: byfood.v1.URLService.Process:output_type -> byfood.v1.ProcessURLResponse
+	9,  // 17: byfood.v1.URLService.ProcessStream:output_type -> byfood.v1.ProcessURLResult
+	11, // [11:18] is the sub-list for method output_type
+	4,  // [4:11] is the sub-list for method input_type
+	4,  // [4:4] is the sub-list for extension type_name
+	4,  // [4:4] is the sub-list for extension extendee
+	0,  // [0:4] is the sub-list for field type_name
+}
+
+func init() { file_byfood_proto_init() }
+func file_byfood_proto_init() {
+	if File_byfood_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_byfood_proto_rawDesc), len(file_byfood_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   10,
+			NumExtensions: 0,
+			NumServices:   2,
+		},
+		GoTypes:           file_byfood_proto_goTypes,
+		DependencyIndexes: file_byfood_proto_depIdxs,
+		MessageInfos:      file_byfood_proto_msgTypes,
+	}.Build()
+	File_byfood_proto = out.File
+	file_byfood_proto_goTypes = nil
+	file_byfood_proto_depIdxs = nil
+}
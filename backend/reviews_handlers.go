@@ -0,0 +1,234 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+type ReviewsAPI struct {
+	store *ReviewStore
+}
+
+func NewReviewsAPI(store *ReviewStore) *ReviewsAPI {
+	return &ReviewsAPI{store: store}
+}
+
+// reviewInput is the editable part of a review.
+type reviewInput struct {
+	Rating int    `json:"rating" example:"5"`
+	Text   string `json:"text" example:"Still the best world-building in the genre."`
+}
+
+type reviewExistsResponse struct {
+	Error    string `json:"error"`
+	Code     string `json:"code"`
+	Existing Review `json:"existing"`
+}
+
+// ListReviewsHandler godoc
+// @Summary List a book's reviews, newest first
+// @Tags reviews
+// @Produce json
+// @Param id path int true "Book ID"
+// @Param min_rating query int false "Only reviews rated at least this (1-5)"
+// @Success 200 {array} Review
+// @Failure 400 {object} errorResponse
+// @Failure 404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /books/{id}/reviews [get]
+func (api *ReviewsAPI) ListReviewsHandler(w http.ResponseWriter, r *http.Request) {
+	bookID, ok := parseIDParam(w, r, "id")
+	if !ok {
+		return
+	}
+	minRating := 0
+	if raw := r.URL.Query().Get("min_rating"); raw != "" {
+		v, err := strconv.Atoi(raw)
+		if err != nil || v < 1 || v > 5 {
+			writeError(w, r, http.StatusBadRequest, "min_rating_not_integer")
+			return
+		}
+		minRating = v
+	}
+
+	reviews, err := api.store.List(r.Context(), bookID, minRating)
+	if err == ErrNotFound {
+		writeError(w, r, http.StatusNotFound, "book_not_found")
+		return
+	}
+	if err != nil {
+		loggerFrom(r.Context()).Error("list reviews", "book_id", bookID, "err", err)
+		writeError(w, r, http.StatusInternalServerError, "internal_error")
+		return
+	}
+	writeJSON(w, http.StatusOK, reviews)
+}
+
+// GetReviewHandler godoc
+// @Summary Get a review
+// @Tags reviews
+// @Produce json
+// @Param id path int true "Book ID"
+// @Param reviewID path int true "Review ID"
+// @Success 200 {object} Review
+// @Failure 400 {object} errorResponse
+// @Failure 404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /books/{id}/reviews/{reviewID} [get]
+func (api *ReviewsAPI) GetReviewHandler(w http.ResponseWriter, r *http.Request) {
+	bookID, ok := parseIDParam(w, r, "id")
+	if !ok {
+		return
+	}
+	id, ok := parseIDParam(w, r, "reviewID")
+	if !ok {
+		return
+	}
+
+	rv, err := api.store.Get(r.Context(), bookID, id)
+	if err == ErrNotFound {
+		writeError(w, r, http.StatusNotFound, "review_not_found")
+		return
+	}
+	if err != nil {
+		loggerFrom(r.Context()).Error("get review", "review_id", id, "err", err)
+		writeError(w, r, http.StatusInternalServerError, "internal_error")
+		return
+	}
+	writeJSON(w, http.StatusOK, rv)
+}
+
+// CreateReviewHandler godoc
+// @Summary Review a book
+// @Description One review per user per book. A second review answers 409 with the existing one, which can be edited with PUT.
+// @Tags reviews
+// @Accept json
+// @Produce json
+// @Param id path int true "Book ID"
+// @Param X-User header string true "Reviewer"
+// @Param review body reviewInput true "Review"
+// @Success 201 {object} Review
+// @Failure 400 {object} errorResponse
+// @Failure 401 {object} errorResponse
+// @Failure 404 {object} errorResponse
+// @Failure 409 {object} reviewExistsResponse
+// @Failure 500 {object} errorResponse
+// @Router /books/{id}/reviews [post]
+func (api *ReviewsAPI) CreateReviewHandler(w http.ResponseWriter, r *http.Request) {
+	bookID, ok := parseIDParam(w, r, "id")
+	if !ok {
+		return
+	}
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	var in reviewInput
+	if !readJSON(w, r, &in) {
+		return
+	}
+
+	rv, err := api.store.Create(r.Context(), bookID, user, Review{Rating: in.Rating, Text: in.Text})
+	api.writeResult(w, r, http.StatusCreated, rv, err, "book_not_found")
+}
+
+// UpdateReviewHandler godoc
+// @Summary Edit your review
+// @Tags reviews
+// @Accept json
+// @Produce json
+// @Param id path int true "Book ID"
+// @Param reviewID path int true "Review ID"
+// @Param X-User header string true "Reviewer"
+// @Param review body reviewInput true "Review"
+// @Success 200 {object} Review
+// @Failure 400 {object} errorResponse
+// @Failure 401 {object} errorResponse
+// @Failure 403 {object} errorResponse
+// @Failure 404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /books/{id}/reviews/{reviewID} [put]
+func (api *ReviewsAPI) UpdateReviewHandler(w http.ResponseWriter, r *http.Request) {
+	bookID, ok := parseIDParam(w, r, "id")
+	if !ok {
+		return
+	}
+	id, ok := parseIDParam(w, r, "reviewID")
+	if !ok {
+		return
+	}
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	var in reviewInput
+	if !readJSON(w, r, &in) {
+		return
+	}
+
+	rv, err := api.store.Update(r.Context(), bookID, id, user, Review{Rating: in.Rating, Text: in.Text})
+	api.writeResult(w, r, http.StatusOK, rv, err, "review_not_found")
+}
+
+// DeleteReviewHandler godoc
+// @Summary Delete your review
+// @Tags reviews
+// @Param id path int true "Book ID"
+// @Param reviewID path int true "Review ID"
+// @Param X-User header string true "Reviewer"
+// @Success 204 "No Content"
+// @Failure 400 {object} errorResponse
+// @Failure 401 {object} errorResponse
+// @Failure 403 {object} errorResponse
+// @Failure 404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /books/{id}/reviews/{reviewID} [delete]
+func (api *ReviewsAPI) DeleteReviewHandler(w http.ResponseWriter, r *http.Request) {
+	bookID, ok := parseIDParam(w, r, "id")
+	if !ok {
+		return
+	}
+	id, ok := parseIDParam(w, r, "reviewID")
+	if !ok {
+		return
+	}
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	err := api.store.Delete(r.Context(), bookID, id, user)
+	if err == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	api.writeResult(w, r, 0, nil, err, "review_not_found")
+}
+
+// writeResult maps review store errors onto responses, or writes v with
+// status on success.
+func (api *ReviewsAPI) writeResult(w http.ResponseWriter, r *http.Request, status int, v any, err error, notFoundCode string) {
+	var (
+		verr   *ValidationError
+		exists *ReviewExistsError
+	)
+	switch {
+	case err == nil:
+		writeJSON(w, status, v)
+	case errors.As(err, &verr):
+		writeError(w, r, http.StatusBadRequest, verr.Code)
+	case errors.As(err, &exists):
+		e := localizeCode(w, r, "review_exists", nil)
+		writeJSON(w, http.StatusConflict, reviewExistsResponse{Error: e.Error, Code: e.Code, Existing: exists.Existing})
+	case err == ErrNotFound:
+		writeError(w, r, http.StatusNotFound, notFoundCode)
+	case err == ErrForbidden:
+		writeError(w, r, http.StatusForbidden, "review_not_yours")
+	default:
+		loggerFrom(r.Context()).Error("write review", "err", err)
+		writeError(w, r, http.StatusInternalServerError, "internal_error")
+	}
+}
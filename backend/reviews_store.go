@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const maxReviewTextLen = 4000
+
+// ErrForbidden means the caller may not change someone else's resource.
+var ErrForbidden = errors.New("forbidden")
+
+// Review is one user's rating of a book. Each user has at most one review
+// per book.
+type Review struct {
+	ID        int64     `json:"id"`
+	BookID    int64     `json:"book_id"`
+	User      string    `json:"user"`
+	Rating    int       `json:"rating"`
+	Text      string    `json:"text"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ReviewExistsError is returned when the user already reviewed the book.
+type ReviewExistsError struct {
+	Existing Review
+}
+
+func (e *ReviewExistsError) Error() string {
+	return "you have already reviewed this book"
+}
+
+func validateReview(rv Review) error {
+	if rv.Rating < 1 || rv.Rating > 5 {
+		return &ValidationError{Field: "rating", Code: "rating_range", Message: "rating must be between 1 and 5"}
+	}
+	if utf8.RuneCountInString(rv.Text) > maxReviewTextLen {
+		return &ValidationError{Field: "text", Code: "text_too_long", Message: "text must be at most 4000 characters"}
+	}
+	return nil
+}
+
+const reviewColumns = `id, book_id, user, rating, text, created_at, updated_at`
+
+func scanReview(row rowScanner) (Review, error) {
+	var rv Review
+	err := row.Scan(&rv.ID, &rv.BookID, &rv.User, &rv.Rating, &rv.Text, &rv.CreatedAt, &rv.UpdatedAt)
+	return rv, err
+}
+
+type ReviewStore struct {
+	db *sql.DB
+}
+
+func NewReviewStore(db *sql.DB) *ReviewStore {
+	return &ReviewStore{db: db}
+}
+
+// List returns the book's reviews, newest first. minRating > 0 drops lower
+// ratings.
+func (s *ReviewStore) List(ctx context.Context, bookID int64, minRating int) ([]Review, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	if err := bookExists(ctx, s.db, bookID); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+reviewColumns+` FROM reviews WHERE book_id = ? AND rating >= ? ORDER BY updated_at DESC, id DESC`,
+		bookID, minRating,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []Review{}
+	for rows.Next() {
+		rv, err := scanReview(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, rv)
+	}
+	return out, rows.Err()
+}
+
+func (s *ReviewStore) Get(ctx context.Context, bookID, id int64) (Review, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rv, err := scanReview(s.db.QueryRowContext(ctx,
+		`SELECT `+reviewColumns+` FROM reviews WHERE id = ? AND book_id = ?`, id, bookID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Review{}, ErrNotFound
+	}
+	return rv, err
+}
+
+// Create adds user's review of the book and refreshes its rating in the same
+// transaction. A second review by the same user is a *ReviewExistsError.
+func (s *ReviewStore) Create(ctx context.Context, bookID int64, user string, rv Review) (Review, error) {
+	rv.Text = strings.TrimSpace(rv.Text)
+	if err := validateReview(rv); err != nil {
+		return Review{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Review{}, err
+	}
+	defer tx.Rollback()
+
+	if err := bookExists(ctx, tx, bookID); err != nil {
+		return Review{}, err
+	}
+
+	// The unique (book_id, user) index decides races between two first
+	// reviews; the loser sees the winner's review.
+	now := time.Now().UTC()
+	rv.BookID, rv.User, rv.CreatedAt, rv.UpdatedAt = bookID, user, now, now
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO reviews(book_id, user, rating, text, created_at, updated_at) VALUES(?, ?, ?, ?, ?, ?)
+		ON CONFLICT (book_id, user) DO NOTHING RETURNING id`,
+		rv.BookID, rv.User, rv.Rating, rv.Text, rv.CreatedAt, rv.UpdatedAt,
+	).Scan(&rv.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		existing, err := scanReview(tx.QueryRowContext(ctx,
+			`SELECT `+reviewColumns+` FROM reviews WHERE book_id = ? AND user = ?`, bookID, user))
+		if err != nil {
+			return Review{}, err
+		}
+		return Review{}, &ReviewExistsError{Existing: existing}
+	}
+	if err != nil {
+		return Review{}, err
+	}
+
+	if err := refreshBookRating(ctx, tx, bookID); err != nil {
+		return Review{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Review{}, err
+	}
+	loggerFrom(ctx).Debug("review created", "book_id", bookID, "review_id", rv.ID)
+	return rv, nil
+}
+
+// Update changes the rating and text of user's own review.
+func (s *ReviewStore) Update(ctx context.Context, bookID, id int64, user string, rv Review) (Review, error) {
+	rv.Text = strings.TrimSpace(rv.Text)
+	if err := validateReview(rv); err != nil {
+		return Review{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Review{}, err
+	}
+	defer tx.Rollback()
+
+	current, err := ownReview(ctx, tx, bookID, id, user)
+	if err != nil {
+		return Review{}, err
+	}
+
+	current.Rating, current.Text, current.UpdatedAt = rv.Rating, rv.Text, time.Now().UTC()
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE reviews SET rating = ?, text = ?, updated_at = ? WHERE id = ?`,
+		current.Rating, current.Text, current.UpdatedAt, id,
+	); err != nil {
+		return Review{}, err
+	}
+
+	if err := refreshBookRating(ctx, tx, bookID); err != nil {
+		return Review{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Review{}, err
+	}
+	loggerFrom(ctx).Debug("review updated", "book_id", bookID, "review_id", id)
+	return current, nil
+}
+
+// Delete removes user's own review.
+func (s *ReviewStore) Delete(ctx context.Context, bookID, id int64, user string) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := ownReview(ctx, tx, bookID, id, user); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM reviews WHERE id = ?`, id); err != nil {
+		return err
+	}
+
+	if err := refreshBookRating(ctx, tx, bookID); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	loggerFrom(ctx).Debug("review deleted", "book_id", bookID, "review_id", id)
+	return nil
+}
+
+// ownReview loads a review for modification inside tx. Reviews by other
+// users are ErrForbidden.
+func ownReview(ctx context.Context, tx *sql.Tx, bookID, id int64, user string) (Review, error) {
+	rv, err := scanReview(tx.QueryRowContext(ctx,
+		`SELECT `+reviewColumns+` FROM reviews WHERE id = ? AND book_id = ?`, id, bookID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Review{}, ErrNotFound
+	}
+	if err != nil {
+		return Review{}, err
+	}
+	if rv.User != user {
+		return Review{}, ErrForbidden
+	}
+	return rv, nil
+}
+
+// refreshBookRating recomputes the book's aggregates from its reviews. It
+// runs in the same transaction as the review write so they never disagree,
+// and bumps updated_at since the aggregates are part of the book.
+func refreshBookRating(ctx context.Context, tx *sql.Tx, bookID int64) error {
+	_, err := tx.ExecContext(ctx, `
+		UPDATE books SET
+			rating_count = (SELECT COUNT(*) FROM reviews WHERE book_id = ?1),
+			rating_avg = COALESCE((SELECT ROUND(AVG(rating), 2) FROM reviews WHERE book_id = ?1), 0),
+			updated_at = ?2
+		WHERE id = ?1`, bookID, time.Now().UTC())
+	return err
+}
+
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// bookExists returns ErrNotFound unless the book exists in the workspace of
+// ctx.
+func bookExists(ctx context.Context, q queryRower, bookID int64) error {
+	var one int
+	err := q.QueryRowContext(ctx,
+		`SELECT 1 FROM books WHERE id = ? AND workspace_id = ?`, bookID, workspaceFromContext(ctx),
+	).Scan(&one)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	return err
+}
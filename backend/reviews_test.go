@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func setupReviews(t *testing.T) (*chi.Mux, func()) {
+	t.Helper()
+
+	_, db := setupTestRouter(t)
+	books := NewBooksAPI(NewBookStore(db))
+	reviews := NewReviewsAPI(NewReviewStore(db))
+
+	r := chi.NewRouter()
+	r.Use(Identify)
+	r.Route("/books", func(r chi.Router) {
+		r.Get("/", books.GetBooksHandler)
+		r.Post("/", books.CreateBookHandler)
+		r.Get("/{id}", books.GetBookHandler)
+		r.Delete("/{id}", books.DeleteBookHandler)
+		r.Route("/{id}/reviews", func(r chi.Router) {
+			r.Get("/", reviews.ListReviewsHandler)
+			r.Post("/", reviews.CreateReviewHandler)
+			r.Get("/{reviewID}", reviews.GetReviewHandler)
+			r.Put("/{reviewID}", reviews.UpdateReviewHandler)
+			r.Delete("/{reviewID}", reviews.DeleteReviewHandler)
+		})
+	})
+	return r, func() { db.Close() }
+}
+
+func doAs(t *testing.T, r http.Handler, user, method, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	if user != "" {
+		req.Header.Set(userHeader, user)
+	}
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestReviews_OnePerUserAndAggregates(t *testing.T) {
+	r, cleanup := setupReviews(t)
+	defer cleanup()
+
+	book := decodeJSON[Book](t, doJSON(t, r, http.MethodPost, "/books", `{"title":"Dune","author":"Frank Herbert","year":1965}`))
+	reviewsPath := fmt.Sprintf("/books/%d/reviews", book.ID)
+
+	rr := doAs(t, r, "alice", http.MethodPost, reviewsPath, `{"rating":5,"text":"  A classic.  "}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("create status %d body=%s", rr.Code, rr.Body.String())
+	}
+	alice := decodeJSON[Review](t, rr)
+	if alice.User != "alice" || alice.Text != "A classic." {
+		t.Fatalf("unexpected review: %+v", alice)
+	}
+
+	rr = doAs(t, r, "alice", http.MethodPost, reviewsPath, `{"rating":1}`)
+	if rr.Code != http.StatusConflict {
+		t.Fatalf("second review status %d", rr.Code)
+	}
+	if existing := decodeJSON[reviewExistsResponse](t, rr); existing.Existing.ID != alice.ID {
+		t.Fatalf("conflict should point at the existing review: %+v", existing)
+	}
+
+	rr = doAs(t, r, "bob", http.MethodPost, reviewsPath, `{"rating":2,"text":"Too long."}`)
+	bob := decodeJSON[Review](t, rr)
+
+	got := decodeJSON[Book](t, doJSON(t, r, http.MethodGet, fmt.Sprintf("/books/%d", book.ID), ``))
+	if got.RatingCount != 2 || got.RatingAvg != 3.5 {
+		t.Fatalf("aggregates after two reviews: avg=%v count=%d", got.RatingAvg, got.RatingCount)
+	}
+
+	bobPath := fmt.Sprintf("%s/%d", reviewsPath, bob.ID)
+	if rr := doAs(t, r, "alice", http.MethodPut, bobPath, `{"rating":5}`); rr.Code != http.StatusForbidden {
+		t.Fatalf("editing someone else's review status %d", rr.Code)
+	}
+	if rr := doAs(t, r, "bob", http.MethodPut, bobPath, `{"rating":4,"text":"Grew on me."}`); rr.Code != http.StatusOK {
+		t.Fatalf("edit status %d body=%s", rr.Code, rr.Body.String())
+	}
+	got = decodeJSON[Book](t, doJSON(t, r, http.MethodGet, fmt.Sprintf("/books/%d", book.ID), ``))
+	if got.RatingCount != 2 || got.RatingAvg != 4.5 {
+		t.Fatalf("aggregates after edit: avg=%v count=%d", got.RatingAvg, got.RatingCount)
+	}
+
+	if list := decodeJSON[[]Review](t, doJSON(t, r, http.MethodGet, reviewsPath+"?min_rating=5", ``)); len(list) != 1 || list[0].ID != alice.ID {
+		t.Fatalf("min_rating filter: %+v", list)
+	}
+
+	if rr := doAs(t, r, "bob", http.MethodDelete, bobPath, ``); rr.Code != http.StatusNoContent {
+		t.Fatalf("delete status %d", rr.Code)
+	}
+	got = decodeJSON[Book](t, doJSON(t, r, http.MethodGet, fmt.Sprintf("/books/%d", book.ID), ``))
+	if got.RatingCount != 1 || got.RatingAvg != 5 {
+		t.Fatalf("aggregates after delete: avg=%v count=%d", got.RatingAvg, got.RatingCount)
+	}
+
+	// Deleting the book removes its reviews.
+	doJSON(t, r, http.MethodDelete, fmt.Sprintf("/books/%d", book.ID), ``)
+	if rr := doJSON(t, r, http.MethodGet, reviewsPath, ``); rr.Code != http.StatusNotFound {
+		t.Fatalf("reviews of a deleted book status %d", rr.Code)
+	}
+}
+
+func TestReviews_Validation(t *testing.T) {
+	r, cleanup := setupReviews(t)
+	defer cleanup()
+
+	book := decodeJSON[Book](t, doJSON(t, r, http.MethodPost, "/books", `{"title":"Dune","author":"Frank Herbert","year":1965}`))
+	reviewsPath := fmt.Sprintf("/books/%d/reviews", book.ID)
+
+	cases := []struct {
+		name   string
+		user   string
+		path   string
+		body   string
+		status int
+	}{
+		{"anonymous", "", reviewsPath, `{"rating":3}`, http.StatusUnauthorized},
+		{"rating too high", "alice", reviewsPath, `{"rating":6}`, http.StatusBadRequest},
+		{"rating missing", "alice", reviewsPath, `{"text":"hm"}`, http.StatusBadRequest},
+		{"unknown book", "alice", "/books/9999/reviews", `{"rating":3}`, http.StatusNotFound},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := doAs(t, r, tc.user, http.MethodPost, tc.path, tc.body)
+			if rr.Code != tc.status {
+				t.Fatalf("status got %d want %d body=%s", rr.Code, tc.status, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestBooks_SortAndFilterByRating(t *testing.T) {
+	r, cleanup := setupReviews(t)
+	defer cleanup()
+
+	ratings := map[string]int{"Dune": 4, "Emma": 5, "Ulysses": 2}
+	for _, title := range []string{"Dune", "Emma", "Ulysses", "Unreviewed"} {
+		b := decodeJSON[Book](t, doJSON(t, r, http.MethodPost, "/books", fmt.Sprintf(`{"title":%q,"author":"A","year":1900}`, title)))
+		if rating, ok := ratings[title]; ok {
+			doAs(t, r, "alice", http.MethodPost, fmt.Sprintf("/books/%d/reviews", b.ID), fmt.Sprintf(`{"rating":%d}`, rating))
+		}
+	}
+
+	books := decodeJSON[[]Book](t, doJSON(t, r, http.MethodGet, "/books?sort=rating", ``))
+	var titles []string
+	for _, b := range books {
+		titles = append(titles, b.Title)
+	}
+	if fmt.Sprint(titles) != "[Emma Dune Ulysses Unreviewed]" {
+		t.Fatalf("sort=rating order: %v", titles)
+	}
+
+	books = decodeJSON[[]Book](t, doJSON(t, r, http.MethodGet, "/books?min_rating=4", ``))
+	if len(books) != 2 || books[0].Title != "Dune" || books[1].Title != "Emma" {
+		t.Fatalf("min_rating=4: %+v", books)
+	}
+
+	if rr := doJSON(t, r, http.MethodGet, "/books?sort=pages", ``); rr.Code != http.StatusBadRequest {
+		t.Fatalf("unknown sort status %d", rr.Code)
+	}
+}
@@ -2,7 +2,6 @@ package main
 
 import (
 	"encoding/json"
-	"errors"
 	"net/http"
 	"net/url"
 	"strings"
@@ -19,6 +18,10 @@ type processURLResponse struct {
 
 type errorResponse struct {
 	Error string `json:"error"`
+	Code  string `json:"code"`
+	// Field and Offset locate the problem in a rejected request body.
+	Field  string `json:"field,omitempty"`
+	Offset int64  `json:"offset,omitempty"`
 }
 
 // ProcessURLHandler godoc
@@ -27,45 +30,57 @@ type errorResponse struct {
 // @Accept json
 // @Produce json
 // @Param payload body processURLRequest true "Payload"
+// @Param Idempotency-Key header string false "Retry-safe key; a repeat with the same body replays the first response"
 // @Success 200 {object} processURLResponse
 // @Failure 400 {object} errorResponse
+// @Failure 409 {object} errorResponse
+// @Failure 413 {object} errorResponse
+// @Failure 415 {object} errorResponse
+// @Failure 422 {object} errorResponse
 // @Router /process-url [post]
 func ProcessURLHandler(w http.ResponseWriter, r *http.Request) {
 	var req processURLRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "invalid JSON body"})
+	if !readJSON(w, r, &req) {
 		return
 	}
 
+	parsed, err := validateProcessURLRequest(&req)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, errorCode(err))
+		return
+	}
+
+	processed, err := processURL(parsed, req.Operation)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, errorCode(err))
+		return
+	}
+	loggerFrom(r.Context()).Debug("url processed", "operation", req.Operation, "host", parsed.Host)
+
+	writeJSON(w, http.StatusOK, processURLResponse{ProcessedURL: processed})
+}
+
+// validateProcessURLRequest trims req in place and checks it, returning the
+// parsed URL. Failures are *ValidationError.
+func validateProcessURLRequest(req *processURLRequest) (*url.URL, error) {
 	req.URL = strings.TrimSpace(req.URL)
 	req.Operation = strings.TrimSpace(req.Operation)
 
 	if req.URL == "" {
-		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "`url` is required"})
-		return
+		return nil, &ValidationError{Field: "url", Code: "url_required", Message: "`url` is required"}
 	}
 	if req.Operation == "" {
-		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "`operation` is required"})
-		return
+		return nil, &ValidationError{Field: "operation", Code: "operation_required", Message: "`operation` is required"}
 	}
 	if !isValidOperation(req.Operation) {
-		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "`operation` must be one of: canonical, redirection, all"})
-		return
+		return nil, &ValidationError{Field: "operation", Code: "operation_invalid", Message: "`operation` must be one of: canonical, redirection, all"}
 	}
 
 	parsed, err := url.Parse(req.URL)
 	if err != nil || parsed.Scheme == "" || parsed.Host == "" {
-		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "invalid URL (must include scheme and host)"})
-		return
+		return nil, &ValidationError{Field: "url", Code: "url_invalid", Message: "invalid URL (must include scheme and host)"}
 	}
-
-	processed, err := processURL(parsed, req.Operation)
-	if err != nil {
-		writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
-		return
-	}
-
-	writeJSON(w, http.StatusOK, processURLResponse{ProcessedURL: processed})
+	return parsed, nil
 }
 
 func processURL(u *url.URL, op string) (string, error) {
@@ -87,7 +102,7 @@ func processURL(u *url.URL, op string) (string, error) {
 		return out.String(), nil
 
 	default:
-		return "", errors.New("unsupported operation")
+		return "", &ValidationError{Field: "operation", Code: "operation_unsupported", Message: "unsupported operation"}
 	}
 }
 
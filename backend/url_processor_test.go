@@ -50,7 +50,7 @@ func TestProcessURLHandler_EdgeCases(t *testing.T) {
 			name:       "invalid json",
 			body:       `{"url":`,
 			wantStatus: http.StatusBadRequest,
-			wantErr:    "invalid JSON body",
+			wantErr:    "JSON body ends unexpectedly at offset 7",
 		},
 		{
 			name:       "missing url",
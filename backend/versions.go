@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// apiVersion is one major version of the HTTP API, served under /v{Number}.
+// Versions share routes, handlers and stores; what a version changes is
+// declared here, so a /v2 is an entry in apiVersions rather than a copy of
+// the router.
+type apiVersion struct {
+	Number int
+	// Book renders a book in this version's representation; nil sends Book
+	// as is. Every book in a response goes through it, via renderBook or
+	// renderBooks. Webhook payloads are not tied to a request and always
+	// carry the v1 Book.
+	Book func(Book) any
+}
+
+// apiVersions are the versions served, oldest first.
+var apiVersions = []apiVersion{
+	{Number: 1},
+}
+
+// The unversioned paths are aliases kept for clients written before /v1.
+var (
+	unversionedDeprecated = time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)
+	unversionedSunset     = time.Date(2027, 5, 1, 0, 0, 0, 0, time.UTC)
+)
+
+type apiVersionKey struct{}
+
+func withAPIVersion(ctx context.Context, v apiVersion) context.Context {
+	return context.WithValue(ctx, apiVersionKey{}, v)
+}
+
+// apiVersionFromContext returns the version a request is served as, v1 for
+// handlers called outside mountVersions.
+func apiVersionFromContext(ctx context.Context) apiVersion {
+	if v, ok := ctx.Value(apiVersionKey{}).(apiVersion); ok {
+		return v
+	}
+	return apiVersion{Number: 1}
+}
+
+// renderBook returns b as the request's API version represents it.
+func renderBook(r *http.Request, b Book) any {
+	if v := apiVersionFromContext(r.Context()); v.Book != nil {
+		return v.Book(b)
+	}
+	return b
+}
+
+// renderBooks is renderBook for a list.
+func renderBooks(r *http.Request, books []Book) any {
+	v := apiVersionFromContext(r.Context())
+	if v.Book == nil {
+		return books
+	}
+	out := make([]any, len(books))
+	for i, b := range books {
+		out[i] = v.Book(b)
+	}
+	return out
+}
+
+// versionedName suffixes a cache key name with the API version beyond v1,
+// so validators of different representations never match.
+func versionedName(r *http.Request, name string) string {
+	if n := apiVersionFromContext(r.Context()).Number; n != 1 {
+		return name + "-v" + strconv.Itoa(n)
+	}
+	return name
+}
+
+// acceptedVersion returns the version parameter of the first JSON media
+// range in an Accept header that has one, e.g.
+// "application/json; version=2" or "application/vnd.byfood+json; version=2".
+func acceptedVersion(header string) (int, bool) {
+	for _, part := range strings.Split(header, ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		if mt != "application/json" && !strings.HasSuffix(mt, "+json") && mt != "*/*" && mt != "application/*" {
+			continue
+		}
+		if raw, ok := params["version"]; ok {
+			n, err := strconv.Atoi(strings.TrimPrefix(raw, "v"))
+			return n, err == nil
+		}
+	}
+	return 0, false
+}
+
+// mountVersions serves routes under /v{n} for every version, and at the root
+// as a deprecated alias. A request may also ask for a version with an Accept
+// parameter: on the root it picks the version (default v1), under /v{n} it
+// must agree with the path. Anything else is 406.
+func mountVersions(r chi.Router, routes func(chi.Router), versions []apiVersion) {
+	for _, v := range versions {
+		v := v
+		r.Route("/v"+strconv.Itoa(v.Number), func(r chi.Router) {
+			r.Use(useAPIVersion(versions, &v))
+			routes(r)
+		})
+	}
+
+	r.Group(func(r chi.Router) {
+		r.Use(deprecatedAlias)
+		r.Use(useAPIVersion(versions, nil))
+		routes(r)
+	})
+}
+
+// useAPIVersion puts the request's version in its context. fixed is the
+// version named by the path, nil on the unversioned alias.
+func useAPIVersion(versions []apiVersion, fixed *apiVersion) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			v := versions[0]
+			if fixed != nil {
+				v = *fixed
+			}
+			if n, ok := acceptedVersion(r.Header.Get("Accept")); ok {
+				found := false
+				for _, candidate := range versions {
+					if candidate.Number == n && (fixed == nil || fixed.Number == n) {
+						v, found = candidate, true
+					}
+				}
+				if !found {
+					writeError(w, r, http.StatusNotAcceptable, "version_not_available")
+					return
+				}
+			}
+			if fixed == nil {
+				w.Header().Add("Vary", "Accept")
+			}
+			w.Header().Set("API-Version", strconv.Itoa(v.Number))
+			next.ServeHTTP(w, r.WithContext(withAPIVersion(r.Context(), v)))
+		})
+	}
+}
+
+// deprecatedAlias marks responses of the unversioned paths as deprecated
+// (RFC 9745) with a sunset date (RFC 8594), and points at the /v1 path.
+func deprecatedAlias(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Set("Deprecation", "@"+strconv.FormatInt(unversionedDeprecated.Unix(), 10))
+		h.Set("Sunset", unversionedSunset.Format(http.TimeFormat))
+		h.Add("Link", `</v1`+r.URL.Path+`>; rel="successor-version"`)
+		next.ServeHTTP(w, r)
+	})
+}
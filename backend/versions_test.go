@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// bookV2 is a stand-in for a future representation: an envelope with the
+// author nested.
+type bookV2 struct {
+	Data struct {
+		ID     int64  `json:"id"`
+		Title  string `json:"title"`
+		Author struct {
+			Name string `json:"name"`
+		} `json:"author"`
+	} `json:"data"`
+}
+
+// testVersions serves bookV2 as v2.
+var testVersions = []apiVersion{
+	{Number: 1},
+	{Number: 2, Book: func(b Book) any {
+		var v bookV2
+		v.Data.ID, v.Data.Title, v.Data.Author.Name = b.ID, b.Title, b.Author
+		return v
+	}},
+}
+
+func setupVersions(t *testing.T) (*chi.Mux, func()) {
+	t.Helper()
+
+	_, db := setupTestRouter(t)
+	api := NewBooksAPI(NewBookStore(db))
+
+	r := chi.NewRouter()
+	mountVersions(r, func(r chi.Router) {
+		r.Get("/books", api.GetBooksHandler)
+		r.Post("/books", api.CreateBookHandler)
+		r.Get("/books/duplicates", api.DuplicatesHandler)
+		r.Post("/books/merge", api.MergeBooksHandler)
+		r.Get("/books/{id}", api.GetBookHandler)
+	}, testVersions)
+	return r, func() { db.Close() }
+}
+
+func getWithAccept(t *testing.T, r http.Handler, path, accept string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestVersions_PathsAndDeprecatedAlias(t *testing.T) {
+	r, cleanup := setupVersions(t)
+	defer cleanup()
+
+	created := decodeJSON[Book](t, doJSON(t, r, http.MethodPost, "/v1/books", `{"title":"Dune","author":"Frank Herbert","year":1965}`))
+	path := "/books/" + strconv.FormatInt(created.ID, 10)
+
+	v1 := getWithAccept(t, r, "/v1"+path, "")
+	if v1.Code != http.StatusOK || decodeJSON[Book](t, v1).Author != "Frank Herbert" {
+		t.Fatalf("v1: %d %s", v1.Code, v1.Body.String())
+	}
+	if v1.Header().Get("Deprecation") != "" || v1.Header().Get("API-Version") != "1" {
+		t.Fatalf("v1 headers: %v", v1.Header())
+	}
+
+	v2 := getWithAccept(t, r, "/v2"+path, "")
+	if got := decodeJSON[bookV2](t, v2); got.Data.Author.Name != "Frank Herbert" || got.Data.ID != created.ID {
+		t.Fatalf("v2: %s", v2.Body.String())
+	}
+
+	alias := getWithAccept(t, r, path, "")
+	if alias.Code != http.StatusOK || alias.Body.String() != v1.Body.String() {
+		t.Fatalf("alias: %d %s", alias.Code, alias.Body.String())
+	}
+	h := alias.Header()
+	if h.Get("Deprecation") != "@1793491200" || h.Get("Sunset") != "Sat, 01 May 2027 00:00:00 GMT" {
+		t.Fatalf("deprecation headers: %v", h)
+	}
+	if want := `</v1` + path + `>; rel="successor-version"`; h.Get("Link") != want {
+		t.Fatalf("Link = %q, want %q", h.Get("Link"), want)
+	}
+
+	// the collection's validators differ per representation
+	list1 := getWithAccept(t, r, "/v1/books", "")
+	list2 := getWithAccept(t, r, "/v2/books", "")
+	if list1.Header().Get("ETag") == list2.Header().Get("ETag") {
+		t.Fatalf("v1 and v2 lists share ETag %s", list1.Header().Get("ETag"))
+	}
+	if got := decodeJSON[[]bookV2](t, list2); len(got) != 1 || got[0].Data.Title != "Dune" {
+		t.Fatalf("v2 list: %s", list2.Body.String())
+	}
+}
+
+func TestVersions_AcceptNegotiation(t *testing.T) {
+	r, cleanup := setupVersions(t)
+	defer cleanup()
+
+	created := decodeJSON[Book](t, doJSON(t, r, http.MethodPost, "/books", `{"title":"Dune","author":"Frank Herbert","year":1965}`))
+	path := "/books/" + strconv.FormatInt(created.ID, 10)
+
+	rr := getWithAccept(t, r, path, "application/vnd.byfood+json; version=2")
+	if got := decodeJSON[bookV2](t, rr); got.Data.Title != "Dune" {
+		t.Fatalf("alias with version=2: %s", rr.Body.String())
+	}
+	if rr.Header().Get("API-Version") != "2" || rr.Header().Get("Vary") != "Accept" {
+		t.Fatalf("headers: %v", rr.Header())
+	}
+
+	if rr := getWithAccept(t, r, "/v2"+path, "application/json; version=2"); rr.Code != http.StatusOK {
+		t.Fatalf("matching path and Accept: %d", rr.Code)
+	}
+	if rr := getWithAccept(t, r, "/v1"+path, "application/json;version=v1, */*;q=0.1"); rr.Code != http.StatusOK {
+		t.Fatalf("v-prefixed version: %d", rr.Code)
+	}
+
+	for _, tc := range []struct{ path, accept string }{
+		{"/v1" + path, "application/json; version=2"},
+		{path, "application/json; version=3"},
+	} {
+		rr := getWithAccept(t, r, tc.path, tc.accept)
+		if rr.Code != http.StatusNotAcceptable {
+			t.Fatalf("%s with %q: %d %s", tc.path, tc.accept, rr.Code, rr.Body.String())
+		}
+		if got := decodeJSON[errorResponse](t, rr); got.Code != "version_not_available" {
+			t.Fatalf("code = %q", got.Code)
+		}
+	}
+}
+
+func TestVersions_EmbeddedBooksAreRendered(t *testing.T) {
+	r, cleanup := setupVersions(t)
+	defer cleanup()
+
+	a := decodeJSON[Book](t, doJSON(t, r, http.MethodPost, "/v1/books", `{"title":"The Hobbit","author":"J.R.R. Tolkien","year":1937,"isbn":"9780547928227"}`))
+	b := decodeJSON[Book](t, doJSON(t, r, http.MethodPost, "/v1/books", `{"title":"Hobbit, The","author":"Tolkien","year":1966}`))
+
+	rr := doJSON(t, r, http.MethodPost, "/v2/books", `{"title":"Copy","author":"X","year":2000,"isbn":"9780547928227"}`)
+	exists := decodeJSON[struct {
+		Code     string `json:"code"`
+		Existing bookV2 `json:"existing"`
+	}](t, rr)
+	if rr.Code != http.StatusConflict || exists.Code != "isbn_exists" || exists.Existing.Data.ID != a.ID {
+		t.Fatalf("conflict: %d %s", rr.Code, rr.Body)
+	}
+
+	rr = getWithAccept(t, r, "/v2/books/duplicates", "")
+	clusters := decodeJSON[[]struct {
+		Score float64  `json:"score"`
+		Books []bookV2 `json:"books"`
+	}](t, rr)
+	if rr.Code != http.StatusOK || len(clusters) != 1 || len(clusters[0].Books) != 2 || clusters[0].Books[0].Data.Author.Name == "" {
+		t.Fatalf("duplicates: %d %s", rr.Code, rr.Body)
+	}
+
+	rr = doJSON(t, r, http.MethodPost, "/v2/books/merge", fmt.Sprintf(`{"book_ids":[%d,%d],"survivor_id":%d}`, a.ID, b.ID, a.ID))
+	merged := decodeJSON[struct {
+		Book      bookV2  `json:"book"`
+		MergedIDs []int64 `json:"merged_ids"`
+	}](t, rr)
+	if rr.Code != http.StatusOK || merged.Book.Data.ID != a.ID || len(merged.MergedIDs) != 1 {
+		t.Fatalf("merge: %d %s", rr.Code, rr.Body)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/v2/books/events", nil)
+	req = req.WithContext(withAPIVersion(req.Context(), testVersions[1]))
+	sse := httptest.NewRecorder()
+	if err := writeSSE(sse, req, BookEvent{ID: 7, Type: EventBookUpdated, Book: a}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(sse.Body.String(), `"book":{"data":{"id":`) {
+		t.Fatalf("event: %s", sse.Body)
+	}
+}
+
+func TestAcceptedVersion(t *testing.T) {
+	cases := []struct {
+		header string
+		want   int
+		ok     bool
+	}{
+		{"", 0, false},
+		{"application/json", 0, false},
+		{"text/html, application/json; version=2", 2, true},
+		{"application/problem+json;version=1", 1, true},
+		{"text/plain; version=2", 0, false},
+		{"application/json; version=two", 0, false},
+	}
+	for _, tc := range cases {
+		got, ok := acceptedVersion(tc.header)
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("acceptedVersion(%q) = %d, %v; want %d, %v", tc.header, got, ok, tc.want, tc.ok)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const (
+	signatureHeader = "X-Webhook-Signature"
+	timestampHeader = "X-Webhook-Timestamp"
+	eventHeader     = "X-Webhook-Event"
+	deliveryHeader  = "X-Webhook-Delivery"
+)
+
+// signPayload returns the value of the signature header: an HMAC-SHA256 over
+// "<timestamp>.<body>" keyed with the webhook secret.
+func signPayload(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// WebhookDispatcher moves outbox events into per-webhook deliveries and sends
+// them, retrying with exponential backoff until MaxAttempts is reached.
+type WebhookDispatcher struct {
+	store  *WebhookStore
+	client *http.Client
+	now    func() time.Time
+
+	MaxAttempts  int
+	BaseBackoff  time.Duration
+	MaxBackoff   time.Duration
+	PollInterval time.Duration
+	BatchSize    int
+}
+
+func NewWebhookDispatcher(store *WebhookStore) *WebhookDispatcher {
+	d := &WebhookDispatcher{
+		store:        store,
+		now:          func() time.Time { return time.Now().UTC() },
+		MaxAttempts:  8,
+		BaseBackoff:  10 * time.Second,
+		MaxBackoff:   time.Hour,
+		PollInterval: 2 * time.Second,
+		BatchSize:    50,
+	}
+
+	// Every connection, redirects included, is checked after DNS
+	// resolution, so a name that later resolves inside the network is still
+	// refused. No proxy: it would be the only address checked.
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = (&net.Dialer{Timeout: 5 * time.Second, Control: d.checkDial}).DialContext
+	d.client = &http.Client{Timeout: 10 * time.Second, Transport: transport}
+	return d
+}
+
+// checkDial refuses connections to addresses that are not public, unless
+// the store allows private webhooks.
+func (d *WebhookDispatcher) checkDial(network, address string, _ syscall.RawConn) error {
+	if d.store.AllowPrivate {
+		return nil
+	}
+	ap, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if !isPublicAddr(ap.Addr()) {
+		return fmt.Errorf("webhook destination %s is not a public address", ap.Addr())
+	}
+	return nil
+}
+
+// Run processes the queue every PollInterval until ctx is cancelled.
+func (d *WebhookDispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := d.ProcessOnce(ctx); err != nil && ctx.Err() == nil {
+			slog.Error("webhook dispatch", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ProcessOnce fans out new events and attempts every delivery that is due.
+func (d *WebhookDispatcher) ProcessOnce(ctx context.Context) error {
+	if _, err := d.store.fanOut(ctx, d.now(), d.BatchSize); err != nil {
+		return fmt.Errorf("fan out: %w", err)
+	}
+
+	due, err := d.store.dueDeliveries(ctx, d.now(), d.BatchSize)
+	if err != nil {
+		return fmt.Errorf("due deliveries: %w", err)
+	}
+
+	for _, pd := range due {
+		attempts := pd.Attempts + 1
+		sendErr := d.send(ctx, pd)
+		now := d.now()
+
+		if sendErr == nil {
+			if err := d.store.markDelivered(ctx, pd.ID, attempts, now); err != nil {
+				return err
+			}
+			continue
+		}
+
+		dead := attempts >= d.MaxAttempts
+		if err := d.store.markFailed(ctx, pd.ID, attempts, now.Add(d.backoff(attempts)), dead, sendErr.Error(), now); err != nil {
+			return err
+		}
+		slog.Warn("webhook delivery failed",
+			"delivery_id", pd.ID, "event_id", pd.Event.ID, "attempts", attempts, "dead", dead, "err", sendErr)
+	}
+	return nil
+}
+
+// backoff returns BaseBackoff * 2^(attempts-1), capped at MaxBackoff.
+func (d *WebhookDispatcher) backoff(attempts int) time.Duration {
+	wait := d.BaseBackoff
+	for i := 1; i < attempts; i++ {
+		wait *= 2
+		if wait >= d.MaxBackoff {
+			return d.MaxBackoff
+		}
+	}
+	return wait
+}
+
+func (d *WebhookDispatcher) send(ctx context.Context, pd pendingDelivery) error {
+	body, err := json.Marshal(pd.Event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, pd.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	ts := strconv.FormatInt(d.now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(eventHeader, pd.Event.Type)
+	req.Header.Set(deliveryHeader, strconv.FormatInt(pd.ID, 10))
+	req.Header.Set(timestampHeader, ts)
+	req.Header.Set(signatureHeader, signPayload(pd.Secret, ts, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+)
+
+type WebhooksAPI struct {
+	store *WebhookStore
+}
+
+func NewWebhooksAPI(store *WebhookStore) *WebhooksAPI {
+	return &WebhooksAPI{store: store}
+}
+
+// ListWebhooksHandler godoc
+// @Summary List webhook subscriptions
+// @Tags webhooks
+// @Produce json
+// @Success 200 {array} Webhook
+// @Failure 500 {object} errorResponse
+// @Router /webhooks [get]
+func (api *WebhooksAPI) ListWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	hooks, err := api.store.List(r.Context())
+	if err != nil {
+		loggerFrom(r.Context()).Error("list webhooks", "err", err)
+		writeError(w, r, http.StatusInternalServerError, "internal_error")
+		return
+	}
+	writeJSON(w, http.StatusOK, hooks)
+}
+
+// CreateWebhookHandler godoc
+// @Summary Subscribe a URL to book events
+// @Description Deliveries are signed with HMAC-SHA256 in the X-Webhook-Signature header. The secret is generated when omitted and only returned here. URLs on loopback, link-local or private networks are refused.
+// @Tags webhooks
+// @Accept json
+// @Produce json
+// @Param webhook body Webhook true "Webhook"
+// @Success 201 {object} Webhook
+// @Failure 400 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /webhooks [post]
+func (api *WebhooksAPI) CreateWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	var wh Webhook
+	if !readJSON(w, r, &wh) {
+		return
+	}
+
+	created, err := api.store.Create(r.Context(), wh)
+	var verr *ValidationError
+	if errors.As(err, &verr) {
+		writeError(w, r, http.StatusBadRequest, verr.Code)
+		return
+	}
+	if err != nil {
+		loggerFrom(r.Context()).Error("create webhook", "err", err)
+		writeError(w, r, http.StatusInternalServerError, "internal_error")
+		return
+	}
+	writeJSON(w, http.StatusCreated, created)
+}
+
+// GetWebhookHandler godoc
+// @Summary Get a webhook subscription by ID
+// @Tags webhooks
+// @Produce json
+// @Param id path int true "Webhook ID"
+// @Success 200 {object} Webhook
+// @Failure 400 {object} errorResponse
+// @Failure 404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /webhooks/{id} [get]
+func (api *WebhooksAPI) GetWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseIDParam(w, r, "id")
+	if !ok {
+		return
+	}
+	wh, err := api.store.Get(r.Context(), id)
+	if err == ErrNotFound {
+		writeError(w, r, http.StatusNotFound, "webhook_not_found")
+		return
+	}
+	if err != nil {
+		loggerFrom(r.Context()).Error("get webhook", "webhook_id", id, "err", err)
+		writeError(w, r, http.StatusInternalServerError, "internal_error")
+		return
+	}
+	writeJSON(w, http.StatusOK, wh)
+}
+
+// DeleteWebhookHandler godoc
+// @Summary Delete a webhook subscription
+// @Tags webhooks
+// @Param id path int true "Webhook ID"
+// @Success 204 "No Content"
+// @Failure 400 {object} errorResponse
+// @Failure 404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /webhooks/{id} [delete]
+func (api *WebhooksAPI) DeleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseIDParam(w, r, "id")
+	if !ok {
+		return
+	}
+	err := api.store.Delete(r.Context(), id)
+	if err == ErrNotFound {
+		writeError(w, r, http.StatusNotFound, "webhook_not_found")
+		return
+	}
+	if err != nil {
+		loggerFrom(r.Context()).Error("delete webhook", "webhook_id", id, "err", err)
+		writeError(w, r, http.StatusInternalServerError, "internal_error")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListDeadLettersHandler godoc
+// @Summary List deliveries that exhausted their retries
+// @Tags webhooks
+// @Produce json
+// @Success 200 {array} WebhookDelivery
+// @Failure 500 {object} errorResponse
+// @Router /webhooks/dead-letters [get]
+func (api *WebhooksAPI) ListDeadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	dead, err := api.store.DeadLetters(r.Context())
+	if err != nil {
+		loggerFrom(r.Context()).Error("list dead letters", "err", err)
+		writeError(w, r, http.StatusInternalServerError, "internal_error")
+		return
+	}
+	writeJSON(w, http.StatusOK, dead)
+}
+
+// ReplayDeadLetterHandler godoc
+// @Summary Re-queue a dead delivery
+// @Tags webhooks
+// @Produce json
+// @Param id path int true "Delivery ID"
+// @Success 202 {object} WebhookDelivery
+// @Failure 400 {object} errorResponse
+// @Failure 404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /webhooks/dead-letters/{id}/replay [post]
+func (api *WebhooksAPI) ReplayDeadLetterHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseIDParam(w, r, "id")
+	if !ok {
+		return
+	}
+	d, err := api.store.Replay(r.Context(), id)
+	if err == ErrNotFound {
+		writeError(w, r, http.StatusNotFound, "dead_letter_not_found")
+		return
+	}
+	if err != nil {
+		loggerFrom(r.Context()).Error("replay dead letter", "delivery_id", id, "err", err)
+		writeError(w, r, http.StatusInternalServerError, "internal_error")
+		return
+	}
+	writeJSON(w, http.StatusAccepted, d)
+}
@@ -0,0 +1,446 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	deliveryPending   = "pending"
+	deliveryDelivered = "delivered"
+	deliveryDead      = "dead"
+)
+
+// Webhook is a subscription to the book events of one workspace. An empty
+// EventTypes list subscribes to every event. Secret is only returned when the
+// webhook is created.
+type Webhook struct {
+	ID         int64     `json:"id"`
+	URL        string    `json:"url"`
+	Secret     string    `json:"secret,omitempty"`
+	EventTypes []string  `json:"event_types"`
+	CreatedAt  time.Time `json:"created_at"`
+
+	WorkspaceID int64 `json:"workspace_id"`
+}
+
+// WebhookDelivery tracks sending one event to one webhook.
+type WebhookDelivery struct {
+	ID            int64     `json:"id"`
+	WebhookID     int64     `json:"webhook_id"`
+	EventID       int64     `json:"event_id"`
+	EventType     string    `json:"event_type"`
+	Status        string    `json:"status"`
+	Attempts      int       `json:"attempts"`
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+	LastError     string    `json:"last_error"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// pendingDelivery is a due delivery with everything needed to send it.
+type pendingDelivery struct {
+	ID       int64
+	Attempts int
+	URL      string
+	Secret   string
+	Event    BookEvent
+}
+
+func validateWebhook(wh Webhook) error {
+	u, err := url.Parse(strings.TrimSpace(wh.URL))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return &ValidationError{Field: "url", Code: "webhook_url_invalid", Message: "url must be an absolute http(s) URL"}
+	}
+	for _, t := range wh.EventTypes {
+		if !isValidEventType(t) {
+			return &ValidationError{Field: "event_types", Code: "event_types_invalid", Message: "event_types must only contain: " + strings.Join(bookEventTypes, ", ")}
+		}
+	}
+	return nil
+}
+
+// errPrivateDestination refuses a webhook URL aimed inside the network.
+var errPrivateDestination = &ValidationError{Field: "url", Code: "webhook_url_private", Message: "url must not point to a loopback, link-local or private address"}
+
+// sharedAddressSpace is carrier-grade NAT space (RFC 6598), private in all
+// but name.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// isPublicAddr reports whether webhooks may be delivered to a.
+func isPublicAddr(a netip.Addr) bool {
+	a = a.Unmap()
+	return a.IsGlobalUnicast() && !a.IsPrivate() && !sharedAddressSpace.Contains(a)
+}
+
+// checkWebhookHost refuses a host that is, or resolves to, an address that
+// is not public. A name that does not resolve is let through: the
+// dispatcher checks the address of every connection it makes anyway, which
+// also covers names that resolve differently later.
+func checkWebhookHost(ctx context.Context, host string) error {
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if ip, perr := netip.ParseAddr(host); perr == nil {
+		addrs, err = []netip.Addr{ip}, nil
+	}
+	if err != nil {
+		return nil
+	}
+	for _, a := range addrs {
+		if !isPublicAddr(a) {
+			return errPrivateDestination
+		}
+	}
+	return nil
+}
+
+func (wh Webhook) wants(eventType string) bool {
+	if len(wh.EventTypes) == 0 {
+		return true
+	}
+	for _, t := range wh.EventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+func splitEventTypes(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(s, ",")
+}
+
+type WebhookStore struct {
+	db *sql.DB
+
+	// AllowPrivate accepts URLs on loopback and private networks, for
+	// local development and tests.
+	AllowPrivate bool
+}
+
+func NewWebhookStore(db *sql.DB) *WebhookStore {
+	return &WebhookStore{db: db}
+}
+
+func (s *WebhookStore) List(ctx context.Context) ([]Webhook, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id, url, event_types, created_at, workspace_id FROM webhooks WHERE workspace_id = ? ORDER BY id ASC`,
+		workspaceFromContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []Webhook
+	for rows.Next() {
+		var (
+			wh     Webhook
+			events string
+		)
+		if err := rows.Scan(&wh.ID, &wh.URL, &events, &wh.CreatedAt, &wh.WorkspaceID); err != nil {
+			return nil, err
+		}
+		wh.EventTypes = splitEventTypes(events)
+		out = append(out, wh)
+	}
+	return out, rows.Err()
+}
+
+func (s *WebhookStore) Get(ctx context.Context, id int64) (Webhook, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var (
+		wh     Webhook
+		events string
+	)
+	err := s.db.QueryRowContext(ctx,
+		`SELECT id, url, event_types, created_at, workspace_id FROM webhooks WHERE id = ? AND workspace_id = ?`,
+		id, workspaceFromContext(ctx),
+	).Scan(&wh.ID, &wh.URL, &events, &wh.CreatedAt, &wh.WorkspaceID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Webhook{}, ErrNotFound
+	}
+	wh.EventTypes = splitEventTypes(events)
+	return wh, err
+}
+
+// Create stores the webhook, generating a secret when none is given.
+func (s *WebhookStore) Create(ctx context.Context, wh Webhook) (Webhook, error) {
+	wh.URL = strings.TrimSpace(wh.URL)
+	if wh.EventTypes == nil {
+		wh.EventTypes = []string{}
+	}
+	if err := validateWebhook(wh); err != nil {
+		return Webhook{}, err
+	}
+	if wh.Secret == "" {
+		buf := make([]byte, 32)
+		if _, err := rand.Read(buf); err != nil {
+			return Webhook{}, err
+		}
+		wh.Secret = hex.EncodeToString(buf)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	if !s.AllowPrivate {
+		u, _ := url.Parse(wh.URL)
+		if err := checkWebhookHost(ctx, u.Hostname()); err != nil {
+			return Webhook{}, err
+		}
+	}
+
+	wh.CreatedAt = time.Now().UTC()
+	wh.WorkspaceID = workspaceFromContext(ctx)
+	res, err := s.db.ExecContext(ctx,
+		`INSERT INTO webhooks(url, secret, event_types, created_at, workspace_id) VALUES(?, ?, ?, ?, ?)`,
+		wh.URL, wh.Secret, strings.Join(wh.EventTypes, ","), wh.CreatedAt, wh.WorkspaceID,
+	)
+	if err != nil {
+		return Webhook{}, err
+	}
+	wh.ID, err = res.LastInsertId()
+	if err != nil {
+		return Webhook{}, err
+	}
+	return wh, nil
+}
+
+// Delete removes the webhook together with its delivery history.
+func (s *WebhookStore) Delete(ctx context.Context, id int64) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `DELETE FROM webhooks WHERE id = ? AND workspace_id = ?`, id, workspaceFromContext(ctx))
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM webhook_deliveries WHERE webhook_id = ?`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+const deliveryColumns = `d.id, d.webhook_id, d.event_id, e.event_type, d.status, d.attempts, d.next_attempt_at, d.last_error, d.updated_at`
+
+func scanDelivery(sc interface{ Scan(...any) error }) (WebhookDelivery, error) {
+	var d WebhookDelivery
+	err := sc.Scan(&d.ID, &d.WebhookID, &d.EventID, &d.EventType, &d.Status, &d.Attempts, &d.NextAttemptAt, &d.LastError, &d.UpdatedAt)
+	return d, err
+}
+
+// DeadLetters lists deliveries that exhausted their retries.
+func (s *WebhookStore) DeadLetters(ctx context.Context) ([]WebhookDelivery, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+deliveryColumns+`
+		FROM webhook_deliveries d JOIN outbox_events e ON e.id = d.event_id
+		JOIN webhooks w ON w.id = d.webhook_id
+		WHERE d.status = ? AND w.workspace_id = ? ORDER BY d.id ASC`, deliveryDead, workspaceFromContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []WebhookDelivery
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
+
+// Replay moves a dead delivery back into the queue with a fresh retry budget.
+func (s *WebhookStore) Replay(ctx context.Context, id int64) (WebhookDelivery, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	now := time.Now().UTC()
+	res, err := s.db.ExecContext(ctx,
+		`UPDATE webhook_deliveries SET status = ?, attempts = 0, next_attempt_at = ?, updated_at = ?
+		WHERE id = ? AND status = ? AND webhook_id IN (SELECT id FROM webhooks WHERE workspace_id = ?)`,
+		deliveryPending, now, now, id, deliveryDead, workspaceFromContext(ctx),
+	)
+	if err != nil {
+		return WebhookDelivery{}, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return WebhookDelivery{}, err
+	}
+	if n == 0 {
+		return WebhookDelivery{}, ErrNotFound
+	}
+
+	return scanDelivery(s.db.QueryRowContext(ctx, `
+		SELECT `+deliveryColumns+`
+		FROM webhook_deliveries d JOIN outbox_events e ON e.id = d.event_id
+		WHERE d.id = ?`, id))
+}
+
+// fanOut turns undispatched outbox events into one pending delivery per
+// matching webhook of the event's workspace and marks the events dispatched.
+func (s *WebhookStore) fanOut(ctx context.Context, now time.Time, limit int) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	hooks, err := func() ([]Webhook, error) {
+		rows, err := tx.QueryContext(ctx, `SELECT id, event_types, workspace_id FROM webhooks`)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		var out []Webhook
+		for rows.Next() {
+			var (
+				wh     Webhook
+				events string
+			)
+			if err := rows.Scan(&wh.ID, &events, &wh.WorkspaceID); err != nil {
+				return nil, err
+			}
+			wh.EventTypes = splitEventTypes(events)
+			out = append(out, wh)
+		}
+		return out, rows.Err()
+	}()
+	if err != nil {
+		return 0, err
+	}
+
+	type outboxEvent struct {
+		id        int64
+		eventType string
+		workspace int64
+	}
+	events, err := func() ([]outboxEvent, error) {
+		rows, err := tx.QueryContext(ctx,
+			`SELECT id, event_type, workspace_id FROM outbox_events WHERE dispatched_at IS NULL ORDER BY id ASC LIMIT ?`, limit)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		var out []outboxEvent
+		for rows.Next() {
+			var ev outboxEvent
+			if err := rows.Scan(&ev.id, &ev.eventType, &ev.workspace); err != nil {
+				return nil, err
+			}
+			out = append(out, ev)
+		}
+		return out, rows.Err()
+	}()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, ev := range events {
+		for _, wh := range hooks {
+			if wh.WorkspaceID != ev.workspace || !wh.wants(ev.eventType) {
+				continue
+			}
+			if _, err := tx.ExecContext(ctx, `
+				INSERT OR IGNORE INTO webhook_deliveries(webhook_id, event_id, status, next_attempt_at, updated_at)
+				VALUES(?, ?, ?, ?, ?)`,
+				wh.ID, ev.id, deliveryPending, now, now,
+			); err != nil {
+				return 0, err
+			}
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE outbox_events SET dispatched_at = ? WHERE id = ?`, now, ev.id); err != nil {
+			return 0, err
+		}
+	}
+	return len(events), tx.Commit()
+}
+
+// dueDeliveries returns pending deliveries whose next attempt is due.
+func (s *WebhookStore) dueDeliveries(ctx context.Context, now time.Time, limit int) ([]pendingDelivery, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT d.id, d.attempts, w.url, w.secret, e.id, e.event_type, e.created_at, e.payload
+		FROM webhook_deliveries d
+		JOIN webhooks w ON w.id = d.webhook_id
+		JOIN outbox_events e ON e.id = d.event_id
+		WHERE d.status = ? AND d.next_attempt_at <= ?
+		ORDER BY d.next_attempt_at ASC, d.id ASC
+		LIMIT ?`, deliveryPending, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []pendingDelivery
+	for rows.Next() {
+		var (
+			pd      pendingDelivery
+			payload string
+		)
+		if err := rows.Scan(&pd.ID, &pd.Attempts, &pd.URL, &pd.Secret,
+			&pd.Event.ID, &pd.Event.Type, &pd.Event.OccurredAt, &payload); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(payload), &pd.Event.Book); err != nil {
+			return nil, err
+		}
+		out = append(out, pd)
+	}
+	return out, rows.Err()
+}
+
+func (s *WebhookStore) markDelivered(ctx context.Context, id int64, attempts int, now time.Time) error {
+	_, err := s.db.ExecContext(ctx,
+		`UPDATE webhook_deliveries SET status = ?, attempts = ?, last_error = '', updated_at = ? WHERE id = ?`,
+		deliveryDelivered, attempts, now, id,
+	)
+	return err
+}
+
+// markFailed records a failed attempt and either schedules the next one or,
+// when dead is set, moves the delivery to the dead-letter list.
+func (s *WebhookStore) markFailed(ctx context.Context, id int64, attempts int, next time.Time, dead bool, reason string, now time.Time) error {
+	status := deliveryPending
+	if dead {
+		status = deliveryDead
+	}
+	_, err := s.db.ExecContext(ctx,
+		`UPDATE webhook_deliveries SET status = ?, attempts = ?, next_attempt_at = ?, last_error = ?, updated_at = ? WHERE id = ?`,
+		status, attempts, next, reason, now, id,
+	)
+	return err
+}
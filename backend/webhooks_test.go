@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type receivedHook struct {
+	event     BookEvent
+	signature string
+	timestamp string
+	body      []byte
+}
+
+// hookSink is a webhook receiver that answers with status until changed.
+type hookSink struct {
+	mu       sync.Mutex
+	status   int
+	received []receivedHook
+}
+
+func (s *hookSink) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	var ev BookEvent
+	_ = json.Unmarshal(body, &ev)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.received = append(s.received, receivedHook{
+		event:     ev,
+		signature: r.Header.Get(signatureHeader),
+		timestamp: r.Header.Get(timestampHeader),
+		body:      body,
+	})
+	w.WriteHeader(s.status)
+}
+
+func setupWebhooks(t *testing.T) (*chi.Mux, *WebhookDispatcher, func()) {
+	t.Helper()
+
+	r, db := setupTestRouter(t)
+	store := NewWebhookStore(db)
+	store.AllowPrivate = true // the sinks listen on loopback
+	api := NewWebhooksAPI(store)
+	r.Route("/webhooks", func(r chi.Router) {
+		r.Get("/", api.ListWebhooksHandler)
+		r.Post("/", api.CreateWebhookHandler)
+		r.Get("/dead-letters", api.ListDeadLettersHandler)
+		r.Post("/dead-letters/{id}/replay", api.ReplayDeadLetterHandler)
+		r.Get("/{id}", api.GetWebhookHandler)
+		r.Delete("/{id}", api.DeleteWebhookHandler)
+	})
+
+	return r, NewWebhookDispatcher(store), func() { db.Close() }
+}
+
+func TestWebhooks_SignedDeliveryWithEventFilter(t *testing.T) {
+	r, dispatcher, cleanup := setupWebhooks(t)
+	defer cleanup()
+
+	sink := &hookSink{status: http.StatusOK}
+	srv := httptest.NewServer(sink)
+	defer srv.Close()
+
+	rr := doJSON(t, r, http.MethodPost, "/webhooks",
+		fmt.Sprintf(`{"url":%q,"secret":"s3cret","event_types":["book.created","book.deleted"]}`, srv.URL))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("create webhook status %d body=%s", rr.Code, rr.Body.String())
+	}
+
+	rr = doJSON(t, r, http.MethodPost, "/books", `{"title":"Dune","author":"Frank Herbert","year":1965}`)
+	book := decodeJSON[Book](t, rr)
+	path := fmt.Sprintf("/books/%d", book.ID)
+	doJSON(t, r, http.MethodPut, path, `{"title":"Dune Messiah","author":"Frank Herbert","year":1969}`)
+	doJSON(t, r, http.MethodDelete, path, ``)
+
+	if err := dispatcher.ProcessOnce(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sink.received) != 2 {
+		t.Fatalf("expected 2 deliveries (update filtered out), got %d", len(sink.received))
+	}
+	if sink.received[0].event.Type != EventBookCreated || sink.received[1].event.Type != EventBookDeleted {
+		t.Fatalf("unexpected event order: %s, %s", sink.received[0].event.Type, sink.received[1].event.Type)
+	}
+	if sink.received[1].event.Book.Title != "Dune Messiah" {
+		t.Fatalf("delete event should carry the last state: %+v", sink.received[1].event.Book)
+	}
+	for _, got := range sink.received {
+		if want := signPayload("s3cret", got.timestamp, got.body); got.signature != want {
+			t.Fatalf("signature got %q want %q", got.signature, want)
+		}
+	}
+
+	// Nothing is delivered twice.
+	if err := dispatcher.ProcessOnce(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	if len(sink.received) != 2 {
+		t.Fatalf("redelivered: %d", len(sink.received))
+	}
+}
+
+func TestWebhooks_RetryDeadLetterAndReplay(t *testing.T) {
+	r, dispatcher, cleanup := setupWebhooks(t)
+	defer cleanup()
+
+	sink := &hookSink{status: http.StatusInternalServerError}
+	srv := httptest.NewServer(sink)
+	defer srv.Close()
+
+	now := time.Now().UTC()
+	dispatcher.now = func() time.Time { return now }
+	dispatcher.MaxAttempts = 3
+
+	doJSON(t, r, http.MethodPost, "/webhooks", fmt.Sprintf(`{"url":%q}`, srv.URL))
+	doJSON(t, r, http.MethodPost, "/books", `{"title":"Dune","author":"Frank Herbert","year":1965}`)
+
+	if err := dispatcher.ProcessOnce(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	// Not due yet: backoff has not elapsed.
+	if err := dispatcher.ProcessOnce(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	if len(sink.received) != 1 {
+		t.Fatalf("expected 1 attempt before backoff elapsed, got %d", len(sink.received))
+	}
+
+	for i := 0; i < 2; i++ {
+		now = now.Add(dispatcher.MaxBackoff)
+		if err := dispatcher.ProcessOnce(t.Context()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(sink.received) != 3 {
+		t.Fatalf("expected 3 attempts, got %d", len(sink.received))
+	}
+
+	rr := doJSON(t, r, http.MethodGet, "/webhooks/dead-letters", ``)
+	dead := decodeJSON[[]WebhookDelivery](t, rr)
+	if len(dead) != 1 || dead[0].Attempts != 3 || dead[0].LastError == "" {
+		t.Fatalf("unexpected dead letters: %+v", dead)
+	}
+
+	replayPath := fmt.Sprintf("/webhooks/dead-letters/%d/replay", dead[0].ID)
+	sink.status = http.StatusNoContent
+	rr = doJSON(t, r, http.MethodPost, replayPath, ``)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("replay status %d body=%s", rr.Code, rr.Body.String())
+	}
+	if err := dispatcher.ProcessOnce(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	if len(sink.received) != 4 {
+		t.Fatalf("expected replayed delivery, got %d attempts", len(sink.received))
+	}
+
+	rr = doJSON(t, r, http.MethodGet, "/webhooks/dead-letters", ``)
+	if dead := decodeJSON[[]WebhookDelivery](t, rr); len(dead) != 0 {
+		t.Fatalf("dead letter not cleared: %+v", dead)
+	}
+
+	// Replaying something that is not dead is a 404.
+	rr = doJSON(t, r, http.MethodPost, replayPath, ``)
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("replay of delivered status %d", rr.Code)
+	}
+}
+
+func TestWebhooks_CreateValidation(t *testing.T) {
+	r, _, cleanup := setupWebhooks(t)
+	defer cleanup()
+
+	cases := []struct {
+		name string
+		body string
+	}{
+		{"missing url", `{}`},
+		{"relative url", `{"url":"/hook"}`},
+		{"bad scheme", `{"url":"ftp://example.com/hook"}`},
+		{"unknown event", `{"url":"https://example.com/hook","event_types":["book.read"]}`},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := doJSON(t, r, http.MethodPost, "/webhooks", tc.body)
+			if rr.Code != http.StatusBadRequest {
+				t.Fatalf("status got %d body=%s", rr.Code, rr.Body.String())
+			}
+		})
+	}
+
+	rr := doJSON(t, r, http.MethodPost, "/webhooks", `{"url":"https://example.com/hook"}`)
+	created := decodeJSON[Webhook](t, rr)
+	if created.Secret == "" {
+		t.Fatal("expected generated secret")
+	}
+	rr = doJSON(t, r, http.MethodGet, fmt.Sprintf("/webhooks/%d", created.ID), ``)
+	if got := decodeJSON[Webhook](t, rr); got.Secret != "" || got.URL != created.URL {
+		t.Fatalf("unexpected webhook on read: %+v", got)
+	}
+}
+
+func TestWebhooks_RefusePrivateDestinations(t *testing.T) {
+	r, db := setupTestRouter(t)
+	defer db.Close()
+	store := NewWebhookStore(db)
+	r.Post("/webhooks", NewWebhooksAPI(store).CreateWebhookHandler)
+
+	for _, url := range []string{
+		"http://127.0.0.1/hook",
+		"http://localhost:8080/hook",
+		"http://10.0.0.5/hook",
+		"http://192.168.1.10/hook",
+		"http://169.254.169.254/latest/meta-data",
+		"http://100.64.0.1/hook",
+		"http://0.0.0.0/hook",
+		"http://[::1]/hook",
+		"http://[fe80::1]/hook",
+		"http://[fd00::1]/hook",
+		"http://[::ffff:127.0.0.1]/hook",
+	} {
+		rr := doJSON(t, r, http.MethodPost, "/webhooks", fmt.Sprintf(`{"url":%q}`, url))
+		if rr.Code != http.StatusBadRequest || decodeJSON[errorResponse](t, rr).Code != "webhook_url_private" {
+			t.Fatalf("%s: status %d body=%s", url, rr.Code, rr.Body.String())
+		}
+	}
+
+	// A name that resolved to a public address when the webhook was created
+	// may point inside later; the dispatcher checks where it connects.
+	sink := &hookSink{status: http.StatusOK}
+	srv := httptest.NewServer(sink)
+	defer srv.Close()
+	store.AllowPrivate = true
+	if _, err := store.Create(t.Context(), Webhook{URL: srv.URL}); err != nil {
+		t.Fatal(err)
+	}
+	store.AllowPrivate = false
+
+	dispatcher := NewWebhookDispatcher(store)
+	dispatcher.MaxAttempts = 1
+	if _, err := NewBookStore(db).Create(t.Context(), Book{Title: "Dune", Author: "Frank Herbert", Year: 1965}); err != nil {
+		t.Fatal(err)
+	}
+	if err := dispatcher.ProcessOnce(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	if len(sink.received) != 0 {
+		t.Fatalf("delivered to loopback: %d", len(sink.received))
+	}
+	dead, err := store.DeadLetters(t.Context())
+	if err != nil || len(dead) != 1 || !strings.Contains(dead[0].LastError, "not a public address") {
+		t.Fatalf("dead letters: %+v %v", dead, err)
+	}
+}
+
+func TestWebhooks_StoreFailureIs500(t *testing.T) {
+	r, _, cleanup := setupWebhooks(t)
+	cleanup()
+
+	rr := doJSON(t, r, http.MethodPost, "/webhooks", `{"url":"https://93.184.215.14/hook"}`)
+	if rr.Code != http.StatusInternalServerError || decodeJSON[errorResponse](t, rr).Code != "internal_error" {
+		t.Fatalf("status %d body=%s", rr.Code, rr.Body.String())
+	}
+}
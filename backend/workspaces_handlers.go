@@ -0,0 +1,222 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type WorkspacesAPI struct {
+	store *WorkspaceStore
+}
+
+func NewWorkspacesAPI(store *WorkspaceStore) *WorkspacesAPI {
+	return &WorkspacesAPI{store: store}
+}
+
+// writeWorkspaceError maps store errors shared by the workspace handlers.
+func writeWorkspaceError(w http.ResponseWriter, r *http.Request, op string, err error) {
+	var verr *ValidationError
+	switch {
+	case errors.As(err, &verr):
+		writeError(w, r, http.StatusBadRequest, verr.Code)
+	case err == ErrWorkspaceNotFound:
+		writeError(w, r, http.StatusNotFound, errorCode(err))
+	case err == ErrWorkspaceExists:
+		writeError(w, r, http.StatusConflict, errorCode(err))
+	case err == ErrDefaultWorkspace:
+		writeError(w, r, http.StatusConflict, errorCode(err))
+	default:
+		loggerFrom(r.Context()).Error(op, "err", err)
+		writeError(w, r, http.StatusInternalServerError, "internal_error")
+	}
+}
+
+// ListWorkspacesHandler godoc
+// @Summary List workspaces, archived ones included
+// @Tags admin
+// @Produce json
+// @Param X-User header string true "Admin user"
+// @Success 200 {array} Workspace
+// @Failure 401 {object} errorResponse
+// @Failure 403 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /admin/workspaces [get]
+func (api *WorkspacesAPI) ListWorkspacesHandler(w http.ResponseWriter, r *http.Request) {
+	list, err := api.store.List(r.Context())
+	if err != nil {
+		writeWorkspaceError(w, r, "list workspaces", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// CreateWorkspaceHandler godoc
+// @Summary Create a workspace
+// @Description `slug` is what clients send in X-Workspace. `max_books` caps the number of books (0 for no limit); `members` are the users allowed in.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param workspace body Workspace true "Workspace"
+// @Param X-User header string true "Admin user"
+// @Success 201 {object} Workspace
+// @Failure 400 {object} errorResponse
+// @Failure 401 {object} errorResponse
+// @Failure 403 {object} errorResponse
+// @Failure 409 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /admin/workspaces [post]
+func (api *WorkspacesAPI) CreateWorkspaceHandler(w http.ResponseWriter, r *http.Request) {
+	var ws Workspace
+	if !readJSON(w, r, &ws) {
+		return
+	}
+	created, err := api.store.Create(r.Context(), ws)
+	if err != nil {
+		writeWorkspaceError(w, r, "create workspace", err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, created)
+}
+
+// GetWorkspaceHandler godoc
+// @Summary Get a workspace by ID
+// @Tags admin
+// @Produce json
+// @Param id path int true "Workspace ID"
+// @Param X-User header string true "Admin user"
+// @Success 200 {object} Workspace
+// @Failure 400 {object} errorResponse
+// @Failure 401 {object} errorResponse
+// @Failure 403 {object} errorResponse
+// @Failure 404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /admin/workspaces/{id} [get]
+func (api *WorkspacesAPI) GetWorkspaceHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseIDParam(w, r, "id")
+	if !ok {
+		return
+	}
+	ws, err := api.store.Get(r.Context(), id)
+	if err != nil {
+		writeWorkspaceError(w, r, "get workspace", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, ws)
+}
+
+// UpdateWorkspaceHandler godoc
+// @Summary Rename a workspace or change its book quota
+// @Description Lowering `max_books` below the current count keeps the books but refuses new ones.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path int true "Workspace ID"
+// @Param patch body WorkspacePatch true "Fields to change"
+// @Param X-User header string true "Admin user"
+// @Success 200 {object} Workspace
+// @Failure 400 {object} errorResponse
+// @Failure 401 {object} errorResponse
+// @Failure 403 {object} errorResponse
+// @Failure 404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /admin/workspaces/{id} [patch]
+func (api *WorkspacesAPI) UpdateWorkspaceHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseIDParam(w, r, "id")
+	if !ok {
+		return
+	}
+	var p WorkspacePatch
+	if !readJSON(w, r, &p) {
+		return
+	}
+	ws, err := api.store.Update(r.Context(), id, p)
+	if err != nil {
+		writeWorkspaceError(w, r, "update workspace", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, ws)
+}
+
+// ArchiveWorkspaceHandler godoc
+// @Summary Archive a workspace
+// @Description Its data is kept, but requests for it answer 410. The default workspace cannot be archived.
+// @Tags admin
+// @Produce json
+// @Param id path int true "Workspace ID"
+// @Param X-User header string true "Admin user"
+// @Success 200 {object} Workspace
+// @Failure 400 {object} errorResponse
+// @Failure 401 {object} errorResponse
+// @Failure 403 {object} errorResponse
+// @Failure 404 {object} errorResponse
+// @Failure 409 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /admin/workspaces/{id}/archive [post]
+func (api *WorkspacesAPI) ArchiveWorkspaceHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseIDParam(w, r, "id")
+	if !ok {
+		return
+	}
+	ws, err := api.store.Archive(r.Context(), id)
+	if err != nil {
+		writeWorkspaceError(w, r, "archive workspace", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, ws)
+}
+
+// AddMemberHandler godoc
+// @Summary Add a user to a workspace
+// @Tags admin
+// @Produce json
+// @Param id path int true "Workspace ID"
+// @Param user path string true "User"
+// @Param X-User header string true "Admin user"
+// @Success 200 {object} Workspace
+// @Failure 400 {object} errorResponse
+// @Failure 401 {object} errorResponse
+// @Failure 403 {object} errorResponse
+// @Failure 404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /admin/workspaces/{id}/members/{user} [put]
+func (api *WorkspacesAPI) AddMemberHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseIDParam(w, r, "id")
+	if !ok {
+		return
+	}
+	ws, err := api.store.AddMember(r.Context(), id, chi.URLParam(r, "user"))
+	if err != nil {
+		writeWorkspaceError(w, r, "add workspace member", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, ws)
+}
+
+// RemoveMemberHandler godoc
+// @Summary Remove a user from a workspace
+// @Tags admin
+// @Produce json
+// @Param id path int true "Workspace ID"
+// @Param user path string true "User"
+// @Param X-User header string true "Admin user"
+// @Success 200 {object} Workspace
+// @Failure 400 {object} errorResponse
+// @Failure 401 {object} errorResponse
+// @Failure 403 {object} errorResponse
+// @Failure 404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /admin/workspaces/{id}/members/{user} [delete]
+func (api *WorkspacesAPI) RemoveMemberHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseIDParam(w, r, "id")
+	if !ok {
+		return
+	}
+	ws, err := api.store.RemoveMember(r.Context(), id, chi.URLParam(r, "user"))
+	if err != nil {
+		writeWorkspaceError(w, r, "remove workspace member", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, ws)
+}
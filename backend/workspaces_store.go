@@ -0,0 +1,411 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// workspaceHeader picks one of the caller's workspaces by slug.
+const workspaceHeader = "X-Workspace"
+
+// DefaultWorkspaceID is the workspace every book belonged to before
+// workspaces existed. It is open to all callers and cannot be archived.
+const DefaultWorkspaceID int64 = 1
+
+var (
+	ErrWorkspaceNotFound = newCodedError("workspace_not_found")
+	ErrWorkspaceArchived = newCodedError("workspace_archived")
+	ErrWorkspaceExists   = newCodedError("workspace_exists")
+	ErrNotMember         = newCodedError("not_member")
+	ErrWorkspaceRequired = newCodedError("workspace_required")
+	ErrDefaultWorkspace  = newCodedError("default_workspace")
+	ErrBookQuota         = newCodedError("book_quota_reached")
+)
+
+var workspaceSlug = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,62}$`)
+
+// Workspace is a separate catalog within one deployment. Books, and
+// everything hanging off them, belong to exactly one workspace.
+type Workspace struct {
+	ID   int64  `json:"id"`
+	Slug string `json:"slug" example:"acme"`
+	Name string `json:"name" example:"Acme Corp"`
+
+	// MaxBooks caps the number of books; 0 means no limit.
+	MaxBooks int `json:"max_books"`
+	Books    int `json:"books"`
+
+	Members    []string   `json:"members"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ArchivedAt *time.Time `json:"archived_at,omitempty"`
+}
+
+// WorkspacePatch changes a workspace; nil fields are left alone.
+type WorkspacePatch struct {
+	Name     *string `json:"name,omitempty"`
+	MaxBooks *int    `json:"max_books,omitempty"`
+}
+
+type workspaceCtxKey struct{}
+
+func withWorkspace(ctx context.Context, id int64) context.Context {
+	return context.WithValue(ctx, workspaceCtxKey{}, id)
+}
+
+// workspaceFromContext returns the workspace the request was resolved to.
+// Work done outside a request, such as the CLI, uses the default workspace.
+func workspaceFromContext(ctx context.Context) int64 {
+	if id, ok := ctx.Value(workspaceCtxKey{}).(int64); ok {
+		return id
+	}
+	return DefaultWorkspaceID
+}
+
+type WorkspaceStore struct {
+	db *sql.DB
+}
+
+func NewWorkspaceStore(db *sql.DB) *WorkspaceStore {
+	return &WorkspaceStore{db: db}
+}
+
+func validateWorkspace(ws Workspace) error {
+	if !workspaceSlug.MatchString(ws.Slug) {
+		return &ValidationError{Field: "slug", Code: "slug_invalid", Message: "slug must be 2-63 lowercase letters, digits or hyphens"}
+	}
+	if strings.TrimSpace(ws.Name) == "" {
+		return &ValidationError{Field: "name", Code: "name_required", Message: "name is required"}
+	}
+	if ws.MaxBooks < 0 {
+		return &ValidationError{Field: "max_books", Code: "max_books_invalid", Message: "max_books must be >= 0"}
+	}
+	for _, m := range ws.Members {
+		if strings.TrimSpace(m) == "" {
+			return &ValidationError{Field: "members", Code: "members_required", Message: "members must not be empty"}
+		}
+	}
+	return nil
+}
+
+const workspaceColumns = `id, slug, name, max_books, created_at, archived_at,
+	(SELECT COUNT(*) FROM books WHERE workspace_id = workspaces.id)`
+
+func scanWorkspace(row rowScanner) (Workspace, error) {
+	var ws Workspace
+	err := row.Scan(&ws.ID, &ws.Slug, &ws.Name, &ws.MaxBooks, &ws.CreatedAt, &ws.ArchivedAt, &ws.Books)
+	return ws, err
+}
+
+func (s *WorkspaceStore) loadMembers(ctx context.Context, q queryRower, ws *Workspace) error {
+	var members string
+	if err := q.QueryRowContext(ctx,
+		`SELECT json_group_array(user) FROM (SELECT user FROM workspace_members WHERE workspace_id = ? ORDER BY user)`, ws.ID,
+	).Scan(&members); err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(members), &ws.Members)
+}
+
+// Create adds a workspace with its initial members.
+func (s *WorkspaceStore) Create(ctx context.Context, ws Workspace) (Workspace, error) {
+	ws.Slug = strings.ToLower(strings.TrimSpace(ws.Slug))
+	ws.Name = strings.TrimSpace(ws.Name)
+	if err := validateWorkspace(ws); err != nil {
+		return Workspace{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Workspace{}, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO workspaces(slug, name, max_books, created_at) VALUES(?, ?, ?, ?) RETURNING id`,
+		ws.Slug, ws.Name, ws.MaxBooks, time.Now().UTC(),
+	).Scan(&ws.ID)
+	if isUniqueViolation(err) {
+		return Workspace{}, ErrWorkspaceExists
+	}
+	if err != nil {
+		return Workspace{}, err
+	}
+	for _, m := range ws.Members {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT OR IGNORE INTO workspace_members(workspace_id, user) VALUES(?, ?)`, ws.ID, strings.TrimSpace(m),
+		); err != nil {
+			return Workspace{}, err
+		}
+	}
+
+	created, err := s.get(ctx, tx, ws.ID)
+	if err != nil {
+		return Workspace{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Workspace{}, err
+	}
+	loggerFrom(ctx).Info("workspace created", "workspace_id", ws.ID, "slug", ws.Slug)
+	return created, nil
+}
+
+func (s *WorkspaceStore) get(ctx context.Context, q queryRower, id int64) (Workspace, error) {
+	ws, err := scanWorkspace(q.QueryRowContext(ctx, `SELECT `+workspaceColumns+` FROM workspaces WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Workspace{}, ErrWorkspaceNotFound
+	}
+	if err != nil {
+		return Workspace{}, err
+	}
+	return ws, s.loadMembers(ctx, q, &ws)
+}
+
+func (s *WorkspaceStore) Get(ctx context.Context, id int64) (Workspace, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	return s.get(ctx, s.db, id)
+}
+
+// List returns every workspace, archived ones included, by id.
+func (s *WorkspaceStore) List(ctx context.Context) ([]Workspace, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `SELECT `+workspaceColumns+` FROM workspaces ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	out := []Workspace{}
+	for rows.Next() {
+		ws, err := scanWorkspace(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		out = append(out, ws)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i := range out {
+		if err := s.loadMembers(ctx, s.db, &out[i]); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// Update renames a workspace or changes its quota. Lowering the quota below
+// the current number of books only stops new books from being added.
+func (s *WorkspaceStore) Update(ctx context.Context, id int64, p WorkspacePatch) (Workspace, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	ws, err := s.get(ctx, s.db, id)
+	if err != nil {
+		return Workspace{}, err
+	}
+	if p.Name != nil {
+		ws.Name = strings.TrimSpace(*p.Name)
+	}
+	if p.MaxBooks != nil {
+		ws.MaxBooks = *p.MaxBooks
+	}
+	if err := validateWorkspace(ws); err != nil {
+		return Workspace{}, err
+	}
+	if _, err := s.db.ExecContext(ctx,
+		`UPDATE workspaces SET name = ?, max_books = ? WHERE id = ?`, ws.Name, ws.MaxBooks, id,
+	); err != nil {
+		return Workspace{}, err
+	}
+	return ws, nil
+}
+
+// Archive closes a workspace. Its data is kept, but requests resolved to it
+// are refused. Archiving twice is a no-op.
+func (s *WorkspaceStore) Archive(ctx context.Context, id int64) (Workspace, error) {
+	if id == DefaultWorkspaceID {
+		return Workspace{}, ErrDefaultWorkspace
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx,
+		`UPDATE workspaces SET archived_at = COALESCE(archived_at, ?) WHERE id = ?`, time.Now().UTC(), id)
+	if err != nil {
+		return Workspace{}, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return Workspace{}, ErrWorkspaceNotFound
+	}
+	loggerFrom(ctx).Info("workspace archived", "workspace_id", id)
+	return s.get(ctx, s.db, id)
+}
+
+// AddMember lets user into the workspace. Adding a member twice is a no-op.
+func (s *WorkspaceStore) AddMember(ctx context.Context, id int64, user string) (Workspace, error) {
+	user = strings.TrimSpace(user)
+	if user == "" {
+		return Workspace{}, &ValidationError{Field: "user", Code: "user_required", Message: "user is required"}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	if _, err := s.get(ctx, s.db, id); err != nil {
+		return Workspace{}, err
+	}
+	if _, err := s.db.ExecContext(ctx,
+		`INSERT OR IGNORE INTO workspace_members(workspace_id, user) VALUES(?, ?)`, id, user,
+	); err != nil {
+		return Workspace{}, err
+	}
+	return s.get(ctx, s.db, id)
+}
+
+// RemoveMember takes user out of the workspace.
+func (s *WorkspaceStore) RemoveMember(ctx context.Context, id int64, user string) (Workspace, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	if _, err := s.get(ctx, s.db, id); err != nil {
+		return Workspace{}, err
+	}
+	if _, err := s.db.ExecContext(ctx,
+		`DELETE FROM workspace_members WHERE workspace_id = ? AND user = ?`, id, user,
+	); err != nil {
+		return Workspace{}, err
+	}
+	return s.get(ctx, s.db, id)
+}
+
+// Resolve picks the workspace for a caller. A slug names it explicitly; the
+// caller must then be a member, unless it is the default workspace. Without
+// a slug, a caller in exactly one open workspace gets that one, a caller in
+// several must choose, and everybody else gets the default workspace.
+func (s *WorkspaceStore) Resolve(ctx context.Context, user, slug string) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var (
+		id       int64
+		archived *time.Time
+	)
+	if slug == "" {
+		// Archived workspaces no longer count as a membership.
+		rows, err := s.db.QueryContext(ctx, `
+			SELECT w.id FROM workspace_members m JOIN workspaces w ON w.id = m.workspace_id
+			WHERE m.user = ? AND w.archived_at IS NULL LIMIT 2`, user)
+		if err != nil {
+			return 0, err
+		}
+		var ids []int64
+		for rows.Next() {
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return 0, err
+			}
+			ids = append(ids, id)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return 0, err
+		}
+		switch len(ids) {
+		case 0:
+			return DefaultWorkspaceID, nil
+		case 1:
+			return ids[0], nil
+		default:
+			return 0, ErrWorkspaceRequired
+		}
+	}
+
+	err := s.db.QueryRowContext(ctx,
+		`SELECT id, archived_at FROM workspaces WHERE slug = ?`, strings.ToLower(slug),
+	).Scan(&id, &archived)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrWorkspaceNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	if id != DefaultWorkspaceID {
+		var member bool
+		if err := s.db.QueryRowContext(ctx,
+			`SELECT EXISTS (SELECT 1 FROM workspace_members WHERE workspace_id = ? AND user = ?)`, id, user,
+		).Scan(&member); err != nil {
+			return 0, err
+		}
+		if !member {
+			return 0, ErrNotMember
+		}
+	}
+	if archived != nil {
+		return 0, ErrWorkspaceArchived
+	}
+	return id, nil
+}
+
+// ResolveWorkspace scopes every request to the caller's workspace; see
+// WorkspaceStore.Resolve. It runs after Identify.
+func ResolveWorkspace(store *WorkspaceStore) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// The same URL answers differently per workspace.
+			w.Header().Add("Vary", workspaceHeader+", "+userHeader)
+
+			user, _ := userFromContext(r.Context())
+			id, err := store.Resolve(r.Context(), user, strings.TrimSpace(r.Header.Get(workspaceHeader)))
+			switch {
+			case err == nil:
+				next.ServeHTTP(w, r.WithContext(withWorkspace(r.Context(), id)))
+			case err == ErrWorkspaceNotFound:
+				writeError(w, r, http.StatusNotFound, errorCode(err))
+			case err == ErrNotMember && user == "":
+				writeError(w, r, http.StatusUnauthorized, "authentication_required")
+			case err == ErrNotMember:
+				writeError(w, r, http.StatusForbidden, errorCode(err))
+			case err == ErrWorkspaceRequired:
+				writeError(w, r, http.StatusBadRequest, errorCode(err))
+			case err == ErrWorkspaceArchived:
+				writeError(w, r, http.StatusGone, errorCode(err))
+			default:
+				loggerFrom(r.Context()).Error("resolve workspace", "err", err)
+				writeError(w, r, http.StatusInternalServerError, "internal_error")
+			}
+		})
+	}
+}
+
+// checkBookQuota returns ErrBookQuota when the workspace is full. It runs in
+// the transaction that adds the book.
+func checkBookQuota(ctx context.Context, tx *sql.Tx, workspace int64) error {
+	var full bool
+	err := tx.QueryRowContext(ctx, `
+		SELECT max_books > 0 AND (SELECT COUNT(*) FROM books WHERE workspace_id = ?1) >= max_books
+		FROM workspaces WHERE id = ?1`, workspace,
+	).Scan(&full)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrWorkspaceNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if full {
+		return ErrBookQuota
+	}
+	return nil
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func setupWorkspaces(t *testing.T) (*chi.Mux, *WebhookStore, func()) {
+	t.Helper()
+
+	_, db := setupTestRouter(t)
+	store := NewBookStore(db)
+	books := NewBooksAPI(store)
+	reviews := NewReviewsAPI(NewReviewStore(db))
+	copies := NewCopiesAPI(NewCopyStore(db))
+	loanStore := NewLoanStore(db)
+	loans := NewLoansAPI(loanStore)
+	holds := NewHoldsAPI(loanStore)
+	webhookStore := NewWebhookStore(db)
+	webhooks := NewWebhooksAPI(webhookStore)
+	workspaceStore := NewWorkspaceStore(db)
+	admin := NewWorkspacesAPI(workspaceStore)
+
+	r := chi.NewRouter()
+	r.Use(Identify)
+	r.Group(func(r chi.Router) {
+		r.Use(ResolveWorkspace(workspaceStore))
+		r.Route("/books", func(r chi.Router) {
+			r.Get("/", books.GetBooksHandler)
+			r.Post("/", books.CreateBookHandler)
+			r.Get("/by-isbn/{isbn}", books.GetBookByISBNHandler)
+			r.Get("/{id}", books.GetBookHandler)
+			r.Put("/{id}", books.UpdateBookHandler)
+			r.Delete("/{id}", books.DeleteBookHandler)
+			r.Route("/{id}/reviews", func(r chi.Router) {
+				r.Use(books.RequireBook)
+				r.Get("/", reviews.ListReviewsHandler)
+				r.Post("/", reviews.CreateReviewHandler)
+				r.Get("/{reviewID}", reviews.GetReviewHandler)
+			})
+			r.Route("/{id}/copies", func(r chi.Router) {
+				r.Use(books.RequireBook)
+				r.Get("/", copies.ListCopiesHandler)
+				r.Post("/", copies.CreateCopyHandler)
+				r.Get("/{copyID}", copies.GetCopyHandler)
+			})
+			r.With(books.RequireBook).Post("/{id}/checkout", loans.CheckoutHandler)
+		})
+		r.Get("/stats", books.StatsHandler)
+		r.Get("/loans", loans.ListLoansHandler)
+		r.Get("/holds", holds.ListHoldsHandler)
+		r.Get("/webhooks", webhooks.ListWebhooksHandler)
+		r.Post("/webhooks", webhooks.CreateWebhookHandler)
+		r.Get("/webhooks/{id}", webhooks.GetWebhookHandler)
+	})
+	r.Route("/admin/workspaces", func(r chi.Router) {
+		r.Use(RequireAdmin([]string{"root"}))
+		r.Get("/", admin.ListWorkspacesHandler)
+		r.Post("/", admin.CreateWorkspaceHandler)
+		r.Get("/{id}", admin.GetWorkspaceHandler)
+		r.Patch("/{id}", admin.UpdateWorkspaceHandler)
+		r.Post("/{id}/archive", admin.ArchiveWorkspaceHandler)
+		r.Put("/{id}/members/{user}", admin.AddMemberHandler)
+		r.Delete("/{id}/members/{user}", admin.RemoveMemberHandler)
+	})
+	return r, webhookStore, func() { db.Close() }
+}
+
+// doIn sends a request as user in the workspace with the given slug; empty
+// values leave the header out.
+func doIn(t *testing.T, r http.Handler, user, workspace, method, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	if user != "" {
+		req.Header.Set(userHeader, user)
+	}
+	if workspace != "" {
+		req.Header.Set(workspaceHeader, workspace)
+	}
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	return rr
+}
+
+func createWorkspace(t *testing.T, r http.Handler, body string) Workspace {
+	t.Helper()
+	rr := doIn(t, r, "root", "", http.MethodPost, "/admin/workspaces", body)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("create workspace status %d body=%s", rr.Code, rr.Body.String())
+	}
+	return decodeJSON[Workspace](t, rr)
+}
+
+func TestWorkspaces_NoCrossTenantAccess(t *testing.T) {
+	r, _, cleanup := setupWorkspaces(t)
+	defer cleanup()
+
+	createWorkspace(t, r, `{"slug":"acme","name":"Acme","members":["alice"]}`)
+	createWorkspace(t, r, `{"slug":"globex","name":"Globex","members":["bob"]}`)
+
+	// Both own the same edition; ISBNs are only unique per workspace.
+	dune := `{"title":"Dune","author":"Frank Herbert","year":1965,"isbn":"9780441172719"}`
+	rr := doIn(t, r, "alice", "", http.MethodPost, "/books", dune)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("alice create status %d body=%s", rr.Code, rr.Body.String())
+	}
+	acme := decodeJSON[Book](t, rr)
+	globex := decodeJSON[Book](t, doIn(t, r, "bob", "globex", http.MethodPost, "/books", dune))
+	if acme.WorkspaceID == globex.WorkspaceID || acme.WorkspaceID == DefaultWorkspaceID {
+		t.Fatalf("workspaces: acme %d globex %d", acme.WorkspaceID, globex.WorkspaceID)
+	}
+	if rr := doIn(t, r, "alice", "", http.MethodPost, "/books", dune); rr.Code != http.StatusConflict {
+		t.Fatalf("duplicate isbn in acme: status %d", rr.Code)
+	}
+	path := fmt.Sprintf("/books/%d", acme.ID)
+	doIn(t, r, "alice", "", http.MethodPost, path+"/reviews", `{"rating":5}`)
+	acmeCopy := decodeJSON[Copy](t, doIn(t, r, "alice", "", http.MethodPost, path+"/copies", `{"barcode":"ACME-1"}`))
+	if rr := doIn(t, r, "alice", "", http.MethodPost, path+"/checkout", ``); rr.Code != http.StatusCreated {
+		t.Fatalf("alice checkout status %d body=%s", rr.Code, rr.Body.String())
+	}
+
+	// Every way bob could reach acme's book by id answers 404.
+	cases := []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{http.MethodGet, path, ``},
+		{http.MethodPut, path, `{"title":"Mine","author":"Bob","year":2000}`},
+		{http.MethodDelete, path, ``},
+		{http.MethodGet, path + "/reviews", ``},
+		{http.MethodGet, path + "/reviews/1", ``},
+		{http.MethodPost, path + "/reviews", `{"rating":1}`},
+		{http.MethodGet, path + "/copies", ``},
+		{http.MethodGet, fmt.Sprintf("%s/copies/%d", path, acmeCopy.ID), ``},
+		{http.MethodPost, path + "/copies", `{"barcode":"GLOBEX-1"}`},
+		{http.MethodPost, path + "/checkout", ``},
+	}
+	for _, tc := range cases {
+		if rr := doIn(t, r, "bob", "", tc.method, tc.path, tc.body); rr.Code != http.StatusNotFound {
+			t.Fatalf("bob %s %s: status %d body=%s", tc.method, tc.path, rr.Code, rr.Body.String())
+		}
+		// The default workspace cannot see it either.
+		if rr := doIn(t, r, "carol", "", tc.method, tc.path, tc.body); rr.Code != http.StatusNotFound {
+			t.Fatalf("carol %s %s: status %d", tc.method, tc.path, rr.Code)
+		}
+	}
+
+	if got := decodeJSON[Book](t, doIn(t, r, "alice", "acme", http.MethodGet, path, ``)); got.Title != "Dune" || got.RatingCount != 1 || got.CopiesTotal != 1 {
+		t.Fatalf("acme book changed: %+v", got)
+	}
+	for _, who := range []struct{ user, slug string }{{"bob", "globex"}, {"carol", ""}} {
+		list := decodeJSON[[]Book](t, doIn(t, r, who.user, who.slug, http.MethodGet, "/books", ``))
+		for _, b := range list {
+			if b.ID == acme.ID {
+				t.Fatalf("%s lists acme's book", who.user)
+			}
+		}
+		if rr := doIn(t, r, who.user, who.slug, http.MethodGet, "/loans", ``); rr.Body.String() != "[]\n" && rr.Body.String() != "null\n" {
+			t.Fatalf("%s sees loans: %s", who.user, rr.Body.String())
+		}
+	}
+	if got := decodeJSON[Book](t, doIn(t, r, "bob", "", http.MethodGet, "/books/by-isbn/9780441172719", ``)); got.ID != globex.ID {
+		t.Fatalf("bob's isbn lookup found book %d", got.ID)
+	}
+	if rr := doIn(t, r, "carol", "", http.MethodGet, "/books/by-isbn/9780441172719", ``); rr.Code != http.StatusNotFound {
+		t.Fatalf("carol's isbn lookup status %d", rr.Code)
+	}
+	if st := decodeJSON[CatalogStats](t, doIn(t, r, "carol", "", http.MethodGet, "/stats", ``)); st.TotalBooks != 0 {
+		t.Fatalf("default stats count other tenants: %+v", st)
+	}
+	if st := decodeJSON[CatalogStats](t, doIn(t, r, "alice", "", http.MethodGet, "/stats", ``)); st.TotalBooks != 1 {
+		t.Fatalf("acme stats: %+v", st)
+	}
+
+	// Naming a workspace you are not in is refused.
+	for _, tc := range []struct {
+		user, slug string
+		status     int
+	}{
+		{"alice", "globex", http.StatusForbidden},
+		{"", "globex", http.StatusUnauthorized},
+		{"alice", "initech", http.StatusNotFound},
+		{"alice", "default", http.StatusOK},
+	} {
+		if rr := doIn(t, r, tc.user, tc.slug, http.MethodGet, "/books", ``); rr.Code != tc.status {
+			t.Fatalf("%q in %q: status %d want %d", tc.user, tc.slug, rr.Code, tc.status)
+		}
+	}
+}
+
+func TestWorkspaces_WebhooksOnlyHearTheirWorkspace(t *testing.T) {
+	r, store, cleanup := setupWorkspaces(t)
+	defer cleanup()
+
+	createWorkspace(t, r, `{"slug":"acme","name":"Acme","members":["alice"]}`)
+	acmeHook := decodeJSON[Webhook](t, doIn(t, r, "alice", "", http.MethodPost, "/webhooks", `{"url":"https://acme.example/hook"}`))
+	defaultHook := decodeJSON[Webhook](t, doIn(t, r, "carol", "", http.MethodPost, "/webhooks", `{"url":"https://default.example/hook"}`))
+
+	if rr := doIn(t, r, "carol", "", http.MethodGet, fmt.Sprintf("/webhooks/%d", acmeHook.ID), ``); rr.Code != http.StatusNotFound {
+		t.Fatalf("carol reads acme's webhook: status %d", rr.Code)
+	}
+	if got := decodeJSON[[]Webhook](t, doIn(t, r, "alice", "", http.MethodGet, "/webhooks", ``)); len(got) != 1 || got[0].ID != acmeHook.ID {
+		t.Fatalf("acme webhooks: %+v", got)
+	}
+
+	doIn(t, r, "alice", "", http.MethodPost, "/books", `{"title":"Dune","author":"Frank Herbert","year":1965}`)
+	if _, err := store.fanOut(t.Context(), time.Now().UTC(), 100); err != nil {
+		t.Fatal(err)
+	}
+	var acmeDeliveries, defaultDeliveries int
+	if err := store.db.QueryRow(`SELECT
+		COUNT(*) FILTER (WHERE webhook_id = ?), COUNT(*) FILTER (WHERE webhook_id = ?)
+		FROM webhook_deliveries`, acmeHook.ID, defaultHook.ID,
+	).Scan(&acmeDeliveries, &defaultDeliveries); err != nil {
+		t.Fatal(err)
+	}
+	if acmeDeliveries != 1 || defaultDeliveries != 0 {
+		t.Fatalf("deliveries: acme %d default %d", acmeDeliveries, defaultDeliveries)
+	}
+}
+
+func TestWorkspaces_QuotaArchiveAndMembership(t *testing.T) {
+	r, _, cleanup := setupWorkspaces(t)
+	defer cleanup()
+
+	ws := createWorkspace(t, r, `{"slug":"acme","name":"Acme","max_books":1,"members":["alice"]}`)
+	wsPath := fmt.Sprintf("/admin/workspaces/%d", ws.ID)
+
+	for _, tc := range []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"bad slug", `{"slug":"Not A Slug","name":"X"}`, http.StatusBadRequest},
+		{"no name", `{"slug":"initech"}`, http.StatusBadRequest},
+		{"negative quota", `{"slug":"initech","name":"Initech","max_books":-1}`, http.StatusBadRequest},
+		{"taken slug", `{"slug":"acme","name":"Acme again"}`, http.StatusConflict},
+	} {
+		if rr := doIn(t, r, "root", "", http.MethodPost, "/admin/workspaces", tc.body); rr.Code != tc.status {
+			t.Fatalf("%s: status %d want %d", tc.name, rr.Code, tc.status)
+		}
+	}
+
+	// The quota only counts acme's books.
+	doIn(t, r, "carol", "", http.MethodPost, "/books", `{"title":"Emma","author":"Jane Austen","year":1815}`)
+	if rr := doIn(t, r, "alice", "", http.MethodPost, "/books", `{"title":"Dune","author":"Frank Herbert","year":1965}`); rr.Code != http.StatusCreated {
+		t.Fatalf("first book status %d", rr.Code)
+	}
+	if rr := doIn(t, r, "alice", "", http.MethodPost, "/books", `{"title":"Emma","author":"Jane Austen","year":1815}`); rr.Code != http.StatusForbidden {
+		t.Fatalf("over quota status %d", rr.Code)
+	}
+	if got := decodeJSON[Workspace](t, doIn(t, r, "root", "", http.MethodPatch, wsPath, `{"max_books":2}`)); got.MaxBooks != 2 || got.Books != 1 {
+		t.Fatalf("patched: %+v", got)
+	}
+	if rr := doIn(t, r, "alice", "", http.MethodPost, "/books", `{"title":"Emma","author":"Jane Austen","year":1815}`); rr.Code != http.StatusCreated {
+		t.Fatalf("after raising quota status %d", rr.Code)
+	}
+
+	// A second workspace means alice has to choose.
+	other := createWorkspace(t, r, `{"slug":"globex","name":"Globex"}`)
+	if got := decodeJSON[Workspace](t, doIn(t, r, "root", "", http.MethodPut, fmt.Sprintf("/admin/workspaces/%d/members/alice", other.ID), ``)); fmt.Sprint(got.Members) != "[alice]" {
+		t.Fatalf("members: %+v", got)
+	}
+	if rr := doIn(t, r, "alice", "", http.MethodGet, "/books", ``); rr.Code != http.StatusBadRequest {
+		t.Fatalf("ambiguous workspace status %d", rr.Code)
+	}
+	if got := decodeJSON[[]Book](t, doIn(t, r, "alice", "globex", http.MethodGet, "/books", ``)); len(got) != 0 {
+		t.Fatalf("globex books: %+v", got)
+	}
+
+	// Archiving keeps the data but closes the workspace.
+	if rr := doIn(t, r, "root", "", http.MethodPost, wsPath+"/archive", ``); rr.Code != http.StatusOK || decodeJSON[Workspace](t, rr).ArchivedAt == nil {
+		t.Fatalf("archive status %d body=%s", rr.Code, rr.Body.String())
+	}
+	if rr := doIn(t, r, "alice", "acme", http.MethodGet, "/books", ``); rr.Code != http.StatusGone {
+		t.Fatalf("archived workspace status %d", rr.Code)
+	}
+	// Only globex is left open, so it is picked without the header.
+	if rr := doIn(t, r, "alice", "", http.MethodGet, "/books", ``); rr.Code != http.StatusOK {
+		t.Fatalf("after archive status %d", rr.Code)
+	}
+	if got := decodeJSON[Workspace](t, doIn(t, r, "root", "", http.MethodGet, wsPath, ``)); got.Books != 2 {
+		t.Fatalf("archived workspace lost its books: %+v", got)
+	}
+	if rr := doIn(t, r, "root", "", http.MethodPost, "/admin/workspaces/1/archive", ``); rr.Code != http.StatusConflict {
+		t.Fatalf("archive default status %d", rr.Code)
+	}
+	if rr := doIn(t, r, "root", "", http.MethodPost, "/admin/workspaces/99/archive", ``); rr.Code != http.StatusNotFound {
+		t.Fatalf("archive unknown status %d", rr.Code)
+	}
+
+	if got := decodeJSON[Workspace](t, doIn(t, r, "root", "", http.MethodDelete, fmt.Sprintf("/admin/workspaces/%d/members/alice", other.ID), ``)); len(got.Members) != 0 {
+		t.Fatalf("members after removal: %+v", got)
+	}
+	if rr := doIn(t, r, "alice", "globex", http.MethodGet, "/books", ``); rr.Code != http.StatusForbidden {
+		t.Fatalf("removed member status %d", rr.Code)
+	}
+	if got := decodeJSON[[]Workspace](t, doIn(t, r, "root", "", http.MethodGet, "/admin/workspaces", ``)); len(got) != 3 || got[0].Slug != "default" {
+		t.Fatalf("workspaces: %+v", got)
+	}
+}
+
+func TestWorkspaces_AdminOnly(t *testing.T) {
+	r, _, cleanup := setupWorkspaces(t)
+	defer cleanup()
+
+	routes := []struct{ method, path, body string }{
+		{http.MethodGet, "/admin/workspaces", ``},
+		{http.MethodPost, "/admin/workspaces", `{"slug":"acme","name":"Acme"}`},
+		{http.MethodGet, "/admin/workspaces/1", ``},
+		{http.MethodPatch, "/admin/workspaces/1", `{"max_books":1}`},
+		{http.MethodPost, "/admin/workspaces/1/archive", ``},
+		{http.MethodPut, "/admin/workspaces/1/members/alice", ``},
+		{http.MethodDelete, "/admin/workspaces/1/members/alice", ``},
+	}
+	for _, rt := range routes {
+		for _, tc := range []struct {
+			user   string
+			status int
+			code   string
+		}{
+			{"", http.StatusUnauthorized, "authentication_required"},
+			{"alice", http.StatusForbidden, "admin_required"},
+		} {
+			rr := doIn(t, r, tc.user, "", rt.method, rt.path, rt.body)
+			if rr.Code != tc.status || decodeJSON[errorResponse](t, rr).Code != tc.code {
+				t.Fatalf("%s %s as %q: status %d body=%s", rt.method, rt.path, tc.user, rr.Code, rr.Body.String())
+			}
+		}
+	}
+
+	// Nothing got through.
+	if got := decodeJSON[[]Workspace](t, doIn(t, r, "root", "", http.MethodGet, "/admin/workspaces", ``)); len(got) != 1 || len(got[0].Members) != 0 {
+		t.Fatalf("workspaces: %+v", got)
+	}
+}
+
+func TestWorkspaces_BarcodesArePerWorkspace(t *testing.T) {
+	r, _, cleanup := setupWorkspaces(t)
+	defer cleanup()
+
+	createWorkspace(t, r, `{"slug":"acme","name":"Acme","members":["alice"]}`)
+	createWorkspace(t, r, `{"slug":"globex","name":"Globex","members":["bob"]}`)
+
+	for _, tc := range []struct{ user, workspace string }{{"alice", "acme"}, {"bob", "globex"}} {
+		book := decodeJSON[Book](t, doIn(t, r, tc.user, tc.workspace, http.MethodPost, "/books", `{"title":"Dune","author":"Frank Herbert","year":1965}`))
+		copies := fmt.Sprintf("/books/%d/copies", book.ID)
+		if rr := doIn(t, r, tc.user, tc.workspace, http.MethodPost, copies, `{"barcode":"LIB-1"}`); rr.Code != http.StatusCreated {
+			t.Fatalf("%s: status %d body=%s", tc.workspace, rr.Code, rr.Body.String())
+		}
+		rr := doIn(t, r, tc.user, tc.workspace, http.MethodPost, copies, `{"barcode":"LIB-1"}`)
+		if rr.Code != http.StatusConflict || decodeJSON[errorResponse](t, rr).Code != "barcode_taken" {
+			t.Fatalf("%s duplicate: status %d body=%s", tc.workspace, rr.Code, rr.Body.String())
+		}
+	}
+}
@@ -9,13 +9,21 @@ import {
 } from "react";
 import {
   type Book,
+  type BookEvent,
   type BookInput,
   listBooks,
   createBook,
   updateBook,
   deleteBook,
+  bookEventsURL,
 } from "@/lib/api";
 
+// upsert keeps the list ordered by id, like GET /books
+function upsert(books: Book[], book: Book): Book[] {
+  const rest = books.filter((b) => b.id !== book.id);
+  return [...rest, book].sort((a, b) => a.id - b.id);
+}
+
 type BooksContextType = {
   books: Book[];
   loading: boolean;
@@ -45,23 +53,44 @@ export function BooksProvider({ children }: { children: ReactNode }) {
     }
   }
 
+  // mutations update local state right away; the event stream makes the
+  // same (idempotent) change and keeps other open dashboards in sync
   async function addBook(input: BookInput) {
-    await createBook(input);
-    await refresh();
+    const created = await createBook(input);
+    setBooks((prev) => upsert(prev, created));
   }
 
   async function editBook(id: number, input: BookInput) {
-    await updateBook(id, input);
-    await refresh();
+    const updated = await updateBook(id, input);
+    setBooks((prev) => upsert(prev, updated));
   }
 
   async function removeBook(id: number) {
     await deleteBook(id);
-    await refresh();
+    setBooks((prev) => prev.filter((b) => b.id !== id));
   }
 
   useEffect(() => {
     refresh();
+
+    const source = new EventSource(bookEventsURL());
+
+    function apply(e: MessageEvent) {
+      const ev: BookEvent = JSON.parse(e.data);
+      if (ev.type === "book.deleted") {
+        setBooks((prev) => prev.filter((b) => b.id !== ev.book.id));
+      } else {
+        setBooks((prev) => upsert(prev, ev.book));
+      }
+    }
+
+    source.addEventListener("book.created", apply);
+    source.addEventListener("book.updated", apply);
+    source.addEventListener("book.deleted", apply);
+    // we missed too much while disconnected: reload everything
+    source.addEventListener("reset", () => refresh());
+
+    return () => source.close();
   }, []);
 
   return (
@@ -1,30 +1,51 @@
 // frontend/src/lib/api.ts
 
+// versioned API root; the unversioned paths are deprecated aliases
 const API_BASE =
-  process.env.NEXT_PUBLIC_API_BASE ?? "http://localhost:8080";
+  (process.env.NEXT_PUBLIC_API_BASE ?? "http://localhost:8080") + "/v1";
+
+// workspace slug sent as X-Workspace; empty lets the server pick
+let workspace = process.env.NEXT_PUBLIC_WORKSPACE ?? "";
+
+export function setWorkspace(slug: string) {
+  workspace = slug;
+}
+
+// ApiError carries the server's stable error code (e.g. "title_required"),
+// which stays the same whatever language the message is in.
+export class ApiError extends Error {
+  constructor(message: string, public status: number, public code?: string) {
+    super(message);
+    this.name = "ApiError";
+  }
+}
 
 async function apiFetch<T>(
   path: string,
   options: RequestInit = {}
 ): Promise<T> {
   const res = await fetch(`${API_BASE}${path}`, {
-    cache: "no-store",
+    // revalidate with ETag / Last-Modified instead of refetching every time
+    cache: "no-cache",
+    ...options,
     headers: {
       "Content-Type": "application/json",
+      ...(workspace ? { "X-Workspace": workspace } : {}),
       ...(options.headers || {}),
     },
-    ...options,
   });
 
   if (!res.ok) {
   let message = `${res.status} ${res.statusText}`;
+  let code: string | undefined;
 
-  // try JSON error: { error: "..." }
+  // try JSON error: { error: "...", code: "..." }
   try {
     const data = await res.json();
     if (data?.error) {
       message = `${message}: ${data.error}`;
     }
+    code = data?.code;
   } catch {
     // fallback to plain text body (sometimes servers return text/html)
     try {
@@ -35,7 +56,7 @@ async function apiFetch<T>(
     }
   }
 
-  throw new Error(message);
+  throw new ApiError(message, res.status, code);
 }
 
 
@@ -54,12 +75,90 @@ export type Book = {
   title: string;
   author: string;
   year: number;
+  // normalized ISBN-13; empty when unknown
+  isbn: string;
+  workspace_id: number;
+  created_at: string;
+  updated_at: string;
+  rating_avg: number;
+  rating_count: number;
+  status: "available" | "on_loan" | "unavailable";
+  copies_total: number;
+  copies_available: number;
+  locations?: LocationCount[];
+};
+
+export type LocationCount = {
+  location: string;
+  total: number;
+  available: number;
+};
+
+export type BookEventType = "book.created" | "book.updated" | "book.deleted";
+
+export type BookEvent = {
+  id: number;
+  type: BookEventType;
+  occurred_at: string;
+  book: Book;
+};
+
+export type CatalogStats = {
+  total_books: number;
+  total_authors: number;
+  top_authors: { author: string; books: number }[];
+  // year is the first year of the decade unless by=year
+  publication: { year: number; books: number }[];
+  additions: { period: string; books: number }[];
+};
+
+export type StatsOptions = {
+  top?: number;
+  by?: "decade" | "year";
+  interval?: "day" | "week" | "month";
+};
+
+export type DuplicateCluster = {
+  // weakest similarity between the books, 0 to 1
+  score: number;
+  books: Book[];
+};
+
+export type MergeRequest = {
+  book_ids: number[];
+  survivor_id?: number;
+  title?: string;
+  author?: string;
+  year?: number;
+  isbn?: string;
+};
+
+export type MergeResult = {
+  book: Book;
+  merged_ids: number[];
+  reviews_moved: number;
+  loans_moved: number;
+  copies_moved: number;
+  holds_moved: number;
 };
 
 export type BookInput = {
   title: string;
   author: string;
   year: number;
+  isbn?: string;
+};
+
+export type BookMetadata = {
+  book_id: number;
+  source: string;
+  isbn: string;
+  publisher: string;
+  page_count: number;
+  subjects: string[];
+  description: string;
+  cover_url: string;
+  fetched_at: string;
 };
 
 //API functions
@@ -72,9 +171,76 @@ export function getBook(id: number): Promise<Book> {
   return apiFetch<Book>(`/books/${id}`);
 }
 
-export function createBook(input: BookInput): Promise<Book> {
+// Book fields for ?fields=; the id is always returned
+export type BookField = Exclude<keyof Book, "id">;
+
+export type BookExpansion = "author_books" | "metadata" | "stats";
+
+export type BookSummary = {
+  id: number;
+  title: string;
+  year: number;
+};
+
+export type BookCounts = {
+  ratings: Record<"1" | "2" | "3" | "4" | "5", number>;
+  loans: number;
+  active_holds: number;
+};
+
+export type SelectedBook<F extends BookField> = Pick<Book, "id" | F> & {
+  author_books?: BookSummary[];
+  metadata?: BookMetadata | null;
+  stats?: BookCounts;
+};
+
+function selectionQuery(fields?: BookField[], expand?: BookExpansion[]): string {
+  const params = new URLSearchParams();
+  if (fields?.length) params.set("fields", fields.join(","));
+  if (expand?.length) params.set("expand", expand.join(","));
+  const qs = params.toString();
+  return qs ? `?${qs}` : "";
+}
+
+// Reads only the given fields, plus any expansions
+export function getBooksSelected<F extends BookField>(
+  fields: F[],
+  expand: BookExpansion[] = []
+): Promise<SelectedBook<F>[]> {
+  return apiFetch<SelectedBook<F>[]>(`/books${selectionQuery(fields, expand)}`);
+}
+
+export function getBookSelected<F extends BookField>(
+  id: number,
+  fields: F[],
+  expand: BookExpansion[] = []
+): Promise<SelectedBook<F>> {
+  return apiFetch<SelectedBook<F>>(`/books/${id}${selectionQuery(fields, expand)}`);
+}
+
+// Accepts ISBN-10 or ISBN-13, with or without hyphens
+export function getBookByISBN(isbn: string): Promise<Book> {
+  return apiFetch<Book>(`/books/by-isbn/${encodeURIComponent(isbn)}`);
+}
+
+export function getStats(options: StatsOptions = {}): Promise<CatalogStats> {
+  const params = new URLSearchParams();
+  if (options.top) params.set("top", String(options.top));
+  if (options.by) params.set("by", options.by);
+  if (options.interval) params.set("interval", options.interval);
+  const query = params.toString();
+  return apiFetch<CatalogStats>(query ? `/stats?${query}` : "/stats");
+}
+
+// Pass the same idempotencyKey when retrying a submission so the server
+// replays the first result instead of creating a duplicate.
+export function createBook(
+  input: BookInput,
+  idempotencyKey: string = crypto.randomUUID()
+): Promise<Book> {
   return apiFetch<Book>("/books", {
     method: "POST",
+    headers: { "Idempotency-Key": idempotencyKey },
     body: JSON.stringify(input),
   });
 }
@@ -94,3 +260,54 @@ export function deleteBook(id: number): Promise<void> {
     method: "DELETE",
   });
 }
+
+export function getBookMetadata(id: number): Promise<BookMetadata> {
+  return apiFetch<BookMetadata>(`/books/${id}/metadata`);
+}
+
+export function enrichBook(id: number): Promise<BookMetadata> {
+  return apiFetch<BookMetadata>(`/books/${id}/enrich`, {
+    method: "POST",
+  });
+}
+
+export function findDuplicates(minScore?: number): Promise<DuplicateCluster[]> {
+  const query = minScore ? `?min_score=${minScore}` : "";
+  return apiFetch<DuplicateCluster[]>(`/books/duplicates${query}`);
+}
+
+export function mergeBooks(req: MergeRequest): Promise<MergeResult> {
+  return apiFetch<MergeResult>("/books/merge", {
+    method: "POST",
+    body: JSON.stringify(req),
+  });
+}
+
+// Server-Sent Events stream of book changes
+export function bookEventsURL(): string {
+  return `${API_BASE}/books/events`;
+}
+
+export type BookChange = {
+  seq: number;
+  op: "upsert" | "delete";
+  book_id: number;
+  book?: Book;
+  changed_at: string;
+};
+
+export type BookChanges = {
+  changes: BookChange[];
+  token: string;
+  has_more: boolean;
+};
+
+// Delta sync: books changed since a token from a previous call, or every book
+// without one. An expired token fails with code "resync_required".
+export function getBookChanges(since?: string, limit?: number): Promise<BookChanges> {
+  const params = new URLSearchParams();
+  if (since) params.set("since", since);
+  if (limit) params.set("limit", String(limit));
+  const qs = params.toString();
+  return apiFetch<BookChanges>(`/books/changes${qs ? `?${qs}` : ""}`);
+}